@@ -6,8 +6,10 @@ import (
 	"os"
 
 	"github.com/fatih/color"
+	"github.com/pkg/errors"
 	"github.com/walteh/snake"
 	"github.com/walteh/testrc/cmd/root"
+	"github.com/walteh/testrc/cmd/root/run"
 )
 
 func main() {
@@ -30,10 +32,16 @@ func main() {
 	rootCmd.SilenceErrors = true
 
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		// the command of testrc run failed and already reported why
+		var exit *run.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
 		_, err = fmt.Fprintf(os.Stderr, "[%s] (error) %+v\n", rootCmd.Name(), err)
 		if err != nil {
 			panic(err)
 		}
+		os.Exit(1)
 	}
 
 }
@@ -0,0 +1,240 @@
+package dynamo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+var _ snake.Snakeable = (*Seed)(nil)
+
+type Seed struct {
+	Target
+
+	table  string
+	source string
+}
+
+func (me *Seed) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "load seed files (json, jsonl, yaml or csv) into a table",
+	}
+
+	cmd.Args = cobra.ExactArgs(2)
+
+	me.Target.flags(cmd)
+
+	return cmd
+}
+
+func (me *Seed) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table, me.source = args[0], args[1]
+	return me.Target.parse()
+}
+
+func (me *Seed) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	n, err := dynamodb_image.Seed(ctx, cli, me.table, me.source)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "seeded %d items into %s\n", n, me.table)
+	return err
+}
+
+var _ snake.Snakeable = (*Dump)(nil)
+
+type Dump struct {
+	Target
+
+	Output      string
+	Parallelism int
+
+	tables  []string
+	archive dynamodb_image.ArchiveFormat
+}
+
+func (me *Dump) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "write the items of a table as DynamoDB JSON lines, or tables to an archive restore can load",
+	}
+
+	cmd.Args = cobra.MinimumNArgs(1)
+
+	me.Target.flags(cmd)
+
+	cmd.Flags().StringVarP(&me.Output, "output", "o", "", "Write to this file instead of stdout, an archive of every table if it ends in .tar, .tgz, .tar.gz or .zip")
+	cmd.Flags().IntVarP(&me.Parallelism, "parallelism", "p", dynamodb_image.DumpParallelism, "Number of scan segments read at once when writing an archive")
+
+	return cmd
+}
+
+func (me *Dump) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.tables = args
+	me.archive = dynamodb_image.ArchiveFormatFor(me.Output)
+
+	if me.archive == "" && len(me.tables) > 1 {
+		return errors.New("dumping more than one table needs an archive --output")
+	}
+
+	return me.Target.parse()
+}
+
+func (me *Dump) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if me.Output != "" {
+		f, err := os.Create(me.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if me.archive != "" {
+		return dynamodb_image.Dump(ctx, cli, me.tables, w, dynamodb_image.DumpFormat(me.archive), dynamodb_image.DumpSegments(me.Parallelism))
+	}
+
+	buf := bufio.NewWriter(w)
+
+	pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{TableName: ptr.String(me.tables[0])})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "scanning %s", me.tables[0])
+		}
+		for _, item := range page.Items {
+			b, err := dynamodb_image.MarshalItemJSON(item)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Flush()
+}
+
+var _ snake.Snakeable = (*Restore)(nil)
+
+type Restore struct {
+	Target
+
+	Rename []string
+
+	source string
+	opts   []dynamodb_image.RestoreOption
+}
+
+func (me *Restore) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "create and load tables from an archive written by dump, or from a DynamoDB export to S3",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+
+	cmd.Flags().StringSliceVar(&me.Rename, "as", nil, "Load a table under another name, as from=to")
+
+	return cmd
+}
+
+func (me *Restore) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.source = args[0]
+
+	for _, r := range me.Rename {
+		from, to, ok := strings.Cut(r, "=")
+		if !ok {
+			return errors.Errorf("invalid --as %q, expected from=to", r)
+		}
+		me.opts = append(me.opts, dynamodb_image.RestoreAs(from, to))
+	}
+
+	return me.Target.parse()
+}
+
+func (me *Restore) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	restored, err := dynamodb_image.RestoreFile(ctx, cli, me.source, me.opts...)
+	for _, r := range restored {
+		if _, err := fmt.Fprintln(cmd.OutOrStdout(), r); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+var _ snake.Snakeable = (*Truncate)(nil)
+
+type Truncate struct {
+	Target
+
+	Parallelism int
+
+	tables []string
+}
+
+func (me *Truncate) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "delete every item of the tables, keeping the tables",
+	}
+
+	cmd.Args = cobra.MinimumNArgs(1)
+
+	me.Target.flags(cmd)
+
+	cmd.Flags().IntVarP(&me.Parallelism, "parallelism", "p", dynamodb_image.TruncateParallelism, "Number of scan segments deleted from at once")
+
+	return cmd
+}
+
+func (me *Truncate) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.tables = args
+	return me.Target.parse()
+}
+
+func (me *Truncate) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range me.tables {
+		res, err := dynamodb_image.Truncate(ctx, cli, table, dynamodb_image.WithParallelism(me.Parallelism))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(cmd.OutOrStdout(), res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package dynamo
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+	"github.com/walteh/testrc/pkg/docker"
+
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+// MustAddGroup adds the dynamo command group to parent. snake.NewGroup adds the group but
+// returns the parent, so the group command is built here. snake uses the name as the Use
+// line, so it carries the arguments.
+func MustAddGroup(ctx context.Context, parent *cobra.Command) *cobra.Command {
+	grp := &cobra.Command{
+		Use:   "dynamo",
+		Short: "inspect and change the tables of a running dynamodb fixture",
+		Long: "Commands against a dynamodb fixture, e.g. while a test is paused. The fixture is " +
+			"found by --endpoint, $" + dynamodb_image.EndpointURLEnv + " or the running containers " +
+			"of --session, in that order.",
+	}
+	parent.AddCommand(grp)
+
+	snake.MustNewCommand(ctx, grp, "tables", &Tables{})
+	snake.MustNewCommand(ctx, grp, "describe <table>", &Describe{})
+	snake.MustNewCommand(ctx, grp, "scan <table>", &Scan{})
+	snake.MustNewCommand(ctx, grp, "counts <table>", &Counts{})
+	snake.MustNewCommand(ctx, grp, "get <table>", &Get{})
+	snake.MustNewCommand(ctx, grp, "query <table>", &Query{})
+	snake.MustNewCommand(ctx, grp, "put <table> <item>...", &Put{})
+	snake.MustNewCommand(ctx, grp, "seed <table> <file or directory>", &Seed{})
+	snake.MustNewCommand(ctx, grp, "dump <table>...", &Dump{})
+	snake.MustNewCommand(ctx, grp, "restore <archive or directory>", &Restore{})
+	snake.MustNewCommand(ctx, grp, "truncate <table>...", &Truncate{})
+	snake.MustNewCommand(ctx, grp, "repl", &Repl{})
+
+	return grp
+}
+
+// Target selects the fixture a command talks to.
+type Target struct {
+	Endpoint string
+	Session  string
+	Format   string
+
+	format dynamodb_image.Format
+}
+
+func (me *Target) flags(cmd *cobra.Command) {
+	formats := make([]string, 0, len(dynamodb_image.Formats))
+	for _, f := range dynamodb_image.Formats {
+		formats = append(formats, string(f))
+	}
+
+	cmd.Flags().StringVarP(&me.Endpoint, "endpoint", "e", "", "Endpoint of the fixture (defaults to $"+dynamodb_image.EndpointURLEnv+")")
+	cmd.Flags().StringVarP(&me.Session, "session", "s", "", "Find the fixture among the containers of this session (defaults to $"+docker.SessionEnv+")")
+	cmd.Flags().StringVarP(&me.Format, "format", "f", string(dynamodb_image.FormatText), "Output format ("+strings.Join(formats, ", ")+")")
+}
+
+func (me *Target) parse() error {
+	if me.Session == "" {
+		me.Session = os.Getenv(docker.SessionEnv)
+	}
+	f, err := dynamodb_image.ParseFormat(me.Format)
+	if err != nil {
+		return err
+	}
+	me.format = f
+	return nil
+}
+
+func (me *Target) client(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.Client, error) {
+	endpoint := me.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv(dynamodb_image.EndpointURLEnv)
+	}
+	if endpoint != "" {
+		return dynamodb_image.NewEndpointClient(endpoint, optFns...), nil
+	}
+
+	stores, err := docker.Attach(ctx, docker.AttachOptions{Session: me.Session, Services: []string{"dynamodb"}})
+	if err != nil {
+		return nil, err
+	}
+	switch len(stores) {
+	case 0:
+		return nil, errors.New("no running dynamodb fixture found, pass --endpoint or --session")
+	case 1:
+		return dynamodb_image.NewEndpointClient(stores[0].GetHttpHost(), optFns...), nil
+	default:
+		return nil, errors.Errorf("%d dynamodb fixtures are running, pick one with --endpoint or --session", len(stores))
+	}
+}
+
+// Expression are the expression flags shared by scan and query.
+type Expression struct {
+	Filter string
+	Values string
+}
+
+func (me *Expression) flags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&me.Filter, "filter", "", "Filter expression, #name placeholders refer to the attribute of the same name")
+	cmd.Flags().StringVar(&me.Values, "values", "", "Expression attribute values as a json object, e.g. '{\":s\": \"open\"}'")
+}
+
+func (me *Expression) values() (map[string]types.AttributeValue, error) {
+	if me.Values == "" {
+		return nil, nil
+	}
+	v, err := dynamodb_image.ParseItem([]byte(me.Values))
+	return v, errors.Wrap(err, "parsing --values")
+}
+
+var placeholder = regexp.MustCompile(`#([A-Za-z0-9_]+)`)
+
+// expressionNames maps every #name placeholder in the expressions to the attribute of
+// the same name.
+func expressionNames(exprs ...string) map[string]string {
+	names := map[string]string{}
+	for _, e := range exprs {
+		for _, m := range placeholder.FindAllStringSubmatch(e, -1) {
+			names[m[0]] = m[1]
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+// Selection are the output flags shared by the commands printing items.
+type Selection struct {
+	Attributes []string
+	Exclude    []string
+	Sort       bool
+	Limit      int
+}
+
+func (me *Selection) flags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&me.Attributes, "attributes", "a", nil, "Only show these attributes, in this order")
+	cmd.Flags().StringSliceVarP(&me.Exclude, "exclude", "x", nil, "Hide these attributes")
+	cmd.Flags().BoolVar(&me.Sort, "sort", false, "Sort by the key schema and show key attributes first")
+	cmd.Flags().IntVarP(&me.Limit, "limit", "l", 0, "Show at most this many items")
+}
+
+func (me *Selection) options(format dynamodb_image.Format) []dynamodb_image.PrintOption {
+	opts := []dynamodb_image.PrintOption{dynamodb_image.WithFormat(format), dynamodb_image.WithLimit(me.Limit)}
+	if len(me.Attributes) > 0 {
+		opts = append(opts, dynamodb_image.WithAttributes(me.Attributes...))
+	}
+	if len(me.Exclude) > 0 {
+		opts = append(opts, dynamodb_image.WithoutAttributes(me.Exclude...))
+	}
+	if me.Sort {
+		opts = append(opts, dynamodb_image.SortByKey())
+	}
+	return opts
+}
@@ -0,0 +1,372 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+var _ snake.Snakeable = (*Scan)(nil)
+
+type Scan struct {
+	Target
+	Selection
+	Expression
+
+	GroupByAttribute string
+	GroupByPrefix    string
+
+	table string
+}
+
+func (me *Scan) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print the items of a table",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+	me.Selection.flags(cmd)
+	me.Expression.flags(cmd)
+
+	cmd.Flags().StringVar(&me.GroupByAttribute, "group-by", "", "Print a table per value of this attribute and the access patterns of the table")
+	cmd.Flags().StringVar(&me.GroupByPrefix, "group-by-key-prefix", "", "Print a table per key prefix up to this separator, e.g. '#', and the access patterns of the table")
+	cmd.MarkFlagsMutuallyExclusive("group-by", "group-by-key-prefix")
+
+	return cmd
+}
+
+func (me *Scan) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+	return me.Target.parse()
+}
+
+func (me *Scan) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := me.Selection.options(me.format)
+	if me.Filter != "" {
+		values, err := me.values()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, dynamodb_image.WithFilter(me.Filter, expressionNames(me.Filter), values))
+	}
+	if me.GroupByAttribute != "" {
+		opts = append(opts, dynamodb_image.GroupByAttribute(me.GroupByAttribute))
+	}
+	if me.GroupByPrefix != "" {
+		opts = append(opts, dynamodb_image.GroupByKeyPrefix(me.GroupByPrefix))
+	}
+
+	return dynamodb_image.FprintScan(ctx, cli, cmd.OutOrStdout(), me.table, opts...)
+}
+
+var _ snake.Snakeable = (*Counts)(nil)
+
+type Counts struct {
+	Target
+
+	table string
+}
+
+func (me *Counts) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print how many items of a table have each attribute",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+
+	return cmd
+}
+
+func (me *Counts) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+	return me.Target.parse()
+}
+
+func (me *Counts) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	return dynamodb_image.FprintCounts(ctx, cli, cmd.OutOrStdout(), me.table, dynamodb_image.WithFormat(me.format))
+}
+
+var _ snake.Snakeable = (*Get)(nil)
+
+type Get struct {
+	Target
+	Selection
+
+	Key string
+
+	table string
+	key   map[string]types.AttributeValue
+}
+
+func (me *Get) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print a single item",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+	me.Selection.flags(cmd)
+
+	cmd.Flags().StringVarP(&me.Key, "key", "k", "", "Key of the item as a json object, e.g. '{\"pk\": \"user#1\"}'")
+
+	return cmd
+}
+
+func (me *Get) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+
+	if me.Key == "" {
+		return errors.New("--key is required")
+	}
+	key, err := dynamodb_image.ParseItem([]byte(me.Key))
+	if err != nil {
+		return errors.Wrap(err, "parsing --key")
+	}
+	me.key = key
+
+	return me.Target.parse()
+}
+
+func (me *Get) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String(me.table), Key: me.key, ConsistentRead: ptr.Bool(true)})
+	if err != nil {
+		return err
+	}
+	if out.Item == nil {
+		return errors.Errorf("no item with key %s in %s", me.Key, me.table)
+	}
+
+	keys, err := tableKeys(ctx, cli, me.table)
+	if err != nil {
+		return err
+	}
+
+	return dynamodb_image.FprintItems(cmd.OutOrStdout(), fmt.Sprintf("Item: %s", me.table), []map[string]types.AttributeValue{out.Item}, keys, me.Selection.options(me.format)...)
+}
+
+var _ snake.Snakeable = (*Query)(nil)
+
+type Query struct {
+	Target
+	Selection
+	Expression
+
+	KeyCondition string
+	Index        string
+	Descending   bool
+
+	table string
+}
+
+func (me *Query) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print the items matching a key condition",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+	me.Selection.flags(cmd)
+	me.Expression.flags(cmd)
+
+	cmd.Flags().StringVarP(&me.KeyCondition, "key-condition", "k", "", "Key condition expression, e.g. '#pk = :pk AND begins_with(#sk, :prefix)'")
+	cmd.Flags().StringVarP(&me.Index, "index", "i", "", "Query this secondary index instead of the table")
+	cmd.Flags().BoolVar(&me.Descending, "desc", false, "Return items in descending sort key order")
+
+	return cmd
+}
+
+func (me *Query) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+
+	if me.KeyCondition == "" {
+		return errors.New("--key-condition is required")
+	}
+
+	return me.Target.parse()
+}
+
+func (me *Query) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	values, err := me.values()
+	if err != nil {
+		return err
+	}
+
+	items, err := (&readRequest{
+		Table:        me.table,
+		KeyCondition: me.KeyCondition,
+		Filter:       me.Filter,
+		Index:        me.Index,
+		Values:       values,
+		Descending:   me.Descending,
+		Limit:        me.Limit,
+	}).items(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	keys, err := tableKeys(ctx, cli, me.table)
+	if err != nil {
+		return err
+	}
+
+	return dynamodb_image.FprintItems(cmd.OutOrStdout(), fmt.Sprintf("Query: %s", me.table), items, keys, me.Selection.options(me.format)...)
+}
+
+// readRequest is a query, or a scan without a key condition, as the query command and
+// the repl run them. #name placeholders in the expressions refer to the attribute of the
+// same name.
+type readRequest struct {
+	Table        string
+	KeyCondition string
+	Filter       string
+	Index        string
+	Values       map[string]types.AttributeValue
+	Descending   bool
+	Consistent   bool
+	Limit        int
+}
+
+// items reads pages until there are no more or Limit items were read.
+func (me *readRequest) items(ctx context.Context, cli *dynamodb.Client) ([]map[string]types.AttributeValue, error) {
+	var filter, index *string
+	if me.Filter != "" {
+		filter = ptr.String(me.Filter)
+	}
+	if me.Index != "" {
+		index = ptr.String(me.Index)
+	}
+	names := expressionNames(me.KeyCondition, me.Filter)
+
+	var next func(context.Context) ([]map[string]types.AttributeValue, bool, error)
+	if me.KeyCondition != "" {
+		pages := dynamodb.NewQueryPaginator(cli, &dynamodb.QueryInput{
+			TableName:                 ptr.String(me.Table),
+			IndexName:                 index,
+			KeyConditionExpression:    ptr.String(me.KeyCondition),
+			FilterExpression:          filter,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: me.Values,
+			ScanIndexForward:          ptr.Bool(!me.Descending),
+			ConsistentRead:            ptr.Bool(me.Consistent),
+		})
+		next = func(ctx context.Context) ([]map[string]types.AttributeValue, bool, error) {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			return page.Items, pages.HasMorePages(), nil
+		}
+	} else {
+		pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{
+			TableName:                 ptr.String(me.Table),
+			IndexName:                 index,
+			FilterExpression:          filter,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: me.Values,
+			ConsistentRead:            ptr.Bool(me.Consistent),
+		})
+		next = func(ctx context.Context) ([]map[string]types.AttributeValue, bool, error) {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			return page.Items, pages.HasMorePages(), nil
+		}
+	}
+
+	items := []map[string]types.AttributeValue{}
+	for more := true; more && (me.Limit == 0 || len(items) < me.Limit); {
+		page, hasMore, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		more = hasMore
+	}
+	return items, nil
+}
+
+var _ snake.Snakeable = (*Put)(nil)
+
+type Put struct {
+	Target
+
+	table string
+	items []map[string]types.AttributeValue
+}
+
+func (me *Put) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "write items given as DynamoDB JSON or plain json objects",
+	}
+
+	cmd.Args = cobra.MinimumNArgs(2)
+
+	me.Target.flags(cmd)
+
+	return cmd
+}
+
+func (me *Put) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+
+	for i, arg := range args[1:] {
+		item, err := dynamodb_image.ParseItem([]byte(arg))
+		if err != nil {
+			return errors.Wrapf(err, "parsing item %d", i+1)
+		}
+		me.items = append(me.items, item)
+	}
+
+	return me.Target.parse()
+}
+
+func (me *Put) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range me.items {
+		if _, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String(me.table), Item: item}); err != nil {
+			return errors.Wrapf(err, "putting item %d", i+1)
+		}
+	}
+
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "put %d items into %s\n", len(me.items), me.table)
+	return err
+}
@@ -0,0 +1,342 @@
+package dynamo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/walteh/snake"
+
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+const replHelp = `commands:
+  SELECT|INSERT|UPDATE|DELETE|EXISTS ...    run a PartiQL statement
+  query <table> -k <key condition> [--filter <expr>] [-i <index>] [--desc] [--consistent] [-l <n>]
+  scan <table> [--filter <expr>] [-i <index>] [--consistent] [-l <n>]
+  \set :name <value>                        set a placeholder, the value is json or a plain string
+  \unset :name                              forget a placeholder
+  \vars                                     print the placeholders
+  \history                                  print the history, !n runs entry n again
+  \help                                     print this help
+  \quit                                     leave
+
+Expressions refer to attributes as #name and to placeholders as :name. In PartiQL
+statements :name is sent as a parameter. End a line with \ to continue it on the next.
+`
+
+var _ snake.Snakeable = (*Repl)(nil)
+
+type Repl struct {
+	Target
+	Selection
+
+	History string
+
+	cli     *dynamodb.Client
+	usage   *dynamodb_image.Usage
+	out     io.Writer
+	vars    map[string]types.AttributeValue
+	history []string
+}
+
+func (me *Repl) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "run PartiQL statements, queries and scans against the fixture interactively",
+		Long:  "Reads commands from stdin and prints their items and consumed capacity.\n\n" + replHelp,
+	}
+
+	cmd.Args = cobra.NoArgs
+
+	me.Target.flags(cmd)
+	me.Selection.flags(cmd)
+
+	history := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		history = filepath.Join(home, ".testrc_dynamo_history")
+	}
+	cmd.Flags().StringVar(&me.History, "history", history, "Keep the history in this file, empty keeps it in memory")
+
+	return cmd
+}
+
+func (me *Repl) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	return me.Target.parse()
+}
+
+func (me *Repl) Run(ctx context.Context, cmd *cobra.Command) error {
+	me.usage = dynamodb_image.NewUsage()
+	cli, err := me.client(ctx, me.usage.ClientOption())
+	if err != nil {
+		return err
+	}
+	me.cli, me.out, me.vars = cli, cmd.OutOrStdout(), map[string]types.AttributeValue{}
+
+	if me.History != "" {
+		if b, err := os.ReadFile(me.History); err == nil {
+			for _, l := range strings.Split(string(b), "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					me.history = append(me.history, l)
+				}
+			}
+		}
+	}
+
+	sc := bufio.NewScanner(cmd.InOrStdin())
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	pending := ""
+	for {
+		if pending == "" {
+			fmt.Fprint(me.out, "dynamo> ")
+		} else {
+			fmt.Fprint(me.out, "     -> ")
+		}
+		if !sc.Scan() {
+			fmt.Fprintln(me.out)
+			return sc.Err()
+		}
+
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasSuffix(line, `\`) {
+			pending += strings.TrimSpace(strings.TrimSuffix(line, `\`)) + " "
+			continue
+		}
+		line, pending = strings.TrimSpace(pending+line), ""
+
+		switch line {
+		case "":
+			continue
+		case `\quit`, `\q`, "quit", "exit":
+			return nil
+		}
+
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(me.history) {
+				fmt.Fprintf(me.out, "error: no history entry %s\n", line[1:])
+				continue
+			}
+			line = me.history[n-1]
+			fmt.Fprintln(me.out, line)
+		}
+		if line != `\history` {
+			me.remember(line)
+		}
+
+		if err := me.exec(ctx, line); err != nil {
+			fmt.Fprintf(me.out, "error: %s\n", err)
+		}
+	}
+}
+
+func (me *Repl) remember(line string) {
+	me.history = append(me.history, line)
+	if me.History == "" {
+		return
+	}
+	f, err := os.OpenFile(me.History, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		fmt.Fprintf(me.out, "error: writing history: %s\n", err)
+		return
+	}
+	defer f.Close()
+	_, _ = fmt.Fprintln(f, line)
+}
+
+func (me *Repl) exec(ctx context.Context, line string) error {
+	word, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	switch strings.ToLower(word) {
+	case `\set`:
+		name, value, _ := strings.Cut(rest, " ")
+		if !strings.HasPrefix(name, ":") || len(name) < 2 {
+			return errors.New(`usage: \set :name <value>`)
+		}
+		v, err := placeholderValue(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		me.vars[name] = v
+		return nil
+	case `\unset`:
+		delete(me.vars, rest)
+		return nil
+	case `\vars`:
+		b, err := dynamodb_image.MarshalItemJSON(me.vars)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(me.out, "%s\n", b)
+		return err
+	case `\history`:
+		for i, h := range me.history {
+			fmt.Fprintf(me.out, "%4d  %s\n", i+1, h)
+		}
+		return nil
+	case `\help`:
+		_, err := fmt.Fprint(me.out, replHelp)
+		return err
+	case "query", "scan":
+		return me.read(ctx, strings.ToLower(word), rest)
+	}
+	if strings.HasPrefix(word, `\`) {
+		return errors.Errorf(`unknown command %s, see \help`, word)
+	}
+	return me.statement(ctx, line)
+}
+
+// placeholderValue parses the value of \set as json, plain or DynamoDB JSON, and takes
+// anything else as a string.
+func placeholderValue(s string) (types.AttributeValue, error) {
+	if s == "" {
+		return nil, errors.New(`usage: \set :name <value>`)
+	}
+	it, err := dynamodb_image.ParseItem([]byte(`{"v": ` + s + `}`))
+	if err != nil {
+		return &types.AttributeValueMemberS{Value: s}, nil
+	}
+	return it["v"], nil
+}
+
+var valuePlaceholder = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// values returns the placeholders the expressions use.
+func (me *Repl) values(exprs ...string) (map[string]types.AttributeValue, error) {
+	values := map[string]types.AttributeValue{}
+	for _, e := range exprs {
+		for _, name := range valuePlaceholder.FindAllString(e, -1) {
+			v, ok := me.vars[name]
+			if !ok {
+				return nil, errors.Errorf(`placeholder %s is not set, use \set %s <value>`, name, name)
+			}
+			values[name] = v
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values, nil
+}
+
+func (me *Repl) read(ctx context.Context, op string, line string) error {
+	args, err := shlex.Split(line)
+	if err != nil {
+		return err
+	}
+
+	fs := pflag.NewFlagSet(op, pflag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	keyCondition := fs.StringP("key-condition", "k", "", "")
+	filter := fs.String("filter", "", "")
+	index := fs.StringP("index", "i", "", "")
+	desc := fs.Bool("desc", false, "")
+	consistent := fs.Bool("consistent", false, "")
+	limit := fs.IntP("limit", "l", me.Limit, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.Errorf(`usage: %s <table> [flags], see \help`, op)
+	}
+	table := fs.Arg(0)
+	if op == "query" && *keyCondition == "" {
+		return errors.New("query needs a key condition, -k '#pk = :pk'")
+	}
+	if op == "scan" && *keyCondition != "" {
+		return errors.New("scan takes no key condition, use query")
+	}
+
+	values, err := me.values(*keyCondition, *filter)
+	if err != nil {
+		return err
+	}
+
+	me.usage.Reset()
+	start := time.Now()
+	items, err := (&readRequest{
+		Table:        table,
+		KeyCondition: *keyCondition,
+		Filter:       *filter,
+		Index:        *index,
+		Values:       values,
+		Descending:   *desc,
+		Consistent:   *consistent,
+		Limit:        *limit,
+	}).items(ctx, me.cli)
+	if err != nil {
+		return err
+	}
+
+	title := "Query: " + table
+	if op == "scan" {
+		title = "Scan: " + table
+	}
+	return me.print(ctx, title, table, items, true, time.Since(start), *limit)
+}
+
+func (me *Repl) statement(ctx context.Context, line string) error {
+	stmt, params, err := dynamodb_image.BindPartiQL(line, me.vars)
+	if err != nil {
+		return err
+	}
+
+	me.usage.Reset()
+	start := time.Now()
+	items := []map[string]types.AttributeValue{}
+	input := &dynamodb.ExecuteStatementInput{Statement: ptr.String(stmt), Parameters: params}
+	for {
+		out, err := me.cli.ExecuteStatement(ctx, input)
+		if err != nil {
+			return err
+		}
+		items = append(items, out.Items...)
+		if out.NextToken == nil || (me.Limit > 0 && len(items) >= me.Limit) {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	word, _, _ := strings.Cut(stmt, " ")
+	table := ""
+	if m := partiqlTable.FindStringSubmatch(stmt); m != nil {
+		table = m[1] + m[2]
+	}
+	return me.print(ctx, "PartiQL: "+table, table, items, strings.EqualFold(word, "SELECT"), time.Since(start), me.Limit)
+}
+
+var partiqlTable = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+(?:"([^"]+)"|([A-Za-z0-9_.-]+))`)
+
+// print writes the items, unless a statement returned none, and what the call cost.
+func (me *Repl) print(ctx context.Context, title, table string, items []map[string]types.AttributeValue, always bool, elapsed time.Duration, limit int) error {
+	rep := me.usage.Report()
+
+	if always || len(items) > 0 {
+		var keys []string
+		if table != "" {
+			keys, _ = tableKeys(ctx, me.cli, table)
+		}
+		opts := append(me.Selection.options(me.format), dynamodb_image.WithLimit(limit))
+		if err := dynamodb_image.FprintItems(me.out, title, items, keys, opts...); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(me.out, "%d items, %d calls, %g read units, %g write units, %s\n",
+		len(items), rep.Calls, rep.ReadUnits, rep.WriteUnits, elapsed.Round(time.Millisecond))
+	return err
+}
@@ -0,0 +1,159 @@
+package dynamo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+var _ snake.Snakeable = (*Tables)(nil)
+
+type Tables struct {
+	Target
+}
+
+func (me *Tables) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "list the tables with their keys, indexes and item counts",
+	}
+
+	cmd.Args = cobra.ExactArgs(0)
+
+	me.Target.flags(cmd)
+
+	return cmd
+}
+
+func (me *Tables) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	return me.Target.parse()
+}
+
+func (me *Tables) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := []map[string]types.AttributeValue{}
+
+	pages := dynamodb.NewListTablesPaginator(cli, &dynamodb.ListTablesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range page.TableNames {
+			desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(name)})
+			if err != nil {
+				return err
+			}
+			rows = append(rows, tableRow(desc.Table))
+		}
+	}
+
+	return dynamodb_image.FprintItems(cmd.OutOrStdout(), "Tables", rows, []string{"table"},
+		dynamodb_image.WithFormat(me.format),
+		dynamodb_image.WithAttributes("table", "status", "keys", "items", "indexes", "stream"),
+	)
+}
+
+func tableRow(t *types.TableDescription) map[string]types.AttributeValue {
+	indexes := []string{}
+	for _, g := range t.GlobalSecondaryIndexes {
+		indexes = append(indexes, fmt.Sprintf("%s(%s)", *g.IndexName, keySchema(g.KeySchema)))
+	}
+	for _, l := range t.LocalSecondaryIndexes {
+		indexes = append(indexes, fmt.Sprintf("%s(%s)", *l.IndexName, keySchema(l.KeySchema)))
+	}
+
+	stream := ""
+	if t.StreamSpecification != nil && ptr.ToBool(t.StreamSpecification.StreamEnabled) {
+		stream = string(t.StreamSpecification.StreamViewType)
+	}
+
+	return map[string]types.AttributeValue{
+		"table":   &types.AttributeValueMemberS{Value: ptr.ToString(t.TableName)},
+		"status":  &types.AttributeValueMemberS{Value: string(t.TableStatus)},
+		"keys":    &types.AttributeValueMemberS{Value: keySchema(t.KeySchema)},
+		"items":   &types.AttributeValueMemberN{Value: fmt.Sprint(ptr.ToInt64(t.ItemCount))},
+		"indexes": &types.AttributeValueMemberS{Value: strings.Join(indexes, ", ")},
+		"stream":  &types.AttributeValueMemberS{Value: stream},
+	}
+}
+
+func keySchema(ks []types.KeySchemaElement) string {
+	names := make([]string, 0, len(ks))
+	for _, k := range ks {
+		names = append(names, *k.AttributeName)
+	}
+	return strings.Join(names, ", ")
+}
+
+// tableKeys returns the key attribute names of the table, hash key first.
+func tableKeys(ctx context.Context, cli *dynamodb.Client, table string) ([]string, error) {
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(table)})
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, typ := range []types.KeyType{types.KeyTypeHash, types.KeyTypeRange} {
+		for _, k := range desc.Table.KeySchema {
+			if k.KeyType == typ {
+				keys = append(keys, *k.AttributeName)
+			}
+		}
+	}
+	return keys, nil
+}
+
+var _ snake.Snakeable = (*Describe)(nil)
+
+type Describe struct {
+	Target
+
+	table string
+}
+
+func (me *Describe) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print the table, ttl, backup and tag descriptions of a table as json",
+	}
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	me.Target.flags(cmd)
+
+	return cmd
+}
+
+func (me *Describe) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+	me.table = args[0]
+	return me.Target.parse()
+}
+
+func (me *Describe) Run(ctx context.Context, cmd *cobra.Command) error {
+	cli, err := me.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	desc, err := dynamodb_image.DescribeProvisioned(ctx, cli, me.table)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	if me.format != dynamodb_image.FormatJSONL {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(desc)
+}
@@ -0,0 +1,73 @@
+package env
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+	"github.com/walteh/testrc/pkg/docker"
+
+	_ "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+var _ snake.Snakeable = (*Handler)(nil)
+
+type Handler struct {
+	Format   string
+	Session  string
+	Services []string
+}
+
+func (me *Handler) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "print endpoint environment variables for running fixtures",
+	}
+
+	cmd.Args = cobra.ExactArgs(0)
+
+	formats := make([]string, 0, len(docker.EnvFormats))
+	for _, f := range docker.EnvFormats {
+		formats = append(formats, string(f))
+	}
+
+	cmd.PersistentFlags().StringVarP(&me.Format, "format", "f", string(docker.EnvFormatShell), "Output format ("+strings.Join(formats, ", ")+")")
+	cmd.PersistentFlags().StringVarP(&me.Session, "session", "s", "", "Only include fixtures from this session (defaults to $"+docker.SessionEnv+")")
+	cmd.PersistentFlags().StringSliceVar(&me.Services, "services", nil, "Only include these services ("+strings.Join(docker.Services(), ", ")+")")
+
+	return cmd
+}
+
+func (me *Handler) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+
+	if me.Session == "" {
+		me.Session = os.Getenv(docker.SessionEnv)
+	}
+
+	for _, f := range docker.EnvFormats {
+		if string(f) == me.Format {
+			return nil
+		}
+	}
+
+	return errors.Errorf("unknown format %q", me.Format)
+}
+
+func (me *Handler) Run(ctx context.Context, cmd *cobra.Command) error {
+
+	stores, err := docker.Attach(ctx, docker.AttachOptions{
+		Session:  me.Session,
+		Services: me.Services,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(stores) == 0 {
+		return errors.New("no running fixtures found")
+	}
+
+	return docker.WriteEnv(cmd.OutOrStdout(), docker.MergeEnv(stores...), docker.EnvFormat(me.Format))
+}
@@ -10,7 +10,10 @@ import (
 
 	myversion "github.com/walteh/buildrc/version"
 	"github.com/walteh/snake"
+	"github.com/walteh/testrc/cmd/root/dynamo"
+	"github.com/walteh/testrc/cmd/root/env"
 	"github.com/walteh/testrc/cmd/root/install"
+	"github.com/walteh/testrc/cmd/root/run"
 )
 
 type Root struct {
@@ -35,6 +38,10 @@ func (me *Root) BuildCommand(ctx context.Context) *cobra.Command {
 	cmd.PersistentFlags().StringVarP(&me.GitDir, "git-dir", "g", ".", "The git directory to use")
 
 	snake.MustNewCommand(ctx, cmd, "install", &install.Handler{})
+	snake.MustNewCommand(ctx, cmd, "env", &env.Handler{})
+	snake.MustNewCommand(ctx, cmd, "run", &run.Handler{})
+
+	dynamo.MustAddGroup(ctx, cmd)
 
 	cmd.SetOutput(os.Stdout)
 
@@ -0,0 +1,175 @@
+package run
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/walteh/snake"
+	"github.com/walteh/testrc/pkg/docker"
+
+	_ "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+var _ snake.Snakeable = (*Handler)(nil)
+
+type Handler struct {
+	Services []string
+	Session  string
+
+	command []string
+}
+
+func (me *Handler) BuildCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "start fixtures, run a command against them and tear them down",
+		Long: "Starts the requested fixtures, runs the command after -- with their endpoint " +
+			"environment variables set, forwards signals to it, removes the fixtures when it " +
+			"exits and exits with its status.",
+		Example: "  testrc run --services dynamodb -- go test ./...",
+	}
+
+	// the child command's flags must reach it untouched, so flag parsing stops at --
+	// or the first argument that is not a flag
+	cmd.Flags().SetInterspersed(false)
+
+	cmd.PersistentFlags().StringSliceVar(&me.Services, "services", nil, "Fixtures to start, defaults to all registered services, --services= starts none")
+	cmd.PersistentFlags().StringVarP(&me.Session, "session", "s", "", "Session name to label the fixtures with, defaults to a random one")
+
+	return cmd
+}
+
+func (me *Handler) ParseArguments(ctx context.Context, cmd *cobra.Command, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("no command given, use testrc run -- <command>")
+	}
+
+	me.command = args
+
+	if !cmd.Flags().Changed("services") {
+		me.Services = docker.Services()
+	}
+
+	for _, name := range me.Services {
+		if _, err := docker.Lookup(name); err != nil {
+			return err
+		}
+	}
+
+	if me.Session == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		me.Session = "run-" + hex.EncodeToString(b)
+	}
+
+	return nil
+}
+
+func (me *Handler) Run(ctx context.Context, cmd *cobra.Command) error {
+
+	if err := os.Setenv(docker.SessionEnv, me.Session); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().Strs("services", me.Services).Str("session", me.Session).Msg("starting fixtures")
+
+	stores, err := docker.RollServices(ctx, me.Services...)
+	if err != nil {
+		return err
+	}
+
+	code, runErr := me.runChild(ctx, stores)
+
+	for _, s := range stores {
+		if err := s.Close(); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("service", s.Service()).Msg("could not remove fixture")
+		}
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+
+	if code != 0 {
+		cmd.SilenceUsage = true
+		return &ExitError{Code: code}
+	}
+
+	return nil
+}
+
+// ExitError is returned when the command exits with a non-zero status, which testrc
+// exits with too.
+type ExitError struct {
+	Code int
+}
+
+func (me *ExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", me.Code)
+}
+
+func (me *Handler) runChild(ctx context.Context, stores []*docker.ContainerStore) (int, error) {
+
+	env := docker.MergeEnv(stores...)
+	env[docker.SessionEnv] = me.Session
+
+	child := exec.Command(me.command[0], me.command[1:]...)
+	child.Env = append(os.Environ(), docker.EnvList(env)...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	// forward signals instead of letting them kill us before the fixtures are removed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	if err := child.Start(); err != nil {
+		return 0, errors.Wrapf(err, "starting %s", me.command[0])
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				zerolog.Ctx(ctx).Debug().Str("signal", sig.String()).Msg("forwarding signal")
+				_ = child.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := child.Wait()
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code, nil
+		}
+		// killed by a signal, reported as 128+n like shells do
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return 1, nil
+	}
+
+	return 0, err
+}
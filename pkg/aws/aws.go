@@ -33,3 +33,15 @@ func V2Config() aws.Config {
 
 	return *cfg
 }
+
+// EnvVars are the environment variables that make an aws sdk in another process
+// use the same region and credentials as V2Config.
+func EnvVars() map[string]string {
+	return map[string]string{
+		"AWS_REGION":            "us-east-1",
+		"AWS_DEFAULT_REGION":    "us-east-1",
+		"AWS_ACCESS_KEY_ID":     "test",
+		"AWS_SECRET_ACCESS_KEY": "test",
+		"AWS_SESSION_TOKEN":     "test",
+	}
+}
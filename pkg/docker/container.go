@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -23,6 +25,17 @@ type ContainerImage interface {
 	OnStart(z *ContainerStore)
 }
 
+// ContainerPorts can be implemented by images that expose more than their http and https ports.
+type ContainerPorts interface {
+	ExposedPorts() []string
+}
+
+// ContainerCommand can be implemented by images that need a command with arguments
+// that can not be expressed through the "cmd=" env var convention.
+type ContainerCommand interface {
+	Cmd() []string
+}
+
 type ContainerStore struct {
 	http     string
 	https    string
@@ -30,9 +43,22 @@ type ContainerStore struct {
 	resource *dockertest.Resource
 	ready    chan error
 	pool     *dockertest.Pool
+	ctx      context.Context
+
+	stats         atomic.Pointer[statsRecorder]
+	statsOnce     sync.Once
+	stopStatsOnce sync.Once
+
+	timings   ContainerTimings
+	timingsMu sync.Mutex
+
+	service string
 }
 
 func (c *ContainerStore) Ready() error {
+	if c.ready == nil {
+		return nil
+	}
 	return <-c.ready
 }
 
@@ -44,16 +70,21 @@ func (me *ContainerStore) GetHttpsHost() string {
 	return strings.Replace(me.https, "https://", "", 1)
 }
 
+// GetHostPort returns the "host:port" address a container port (e.g. "8000/tcp") is published on.
+func (me *ContainerStore) GetHostPort(port string) string {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+	if me.resource == nil {
+		return ""
+	}
+	return me.resource.GetHostPort(port)
+}
+
 func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 	startTime := time.Now()
 
-	endpoint := os.Getenv("DOCKER_HOST")
-
-	if endpoint == "" {
-		endpoint = "unix:///var/run/docker.sock"
-	}
-
-	p, err := dockertest.NewPool(endpoint)
+	p, err := dockertest.NewPool(dockerEndpoint())
 	if err != nil {
 		log.Fatalf("Could not construct pool: %s", err)
 	}
@@ -81,25 +112,50 @@ func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 			filteredEnvVars = append(filteredEnvVars, envVar)
 		}
 	}
-	var r, tag string
-	splt := strings.Split(reg.Tag(), ":")
-	if len(splt) == 2 {
-		r = splt[0]
-		tag = splt[1]
-	} else {
-		r = reg.Tag()
-		tag = "latest"
+	if c, ok := reg.(ContainerCommand); ok {
+		cmdArgs = c.Cmd()
+	}
+
+	exposedPorts := []string{fmt.Sprintf("%d/tcp", reg.HttpPort()), fmt.Sprintf("%d/tcp", reg.HttpsPort())}
+	if c, ok := reg.(ContainerPorts); ok {
+		for _, port := range c.ExposedPorts() {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			exposedPorts = append(exposedPorts, port)
+		}
+	}
+
+	r, tag := splitTag(reg.Tag())
+
+	timings := ContainerTimings{
+		Image:  reg.Tag(),
+		Budget: budgetFor(ctx, reg),
+	}
+
+	// pull explicitly so the pull time is not counted against the start budget
+	if _, err := p.Client.InspectImage(reg.Tag()); err != nil {
+		pullStart := time.Now()
+		zerolog.Ctx(ctx).Info().Msg("Pulling image")
+		if err := p.Client.PullImage(docker.PullImageOptions{Repository: r, Tag: tag}, docker.AuthConfiguration{}); err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Could not pull image")
+			return nil, err
+		}
+		timings.Pull = time.Since(pullStart)
 	}
 
 	zerolog.Ctx(ctx).Info().Msg("Creating new container")
 
+	runStart := time.Now()
+
 	// Create the container
 	resource, err := p.RunWithOptions(&dockertest.RunOptions{
 		Repository:   r,
 		Tag:          tag,
 		Env:          filteredEnvVars,
-		ExposedPorts: []string{fmt.Sprintf("%d/tcp", reg.HttpPort()), fmt.Sprintf("%d/tcp", reg.HttpsPort())},
+		ExposedPorts: exposedPorts,
 		Cmd:          cmdArgs,
+		Labels:       labelsFor(reg),
 	}, func(hc *docker.HostConfig) {
 		hc.AutoRemove = true
 	})
@@ -108,6 +164,10 @@ func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 		return nil, err
 	}
 
+	runDone := time.Now()
+	timings.Container = resource.Container.ID
+	timings.Create, timings.Start = splitRunDuration(runStart, runDone, resource.Container.Created, resource.Container.State.StartedAt)
+
 	// Set expiration for the resource
 	if err := resource.Expire(600); err != nil {
 		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Could not set expiration")
@@ -124,6 +184,9 @@ func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 		resource: resource,
 		ready:    make(chan error),
 		pool:     p,
+		ctx:      ctx,
+		timings:  timings,
+		service:  serviceName(reg),
 	}
 
 	reg.OnStart(newContainer)
@@ -142,6 +205,23 @@ func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 		}); err != nil {
 			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Could not connect to Docker")
 		}
+
+		newContainer.timingsMu.Lock()
+		newContainer.timings.Ready = time.Since(runDone)
+		final := newContainer.timings
+		newContainer.timingsMu.Unlock()
+
+		recordTimings(final)
+
+		ev := zerolog.Ctx(ctx).Info()
+		if final.OverStartBudget() || final.OverReadyBudget() {
+			ev = zerolog.Ctx(ctx).Warn().
+				Dur("start_budget", final.Budget.Start).
+				Dur("ready_budget", final.Budget.Ready)
+		}
+		ev.EmbedObject(&final).
+			Dur("elapsedTime", time.Since(startTime)).
+			Msg("Mock container ready")
 	}()
 
 	zerolog.Ctx(ctx).Info().
@@ -151,6 +231,43 @@ func Roll(ctx context.Context, reg ContainerImage) (*ContainerStore, error) {
 	return newContainer, nil
 }
 
+func dockerEndpoint() string {
+	endpoint := os.Getenv("DOCKER_HOST")
+
+	if endpoint == "" {
+		endpoint = "unix:///var/run/docker.sock"
+	}
+
+	return endpoint
+}
+
 func (me *ContainerStore) Close() error {
+	if me.stats.Load() != nil {
+		me.stopStats()
+		sum := me.Stats()
+		zerolog.Ctx(me.ctx).Info().EmbedObject(&sum).Msg("container stats")
+	}
+	if me.pool == nil || me.resource == nil {
+		return nil
+	}
 	return me.pool.Purge(me.resource)
 }
+
+// splitTag splits an image reference into repository and tag, keeping registry ports intact.
+func splitTag(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// splitRunDuration splits the time spent in RunWithOptions into create and start
+// using the timestamps reported by the daemon, falling back to counting it all as
+// create when the daemon clock does not line up with ours.
+func splitRunDuration(begin, end, created, started time.Time) (create time.Duration, start time.Duration) {
+	total := end.Sub(begin)
+	if created.Before(begin) || started.Before(created) || started.After(end) {
+		return total, 0
+	}
+	return created.Sub(begin), end.Sub(created)
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContainerEndpoints can be implemented by images to describe how clients reach
+// the running container, e.g. AWS_ENDPOINT_URL_DYNAMODB=http://localhost:32768.
+type ContainerEndpoints interface {
+	EndpointEnv(z *ContainerStore) map[string]string
+}
+
+// Env returns the environment variables that point clients at this container.
+// Images that do not implement ContainerEndpoints get generic TESTRC_<SERVICE>_HTTP
+// style variables when they are registered, and nothing otherwise.
+func (me *ContainerStore) Env() map[string]string {
+	if e, ok := me.image.(ContainerEndpoints); ok {
+		return e.EndpointEnv(me)
+	}
+
+	if me.service == "" {
+		return map[string]string{}
+	}
+
+	prefix := "TESTRC_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(me.service))
+	return map[string]string{
+		prefix + "_HTTP":  me.GetHttpHost(),
+		prefix + "_HTTPS": me.https,
+	}
+}
+
+// MergeEnv combines the env of several containers. Later containers win on conflicts.
+func MergeEnv(stores ...*ContainerStore) map[string]string {
+	env := map[string]string{}
+	for _, s := range stores {
+		for k, v := range s.Env() {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// EnvList returns env as sorted KEY=VALUE pairs, ready for exec.Cmd.Env.
+func EnvList(env map[string]string) []string {
+	out := make([]string, 0, len(env))
+	for _, k := range sortedKeys(env) {
+		out = append(out, k+"="+env[k])
+	}
+	return out
+}
+
+type EnvFormat string
+
+const (
+	EnvFormatShell  EnvFormat = "shell"
+	EnvFormatDotenv EnvFormat = "dotenv"
+	EnvFormatJSON   EnvFormat = "json"
+)
+
+var EnvFormats = []EnvFormat{EnvFormatShell, EnvFormatDotenv, EnvFormatJSON}
+
+// WriteEnv writes env in the given format: shell `export` lines, a dotenv file or a json object.
+func WriteEnv(w io.Writer, env map[string]string, format EnvFormat) error {
+	switch format {
+	case EnvFormatShell:
+		for _, k := range sortedKeys(env) {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", k, shellQuote(env[k])); err != nil {
+				return err
+			}
+		}
+	case EnvFormatDotenv:
+		for _, k := range sortedKeys(env) {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", k, dotenvQuote(env[k])); err != nil {
+				return err
+			}
+		}
+	case EnvFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(env)
+	default:
+		return errors.Errorf("unknown env format %q", format)
+	}
+	return nil
+}
+
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func dotenvQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'#$\\=") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`).Replace(s) + `"`
+}
@@ -0,0 +1,209 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/pkg/errors"
+)
+
+const (
+	LabelManaged = "testrc.managed"
+	LabelService = "testrc.service"
+	LabelImage   = "testrc.image"
+	LabelSession = "testrc.session"
+
+	// SessionEnv groups the containers started by one process (or one `testrc run`)
+	// so they can be found again from the cli.
+	SessionEnv = "TESTRC_SESSION"
+)
+
+var registry = struct {
+	sync.Mutex
+	images map[string]func() ContainerImage
+}{images: map[string]func() ContainerImage{}}
+
+// Register makes an image module available by service name to the cli and to Attach.
+// Image packages call it from init.
+func Register(name string, fn func() ContainerImage) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.images[name] = fn
+}
+
+// Lookup returns a new instance of the image registered under name.
+func Lookup(name string) (ContainerImage, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	fn, ok := registry.images[name]
+	if !ok {
+		return nil, errors.Errorf("unknown service %q, known services are %s", name, strings.Join(servicesLocked(), ", "))
+	}
+	return fn(), nil
+}
+
+// Services returns the names of all registered images.
+func Services() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	return servicesLocked()
+}
+
+func servicesLocked() []string {
+	names := make([]string, 0, len(registry.images))
+	for k := range registry.images {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func serviceName(img ContainerImage) string {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, name := range servicesLocked() {
+		if registry.images[name]().Tag() == img.Tag() {
+			return name
+		}
+	}
+	return ""
+}
+
+func labelsFor(img ContainerImage) map[string]string {
+	return map[string]string{
+		LabelManaged: "true",
+		LabelService: serviceName(img),
+		LabelImage:   img.Tag(),
+		LabelSession: os.Getenv(SessionEnv),
+	}
+}
+
+// Service is the registered name of the image this container was started from, if any.
+func (me *ContainerStore) Service() string {
+	return me.service
+}
+
+type AttachOptions struct {
+	// Session only matches containers started with the same TESTRC_SESSION, empty matches all.
+	Session string
+	// Services only matches the named services, empty matches all.
+	Services []string
+}
+
+// Attach finds fixtures that are already running (e.g. started by a paused test or by
+// `testrc run`) and hands them to a fresh instance of their registered image, so its
+// helpers can be used against the running container. Containers whose image is not
+// registered are skipped. Closing an attached store removes its container just like a
+// store returned by Roll, so only do that to tear the fixture down.
+func Attach(ctx context.Context, opts AttachOptions) ([]*ContainerStore, error) {
+	p, err := dockertest.NewPool(dockerEndpoint())
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to docker")
+	}
+
+	filters := []string{LabelManaged + "=true"}
+	if opts.Session != "" {
+		filters = append(filters, LabelSession+"="+opts.Session)
+	}
+
+	containers, err := p.Client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": filters},
+		Context: ctx,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing containers")
+	}
+
+	want := map[string]bool{}
+	for _, s := range opts.Services {
+		want[s] = true
+	}
+
+	stores := make([]*ContainerStore, 0, len(containers))
+	for _, c := range containers {
+		name := c.Labels[LabelService]
+		if name == "" || (len(want) > 0 && !want[name]) {
+			continue
+		}
+
+		img, err := Lookup(name)
+		if err != nil {
+			continue
+		}
+
+		if len(c.Names) == 0 {
+			continue
+		}
+
+		resource, ok := p.ContainerByName(fmt.Sprintf("^%s$", c.Names[0]))
+		if !ok {
+			continue
+		}
+
+		store := &ContainerStore{
+			http:     fmt.Sprintf("http://%s", resource.GetHostPort(fmt.Sprintf("%d/tcp", img.HttpPort()))),
+			https:    fmt.Sprintf("https://%s", resource.GetHostPort(fmt.Sprintf("%d/tcp", img.HttpsPort()))),
+			image:    img,
+			resource: resource,
+			ready:    make(chan error, 1),
+			pool:     p,
+			ctx:      ctx,
+			service:  name,
+			timings:  ContainerTimings{Image: img.Tag(), Container: c.ID},
+		}
+		store.ready <- nil
+
+		img.OnStart(store)
+
+		stores = append(stores, store)
+	}
+
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].service < stores[j].service
+	})
+
+	return stores, nil
+}
+
+// RollServices starts the named registered images and waits for all of them to be
+// ready. If any of them fails to start, the ones already started are removed.
+func RollServices(ctx context.Context, names ...string) ([]*ContainerStore, error) {
+	stores := make([]*ContainerStore, 0, len(names))
+
+	cleanup := func() {
+		for _, s := range stores {
+			_ = s.Close()
+		}
+	}
+
+	for _, name := range names {
+		img, err := Lookup(name)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		store, err := Roll(ctx, img)
+		if err != nil {
+			cleanup()
+			return nil, errors.Wrapf(err, "starting %s", name)
+		}
+
+		stores = append(stores, store)
+	}
+
+	for i, store := range stores {
+		if err := store.Ready(); err != nil {
+			cleanup()
+			return nil, errors.Wrapf(err, "waiting for %s", names[i])
+		}
+	}
+
+	return stores, nil
+}
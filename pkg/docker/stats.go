@@ -0,0 +1,223 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ory/dockertest/v3/docker"
+)
+
+const DefaultStatsInterval = time.Second
+
+// StatsSample is a single reading from the docker stats api.
+type StatsSample struct {
+	Time        time.Time `json:"time"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemoryUsage uint64    `json:"memory_usage"`
+	MemoryLimit uint64    `json:"memory_limit"`
+	NetworkRx   uint64    `json:"network_rx"`
+	NetworkTx   uint64    `json:"network_tx"`
+	BlockRead   uint64    `json:"block_read"`
+	BlockWrite  uint64    `json:"block_write"`
+}
+
+// StatsSummary aggregates every sample taken while the container was alive.
+// Network and block io values are the totals observed at the last sample.
+type StatsSummary struct {
+	Image         string        `json:"image"`
+	Container     string        `json:"container"`
+	Samples       int           `json:"samples"`
+	Duration      time.Duration `json:"duration"`
+	CPUAvgPercent float64       `json:"cpu_avg_percent"`
+	CPUMaxPercent float64       `json:"cpu_max_percent"`
+	MemoryAvg     uint64        `json:"memory_avg"`
+	MemoryMax     uint64        `json:"memory_max"`
+	MemoryLimit   uint64        `json:"memory_limit"`
+	NetworkRx     uint64        `json:"network_rx"`
+	NetworkTx     uint64        `json:"network_tx"`
+	BlockRead     uint64        `json:"block_read"`
+	BlockWrite    uint64        `json:"block_write"`
+}
+
+var _ zerolog.LogObjectMarshaler = (*StatsSummary)(nil)
+
+func (me *StatsSummary) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("image", me.Image).
+		Str("container", me.Container).
+		Int("samples", me.Samples).
+		Dur("duration", me.Duration).
+		Float64("cpu_avg_percent", me.CPUAvgPercent).
+		Float64("cpu_max_percent", me.CPUMaxPercent).
+		Uint64("memory_avg", me.MemoryAvg).
+		Uint64("memory_max", me.MemoryMax).
+		Uint64("memory_limit", me.MemoryLimit).
+		Uint64("network_rx", me.NetworkRx).
+		Uint64("network_tx", me.NetworkTx).
+		Uint64("block_read", me.BlockRead).
+		Uint64("block_write", me.BlockWrite)
+}
+
+type statsRecorder struct {
+	mu      sync.Mutex
+	samples []StatsSample
+	done    chan bool
+	stopped chan struct{}
+}
+
+// SampleStats starts recording resource usage for the container every interval
+// until the container is closed or ctx is cancelled. Calling it more than once, or on a
+// store that did not start its container, is a no-op.
+func (me *ContainerStore) SampleStats(ctx context.Context, interval time.Duration) {
+	if me.pool == nil || me.resource == nil || me.resource.Container == nil {
+		return
+	}
+	me.statsOnce.Do(func() {
+		if interval <= 0 {
+			interval = DefaultStatsInterval
+		}
+
+		rec := &statsRecorder{
+			done:    make(chan bool),
+			stopped: make(chan struct{}),
+		}
+		me.stats.Store(rec)
+
+		ch := make(chan *docker.Stats)
+
+		go func() {
+			err := me.pool.Client.Stats(docker.StatsOptions{
+				ID:      me.resource.Container.ID,
+				Stats:   ch,
+				Stream:  true,
+				Done:    rec.done,
+				Context: ctx,
+			})
+			if err != nil && ctx.Err() == nil {
+				zerolog.Ctx(ctx).Debug().Err(err).Msg("stats stream ended")
+			}
+		}()
+
+		go func() {
+			defer close(rec.stopped)
+			var last time.Time
+			for s := range ch {
+				if !last.IsZero() && s.Read.Sub(last) < interval {
+					continue
+				}
+				last = s.Read
+				sample := NewStatsSample(s)
+				rec.mu.Lock()
+				rec.samples = append(rec.samples, sample)
+				rec.mu.Unlock()
+				zerolog.Ctx(ctx).Trace().
+					Float64("cpu_percent", sample.CPUPercent).
+					Uint64("memory_usage", sample.MemoryUsage).
+					Msg("container stats sample")
+			}
+		}()
+	})
+}
+
+// Stats returns a summary of the samples recorded so far.
+func (me *ContainerStore) Stats() StatsSummary {
+	var samples []StatsSample
+	if rec := me.stats.Load(); rec != nil {
+		rec.mu.Lock()
+		samples = append(samples, rec.samples...)
+		rec.mu.Unlock()
+	}
+
+	sum := SummarizeStats(samples)
+	sum.Image = me.image.Tag()
+	if me.resource != nil && me.resource.Container != nil {
+		sum.Container = me.resource.Container.ID
+	}
+	return sum
+}
+
+func (me *ContainerStore) stopStats() {
+	rec := me.stats.Load()
+	if rec == nil {
+		return
+	}
+	me.stopStatsOnce.Do(func() {
+		close(rec.done)
+		select {
+		case <-rec.stopped:
+		case <-time.After(5 * time.Second):
+		}
+	})
+}
+
+// SummarizeStats aggregates samples ordered by time.
+func SummarizeStats(samples []StatsSample) StatsSummary {
+	sum := StatsSummary{Samples: len(samples)}
+	if len(samples) == 0 {
+		return sum
+	}
+
+	var cpuTotal float64
+	var memTotal uint64
+	for _, s := range samples {
+		cpuTotal += s.CPUPercent
+		memTotal += s.MemoryUsage
+		if s.CPUPercent > sum.CPUMaxPercent {
+			sum.CPUMaxPercent = s.CPUPercent
+		}
+		if s.MemoryUsage > sum.MemoryMax {
+			sum.MemoryMax = s.MemoryUsage
+		}
+	}
+
+	last := samples[len(samples)-1]
+	sum.Duration = last.Time.Sub(samples[0].Time)
+	sum.CPUAvgPercent = cpuTotal / float64(len(samples))
+	sum.MemoryAvg = memTotal / uint64(len(samples))
+	sum.MemoryLimit = last.MemoryLimit
+	sum.NetworkRx = last.NetworkRx
+	sum.NetworkTx = last.NetworkTx
+	sum.BlockRead = last.BlockRead
+	sum.BlockWrite = last.BlockWrite
+
+	return sum
+}
+
+// NewStatsSample turns a reading of the docker stats api into a sample.
+func NewStatsSample(s *docker.Stats) StatsSample {
+	sample := StatsSample{
+		Time:        s.Read,
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+	}
+
+	// same calculation as the docker cli
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemCPUUsage) - float64(s.PreCPUStats.SystemCPUUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		sample.CPUPercent = (cpuDelta / systemDelta) * cpus * 100.0
+	}
+
+	for _, n := range s.Networks {
+		sample.NetworkRx += n.RxBytes
+		sample.NetworkTx += n.TxBytes
+	}
+
+	for _, b := range s.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(b.Op) {
+		case "read":
+			sample.BlockRead += b.Value
+		case "write":
+			sample.BlockWrite += b.Value
+		}
+	}
+
+	return sample
+}
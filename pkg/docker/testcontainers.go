@@ -0,0 +1,188 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContainerRequest is a look-alike of testcontainers-go's ContainerRequest with the
+// fields that matter for fixtures. It is not that type and this package does not depend
+// on testcontainers-go, so values of testcontainers.ContainerRequest or wait.Strategy
+// can not be passed in. Code written against them only carries over at the source level:
+// the field names match, and ForListeningPort, ForHTTP and ForAll stand in for the
+// wait package functions of the same name.
+type ContainerRequest struct {
+	Image        string
+	ExposedPorts []string
+	Env          map[string]string
+	Cmd          []string
+	WaitingFor   WaitStrategy
+}
+
+var _ ContainerImage = (*RequestImage)(nil)
+var _ ContainerPorts = (*RequestImage)(nil)
+var _ ContainerCommand = (*RequestImage)(nil)
+
+// RequestImage runs a ContainerRequest through Roll.
+type RequestImage struct {
+	req    ContainerRequest
+	active *ContainerStore
+}
+
+// FromRequest runs a request written in the testcontainers-go style as a ContainerImage.
+// The first exposed port is used as the http port and the second, if any, as the https port.
+func FromRequest(req ContainerRequest) (*RequestImage, error) {
+	if req.Image == "" {
+		return nil, errors.New("request has no image")
+	}
+	if len(req.ExposedPorts) == 0 {
+		return nil, errors.New("request exposes no ports")
+	}
+	for _, p := range req.ExposedPorts {
+		if _, err := portNumber(p); err != nil {
+			return nil, err
+		}
+	}
+	return &RequestImage{req: req}, nil
+}
+
+func (me *RequestImage) Tag() string {
+	return me.req.Image
+}
+
+func (me *RequestImage) HttpPort() int {
+	p, _ := portNumber(me.req.ExposedPorts[0])
+	return p
+}
+
+func (me *RequestImage) HttpsPort() int {
+	if len(me.req.ExposedPorts) < 2 {
+		return me.HttpPort()
+	}
+	p, _ := portNumber(me.req.ExposedPorts[1])
+	return p
+}
+
+func (me *RequestImage) ExposedPorts() []string {
+	return me.req.ExposedPorts
+}
+
+func (me *RequestImage) Cmd() []string {
+	return me.req.Cmd
+}
+
+func (me *RequestImage) EnvVars() []string {
+	env := make([]string, 0, len(me.req.Env))
+	for k, v := range me.req.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (me *RequestImage) OnStart(z *ContainerStore) {
+	me.active = z
+}
+
+func (me *RequestImage) Ping(ctx context.Context) error {
+	if me.active == nil {
+		return errors.New("container not active")
+	}
+	if me.req.WaitingFor == nil {
+		return nil
+	}
+	// Roll already retries Ping until its deadline, so the strategy only gets one attempt
+	return me.req.WaitingFor.WaitUntilReady(withSingleAttempt(ctx), me.active)
+}
+
+// ToRequest describes a ContainerImage as a testcontainers-go style request, e.g. to
+// copy its fields into a real testcontainers.ContainerRequest. The wait
+// strategy hands the started container to the image through OnStart and then
+// waits for its Ping to succeed, so helpers like NewClient keep working.
+func ToRequest(img ContainerImage) ContainerRequest {
+	req := ContainerRequest{
+		Image:        img.Tag(),
+		ExposedPorts: []string{fmt.Sprintf("%d/tcp", img.HttpPort())},
+		Env:          map[string]string{},
+		WaitingFor:   &imageStrategy{image: img},
+	}
+
+	ports := []string{fmt.Sprintf("%d/tcp", img.HttpsPort())}
+	if c, ok := img.(ContainerPorts); ok {
+		ports = append(ports, c.ExposedPorts()...)
+	}
+	for _, p := range ports {
+		if !strings.Contains(p, "/") {
+			p += "/tcp"
+		}
+		found := false
+		for _, e := range req.ExposedPorts {
+			found = found || e == p
+		}
+		if !found {
+			req.ExposedPorts = append(req.ExposedPorts, p)
+		}
+	}
+
+	for _, envVar := range img.EnvVars() {
+		if strings.HasPrefix(envVar, "cmd=") {
+			req.Cmd = append(req.Cmd, strings.Split(strings.TrimPrefix(envVar, "cmd="), " ")...)
+			continue
+		}
+		k, v, _ := strings.Cut(envVar, "=")
+		req.Env[k] = v
+	}
+
+	if c, ok := img.(ContainerCommand); ok {
+		req.Cmd = c.Cmd()
+	}
+
+	return req
+}
+
+type imageStrategy struct {
+	image ContainerImage
+}
+
+func (me *imageStrategy) WaitUntilReady(ctx context.Context, target StrategyTarget) error {
+	host, err := target.Host(ctx)
+	if err != nil {
+		return err
+	}
+
+	httpPort, err := target.MappedPort(ctx, fmt.Sprintf("%d/tcp", me.image.HttpPort()))
+	if err != nil {
+		return err
+	}
+
+	httpsPort, err := target.MappedPort(ctx, fmt.Sprintf("%d/tcp", me.image.HttpsPort()))
+	if err != nil {
+		return err
+	}
+
+	// the container belongs to whoever started it, so the store has no pool or
+	// resource and Close, SampleStats and Ready do nothing
+	me.image.OnStart(&ContainerStore{
+		http:  "http://" + net.JoinHostPort(host, httpPort),
+		https: "https://" + net.JoinHostPort(host, httpsPort),
+		image: me.image,
+		ctx:   ctx,
+	})
+
+	return poll(ctx, defaultWaitTimeout, me.image.Ping)
+}
+
+func portNumber(port string) (int, error) {
+	p, _, _ := strings.Cut(port, "/")
+	n, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid port %q", port)
+	}
+	return n, nil
+}
@@ -0,0 +1,41 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// RollT starts the image for the lifetime of the test, waits for it to be ready and
+// records its resource usage. The stats summary is written to the test log on cleanup
+// so it lands in the test report next to the test timings.
+func RollT(t testing.TB, ctx context.Context, reg ContainerImage) *ContainerStore {
+	t.Helper()
+
+	cont, err := Roll(ctx, reg)
+	if err != nil {
+		t.Fatalf("docker: roll failed: %s", err)
+	}
+
+	cont.SampleStats(ctx, DefaultStatsInterval)
+
+	t.Cleanup(func() {
+		cont.stopStats()
+		sum := cont.Stats()
+		if b, err := json.Marshal(sum); err == nil {
+			t.Logf("container stats: %s", b)
+		}
+		if b, err := json.Marshal(cont.Timings()); err == nil {
+			t.Logf("container timings: %s", b)
+		}
+		if err := cont.Close(); err != nil {
+			t.Errorf("docker: close failed: %s", err)
+		}
+	})
+
+	if err := cont.Ready(); err != nil {
+		t.Fatalf("docker: container not ready: %s", err)
+	}
+
+	return cont
+}
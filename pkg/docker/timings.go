@@ -0,0 +1,342 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// BudgetsFileEnv points at a json file mapping image tags to budgets, e.g.
+	// {"amazon/dynamodb-local:latest": {"start": "5s", "ready": "10s"}}.
+	BudgetsFileEnv = "TESTRC_BUDGETS_FILE"
+
+	// TimingsFileEnv points at the json timing report. The previous run's report is
+	// read from it to detect regressions and the current report is written back.
+	TimingsFileEnv = "TESTRC_TIMINGS_FILE"
+)
+
+// RegressionThreshold is the fractional slowdown against the previous run that is
+// flagged as a regression. Slowdowns under RegressionMinimum are always ignored.
+var (
+	RegressionThreshold = 0.5
+	RegressionMinimum   = 250 * time.Millisecond
+)
+
+// Budget is how long an image may take to create and start its container (Start)
+// and to pass its Ping once started (Ready). Zero means no budget.
+type Budget struct {
+	Start time.Duration `json:"start"`
+	Ready time.Duration `json:"ready"`
+}
+
+func (me *Budget) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Start json.RawMessage `json:"start"`
+		Ready json.RawMessage `json:"ready"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	var err error
+	if me.Start, err = parseJSONDuration(raw.Start); err != nil {
+		return errors.Wrap(err, "invalid start budget")
+	}
+	if me.Ready, err = parseJSONDuration(raw.Ready); err != nil {
+		return errors.Wrap(err, "invalid ready budget")
+	}
+	return nil
+}
+
+func parseJSONDuration(b json.RawMessage) (time.Duration, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return time.ParseDuration(s)
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return 0, err
+	}
+	return time.Duration(n), nil
+}
+
+// ContainerBudget can be implemented by images that know how long they should take to start.
+type ContainerBudget interface {
+	Budget() Budget
+}
+
+var budgets = struct {
+	sync.Mutex
+	code     map[string]Budget
+	config   map[string]Budget
+	loadOnce sync.Once
+}{code: map[string]Budget{}}
+
+// SetBudget overrides the budget for an image tag. Budgets from the file in
+// TESTRC_BUDGETS_FILE take precedence so CI can loosen them without code changes.
+func SetBudget(tag string, b Budget) {
+	budgets.Lock()
+	defer budgets.Unlock()
+	budgets.code[tag] = b
+}
+
+// LoadBudgets reads a json map of image tag to budget and replaces the config budgets.
+func LoadBudgets(r io.Reader) error {
+	m := map[string]Budget{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return errors.Wrap(err, "decoding budgets")
+	}
+	budgets.Lock()
+	defer budgets.Unlock()
+	budgets.config = m
+	return nil
+}
+
+func budgetFor(ctx context.Context, img ContainerImage) Budget {
+	budgets.loadOnce.Do(func() {
+		path := os.Getenv(BudgetsFileEnv)
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("path", path).Msg("could not open budgets file")
+			return
+		}
+		defer f.Close()
+		if err := LoadBudgets(f); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("path", path).Msg("could not load budgets file")
+		}
+	})
+
+	budgets.Lock()
+	defer budgets.Unlock()
+
+	if b, ok := budgets.config[img.Tag()]; ok {
+		return b
+	}
+	if b, ok := budgets.code[img.Tag()]; ok {
+		return b
+	}
+	if b, ok := img.(ContainerBudget); ok {
+		return b.Budget()
+	}
+	return Budget{}
+}
+
+// ContainerTimings records how long each phase of bringing up a container took.
+type ContainerTimings struct {
+	Image     string        `json:"image"`
+	Container string        `json:"container"`
+	Pull      time.Duration `json:"pull"`
+	Create    time.Duration `json:"create"`
+	Start     time.Duration `json:"start"`
+	Ready     time.Duration `json:"ready"`
+	Budget    Budget        `json:"budget"`
+}
+
+// Total is the time from the first docker call until the container was ready.
+func (me ContainerTimings) Total() time.Duration {
+	return me.Pull + me.Create + me.Start + me.Ready
+}
+
+func (me ContainerTimings) OverStartBudget() bool {
+	return me.Budget.Start > 0 && me.Create+me.Start > me.Budget.Start
+}
+
+func (me ContainerTimings) OverReadyBudget() bool {
+	return me.Budget.Ready > 0 && me.Ready > me.Budget.Ready
+}
+
+var _ zerolog.LogObjectMarshaler = (*ContainerTimings)(nil)
+
+func (me *ContainerTimings) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("image", me.Image).
+		Str("container", me.Container).
+		Dur("pull", me.Pull).
+		Dur("create", me.Create).
+		Dur("start", me.Start).
+		Dur("ready", me.Ready).
+		Dur("total", me.Total())
+}
+
+var timings = struct {
+	sync.Mutex
+	all []ContainerTimings
+}{}
+
+func recordTimings(t ContainerTimings) {
+	timings.Lock()
+	defer timings.Unlock()
+	timings.all = append(timings.all, t)
+}
+
+// Timings returns the phase durations of this container. Ready is zero until the container is ready.
+func (me *ContainerStore) Timings() ContainerTimings {
+	me.timingsMu.Lock()
+	defer me.timingsMu.Unlock()
+	return me.timings
+}
+
+type TimingEntry struct {
+	ContainerTimings
+	OverStartBudget bool              `json:"over_start_budget"`
+	OverReadyBudget bool              `json:"over_ready_budget"`
+	Regressed       bool              `json:"regressed"`
+	Previous        *ContainerTimings `json:"previous,omitempty"`
+}
+
+type TimingReport struct {
+	Containers []TimingEntry `json:"containers"`
+	Tests      []TestResult  `json:"tests,omitempty"`
+}
+
+// TestResult is a measurement of one test that is written to the timing report next to
+// the container timings, so it can be tracked across runs, e.g. the dynamodb usage of a test.
+type TestResult struct {
+	Test  string          `json:"test"`
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+var testResults = struct {
+	sync.Mutex
+	all []TestResult
+}{}
+
+// RecordTestResult adds a json measurement of a test to the report FinishRun writes.
+func RecordTestResult(test, name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s of %s", name, test)
+	}
+	testResults.Lock()
+	defer testResults.Unlock()
+	testResults.all = append(testResults.all, TestResult{Test: test, Name: name, Value: b})
+	return nil
+}
+
+// Flagged returns the entries that went over budget or regressed.
+func (me *TimingReport) Flagged() []TimingEntry {
+	out := make([]TimingEntry, 0)
+	for _, e := range me.Containers {
+		if e.OverStartBudget || e.OverReadyBudget || e.Regressed {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// BuildTimingReport summarizes every container started and every test result recorded
+// in this process. The nth
+// container of an image is compared against the nth container of the same image in prev.
+func BuildTimingReport(prev *TimingReport) *TimingReport {
+	timings.Lock()
+	all := append([]ContainerTimings{}, timings.all...)
+	timings.Unlock()
+
+	previous := map[string][]ContainerTimings{}
+	if prev != nil {
+		for _, e := range prev.Containers {
+			previous[e.Image] = append(previous[e.Image], e.ContainerTimings)
+		}
+	}
+
+	testResults.Lock()
+	tests := append([]TestResult{}, testResults.all...)
+	testResults.Unlock()
+
+	seen := map[string]int{}
+	rep := &TimingReport{Containers: make([]TimingEntry, 0, len(all)), Tests: tests}
+	for _, t := range all {
+		entry := TimingEntry{
+			ContainerTimings: t,
+			OverStartBudget:  t.OverStartBudget(),
+			OverReadyBudget:  t.OverReadyBudget(),
+		}
+		if p := previous[t.Image]; seen[t.Image] < len(p) {
+			last := p[seen[t.Image]]
+			entry.Previous = &last
+			// pull time depends on the local image cache, so leave it out of the comparison
+			cur, old := t.Total()-t.Pull, last.Total()-last.Pull
+			entry.Regressed = cur-old > RegressionMinimum && float64(cur) > float64(old)*(1+RegressionThreshold)
+		}
+		seen[t.Image]++
+		rep.Containers = append(rep.Containers, entry)
+	}
+
+	return rep
+}
+
+func (me *TimingReport) Log(ctx context.Context) {
+	for i := range me.Containers {
+		e := &me.Containers[i]
+		ev := zerolog.Ctx(ctx).Info()
+		if e.OverStartBudget || e.OverReadyBudget || e.Regressed {
+			ev = zerolog.Ctx(ctx).Warn()
+		}
+		ev = ev.EmbedObject(&e.ContainerTimings).
+			Bool("over_start_budget", e.OverStartBudget).
+			Bool("over_ready_budget", e.OverReadyBudget).
+			Bool("regressed", e.Regressed)
+		if e.Previous != nil {
+			ev = ev.Dur("previous_total", e.Previous.Total())
+		}
+		ev.Msg("container timings")
+	}
+	zerolog.Ctx(ctx).Info().
+		Int("containers", len(me.Containers)).
+		Int("flagged", len(me.Flagged())).
+		Msg("container timing summary")
+}
+
+func (me *TimingReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(me)
+}
+
+// FinishRun builds the timing report for this process, compares it against the
+// report left in TESTRC_TIMINGS_FILE by the previous run, logs it, and writes it
+// back to the same file. It is meant to be called from TestMain after m.Run.
+func FinishRun(ctx context.Context) (*TimingReport, error) {
+	path := os.Getenv(TimingsFileEnv)
+
+	var prev *TimingReport
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			prev = &TimingReport{}
+			if err := json.Unmarshal(b, prev); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("path", path).Msg("ignoring unreadable previous timings")
+				prev = nil
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "reading previous timings")
+		}
+	}
+
+	rep := BuildTimingReport(prev)
+	rep.Log(ctx)
+
+	if path == "" {
+		return rep, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return rep, errors.Wrap(err, "writing timings")
+	}
+	defer f.Close()
+
+	return rep, rep.WriteJSON(f)
+}
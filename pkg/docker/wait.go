@@ -0,0 +1,211 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWaitTimeout      = time.Minute
+	defaultWaitPollInterval = 100 * time.Millisecond
+)
+
+// StrategyTarget has the methods of testcontainers-go's wait.StrategyTarget that the
+// strategies in this package need, with the port as a string instead of nat.Port. Ports are "8000/tcp" style strings and
+// MappedPort returns the host port number.
+type StrategyTarget interface {
+	Host(ctx context.Context) (string, error)
+	MappedPort(ctx context.Context, port string) (string, error)
+}
+
+// WaitStrategy looks like testcontainers-go's wait.Strategy but takes this package's
+// StrategyTarget, so strategies of one can not be used as the other.
+type WaitStrategy interface {
+	WaitUntilReady(ctx context.Context, target StrategyTarget) error
+}
+
+var _ StrategyTarget = (*ContainerStore)(nil)
+
+func (me *ContainerStore) Host(ctx context.Context) (string, error) {
+	if me.resource == nil || me.resource.Container == nil || me.resource.Container.NetworkSettings == nil {
+		return "", errors.New("container not active")
+	}
+	for _, port := range me.resource.Container.NetworkSettings.Ports {
+		if len(port) > 0 {
+			if port[0].HostIP == "" || port[0].HostIP == "0.0.0.0" {
+				return "localhost", nil
+			}
+			return port[0].HostIP, nil
+		}
+	}
+	return "", errors.New("container has no published ports")
+}
+
+func (me *ContainerStore) MappedPort(ctx context.Context, port string) (string, error) {
+	hostPort := me.GetHostPort(port)
+	if hostPort == "" {
+		return "", errors.Errorf("port %s is not published", port)
+	}
+	_, p, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// ForListeningPort waits until a tcp connection to the mapped port succeeds.
+func ForListeningPort(port string) *PortStrategy {
+	return &PortStrategy{Port: port, Timeout: defaultWaitTimeout}
+}
+
+type PortStrategy struct {
+	Port    string
+	Timeout time.Duration
+}
+
+func (me *PortStrategy) WithStartupTimeout(d time.Duration) *PortStrategy {
+	me.Timeout = d
+	return me
+}
+
+func (me *PortStrategy) WaitUntilReady(ctx context.Context, target StrategyTarget) error {
+	return poll(ctx, me.Timeout, func(ctx context.Context) error {
+		addr, err := targetAddress(ctx, target, me.Port)
+		if err != nil {
+			return err
+		}
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// ForHTTP waits until a GET request to path on the mapped port returns the expected status.
+func ForHTTP(path string) *HTTPStrategy {
+	return &HTTPStrategy{Path: path, Timeout: defaultWaitTimeout, StatusCodeMatcher: func(status int) bool {
+		return status == http.StatusOK
+	}}
+}
+
+type HTTPStrategy struct {
+	Path              string
+	Port              string
+	TLS               bool
+	Timeout           time.Duration
+	StatusCodeMatcher func(status int) bool
+}
+
+func (me *HTTPStrategy) WithPort(port string) *HTTPStrategy {
+	me.Port = port
+	return me
+}
+
+func (me *HTTPStrategy) WithTLS(tls bool) *HTTPStrategy {
+	me.TLS = tls
+	return me
+}
+
+func (me *HTTPStrategy) WithStatusCodeMatcher(fn func(status int) bool) *HTTPStrategy {
+	me.StatusCodeMatcher = fn
+	return me
+}
+
+func (me *HTTPStrategy) WithStartupTimeout(d time.Duration) *HTTPStrategy {
+	me.Timeout = d
+	return me
+}
+
+func (me *HTTPStrategy) WaitUntilReady(ctx context.Context, target StrategyTarget) error {
+	if me.Port == "" {
+		return errors.New("http wait strategy requires a port")
+	}
+	scheme := "http"
+	if me.TLS {
+		scheme = "https"
+	}
+	return poll(ctx, me.Timeout, func(ctx context.Context) error {
+		addr, err := targetAddress(ctx, target, me.Port)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+addr+"/"+strings.TrimPrefix(me.Path, "/"), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if me.StatusCodeMatcher != nil && !me.StatusCodeMatcher(resp.StatusCode) {
+			return errors.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// ForAll waits for every strategy in order.
+func ForAll(strategies ...WaitStrategy) WaitStrategy {
+	return multiStrategy(strategies)
+}
+
+type multiStrategy []WaitStrategy
+
+func (me multiStrategy) WaitUntilReady(ctx context.Context, target StrategyTarget) error {
+	for _, s := range me {
+		if err := s.WaitUntilReady(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func targetAddress(ctx context.Context, target StrategyTarget, port string) (string, error) {
+	host, err := target.Host(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := target.MappedPort(ctx, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, p), nil
+}
+
+type singleAttemptKey struct{}
+
+// withSingleAttempt makes the strategies waiting on ctx try once and leave retrying to
+// the caller, so nested waits do not multiply the timeout.
+func withSingleAttempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, singleAttemptKey{}, true)
+}
+
+func poll(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if ctx.Value(singleAttemptKey{}) != nil {
+		return fn(ctx)
+	}
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(err, "wait strategy timed out")
+		case <-time.After(defaultWaitPollInterval):
+		}
+	}
+}
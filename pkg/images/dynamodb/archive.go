@@ -0,0 +1,938 @@
+package dynamodb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+)
+
+// An archive holds one directory per table laid out like DynamoDB's export to S3, plus
+// the table definition:
+//
+//	<table>/manifest-summary.json
+//	<table>/manifest-files.json
+//	<table>/table.json
+//	<table>/data/<segment>.json
+//
+// Data files are DynamoDB JSON lines of the form {"Item": {...}}. Dump writes them after
+// the manifests so Restore can write the items as it reads them. Because the layout is
+// the same, Restore also reads an S3 export (AWSDynamoDB/<export id>/...) that was
+// downloaded and packed, or the downloaded directory itself with RestoreFile.
+const (
+	archiveSummary    = "manifest-summary.json"
+	archiveFiles      = "manifest-files.json"
+	archiveDefinition = "table.json"
+	archiveVersion    = "2020-06-30"
+	exportFormatJSON  = "DYNAMODB_JSON"
+)
+
+// ArchiveFormat is the container format Dump writes.
+type ArchiveFormat string
+
+const (
+	ArchiveTar     ArchiveFormat = "tar"
+	ArchiveTarGzip ArchiveFormat = "tgz"
+	ArchiveZip     ArchiveFormat = "zip"
+)
+
+// ArchiveFormatFor picks the format from the extension of a file name, or returns "" if
+// the extension is not an archive.
+func ArchiveFormatFor(name string) ArchiveFormat {
+	switch lower := strings.ToLower(name); {
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip
+	case strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".tar.gz"):
+		return ArchiveTarGzip
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar
+	default:
+		return ""
+	}
+}
+
+// DumpParallelism is the default number of scan segments Dump reads at once.
+var DumpParallelism = 4
+
+// DumpOption configures Dump.
+type DumpOption func(*dumpConfig)
+
+type dumpConfig struct {
+	format      ArchiveFormat
+	parallelism int
+}
+
+// DumpFormat sets the archive format, tar by default.
+func DumpFormat(f ArchiveFormat) DumpOption {
+	return func(c *dumpConfig) { c.format = f }
+}
+
+// DumpSegments sets how many scan segments of a table are read concurrently. Every
+// segment becomes its own data file.
+func DumpSegments(n int) DumpOption {
+	return func(c *dumpConfig) { c.parallelism = n }
+}
+
+type exportSummary struct {
+	Version            string    `json:"version"`
+	TableArn           string    `json:"tableArn"`
+	ExportTime         time.Time `json:"exportTime"`
+	ExportType         string    `json:"exportType,omitempty"`
+	ItemCount          int64     `json:"itemCount"`
+	OutputFormat       string    `json:"outputFormat"`
+	ManifestFilesS3Key string    `json:"manifestFilesS3Key"`
+}
+
+type exportFile struct {
+	ItemCount     int64  `json:"itemCount"`
+	MD5Checksum   string `json:"md5Checksum"`
+	DataFileS3Key string `json:"dataFileS3Key"`
+}
+
+// Dump writes the definitions and items of the tables to w as a single archive, which
+// Restore loads again, e.g. to reproduce a bug locally with the data that caused it.
+func Dump(ctx context.Context, cli *dynamodb.Client, tables []string, w io.Writer, opts ...DumpOption) error {
+	cfg := &dumpConfig{format: ArchiveTar, parallelism: DumpParallelism}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.parallelism < 1 {
+		cfg.parallelism = 1
+	}
+
+	aw, err := newArchiveWriter(w, cfg.format)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if err := dumpTable(ctx, cli, aw, table, cfg.parallelism); err != nil {
+			return errors.Wrapf(err, "dumping %s", table)
+		}
+	}
+
+	return aw.Close()
+}
+
+func dumpTable(ctx context.Context, cli *dynamodb.Client, aw archiveWriter, table string, parallelism int) error {
+	start := time.Now()
+
+	desc, err := DescribeProvisioned(ctx, cli, table)
+	if err != nil {
+		return err
+	}
+
+	segments := make([]*dumpSegment, parallelism)
+	defer func() {
+		for _, s := range segments {
+			s.remove()
+		}
+	}()
+
+	grp, gctx := errgroup.WithContext(ctx)
+	for segment := 0; segment < parallelism; segment++ {
+		segment := segment
+		grp.Go(func() (err error) {
+			segments[segment], err = scanSegment(gctx, cli, table, segment, parallelism)
+			return err
+		})
+	}
+	if err := grp.Wait(); err != nil {
+		return err
+	}
+
+	def, err := json.MarshalIndent(definitionFromDescription(desc), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBytes(aw, slashpath.Join(table, archiveDefinition), def); err != nil {
+		return err
+	}
+
+	var total int64
+	files := &bytes.Buffer{}
+	for i, s := range segments {
+		total += s.items
+		s.name = slashpath.Join(table, "data", fmt.Sprintf("segment-%04d.json", i))
+		if err := writeJSONLine(files, &exportFile{ItemCount: s.items, MD5Checksum: hex.EncodeToString(s.sum), DataFileS3Key: s.name}); err != nil {
+			return err
+		}
+	}
+	if err := addBytes(aw, slashpath.Join(table, archiveFiles), files.Bytes()); err != nil {
+		return err
+	}
+
+	arn := ptr.ToString(desc.Table.TableArn)
+	if arn == "" {
+		arn = "arn:aws:dynamodb:local:000000000000:table/" + table
+	}
+	summary, err := json.MarshalIndent(&exportSummary{
+		Version:            archiveVersion,
+		TableArn:           arn,
+		ExportTime:         start.UTC(),
+		ItemCount:          total,
+		OutputFormat:       exportFormatJSON,
+		ManifestFilesS3Key: slashpath.Join(table, archiveFiles),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBytes(aw, slashpath.Join(table, archiveSummary), summary); err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		if err := s.addTo(aw); err != nil {
+			return err
+		}
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("table", table).Int64("items", total).Dur("elapsed", time.Since(start)).Msg("dumped table")
+	return nil
+}
+
+// dumpSegment is the data file of one scan segment, spooled to a temporary file so tar
+// knows its size and the manifest its checksum before it is added to the archive.
+type dumpSegment struct {
+	name  string
+	file  *os.File
+	items int64
+	sum   []byte
+}
+
+func scanSegment(ctx context.Context, cli *dynamodb.Client, table string, segment, total int) (*dumpSegment, error) {
+	f, err := os.CreateTemp("", "dynamodb-dump-*.json")
+	if err != nil {
+		return nil, err
+	}
+	s := &dumpSegment{file: f}
+
+	h := md5.New()
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+	pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{
+		TableName:     ptr.String(table),
+		Segment:       ptr.Int32(int32(segment)),
+		TotalSegments: ptr.Int32(int32(total)),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return s, errors.Wrapf(err, "scanning segment %d", segment)
+		}
+		for _, it := range page.Items {
+			b, err := MarshalItemJSON(it)
+			if err != nil {
+				return s, err
+			}
+			if _, err := fmt.Fprintf(w, "{\"Item\":%s}\n", b); err != nil {
+				return s, err
+			}
+			s.items++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return s, err
+	}
+	s.sum = h.Sum(nil)
+	return s, nil
+}
+
+func (me *dumpSegment) addTo(aw archiveWriter) error {
+	fi, err := me.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := me.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return aw.add(me.name, me.file, fi.Size())
+}
+
+func (me *dumpSegment) remove() {
+	if me == nil {
+		return
+	}
+	me.file.Close()
+	os.Remove(me.file.Name())
+}
+
+// definitionFromDescription turns a described table back into the definition that
+// creates it.
+func definitionFromDescription(p *ProvisionedTable) *TableDefinition {
+	t := p.Table
+	in := &dynamodb.CreateTableInput{
+		TableName:            t.TableName,
+		KeySchema:            t.KeySchema,
+		AttributeDefinitions: t.AttributeDefinitions,
+		BillingMode:          types.BillingModePayPerRequest,
+		Tags:                 p.Tags,
+	}
+
+	provisioned := t.BillingModeSummary != nil && t.BillingModeSummary.BillingMode == types.BillingModeProvisioned
+	if t.BillingModeSummary == nil && t.ProvisionedThroughput != nil && ptr.ToInt64(t.ProvisionedThroughput.ReadCapacityUnits) > 0 {
+		provisioned = true
+	}
+	capacity := func(d *types.ProvisionedThroughputDescription) *types.ProvisionedThroughput {
+		if !provisioned || d == nil {
+			return nil
+		}
+		return &types.ProvisionedThroughput{ReadCapacityUnits: d.ReadCapacityUnits, WriteCapacityUnits: d.WriteCapacityUnits}
+	}
+	if provisioned {
+		in.BillingMode = types.BillingModeProvisioned
+		in.ProvisionedThroughput = capacity(t.ProvisionedThroughput)
+	}
+
+	for _, g := range t.GlobalSecondaryIndexes {
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:             g.IndexName,
+			KeySchema:             g.KeySchema,
+			Projection:            g.Projection,
+			ProvisionedThroughput: capacity(g.ProvisionedThroughput),
+		})
+	}
+	for _, l := range t.LocalSecondaryIndexes {
+		in.LocalSecondaryIndexes = append(in.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+			IndexName:  l.IndexName,
+			KeySchema:  l.KeySchema,
+			Projection: l.Projection,
+		})
+	}
+	if t.StreamSpecification != nil && ptr.ToBool(t.StreamSpecification.StreamEnabled) {
+		in.StreamSpecification = t.StreamSpecification
+	}
+
+	def := &TableDefinition{Resource: ptr.ToString(t.TableName), Input: in}
+	if ttl := p.TimeToLive; ttl != nil && ttl.AttributeName != nil &&
+		(ttl.TimeToLiveStatus == types.TimeToLiveStatusEnabled || ttl.TimeToLiveStatus == types.TimeToLiveStatusEnabling) {
+		def.TimeToLive = &types.TimeToLiveSpecification{AttributeName: ttl.AttributeName, Enabled: ptr.Bool(true)}
+	}
+	if p.PointInTimeRecovery != nil && p.PointInTimeRecovery.PointInTimeRecoveryStatus == types.PointInTimeRecoveryStatusEnabled {
+		def.PointInTimeRecovery = true
+	}
+	return def
+}
+
+type archiveWriter interface {
+	add(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+func addBytes(aw archiveWriter, name string, b []byte) error {
+	return aw.add(name, bytes.NewReader(b), int64(len(b)))
+}
+
+func newArchiveWriter(w io.Writer, f ArchiveFormat) (archiveWriter, error) {
+	switch f {
+	case ArchiveTar:
+		return &tarWriter{Writer: tar.NewWriter(w)}, nil
+	case ArchiveTarGzip:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{Writer: tar.NewWriter(gz), gz: gz}, nil
+	case ArchiveZip:
+		return &zipWriter{Writer: zip.NewWriter(w)}, nil
+	default:
+		return nil, errors.Errorf("unknown archive format %q", f)
+	}
+}
+
+type tarWriter struct {
+	*tar.Writer
+	gz *gzip.Writer
+}
+
+func (me *tarWriter) add(name string, r io.Reader, size int64) error {
+	if err := me.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.Copy(me, r)
+	return err
+}
+
+func (me *tarWriter) Close() error {
+	if err := me.Writer.Close(); err != nil {
+		return err
+	}
+	if me.gz != nil {
+		return me.gz.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (me *zipWriter) add(name string, r io.Reader, _ int64) error {
+	f, err := me.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// RestoreOption configures Restore.
+type RestoreOption func(*restoreConfig)
+
+type restoreConfig struct {
+	rename      map[string]string
+	definitions map[string]*TableDefinition
+}
+
+// RestoreAs loads the table named from in the archive into the table named to.
+func RestoreAs(from, to string) RestoreOption {
+	return func(c *restoreConfig) { c.rename[from] = to }
+}
+
+// RestoreDefinitions creates tables missing from the archive, like those of an S3
+// export, from these definitions. They are matched by table name after RestoreAs.
+func RestoreDefinitions(defs ...*TableDefinition) RestoreOption {
+	return func(c *restoreConfig) {
+		for _, d := range defs {
+			c.definitions[ptr.ToString(d.Input.TableName)] = d
+		}
+	}
+}
+
+// RestoredTable reports what Restore did for one table of the archive.
+type RestoredTable struct {
+	Table   string
+	Created bool
+	Items   int
+}
+
+func (me *RestoredTable) String() string {
+	created := ""
+	if me.Created {
+		created = " into a new table"
+	}
+	return fmt.Sprintf("%s: restored %d items%s", me.Table, me.Items, created)
+}
+
+// Restore reads an archive written by Dump, or a packed S3 export, as tar, gzipped tar
+// or zip. Tables that do not exist yet are created from the definition in the archive;
+// existing tables are kept and the items are written on top of what they hold. Data
+// files are checked against the checksums in manifest-files.json.
+func Restore(ctx context.Context, cli *dynamodb.Client, r io.Reader, opts ...RestoreOption) ([]*RestoredTable, error) {
+	out, _, err := restore(ctx, cli, func(fn archiveEntryFunc) error { return walkArchive(r, fn) }, opts)
+	return out, err
+}
+
+// RestoreFile restores from an archive file, or from a directory like a downloaded S3
+// export.
+func RestoreFile(ctx context.Context, cli *dynamodb.Client, name string, opts ...RestoreOption) ([]*RestoredTable, error) {
+	out, _, err := restore(ctx, cli, func(fn archiveEntryFunc) error { return walkArchiveFile(name, fn) }, opts)
+	return out, err
+}
+
+// RestoreT restores from an archive file or directory for the lifetime of the test,
+// deleting the tables it created when the test ends.
+func RestoreT(t testing.TB, ctx context.Context, cli *dynamodb.Client, name string, opts ...RestoreOption) []*RestoredTable {
+	t.Helper()
+
+	out, teardown, err := restore(ctx, cli, func(fn archiveEntryFunc) error { return walkArchiveFile(name, fn) }, opts)
+	t.Cleanup(func() {
+		if err := teardown(); err != nil {
+			t.Errorf("dynamodb: teardown failed: %s", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("dynamodb: restoring %s: %s", name, err)
+	}
+	for _, r := range out {
+		t.Logf("dynamodb: %s", r)
+	}
+	return out
+}
+
+// archiveEntryFunc is called with every file of an archive, in archive order.
+type archiveEntryFunc func(name string, r io.Reader) error
+
+// isDataFile reports whether the archive file is under the data directory of a table.
+func isDataFile(name string) bool {
+	return slashpath.Base(slashpath.Dir(name)) == "data"
+}
+
+// manifestsFirst orders file names the way Dump writes them: the definition, then the
+// manifests and then the data files, which lets the data be restored as it is read.
+func manifestsFirst(names []string) {
+	rank := func(name string) int {
+		switch {
+		case slashpath.Base(name) == archiveDefinition:
+			return 0
+		case isDataFile(name):
+			return 2
+		default:
+			return 1
+		}
+	}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool { return rank(names[i]) < rank(names[j]) })
+}
+
+func walkArchiveFile(name string, fn archiveEntryFunc) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		zr, err := zip.OpenReader(name)
+		if err == nil {
+			defer zr.Close()
+			return walkZip(&zr.Reader, fn)
+		}
+		if !errors.Is(err, zip.ErrFormat) {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return walkArchive(f, fn)
+	}
+
+	names := []string{}
+	err = filepath.WalkDir(name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(name, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", name)
+	}
+	manifestsFirst(names)
+
+	for _, rel := range names {
+		f, err := os.Open(filepath.Join(name, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		err = fn(rel, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkArchive reads a tar, gzipped tar or zip archive one file at a time. A zip keeps
+// its directory at the end, so it is spooled to a temporary file first.
+func walkArchive(r io.Reader, fn archiveEntryFunc) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "reading archive")
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		f, err := os.CreateTemp("", "dynamodb-restore-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		size, err := io.Copy(f, br)
+		if err != nil {
+			return errors.Wrap(err, "reading archive")
+		}
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return errors.Wrap(err, "reading zip archive")
+		}
+		return walkZip(zr, fn)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "reading gzipped archive")
+		}
+		return walkTar(tar.NewReader(gz), fn)
+	default:
+		return walkTar(tar.NewReader(br), fn)
+	}
+}
+
+func walkTar(tr *tar.Reader, fn archiveEntryFunc) error {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading tar archive")
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(slashpath.Clean(h.Name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(zr *zip.Reader, fn archiveEntryFunc) error {
+	byName := map[string]*zip.File{}
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			byName[slashpath.Clean(f.Name)] = f
+		}
+	}
+	names := sortedKeysOf(byName)
+	manifestsFirst(names)
+
+	for _, name := range names {
+		rc, err := byName[name].Open()
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		err = fn(name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBatchSize is how many items of a data file are read before they are written.
+const restoreBatchSize = 1000
+
+// restorer restores the export directories of an archive as its files are read. Data
+// files are written to their table as soon as its manifests have been read; those that
+// come earlier, as in a packed S3 export, are spooled to temporary files until then.
+type restorer struct {
+	ctx       context.Context
+	cli       *dynamodb.Client
+	cfg       *restoreConfig
+	dirs      map[string]*restoreDir
+	teardowns []func() error
+}
+
+// restoreDir is one export directory of the archive.
+type restoreDir struct {
+	dir        string
+	summary    *exportSummary
+	definition *TableDefinition
+	files      map[string]*exportFile // nil without manifest-files.json
+	spooled    map[string]string
+	restored   map[string]bool
+	items      int64
+	res        *RestoredTable // set once the table is ready for items
+}
+
+func restore(ctx context.Context, cli *dynamodb.Client, walk func(archiveEntryFunc) error, opts []RestoreOption) ([]*RestoredTable, func() error, error) {
+	cfg := &restoreConfig{rename: map[string]string{}, definitions: map[string]*TableDefinition{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	me := &restorer{ctx: ctx, cli: cli, cfg: cfg, dirs: map[string]*restoreDir{}}
+	defer me.removeSpooled()
+
+	err := walk(me.entry)
+	if err == nil {
+		err = me.finish()
+	}
+	return me.results(), me.teardown, err
+}
+
+func (me *restorer) dir(name string) *restoreDir {
+	d, ok := me.dirs[name]
+	if !ok {
+		d = &restoreDir{dir: name, spooled: map[string]string{}, restored: map[string]bool{}}
+		me.dirs[name] = d
+	}
+	return d
+}
+
+func (me *restorer) entry(name string, r io.Reader) error {
+	if isDataFile(name) {
+		d := me.dir(slashpath.Dir(slashpath.Dir(name)))
+		if d.res == nil {
+			return d.spool(name, r)
+		}
+		return errors.Wrapf(me.restoreData(d, name, r), "restoring %s", d.dir)
+	}
+
+	d := me.dir(slashpath.Dir(name))
+	switch slashpath.Base(name) {
+	case archiveSummary:
+		d.summary = &exportSummary{}
+		if err := json.NewDecoder(r).Decode(d.summary); err != nil {
+			return errors.Wrapf(err, "decoding %s", name)
+		}
+	case archiveFiles:
+		d.files = map[string]*exportFile{}
+		dec := json.NewDecoder(r)
+		for dec.More() {
+			f := &exportFile{}
+			if err := dec.Decode(f); err != nil {
+				return errors.Wrapf(err, "decoding %s", name)
+			}
+			d.files[slashpath.Join(d.dir, "data", slashpath.Base(f.DataFileS3Key))] = f
+		}
+	case archiveDefinition:
+		d.definition = &TableDefinition{}
+		if err := json.NewDecoder(r).Decode(d.definition); err != nil {
+			return errors.Wrapf(err, "decoding %s", name)
+		}
+	default:
+		return nil
+	}
+
+	if d.summary != nil && d.files != nil && d.res == nil {
+		return errors.Wrapf(me.start(d), "restoring %s", d.dir)
+	}
+	return nil
+}
+
+// finish restores the tables whose data files all came before their manifests, or that
+// have no manifest-files.json, and checks that every table got all of its items.
+func (me *restorer) finish() error {
+	found := false
+	for _, name := range sortedKeysOf(me.dirs) {
+		d := me.dirs[name]
+		if d.summary == nil {
+			continue
+		}
+		found = true
+
+		if d.res == nil {
+			if err := me.start(d); err != nil {
+				return errors.Wrapf(err, "restoring %s", d.dir)
+			}
+		}
+		for _, f := range sortedKeysOf(d.files) {
+			if !d.restored[f] {
+				return errors.Errorf("restoring %s: data file %s is missing", d.dir, f)
+			}
+		}
+		if d.summary.ItemCount != d.items {
+			return errors.Errorf("restoring %s: manifest lists %d items but the data files hold %d", d.dir, d.summary.ItemCount, d.items)
+		}
+
+		zerolog.Ctx(me.ctx).Debug().Str("table", d.res.Table).Int("items", d.res.Items).Bool("created", d.res.Created).Msg("restored table")
+	}
+	if !found {
+		return errors.Errorf("no %s in archive", archiveSummary)
+	}
+	return nil
+}
+
+// start creates the table of the directory if needed and restores the data files
+// spooled so far.
+func (me *restorer) start(d *restoreDir) error {
+	if d.summary.OutputFormat != "" && d.summary.OutputFormat != exportFormatJSON {
+		return errors.Errorf("exports in %s format are not supported, only %s", d.summary.OutputFormat, exportFormatJSON)
+	}
+	if d.summary.ExportType != "" && d.summary.ExportType != "FULL_EXPORT" {
+		return errors.Errorf("%s exports are not supported", d.summary.ExportType)
+	}
+
+	def := d.definition
+	table := d.summary.TableArn[strings.LastIndex(d.summary.TableArn, "/")+1:]
+	if def != nil && def.Input != nil && def.Input.TableName != nil {
+		table = *def.Input.TableName
+	}
+	if to, ok := me.cfg.rename[table]; ok {
+		table = to
+	}
+	if table == "" {
+		return errors.New("no table name in the manifest")
+	}
+	if override, ok := me.cfg.definitions[table]; ok {
+		def = override
+	}
+
+	res := &RestoredTable{Table: table}
+
+	_, err := me.cli.DescribeTable(me.ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(table)})
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		if def == nil || def.Input == nil {
+			return errors.Errorf("table %s does not exist and the archive has no definition for it, pass one with RestoreDefinitions", table)
+		}
+		in := *def.Input
+		in.TableName = ptr.String(table)
+		def = &TableDefinition{Resource: def.Resource, Input: &in, TimeToLive: def.TimeToLive, PointInTimeRecovery: def.PointInTimeRecovery}
+		_, teardown, err := Provision(me.ctx, me.cli, def)
+		if err != nil {
+			return err
+		}
+		me.teardowns = append(me.teardowns, teardown)
+		res.Created = true
+	case err != nil:
+		return errors.Wrapf(err, "describing %s", table)
+	}
+	d.res = res
+
+	for _, name := range sortedKeysOf(d.spooled) {
+		if err := me.restoreSpooled(d, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (me *restorer) restoreSpooled(d *restoreDir, name string) error {
+	f, err := os.Open(d.spooled[name])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return me.restoreData(d, name, f)
+}
+
+// restoreData writes the items of a data file to the table as it is read. Data files
+// that manifest-files.json does not list are skipped.
+func (me *restorer) restoreData(d *restoreDir, name string, r io.Reader) error {
+	var want *exportFile
+	if d.files != nil {
+		if want = d.files[name]; want == nil {
+			return nil
+		}
+	}
+	if d.restored[name] {
+		return errors.Errorf("data file %s appears twice", name)
+	}
+	d.restored[name] = true
+
+	h := md5.New()
+	src := io.TeeReader(r, h)
+	lines := src
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		lines = gz
+	}
+
+	batch := make([]map[string]types.AttributeValue, 0, restoreBatchSize)
+	flush := func() error {
+		n, err := SeedItems(me.ctx, me.cli, d.res.Table, batch)
+		d.res.Items += n
+		batch = batch[:0]
+		return err
+	}
+
+	sc := bufio.NewScanner(lines)
+	sc.Buffer(nil, 4<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var wrapped struct {
+			Item json.RawMessage
+		}
+		if err := json.Unmarshal(sc.Bytes(), &wrapped); err != nil {
+			return errors.Wrapf(err, "%s:%d", name, line)
+		}
+		if wrapped.Item == nil {
+			return errors.Errorf("%s:%d: no Item", name, line)
+		}
+		it, err := UnmarshalItemJSON(wrapped.Item)
+		if err != nil {
+			return errors.Wrapf(err, "%s:%d", name, line)
+		}
+		d.items++
+		if batch = append(batch, it); len(batch) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrapf(err, "reading %s", name)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if want == nil || want.MD5Checksum == "" {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return errors.Wrapf(err, "reading %s", name)
+	}
+	sum := h.Sum(nil)
+	if want.MD5Checksum != hex.EncodeToString(sum) && want.MD5Checksum != base64.StdEncoding.EncodeToString(sum) {
+		return errors.Errorf("%s does not match the md5 checksum in %s", name, archiveFiles)
+	}
+	return nil
+}
+
+func (me *restoreDir) spool(name string, r io.Reader) error {
+	f, err := os.CreateTemp("", "dynamodb-restore-*")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	me.spooled[name] = f.Name()
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "reading %s", name)
+	}
+	return nil
+}
+
+func (me *restorer) removeSpooled() {
+	for _, d := range me.dirs {
+		for _, p := range d.spooled {
+			os.Remove(p)
+		}
+	}
+}
+
+func (me *restorer) results() []*RestoredTable {
+	out := []*RestoredTable{}
+	for _, name := range sortedKeysOf(me.dirs) {
+		if res := me.dirs[name].res; res != nil {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+func (me *restorer) teardown() error {
+	var first error
+	for i := len(me.teardowns) - 1; i >= 0; i-- {
+		if err := me.teardowns[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,548 @@
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/pkg/errors"
+)
+
+// RecordCassettesEnv makes cassettes record even when their file exists, when set to a
+// true value. A boolean -record flag defined by the test binary does the same.
+const RecordCassettesEnv = "TESTRC_RECORD_CASSETTES"
+
+// CassetteMode decides whether a cassette records or replays.
+type CassetteMode int
+
+const (
+	// CassetteAuto replays when the cassette file exists and records otherwise.
+	CassetteAuto CassetteMode = iota
+	CassetteRecord
+	CassetteReplay
+)
+
+// CassetteMatch decides how replayed requests are matched with recorded ones.
+type CassetteMatch int
+
+const (
+	// MatchLenient replays any recording of the same operation and input, in any order
+	// and as often as it is requested.
+	MatchLenient CassetteMatch = iota
+	// MatchStrict replays the recordings in order, each once, and requires all of them
+	// to be used.
+	MatchStrict
+)
+
+// CassetteOption configures a Cassette.
+type CassetteOption func(*Cassette)
+
+// WithCassetteMode forces recording or replaying.
+func WithCassetteMode(mode CassetteMode) CassetteOption {
+	return func(c *Cassette) { c.mode = mode }
+}
+
+// WithCassetteMatch sets how requests are matched, MatchLenient by default.
+func WithCassetteMatch(match CassetteMatch) CassetteOption {
+	return func(c *Cassette) { c.match = match }
+}
+
+// IgnoreRequestFields leaves fields of the request out of matching, by path like
+// IgnoreAttributes, e.g. "ExpressionAttributeValues.:now.N". ClientRequestToken, which
+// the sdk fills with a random token, is always ignored.
+func IgnoreRequestFields(paths ...string) CassetteOption {
+	return func(c *Cassette) { c.ignore = append(c.ignore, paths...) }
+}
+
+// Interaction is one recorded request and its response. Request is the canonical json
+// of the request body, Operation is the service and operation, e.g. "DynamoDB.GetItem".
+type Interaction struct {
+	Operation    string            `json:"operation"`
+	Request      json.RawMessage   `json:"request,omitempty"`
+	Status       int               `json:"status"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Response     json.RawMessage   `json:"response,omitempty"`
+	ResponseText string            `json:"response_text,omitempty"`
+}
+
+// Cassette records the http traffic of aws clients to a file and replays it later
+// without an endpoint. It works on the raw requests and responses, so any client built
+// from an aws.Config can use it, not only DynamoDB.
+type Cassette struct {
+	Path         string
+	Interactions []*Interaction
+
+	mode      CassetteMode
+	match     CassetteMatch
+	ignore    []string
+	recording bool
+
+	mu         sync.Mutex
+	used       []bool
+	next       int
+	mismatches []*CassetteMismatch
+}
+
+type cassetteFile struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// skippedHeaders are not recorded. The checksum is dropped because bodies are
+// reformatted in the cassette.
+var skippedHeaders = map[string]bool{"Date": true, "Content-Length": true, "Connection": true, "Server": true, "X-Amz-Crc32": true}
+
+func recordCassettes() bool {
+	if v, err := strconv.ParseBool(os.Getenv(RecordCassettesEnv)); err == nil && v {
+		return true
+	}
+	if f := flag.Lookup("record"); f != nil {
+		if v, err := strconv.ParseBool(f.Value.String()); err == nil && v {
+			return true
+		}
+	}
+	return false
+}
+
+// NewCassette opens the cassette at path, loading its interactions when it replays.
+func NewCassette(path string, opts ...CassetteOption) (*Cassette, error) {
+	me := &Cassette{Path: path, ignore: []string{"ClientRequestToken"}}
+	for _, opt := range opts {
+		opt(me)
+	}
+
+	switch me.mode {
+	case CassetteRecord:
+		me.recording = true
+	case CassetteAuto:
+		if recordCassettes() {
+			me.recording = true
+		} else if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			me.recording = true
+		}
+	}
+	if me.recording {
+		return me, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading cassette (set %s=1 or run with -record to record it)", RecordCassettesEnv)
+	}
+	var f cassetteFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.Wrapf(err, "decoding cassette %s", path)
+	}
+	me.Interactions = f.Interactions
+	me.used = make([]bool, len(f.Interactions))
+	return me, nil
+}
+
+// Recording reports whether the cassette records, so the test needs a real endpoint.
+func (me *Cassette) Recording() bool {
+	return me.recording
+}
+
+// APIOption adds the cassette to a stack, e.g. appended to aws.Config.APIOptions.
+func (me *Cassette) APIOption() func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("testrc.cassette", me.handle), middleware.After)
+	}
+}
+
+// ClientOption adds the cassette to a client, e.g. img.NewClient(cas.ClientOption()).
+func (me *Cassette) ClientOption() func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, me.APIOption())
+	}
+}
+
+// ReplayClient returns a client that replays the cassette. Its endpoint does not
+// resolve, so nothing leaves the process.
+func (me *Cassette) ReplayClient(optFns ...func(*dynamodb.Options)) *dynamodb.Client {
+	return NewEndpointClient("http://cassette.invalid", append(optFns, me.ClientOption())...)
+}
+
+// Mismatches returns the requests that were not found while replaying.
+func (me *Cassette) Mismatches() []*CassetteMismatch {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]*CassetteMismatch{}, me.mismatches...)
+}
+
+// Unused returns the recorded interactions no request has replayed.
+func (me *Cassette) Unused() []*Interaction {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	out := []*Interaction{}
+	for i, u := range me.used {
+		if !u {
+			out = append(out, me.Interactions[i])
+		}
+	}
+	return out
+}
+
+// Save writes the recorded interactions to the cassette file.
+func (me *Cassette) Save() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&cassetteFile{Interactions: me.Interactions}); err != nil {
+		return errors.Wrap(err, "encoding cassette")
+	}
+	if err := os.MkdirAll(filepath.Dir(me.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(me.Path, buf.Bytes(), 0o644)
+}
+
+func (me *Cassette) handle(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return next.HandleDeserialize(ctx, in)
+	}
+
+	var body []byte
+	if s := req.GetStream(); s != nil {
+		b, err := io.ReadAll(s)
+		if err != nil {
+			return middleware.DeserializeOutput{}, middleware.Metadata{}, errors.Wrap(err, "reading request body for the cassette")
+		}
+		if req, err = req.SetStream(bytes.NewReader(b)); err != nil {
+			return middleware.DeserializeOutput{}, middleware.Metadata{}, err
+		}
+		in.Request, body = req, b
+	}
+
+	op := awsmiddleware.GetServiceID(ctx) + "." + awsmiddleware.GetOperationName(ctx)
+	if me.recording {
+		return me.record(ctx, in, next, op, body)
+	}
+	return me.replay(op, body)
+}
+
+func (me *Cassette) record(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler, op string, body []byte) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	out, md, err := next.HandleDeserialize(ctx, in)
+	if err != nil {
+		return out, md, err
+	}
+	resp, ok := out.RawResponse.(*smithyhttp.Response)
+	if !ok {
+		return out, md, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return out, md, errors.Wrap(err, "reading response body for the cassette")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	it := &Interaction{Operation: op, Request: cassetteRequest(body), Status: resp.StatusCode, Headers: map[string]string{}}
+	for k := range resp.Header {
+		if !skippedHeaders[k] {
+			it.Headers[k] = resp.Header.Get(k)
+		}
+	}
+	if json.Valid(b) {
+		it.Response = b
+	} else {
+		it.ResponseText = string(b)
+	}
+
+	me.mu.Lock()
+	me.Interactions = append(me.Interactions, it)
+	me.mu.Unlock()
+	return out, md, nil
+}
+
+func (me *Cassette) replay(op string, body []byte) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	req := me.normalize(cassetteRequest(body))
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	found := -1
+	if me.match == MatchStrict {
+		if me.next < len(me.Interactions) {
+			it := me.Interactions[me.next]
+			if it.Operation == op && me.normalize(it.Request) == req {
+				found = me.next
+				me.next++
+			}
+		}
+	} else {
+		for i, it := range me.Interactions {
+			if it.Operation == op && me.normalize(it.Request) == req {
+				if found < 0 || (me.used[found] && !me.used[i]) {
+					found = i
+				}
+			}
+		}
+	}
+	if found < 0 {
+		m := me.mismatch(op, req)
+		me.mismatches = append(me.mismatches, m)
+		return middleware.DeserializeOutput{}, middleware.Metadata{}, m
+	}
+	me.used[found] = true
+
+	it := me.Interactions[found]
+	b := []byte(it.Response)
+	if len(b) == 0 {
+		b = []byte(it.ResponseText)
+	}
+	resp := &http.Response{StatusCode: it.Status, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(b)), ContentLength: int64(len(b))}
+	for k, v := range it.Headers {
+		resp.Header.Set(k, v)
+	}
+	return middleware.DeserializeOutput{RawResponse: &smithyhttp.Response{Response: resp}}, middleware.Metadata{}, nil
+}
+
+// cassetteRequest is the canonical json of a request body, or the body as a json string
+// when it is not json.
+func cassetteRequest(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	v, err := decodeCassetteJSON(body)
+	if err != nil {
+		v = string(body)
+	}
+	return json.RawMessage(canonicalJSON(v))
+}
+
+func decodeCassetteJSON(b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// normalize is the request without ignored fields, as compared when replaying.
+func (me *Cassette) normalize(raw json.RawMessage) string {
+	v, err := decodeCassetteJSON(raw)
+	if err != nil {
+		return string(raw)
+	}
+	return canonicalJSON(me.strip("", v))
+}
+
+func (me *Cassette) strip(p string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			cp := joinFieldPath(p, k)
+			if !me.ignored(cp) {
+				out[k] = me.strip(cp, e)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(v))
+		for i, e := range v {
+			cp := joinFieldPath(p, strconv.Itoa(i))
+			if !me.ignored(cp) {
+				out = append(out, me.strip(cp, e))
+			}
+		}
+		return out
+	}
+	return v
+}
+
+func (me *Cassette) ignored(p string) bool {
+	for _, pattern := range me.ignore {
+		if matchAttributePath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinFieldPath(p, k string) string {
+	if p == "" {
+		return k
+	}
+	return p + "." + k
+}
+
+// CassetteMismatch is a replayed request without a recording. Closest is the recording
+// of the same operation with the fewest differing fields, Differences lists them with -
+// for fields only in the recording and + for fields only in the request.
+type CassetteMismatch struct {
+	Path        string
+	Operation   string
+	Request     string
+	Strict      bool
+	Expected    *Interaction
+	Closest     *Interaction
+	Differences []string
+}
+
+func (me *CassetteMismatch) Error() string {
+	var b strings.Builder
+	if me.Strict && me.Expected != nil {
+		fmt.Fprintf(&b, "cassette %s: expected %s, got %s %s", me.Path, me.Expected.Operation, me.Operation, me.Request)
+	} else if me.Strict {
+		fmt.Fprintf(&b, "cassette %s: all interactions are used, got %s %s", me.Path, me.Operation, me.Request)
+	} else {
+		fmt.Fprintf(&b, "cassette %s: no recorded %s matches %s", me.Path, me.Operation, me.Request)
+	}
+	if me.Closest == nil {
+		return b.String()
+	}
+	if me.Strict {
+		b.WriteString("\ndifferences from the expected request:")
+	} else {
+		b.WriteString("\ndifferences from the closest recording:")
+	}
+	for _, d := range me.Differences {
+		b.WriteString("\n    ")
+		b.WriteString(d)
+	}
+	return b.String()
+}
+
+func (me *Cassette) mismatch(op, req string) *CassetteMismatch {
+	m := &CassetteMismatch{Path: me.Path, Operation: op, Request: req, Strict: me.match == MatchStrict}
+
+	candidates := me.Interactions
+	if m.Strict {
+		candidates = nil
+		if me.next < len(me.Interactions) {
+			m.Expected = me.Interactions[me.next]
+			candidates = []*Interaction{m.Expected}
+		}
+	}
+	for _, it := range candidates {
+		if it.Operation != op {
+			continue
+		}
+		diffs := diffCassetteRequests(me.normalize(it.Request), req)
+		if m.Closest == nil || len(diffs) < len(m.Differences) {
+			m.Closest, m.Differences = it, diffs
+		}
+	}
+	return m
+}
+
+func diffCassetteRequests(recorded, requested string) []string {
+	a, b := map[string]string{}, map[string]string{}
+	if v, err := decodeCassetteJSON([]byte(recorded)); err == nil {
+		flattenCassetteJSON("", v, a)
+	}
+	if v, err := decodeCassetteJSON([]byte(requested)); err == nil {
+		flattenCassetteJSON("", v, b)
+	}
+
+	paths := map[string]bool{}
+	for p := range a {
+		paths[p] = true
+	}
+	for p := range b {
+		paths[p] = true
+	}
+	out := []string{}
+	for _, p := range sortedKeysOf(paths) {
+		av, inA := a[p]
+		bv, inB := b[p]
+		switch {
+		case !inB:
+			out = append(out, fmt.Sprintf("- %s: %s", p, av))
+		case !inA:
+			out = append(out, fmt.Sprintf("+ %s: %s", p, bv))
+		case av != bv:
+			out = append(out, fmt.Sprintf("~ %s: %s -> %s", p, av, bv))
+		}
+	}
+	return out
+}
+
+func flattenCassetteJSON(p string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			out[p] = "{}"
+		}
+		for k, e := range v {
+			flattenCassetteJSON(joinFieldPath(p, k), e, out)
+		}
+	case []any:
+		if len(v) == 0 {
+			out[p] = "[]"
+		}
+		for i, e := range v {
+			flattenCassetteJSON(joinFieldPath(p, strconv.Itoa(i)), e, out)
+		}
+	default:
+		out[p] = canonicalJSON(v)
+	}
+}
+
+// CassetteT opens the cassette at path for the test. When it records, the cassette is
+// saved if the test passes. When it replays, requests without a recording fail the test
+// and so do unused recordings with MatchStrict. For example:
+//
+//	cas := dynamodb.CassetteT(t, "testdata/orders.cassette.json")
+//	cli := cas.ReplayClient()
+//	if cas.Recording() {
+//		cli, _ = dynamodb.EmulateT(t).NewClient(cas.ClientOption())
+//	}
+func CassetteT(t testing.TB, path string, opts ...CassetteOption) *Cassette {
+	t.Helper()
+
+	cas, err := NewCassette(path, opts...)
+	if err != nil {
+		t.Fatalf("dynamodb: %s", err)
+		return nil
+	}
+
+	t.Cleanup(func() {
+		if cas.recording {
+			if t.Failed() {
+				return
+			}
+			if err := cas.Save(); err != nil {
+				t.Errorf("dynamodb: saving cassette %s: %s", path, err)
+				return
+			}
+			t.Logf("dynamodb: recorded %d interactions to %s", len(cas.Interactions), path)
+			return
+		}
+		for _, m := range cas.Mismatches() {
+			t.Errorf("dynamodb: %s", m)
+		}
+		if cas.match == MatchStrict {
+			if unused := cas.Unused(); len(unused) > 0 {
+				t.Errorf("dynamodb: cassette %s: %d interactions were not replayed, the first is %s %s", path, len(unused), unused[0].Operation, unused[0].Request)
+			}
+		}
+	})
+	return cas
+}
@@ -1,45 +1,56 @@
 package dynamodb
 
 import (
-	"context"
 	"errors"
 
 	"github.com/walteh/testrc/pkg/aws"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	"github.com/aws/smithy-go/ptr"
 )
 
-func (me *DockerImage) NewClient() (*dynamodb.Client, error) {
-	if me.active == nil {
-		return nil, errors.New("container not active")
+func (me *DockerImage) endpoint() (string, error) {
+	switch {
+	case me.emulator != nil:
+		return me.emulator.URL(), nil
+	case me.active != nil:
+		return me.active.GetHttpHost(), nil
+	default:
+		return "", errors.New("container not active")
 	}
-	cli := dynamodb.NewFromConfig(aws.V2Config(), func(o *dynamodb.Options) {
-		o.BaseEndpoint = ptr.String(me.active.GetHttpHost())
-	})
-
-	return cli, nil
 }
 
-func Provision(ctx context.Context, cli *dynamodb.Client, input *dynamodb.CreateTableInput) (func() error, error) {
-
-	if input.TableName == nil {
-		return nil, errors.New("TableName is nil")
-	}
-	_, err := cli.CreateTable(ctx, input)
+// NewClient returns a client for the container or emulator. optFns are applied after the
+// endpoint is set, e.g. to add middleware like a Namespace.
+func (me *DockerImage) NewClient(optFns ...func(*dynamodb.Options)) (*dynamodb.Client, error) {
+	endpoint, err := me.endpoint()
 	if err != nil {
 		return nil, err
 	}
+	return NewEndpointClient(endpoint, optFns...), nil
+}
 
-	teardown := func() error {
-		_, err := cli.DeleteTable(ctx, &dynamodb.DeleteTableInput{
-			TableName: input.TableName,
-		})
-		if err != nil {
-			return err
-		}
-		return nil
+// NewEndpointClient returns a client for a dynamodb-local or emulator endpoint, e.g. the
+// value of EndpointURLEnv.
+func NewEndpointClient(endpoint string, optFns ...func(*dynamodb.Options)) *dynamodb.Client {
+	optFns = append([]func(*dynamodb.Options){func(o *dynamodb.Options) {
+		o.BaseEndpoint = ptr.String(endpoint)
+	}}, optFns...)
+	return dynamodb.NewFromConfig(aws.V2Config(), optFns...)
+}
+
+// NewStreamsClient returns a DynamoDB Streams client for the container or emulator, which
+// serve streams on the same endpoint.
+func (me *DockerImage) NewStreamsClient(optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.Client, error) {
+	endpoint, err := me.endpoint()
+	if err != nil {
+		return nil, err
 	}
+	optFns = append([]func(*dynamodbstreams.Options){func(o *dynamodbstreams.Options) {
+		o.BaseEndpoint = ptr.String(endpoint)
+	}}, optFns...)
+	cli := dynamodbstreams.NewFromConfig(aws.V2Config(), optFns...)
 
-	return teardown, nil
+	return cli, nil
 }
@@ -0,0 +1,447 @@
+package dynamodb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseCloudFormation returns the AWS::DynamoDB::Table, AWS::DynamoDB::GlobalTable and
+// AWS::Serverless::SimpleTable resources of a template. Json templates are parsed as
+// yaml. Ref, Fn::Sub, Fn::Join and Fn::Select are resolved against the parameter
+// defaults, vars and the usual pseudo parameters; anything else is an error when a
+// table needs it.
+func ParseCloudFormation(b []byte, vars map[string]string) ([]*TableDefinition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, errors.Wrap(err, "parsing template")
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root, ok := cfnValue(doc.Content[0]).(map[string]any)
+	if !ok {
+		return nil, errors.New("template is not a mapping")
+	}
+
+	r := &cfnResolver{params: map[string]string{
+		"AWS::Region":    "us-east-1",
+		"AWS::AccountId": "000000000000",
+		"AWS::StackName": "testrc",
+		"AWS::Partition": "aws",
+		"AWS::URLSuffix": "amazonaws.com",
+	}}
+	if params, ok := root["Parameters"].(map[string]any); ok {
+		for name, p := range params {
+			if pm, ok := p.(map[string]any); ok {
+				if def, ok := pm["Default"].(string); ok {
+					r.params[name] = def
+				}
+			}
+		}
+	}
+	for k, v := range vars {
+		r.params[k] = v
+	}
+
+	resources, _ := root["Resources"].(map[string]any)
+	ids := make([]string, 0, len(resources))
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	defs := []*TableDefinition{}
+	for _, id := range ids {
+		res, ok := resources[id].(map[string]any)
+		if !ok {
+			continue
+		}
+		typ, _ := res["Type"].(string)
+		props, _ := res["Properties"].(map[string]any)
+		if props == nil {
+			props = map[string]any{}
+		}
+
+		var def *TableDefinition
+		var err error
+		switch typ {
+		case "AWS::DynamoDB::Table", "AWS::DynamoDB::GlobalTable":
+			def, err = r.table(id, props)
+		case "AWS::Serverless::SimpleTable":
+			def, err = r.simpleTable(id, props)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "resource %s", id)
+		}
+		defs = append(defs, def)
+	}
+
+	return defs, nil
+}
+
+// cfnValue turns a yaml node into maps, slices and strings, rewriting the short form
+// intrinsic tags (!Ref, !Sub, ...) into their long form.
+func cfnValue(n *yaml.Node) any {
+	var v any
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 {
+			return cfnValue(n.Content[0])
+		}
+		return nil
+	case yaml.AliasNode:
+		return cfnValue(n.Alias)
+	case yaml.MappingNode:
+		m := map[string]any{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = cfnValue(n.Content[i+1])
+		}
+		v = m
+	case yaml.SequenceNode:
+		l := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			l = append(l, cfnValue(c))
+		}
+		v = l
+	default:
+		v = n.Value
+	}
+
+	if strings.HasPrefix(n.Tag, "!") && !strings.HasPrefix(n.Tag, "!!") {
+		fn := strings.TrimPrefix(n.Tag, "!")
+		if fn == "GetAtt" {
+			if s, ok := v.(string); ok {
+				v = strings.SplitN(s, ".", 2)
+			}
+		}
+		if fn != "Ref" && fn != "Condition" {
+			fn = "Fn::" + fn
+		}
+		return map[string]any{fn: v}
+	}
+	return v
+}
+
+type cfnResolver struct {
+	params map[string]string
+}
+
+var cfnSubVar = regexp.MustCompile(`\$\{([^}!][^}]*)\}`)
+
+// str resolves a property to a string.
+func (me *cfnResolver) str(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case map[string]any:
+		if len(v) != 1 {
+			break
+		}
+		for fn, arg := range v {
+			switch fn {
+			case "Ref":
+				name, _ := arg.(string)
+				if p, ok := me.params[name]; ok {
+					return p, nil
+				}
+				return "", errors.Errorf("cannot resolve Ref %s", name)
+			case "Fn::Sub":
+				tmpl := arg
+				extra := map[string]any{}
+				if l, ok := arg.([]any); ok && len(l) == 2 {
+					tmpl = l[0]
+					extra, _ = l[1].(map[string]any)
+				}
+				s, ok := tmpl.(string)
+				if !ok {
+					return "", errors.New("Fn::Sub needs a string template")
+				}
+				var subErr error
+				out := cfnSubVar.ReplaceAllStringFunc(s, func(m string) string {
+					name := m[2 : len(m)-1]
+					if e, ok := extra[name]; ok {
+						r, err := me.str(e)
+						if err != nil {
+							subErr = err
+						}
+						return r
+					}
+					if p, ok := me.params[name]; ok {
+						return p
+					}
+					subErr = errors.Errorf("cannot resolve ${%s} in Fn::Sub", name)
+					return m
+				})
+				return strings.ReplaceAll(out, "${!", "${"), subErr
+			case "Fn::Join":
+				l, ok := arg.([]any)
+				if !ok || len(l) != 2 {
+					return "", errors.New("Fn::Join needs a delimiter and a list")
+				}
+				sep, _ := l[0].(string)
+				parts, _ := l[1].([]any)
+				strs := make([]string, 0, len(parts))
+				for _, p := range parts {
+					s, err := me.str(p)
+					if err != nil {
+						return "", err
+					}
+					strs = append(strs, s)
+				}
+				return strings.Join(strs, sep), nil
+			case "Fn::Select":
+				l, ok := arg.([]any)
+				if !ok || len(l) != 2 {
+					return "", errors.New("Fn::Select needs an index and a list")
+				}
+				idx, err := me.str(l[0])
+				if err != nil {
+					return "", err
+				}
+				var i int
+				if _, err := fmt.Sscanf(idx, "%d", &i); err != nil {
+					return "", errors.Errorf("Fn::Select index %q is not a number", idx)
+				}
+				list, _ := l[1].([]any)
+				if i < 0 || i >= len(list) {
+					return "", errors.Errorf("Fn::Select index %d out of range", i)
+				}
+				return me.str(list[i])
+			}
+			return "", errors.Errorf("unsupported intrinsic function %s", fn)
+		}
+	}
+	return "", errors.Errorf("expected a string, got %T", v)
+}
+
+func (me *cfnResolver) list(v any) []map[string]any {
+	l, _ := v.([]any)
+	out := make([]map[string]any, 0, len(l))
+	for _, e := range l {
+		if m, ok := e.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (me *cfnResolver) keySchema(v any) ([]types.KeySchemaElement, error) {
+	ks := []types.KeySchemaElement{}
+	for _, k := range me.list(v) {
+		name, err := me.str(k["AttributeName"])
+		if err != nil {
+			return nil, err
+		}
+		typ, err := me.str(k["KeyType"])
+		if err != nil {
+			return nil, err
+		}
+		ks = append(ks, types.KeySchemaElement{AttributeName: ptr.String(name), KeyType: types.KeyType(strings.ToUpper(typ))})
+	}
+	if len(ks) == 0 {
+		return nil, errors.New("KeySchema is empty")
+	}
+	return ks, nil
+}
+
+func (me *cfnResolver) throughput(v any) (*types.ProvisionedThroughput, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	read, err := me.str(m["ReadCapacityUnits"])
+	if err != nil {
+		return nil, err
+	}
+	write, err := me.str(m["WriteCapacityUnits"])
+	if err != nil {
+		return nil, err
+	}
+	return throughput(read, write)
+}
+
+func (me *cfnResolver) projection(v any) (*types.Projection, error) {
+	m, _ := v.(map[string]any)
+	typ, err := me.str(m["ProjectionType"])
+	if err != nil {
+		return nil, err
+	}
+	nonKey := []string{}
+	l, _ := m["NonKeyAttributes"].([]any)
+	for _, e := range l {
+		s, err := me.str(e)
+		if err != nil {
+			return nil, err
+		}
+		nonKey = append(nonKey, s)
+	}
+	if len(nonKey) == 0 {
+		nonKey = nil
+	}
+	return projection(typ, nonKey), nil
+}
+
+func (me *cfnResolver) table(id string, props map[string]any) (*TableDefinition, error) {
+	b := &tableBuilder{resource: id, attrs: map[string]string{}, tags: map[string]string{}}
+	var err error
+
+	if b.name, err = me.str(props["TableName"]); err != nil {
+		return nil, errors.Wrap(err, "TableName")
+	}
+	if b.billingMode, err = me.str(props["BillingMode"]); err != nil {
+		return nil, errors.Wrap(err, "BillingMode")
+	}
+	if b.keySchema, err = me.keySchema(props["KeySchema"]); err != nil {
+		return nil, errors.Wrap(err, "KeySchema")
+	}
+
+	for _, a := range me.list(props["AttributeDefinitions"]) {
+		name, err := me.str(a["AttributeName"])
+		if err != nil {
+			return nil, errors.Wrap(err, "AttributeDefinitions")
+		}
+		typ, err := me.str(a["AttributeType"])
+		if err != nil {
+			return nil, errors.Wrap(err, "AttributeDefinitions")
+		}
+		b.attrs[name] = typ
+	}
+
+	if tp, ok := props["ProvisionedThroughput"].(map[string]any); ok {
+		if b.read, err = me.str(tp["ReadCapacityUnits"]); err != nil {
+			return nil, errors.Wrap(err, "ProvisionedThroughput")
+		}
+		if b.write, err = me.str(tp["WriteCapacityUnits"]); err != nil {
+			return nil, errors.Wrap(err, "ProvisionedThroughput")
+		}
+	}
+
+	for _, g := range me.list(props["GlobalSecondaryIndexes"]) {
+		idx := types.GlobalSecondaryIndex{}
+		name, err := me.str(g["IndexName"])
+		if err != nil {
+			return nil, errors.Wrap(err, "GlobalSecondaryIndexes")
+		}
+		idx.IndexName = ptr.String(name)
+		if idx.KeySchema, err = me.keySchema(g["KeySchema"]); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		if idx.Projection, err = me.projection(g["Projection"]); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		if idx.ProvisionedThroughput, err = me.throughput(g["ProvisionedThroughput"]); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		b.gsis = append(b.gsis, idx)
+	}
+
+	for _, l := range me.list(props["LocalSecondaryIndexes"]) {
+		idx := types.LocalSecondaryIndex{}
+		name, err := me.str(l["IndexName"])
+		if err != nil {
+			return nil, errors.Wrap(err, "LocalSecondaryIndexes")
+		}
+		idx.IndexName = ptr.String(name)
+		if idx.KeySchema, err = me.keySchema(l["KeySchema"]); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		if idx.Projection, err = me.projection(l["Projection"]); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		b.lsis = append(b.lsis, idx)
+	}
+
+	if s, ok := props["StreamSpecification"].(map[string]any); ok {
+		if b.streamView, err = me.str(s["StreamViewType"]); err != nil {
+			return nil, errors.Wrap(err, "StreamSpecification")
+		}
+	}
+
+	if t, ok := props["TimeToLiveSpecification"].(map[string]any); ok {
+		if b.ttlAttr, err = me.str(t["AttributeName"]); err != nil {
+			return nil, errors.Wrap(err, "TimeToLiveSpecification")
+		}
+		enabled, err := me.str(t["Enabled"])
+		if err != nil {
+			return nil, errors.Wrap(err, "TimeToLiveSpecification")
+		}
+		b.ttlEnabled = strings.EqualFold(enabled, "true")
+	}
+
+	if p, ok := props["PointInTimeRecoverySpecification"].(map[string]any); ok {
+		enabled, err := me.str(p["PointInTimeRecoveryEnabled"])
+		if err != nil {
+			return nil, errors.Wrap(err, "PointInTimeRecoverySpecification")
+		}
+		b.pitr = strings.EqualFold(enabled, "true")
+	}
+
+	for _, t := range me.list(props["Tags"]) {
+		k, err := me.str(t["Key"])
+		if err != nil {
+			return nil, errors.Wrap(err, "Tags")
+		}
+		v, err := me.str(t["Value"])
+		if err != nil {
+			return nil, errors.Wrap(err, "Tags")
+		}
+		b.tags[k] = v
+	}
+
+	return b.build()
+}
+
+var samKeyTypes = map[string]string{"String": "S", "Number": "N", "Binary": "B"}
+
+func (me *cfnResolver) simpleTable(id string, props map[string]any) (*TableDefinition, error) {
+	b := &tableBuilder{resource: id, attrs: map[string]string{}, tags: map[string]string{}, hashKey: "id"}
+	var err error
+
+	if b.name, err = me.str(props["TableName"]); err != nil {
+		return nil, errors.Wrap(err, "TableName")
+	}
+
+	typ := "String"
+	if pk, ok := props["PrimaryKey"].(map[string]any); ok {
+		if b.hashKey, err = me.str(pk["Name"]); err != nil {
+			return nil, errors.Wrap(err, "PrimaryKey")
+		}
+		if typ, err = me.str(pk["Type"]); err != nil {
+			return nil, errors.Wrap(err, "PrimaryKey")
+		}
+	}
+	if b.attrs[b.hashKey] = samKeyTypes[typ]; b.attrs[b.hashKey] == "" {
+		return nil, errors.Errorf("PrimaryKey type %q must be String, Number or Binary", typ)
+	}
+
+	if tp, ok := props["ProvisionedThroughput"].(map[string]any); ok {
+		if b.read, err = me.str(tp["ReadCapacityUnits"]); err != nil {
+			return nil, errors.Wrap(err, "ProvisionedThroughput")
+		}
+		if b.write, err = me.str(tp["WriteCapacityUnits"]); err != nil {
+			return nil, errors.Wrap(err, "ProvisionedThroughput")
+		}
+	}
+
+	if tags, ok := props["Tags"].(map[string]any); ok {
+		for k, v := range tags {
+			if b.tags[k], err = me.str(v); err != nil {
+				return nil, errors.Wrap(err, "Tags")
+			}
+		}
+	}
+
+	return b.build()
+}
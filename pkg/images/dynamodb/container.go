@@ -2,16 +2,31 @@ package dynamodb
 
 import (
 	"context"
+	"time"
 
+	"github.com/walteh/testrc/pkg/aws"
 	"github.com/walteh/testrc/pkg/docker"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
 var _ docker.ContainerImage = (*DockerImage)(nil)
+var _ docker.ContainerBudget = (*DockerImage)(nil)
+var _ docker.ContainerEndpoints = (*DockerImage)(nil)
+
+func init() {
+	docker.Register("dynamodb", func() docker.ContainerImage {
+		return &DockerImage{}
+	})
+}
+
+// EndpointURLEnv holds the endpoint of a running fixture in the environment set up by
+// `testrc run` and `testrc env`.
+const EndpointURLEnv = "AWS_ENDPOINT_URL_DYNAMODB"
 
 type DockerImage struct {
-	active *docker.ContainerStore
+	active   *docker.ContainerStore
+	emulator *Emulator
 }
 
 func (me *DockerImage) OnStart(z *docker.ContainerStore) {
@@ -34,6 +49,19 @@ func (me *DockerImage) EnvVars() []string {
 	return []string{}
 }
 
+func (me *DockerImage) EndpointEnv(z *docker.ContainerStore) map[string]string {
+	env := aws.EnvVars()
+	env[EndpointURLEnv] = z.GetHttpHost()
+	return env
+}
+
+func (me *DockerImage) Budget() docker.Budget {
+	return docker.Budget{
+		Start: 30 * time.Second,
+		Ready: 30 * time.Second,
+	}
+}
+
 func (me *DockerImage) Ping(ctx context.Context) error {
 	c, err := me.NewClient()
 	if err != nil {
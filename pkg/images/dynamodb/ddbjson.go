@@ -0,0 +1,128 @@
+package dynamodb
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+// UnmarshalItemJSON decodes an item in DynamoDB JSON, the wire format that the cli,
+// exports and the emulator use: {"pk": {"S": "a"}, "n": {"N": "1"}}.
+func UnmarshalItemJSON(b []byte) (map[string]types.AttributeValue, error) {
+	it := item{}
+	if err := json.Unmarshal(b, &it); err != nil {
+		return nil, errors.Wrap(err, "decoding dynamodb json")
+	}
+	return it.toSDK(), nil
+}
+
+// MarshalItemJSON encodes an item as DynamoDB JSON. Attribute names are sorted, so the
+// output is stable.
+func MarshalItemJSON(av map[string]types.AttributeValue) ([]byte, error) {
+	it, err := itemFromSDK(av)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(it)
+}
+
+func (me item) toSDK() map[string]types.AttributeValue {
+	out := make(map[string]types.AttributeValue, len(me))
+	for k, v := range me {
+		out[k] = v.toSDK()
+	}
+	return out
+}
+
+func (me *attrValue) toSDK() types.AttributeValue {
+	switch me.kind {
+	case "S":
+		return &types.AttributeValueMemberS{Value: me.s}
+	case "N":
+		return &types.AttributeValueMemberN{Value: me.s}
+	case "B":
+		return &types.AttributeValueMemberB{Value: me.b}
+	case "BOOL":
+		return &types.AttributeValueMemberBOOL{Value: me.bl}
+	case "NULL":
+		return &types.AttributeValueMemberNULL{Value: me.bl}
+	case "SS":
+		return &types.AttributeValueMemberSS{Value: append([]string{}, me.ss...)}
+	case "NS":
+		return &types.AttributeValueMemberNS{Value: append([]string{}, me.ss...)}
+	case "BS":
+		return &types.AttributeValueMemberBS{Value: me.bs}
+	case "L":
+		l := make([]types.AttributeValue, len(me.l))
+		for i, v := range me.l {
+			l[i] = v.toSDK()
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	case "M":
+		return &types.AttributeValueMemberM{Value: item(me.m).toSDK()}
+	}
+	return nil
+}
+
+func itemFromSDK(av map[string]types.AttributeValue) (item, error) {
+	it := make(item, len(av))
+	for k, v := range av {
+		a, err := attrFromSDK(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "attribute %s", k)
+		}
+		it[k] = a
+	}
+	return it, nil
+}
+
+func attrFromSDK(av types.AttributeValue) (*attrValue, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return &attrValue{kind: "S", s: v.Value}, nil
+	case *types.AttributeValueMemberN:
+		n, err := normalizeNumber(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &attrValue{kind: "N", s: n}, nil
+	case *types.AttributeValueMemberB:
+		return &attrValue{kind: "B", b: v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return &attrValue{kind: "BOOL", bl: v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return &attrValue{kind: "NULL", bl: v.Value}, nil
+	case *types.AttributeValueMemberSS:
+		return &attrValue{kind: "SS", ss: append([]string{}, v.Value...)}, nil
+	case *types.AttributeValueMemberNS:
+		ns := make([]string, len(v.Value))
+		for i, n := range v.Value {
+			norm, err := normalizeNumber(n)
+			if err != nil {
+				return nil, err
+			}
+			ns[i] = norm
+		}
+		return &attrValue{kind: "NS", ss: ns}, nil
+	case *types.AttributeValueMemberBS:
+		return &attrValue{kind: "BS", bs: v.Value}, nil
+	case *types.AttributeValueMemberL:
+		l := make([]*attrValue, len(v.Value))
+		for i, e := range v.Value {
+			a, err := attrFromSDK(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = a
+		}
+		return &attrValue{kind: "L", l: l}, nil
+	case *types.AttributeValueMemberM:
+		m, err := itemFromSDK(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &attrValue{kind: "M", m: m}, nil
+	}
+	return nil, errors.Errorf("unsupported attribute value %T", av)
+}
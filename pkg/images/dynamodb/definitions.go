@@ -0,0 +1,218 @@
+package dynamodb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// TableDefinition is a table declared in infrastructure code, ready to be provisioned.
+type TableDefinition struct {
+	// Resource is the CloudFormation logical id or the terraform resource name.
+	Resource string
+	Input    *dynamodb.CreateTableInput
+	// TimeToLive and PointInTimeRecovery are applied after the table is created, since
+	// CreateTable does not take them.
+	TimeToLive          *types.TimeToLiveSpecification
+	PointInTimeRecovery bool
+}
+
+// LoadDefinitions reads the tables declared in a CloudFormation/SAM template (yaml or
+// json) or a terraform file. Terraform is detected by the .tf extension. Parameters
+// and variables resolve to their defaults unless overridden in vars.
+func LoadDefinitions(path string, vars map[string]string) ([]*TableDefinition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	var defs []*TableDefinition
+	if filepath.Ext(path) == ".tf" {
+		defs, err = ParseTerraform(b, vars)
+	} else {
+		defs, err = ParseCloudFormation(b, vars)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading tables from %s", path)
+	}
+	return defs, nil
+}
+
+// ProvisionAll provisions every table and returns a teardown that deletes them again.
+// If one of them fails, the tables created so far are deleted before returning.
+func ProvisionAll(ctx context.Context, cli *dynamodb.Client, defs ...*TableDefinition) ([]*ProvisionedTable, func() error, error) {
+	tables := make([]*ProvisionedTable, 0, len(defs))
+	teardowns := make([]func() error, 0, len(defs))
+
+	teardown := func() error {
+		var first error
+		for i := len(teardowns) - 1; i >= 0; i-- {
+			if err := teardowns[i](); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	for _, def := range defs {
+		table, td, err := Provision(ctx, cli, def)
+		if err != nil {
+			_ = teardown()
+			return nil, nil, errors.Wrapf(err, "provisioning %s", def.Resource)
+		}
+		tables = append(tables, table)
+		teardowns = append(teardowns, td)
+	}
+
+	return tables, teardown, nil
+}
+
+// tableBuilder collects the pieces both template formats describe and turns them into
+// a CreateTableInput, so the defaults are the same for both.
+type tableBuilder struct {
+	resource    string
+	name        string
+	billingMode string
+	read, write string
+	hashKey     string
+	rangeKey    string
+	attrs       map[string]string
+	keySchema   []types.KeySchemaElement
+	gsis        []types.GlobalSecondaryIndex
+	lsis        []types.LocalSecondaryIndex
+	streamView  string
+	ttlAttr     string
+	ttlEnabled  bool
+	pitr        bool
+	tags        map[string]string
+}
+
+func parseCapacity(field, v string) (*int64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("%s must be a number, got %q", field, v)
+	}
+	return &n, nil
+}
+
+func throughput(read, write string) (*types.ProvisionedThroughput, error) {
+	r, err := parseCapacity("read capacity", read)
+	if err != nil {
+		return nil, err
+	}
+	w, err := parseCapacity("write capacity", write)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil && w == nil {
+		return nil, nil
+	}
+	if r == nil || w == nil {
+		return nil, errors.New("read and write capacity must be set together")
+	}
+	return &types.ProvisionedThroughput{ReadCapacityUnits: r, WriteCapacityUnits: w}, nil
+}
+
+func keySchema(hash, rng string) []types.KeySchemaElement {
+	ks := []types.KeySchemaElement{{AttributeName: ptr.String(hash), KeyType: types.KeyTypeHash}}
+	if rng != "" {
+		ks = append(ks, types.KeySchemaElement{AttributeName: ptr.String(rng), KeyType: types.KeyTypeRange})
+	}
+	return ks
+}
+
+func projection(typ string, nonKey []string) *types.Projection {
+	if typ == "" {
+		typ = string(types.ProjectionTypeAll)
+	}
+	return &types.Projection{ProjectionType: types.ProjectionType(strings.ToUpper(typ)), NonKeyAttributes: nonKey}
+}
+
+func (me *tableBuilder) build() (*TableDefinition, error) {
+	if me.name == "" {
+		me.name = me.resource
+	}
+
+	in := &dynamodb.CreateTableInput{
+		TableName: ptr.String(me.name),
+		KeySchema: me.keySchema,
+	}
+	if in.KeySchema == nil {
+		if me.hashKey == "" {
+			return nil, errors.Errorf("%s: no hash key", me.resource)
+		}
+		in.KeySchema = keySchema(me.hashKey, me.rangeKey)
+	}
+
+	for _, name := range sortedKeysOf(me.attrs) {
+		in.AttributeDefinitions = append(in.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: ptr.String(name),
+			AttributeType: types.ScalarAttributeType(me.attrs[name]),
+		})
+	}
+
+	mode := strings.ToUpper(me.billingMode)
+	tp, err := throughput(me.read, me.write)
+	if err != nil {
+		return nil, errors.Wrap(err, me.resource)
+	}
+	switch {
+	case mode == string(types.BillingModePayPerRequest):
+		in.BillingMode = types.BillingModePayPerRequest
+	case tp != nil:
+		in.BillingMode = types.BillingModeProvisioned
+		in.ProvisionedThroughput = tp
+	case mode == "" || mode == string(types.BillingModeProvisioned):
+		// templates without capacity deploy fine with defaults, local tables should too
+		in.BillingMode = types.BillingModePayPerRequest
+	default:
+		return nil, errors.Errorf("%s: unknown billing mode %q", me.resource, me.billingMode)
+	}
+
+	for _, g := range me.gsis {
+		if in.BillingMode == types.BillingModePayPerRequest {
+			g.ProvisionedThroughput = nil
+		} else if g.ProvisionedThroughput == nil {
+			g.ProvisionedThroughput = in.ProvisionedThroughput
+		}
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, g)
+	}
+	in.LocalSecondaryIndexes = me.lsis
+
+	if me.streamView != "" {
+		in.StreamSpecification = &types.StreamSpecification{
+			StreamEnabled:  ptr.Bool(true),
+			StreamViewType: types.StreamViewType(strings.ToUpper(me.streamView)),
+		}
+	}
+
+	keys := make([]string, 0, len(me.tags))
+	for k := range me.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		in.Tags = append(in.Tags, types.Tag{Key: ptr.String(k), Value: ptr.String(me.tags[k])})
+	}
+
+	def := &TableDefinition{Resource: me.resource, Input: in, PointInTimeRecovery: me.pitr}
+	// a disabled ttl is the default, and asking dynamodb to disable it again fails
+	if me.ttlAttr != "" && me.ttlEnabled {
+		def.TimeToLive = &types.TimeToLiveSpecification{
+			AttributeName: ptr.String(me.ttlAttr),
+			Enabled:       ptr.Bool(true),
+		}
+	}
+	return def, nil
+}
@@ -0,0 +1,291 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// Snapshot is the content of a table at one point in time, held in memory.
+type Snapshot struct {
+	Table string
+	Keys  []string
+	Time  time.Time
+
+	items map[string]map[string]types.AttributeValue
+}
+
+// Capture reads every item of the table with consistent reads.
+func Capture(ctx context.Context, cli *dynamodb.Client, table string) (*Snapshot, error) {
+	keys, err := keyNames(ctx, cli, table)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Table: table, Keys: keys, Time: time.Now(), items: map[string]map[string]types.AttributeValue{}}
+	pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{TableName: ptr.String(table), ConsistentRead: ptr.Bool(true)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning %s", table)
+		}
+		for _, it := range page.Items {
+			k, err := itemKey(it, keys)
+			if err != nil {
+				return nil, err
+			}
+			snap.items[k] = it
+		}
+	}
+	return snap, nil
+}
+
+// CaptureT captures the table and fails the test if that does not work.
+func CaptureT(t testing.TB, ctx context.Context, cli *dynamodb.Client, table string) *Snapshot {
+	t.Helper()
+
+	snap, err := Capture(ctx, cli, table)
+	if err != nil {
+		t.Fatalf("dynamodb: capturing %s: %s", table, err)
+	}
+	return snap
+}
+
+// Len is the number of items in the snapshot.
+func (me *Snapshot) Len() int {
+	return len(me.items)
+}
+
+// Items returns the items ordered by key.
+func (me *Snapshot) Items() []map[string]types.AttributeValue {
+	out := make([]map[string]types.AttributeValue, 0, len(me.items))
+	for _, k := range sortedKeysOf(me.items) {
+		out = append(out, me.items[k])
+	}
+	return out
+}
+
+// ChangeType says how an item or attribute differs between two snapshots.
+type ChangeType string
+
+const (
+	ItemAdded    ChangeType = "added"
+	ItemRemoved  ChangeType = "removed"
+	ItemModified ChangeType = "modified"
+)
+
+// AttributeChange is one top level attribute of a modified item.
+type AttributeChange struct {
+	Name   string
+	Type   ChangeType
+	Before types.AttributeValue
+	After  types.AttributeValue
+}
+
+func (me *AttributeChange) String() string {
+	switch me.Type {
+	case ItemAdded:
+		return fmt.Sprintf("+ %s: %s", me.Name, diffJSON(me.After))
+	case ItemRemoved:
+		return fmt.Sprintf("- %s: %s", me.Name, diffJSON(me.Before))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", me.Name, diffJSON(me.Before), diffJSON(me.After))
+	}
+}
+
+// ItemChange is an item that was added, removed or modified. Before is nil for added
+// items and After for removed ones.
+type ItemChange struct {
+	Type       ChangeType
+	Key        string
+	Before     map[string]types.AttributeValue
+	After      map[string]types.AttributeValue
+	Attributes []*AttributeChange
+}
+
+// TableDiff lists the items that differ between two snapshots, ordered by key.
+type TableDiff struct {
+	Before  *Snapshot
+	After   *Snapshot
+	Changes []*ItemChange
+}
+
+// Empty reports whether the snapshots hold the same items.
+func (me *TableDiff) Empty() bool {
+	return len(me.Changes) == 0
+}
+
+// Count returns how many items changed in the given way.
+func (me *TableDiff) Count(typ ChangeType) int {
+	n := 0
+	for _, c := range me.Changes {
+		if c.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// String describes the changes like the golden diff does: one line per item and an
+// indented line per attribute of modified items.
+func (me *TableDiff) String() string {
+	if me.Empty() {
+		return "no changes"
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s: %d added, %d removed, %d modified\n", me.After.Table, me.Count(ItemAdded), me.Count(ItemRemoved), me.Count(ItemModified))
+	for _, c := range me.Changes {
+		switch c.Type {
+		case ItemAdded:
+			fmt.Fprintf(&out, "+ item %s\n    %s\n", c.Key, diffJSON(c.After))
+		case ItemRemoved:
+			fmt.Fprintf(&out, "- item %s\n    %s\n", c.Key, diffJSON(c.Before))
+		default:
+			fmt.Fprintf(&out, "~ item %s:\n", c.Key)
+		}
+		for _, a := range c.Attributes {
+			fmt.Fprintf(&out, "    %s\n", a)
+		}
+	}
+	return out.String()
+}
+
+// Diff compares two snapshots, of the same table at different times or of two tables
+// with the same key schema. Items are matched by the key attributes of a. Sets compare
+// equal regardless of order, and attributes matched by IgnoreAttributes are skipped.
+func Diff(a, b *Snapshot, opts ...GoldenOption) *TableDiff {
+	cfg := &goldenConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	diff := &TableDiff{Before: a, After: b}
+
+	// b is keyed again by a's key attributes, in case the snapshots are of two tables
+	after := map[string]map[string]types.AttributeValue{}
+	for _, it := range b.items {
+		k, err := itemKey(it, a.Keys)
+		if err != nil {
+			k = "<no key> " + diffJSON(it)
+		}
+		after[k] = it
+	}
+
+	names := map[string]bool{}
+	for k := range a.items {
+		names[k] = true
+	}
+	for k := range after {
+		names[k] = true
+	}
+
+	for _, k := range sortedKeysOf(names) {
+		before, inBefore := a.items[k]
+		now, inAfter := after[k]
+		switch {
+		case !inAfter:
+			diff.Changes = append(diff.Changes, &ItemChange{Type: ItemRemoved, Key: diffKey(before, a.Keys), Before: before})
+		case !inBefore:
+			diff.Changes = append(diff.Changes, &ItemChange{Type: ItemAdded, Key: diffKey(now, a.Keys), After: now})
+		default:
+			if attrs := diffAttributes(before, now, cfg); len(attrs) > 0 {
+				diff.Changes = append(diff.Changes, &ItemChange{Type: ItemModified, Key: diffKey(now, a.Keys), Before: before, After: now, Attributes: attrs})
+			}
+		}
+	}
+
+	return diff
+}
+
+func diffAttributes(before, after map[string]types.AttributeValue, cfg *goldenConfig) []*AttributeChange {
+	names := map[string]bool{}
+	for k := range before {
+		names[k] = true
+	}
+	for k := range after {
+		names[k] = true
+	}
+
+	out := []*AttributeChange{}
+	for _, name := range sortedKeysOf(names) {
+		b, inBefore := before[name]
+		a, inAfter := after[name]
+		switch {
+		case !inAfter:
+			out = append(out, &AttributeChange{Name: name, Type: ItemRemoved, Before: b})
+		case !inBefore:
+			out = append(out, &AttributeChange{Name: name, Type: ItemAdded, After: a})
+		case diffValue(name, b, cfg) != diffValue(name, a, cfg):
+			out = append(out, &AttributeChange{Name: name, Type: ItemModified, Before: b, After: a})
+		}
+	}
+	return out
+}
+
+// diffValue is the canonical form of an attribute, with sets sorted and ignored parts
+// masked like in golden files.
+func diffValue(name string, av types.AttributeValue, cfg *goldenConfig) string {
+	it, err := normalizeGoldenItem(map[string]types.AttributeValue{name: av}, cfg)
+	if err != nil {
+		return fmt.Sprintf("%#v", av)
+	}
+	return canonicalJSON(it[name])
+}
+
+func diffKey(it map[string]types.AttributeValue, keys []string) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+diffJSON(it[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// diffJSON formats items and attributes as compact DynamoDB JSON.
+func diffJSON(v any) string {
+	switch v := v.(type) {
+	case map[string]types.AttributeValue:
+		it, err := normalizeGoldenItem(v, &goldenConfig{})
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return canonicalJSON(it)
+	case types.AttributeValue:
+		return diffValue("v", v, &goldenConfig{})
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// DiffOnFailureT captures the tables now and, if the test has failed by the time it
+// ends, logs what changed in each of them since. Call it after ProvisionT for the
+// tables worth diffing; every call scans the tables twice.
+func DiffOnFailureT(t testing.TB, ctx context.Context, cli *dynamodb.Client, tables ...string) {
+	t.Helper()
+
+	before := make([]*Snapshot, 0, len(tables))
+	for _, table := range tables {
+		before = append(before, CaptureT(t, ctx, cli, table))
+	}
+
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		for _, snap := range before {
+			after, err := Capture(ctx, cli, snap.Table)
+			if err != nil {
+				t.Logf("dynamodb: capturing %s for the failure diff: %s", snap.Table, err)
+				continue
+			}
+			t.Logf("dynamodb: changes to %s during the test:\n%s", snap.Table, Diff(snap, after))
+		}
+	})
+}
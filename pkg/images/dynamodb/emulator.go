@@ -0,0 +1,213 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const emulatorTargetPrefix = "DynamoDB_20120810."
+
+// Emulator is an in-process DynamoDB that speaks the JSON 1.0 protocol over http, so the
+// regular sdk client can be pointed at it instead of a dynamodb-local container. It keeps
+// everything in memory behind a single lock and only implements the data plane that
+// tests use, rejecting anything else with an UnknownOperationException.
+type Emulator struct {
+	mu       sync.Mutex
+	tables   map[string]*emuTable
+	streams  map[string]*emuStream
+	ops      map[string]func(me *Emulator, body []byte) (any, error)
+	requests atomic.Int64
+
+	server *http.Server
+	url    string
+}
+
+func NewEmulator() *Emulator {
+	me := &Emulator{
+		tables:  map[string]*emuTable{},
+		streams: map[string]*emuStream{},
+		ops:     map[string]func(me *Emulator, body []byte) (any, error){},
+	}
+	me.handle("CreateTable", handler((*Emulator).createTable))
+	me.handle("DescribeTable", handler((*Emulator).describeTable))
+	me.handle("UpdateTable", handler((*Emulator).updateTable))
+	me.handle("DeleteTable", handler((*Emulator).deleteTable))
+	me.handle("ListTables", handler((*Emulator).listTables))
+	me.handle("UpdateTimeToLive", handler((*Emulator).updateTimeToLive))
+	me.handle("DescribeTimeToLive", handler((*Emulator).describeTimeToLive))
+	me.handle("UpdateContinuousBackups", handler((*Emulator).updateContinuousBackups))
+	me.handle("DescribeContinuousBackups", handler((*Emulator).describeContinuousBackups))
+	me.handle("TagResource", handler((*Emulator).tagResource))
+	me.handle("UntagResource", handler((*Emulator).untagResource))
+	me.handle("ListTagsOfResource", handler((*Emulator).listTagsOfResource))
+	me.handle("DescribeLimits", handler((*Emulator).describeLimits))
+	me.handle("PutItem", handler((*Emulator).putItem))
+	me.handle("GetItem", handler((*Emulator).getItem))
+	me.handle("UpdateItem", handler((*Emulator).updateItem))
+	me.handle("DeleteItem", handler((*Emulator).deleteItem))
+	me.handle("Query", handler((*Emulator).query))
+	me.handle("Scan", handler((*Emulator).scan))
+	me.handle("BatchWriteItem", handler((*Emulator).batchWriteItem))
+	me.handle("BatchGetItem", handler((*Emulator).batchGetItem))
+	me.handle("TransactWriteItems", handler((*Emulator).transactWriteItems))
+	me.handle("ExecuteStatement", handler((*Emulator).executeStatement))
+	me.handle("ListStreams", handler((*Emulator).listStreams))
+	me.handle("DescribeStream", handler((*Emulator).describeStream))
+	me.handle("GetShardIterator", handler((*Emulator).getShardIterator))
+	me.handle("GetRecords", handler((*Emulator).getRecords))
+	return me
+}
+
+// handle registers the implementation of an operation. It is called with the emulator locked.
+func (me *Emulator) handle(op string, fn func(me *Emulator, body []byte) (any, error)) {
+	me.ops[op] = fn
+}
+
+func handler[T any](fn func(me *Emulator, in *T) (any, error)) func(me *Emulator, body []byte) (any, error) {
+	return func(me *Emulator, body []byte) (any, error) {
+		in := new(T)
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, in); err != nil {
+				var syn *json.SyntaxError
+				var typ *json.UnmarshalTypeError
+				if errors.As(err, &syn) || errors.As(err, &typ) {
+					return nil, &emuError{code: "SerializationException", message: err.Error()}
+				}
+				return nil, validationError("%s", err.Error())
+			}
+		}
+		return fn(me, in)
+	}
+}
+
+// Start serves the emulator on a random local port and returns its url.
+func (me *Emulator) Start() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "listening for the dynamodb emulator")
+	}
+
+	me.url = fmt.Sprintf("http://%s", l.Addr().String())
+	me.server = &http.Server{Handler: me}
+
+	go func() {
+		_ = me.server.Serve(l)
+	}()
+
+	return me.url, nil
+}
+
+func (me *Emulator) URL() string {
+	return me.url
+}
+
+func (me *Emulator) Close() error {
+	if me.server == nil {
+		return nil
+	}
+	return me.server.Close()
+}
+
+// Requests is the number of requests the emulator has served.
+func (me *Emulator) Requests() int64 {
+	return me.requests.Load()
+}
+
+// Image returns a DockerImage whose clients talk to the emulator, so helpers written
+// against a container work unchanged.
+func (me *Emulator) Image() *DockerImage {
+	return &DockerImage{emulator: me}
+}
+
+func (me *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	me.requests.Add(1)
+
+	if r.Method != http.MethodPost {
+		writeEmulatorError(w, &emuError{code: "UnknownOperationException", message: "only POST is supported"})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeEmulatorError(w, &emuError{code: "SerializationException", message: err.Error()})
+		return
+	}
+
+	// the streams operations are served on the same endpoint, like dynamodb-local does
+	op := r.Header.Get("X-Amz-Target")
+	op = strings.TrimPrefix(strings.TrimPrefix(op, emulatorTargetPrefix), emulatorStreamsTargetPrefix)
+
+	me.mu.Lock()
+	fn, ok := me.ops[op]
+	var out any
+	if ok {
+		out, err = fn(me, body)
+	}
+	var payload []byte
+	if err == nil && ok {
+		payload, err = json.Marshal(out)
+	}
+	me.mu.Unlock()
+
+	if !ok {
+		writeEmulatorError(w, &emuError{code: "UnknownOperationException", message: "the emulator does not implement " + op})
+		return
+	}
+	if err != nil {
+		writeEmulatorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	_, _ = w.Write(payload)
+}
+
+func writeEmulatorError(w http.ResponseWriter, err error) {
+	var ee *emuError
+	if !errors.As(err, &ee) {
+		ee = validationError("%s", err.Error())
+	}
+
+	body := map[string]any{
+		"__type":  "com.amazonaws.dynamodb.v20120810#" + ee.code,
+		"message": ee.message,
+	}
+	if ee.item != nil {
+		body["Item"] = ee.item
+	}
+	if ee.reasons != nil {
+		body["CancellationReasons"] = ee.reasons
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// EmulateT starts an emulator for the lifetime of the test and returns an image that
+// points at it, the in-process equivalent of docker.RollT.
+func EmulateT(t testing.TB) *DockerImage {
+	t.Helper()
+
+	emu := NewEmulator()
+	if _, err := emu.Start(); err != nil {
+		t.Fatalf("dynamodb: starting emulator: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := emu.Close(); err != nil {
+			t.Errorf("dynamodb: closing emulator: %s", err)
+		}
+	})
+
+	return emu.Image()
+}
@@ -0,0 +1,1183 @@
+package dynamodb
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// exprContext resolves #name and :value placeholders for every expression in one
+// request and remembers which ones were used, since DynamoDB rejects unused ones.
+type exprContext struct {
+	names      map[string]string
+	values     map[string]*attrValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprContext(names map[string]string, values map[string]*attrValue) *exprContext {
+	return &exprContext{
+		names:      names,
+		values:     values,
+		usedNames:  map[string]bool{},
+		usedValues: map[string]bool{},
+	}
+}
+
+func (me *exprContext) checkUnused() error {
+	for k := range me.names {
+		if !me.usedNames[k] {
+			return errors.Errorf("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", k)
+		}
+	}
+	for k := range me.values {
+		if !me.usedValues[k] {
+			return errors.Errorf("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", k)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokName  // #name
+	tokValue // :value
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(expr string) ([]token, error) {
+	toks := []token{}
+	i := 0
+	for i < len(expr) {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#' || c == ':':
+			j := i + 1
+			for j < len(expr) && isIdentChar(rune(expr[j])) {
+				j++
+			}
+			if j == i+1 {
+				return nil, errors.Errorf("Invalid expression: Syntax error; token: %q, near: %q", string(c), expr[i:])
+			}
+			kind := tokName
+			if c == ':' {
+				kind = tokValue
+			}
+			toks = append(toks, token{kind: kind, text: expr[i:j], pos: i})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(expr) && unicode.IsDigit(rune(expr[j])) {
+				j++
+			}
+			toks = append(toks, token{kind: tokNumber, text: expr[i:j], pos: i})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(expr) && isIdentChar(rune(expr[j])) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: expr[i:j], pos: i})
+			i = j
+		default:
+			two := ""
+			if i+1 < len(expr) {
+				two = expr[i : i+2]
+			}
+			if two == "<>" || two == "<=" || two == ">=" {
+				toks = append(toks, token{kind: tokPunct, text: two, pos: i})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("()[],.=<>+-", c) {
+				toks = append(toks, token{kind: tokPunct, text: string(c), pos: i})
+				i++
+				continue
+			}
+			return nil, errors.Errorf("Invalid expression: Syntax error; token: %q, near: %q", string(c), expr[i:])
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(expr)}), nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type parser struct {
+	expr string
+	toks []token
+	pos  int
+	ctx  *exprContext
+}
+
+func newParser(expr string, ctx *exprContext) (*parser, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{expr: expr, toks: toks, ctx: ctx}, nil
+}
+
+func (me *parser) peek() token {
+	return me.toks[me.pos]
+}
+
+func (me *parser) next() token {
+	t := me.toks[me.pos]
+	if t.kind != tokEOF {
+		me.pos++
+	}
+	return t
+}
+
+func (me *parser) isKeyword(kw string) bool {
+	t := me.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func (me *parser) isPunct(p string) bool {
+	t := me.peek()
+	return t.kind == tokPunct && t.text == p
+}
+
+func (me *parser) expectPunct(p string) error {
+	if !me.isPunct(p) {
+		return me.syntaxError()
+	}
+	me.next()
+	return nil
+}
+
+func (me *parser) syntaxError() error {
+	t := me.peek()
+	if t.kind == tokEOF {
+		return errors.Errorf("Invalid expression: Syntax error; token: <EOF>, near: %q", me.expr)
+	}
+	return errors.Errorf("Invalid expression: Syntax error; token: %q, near: %q", t.text, me.expr[t.pos:])
+}
+
+// ---------------------------------------------------------------------------
+// paths
+
+type pathElem struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+type path []pathElem
+
+func (me path) String() string {
+	var sb strings.Builder
+	for i, e := range me {
+		if e.isIdx {
+			sb.WriteString("[" + strconv.Itoa(e.index) + "]")
+			continue
+		}
+		if i > 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(e.name)
+	}
+	return sb.String()
+}
+
+func (me *parser) parseName() (string, error) {
+	t := me.next()
+	switch t.kind {
+	case tokIdent:
+		if isReservedWord(t.text) {
+			return "", errors.Errorf("Invalid expression: Attribute name is a reserved keyword; reserved keyword: %s", t.text)
+		}
+		return t.text, nil
+	case tokName:
+		v, ok := me.ctx.names[t.text]
+		if !ok {
+			return "", errors.Errorf("Invalid expression: An expression attribute name used in the document path is not defined; attribute name: %s", t.text)
+		}
+		me.ctx.usedNames[t.text] = true
+		return v, nil
+	}
+	me.pos--
+	return "", me.syntaxError()
+}
+
+func (me *parser) parsePath() (path, error) {
+	name, err := me.parseName()
+	if err != nil {
+		return nil, err
+	}
+	p := path{{name: name}}
+	for {
+		switch {
+		case me.isPunct("."):
+			me.next()
+			name, err := me.parseName()
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, pathElem{name: name})
+		case me.isPunct("["):
+			me.next()
+			t := me.next()
+			if t.kind != tokNumber {
+				me.pos--
+				return nil, me.syntaxError()
+			}
+			idx, err := strconv.Atoi(t.text)
+			if err != nil {
+				return nil, err
+			}
+			if err := me.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			p = append(p, pathElem{index: idx, isIdx: true})
+		default:
+			return p, nil
+		}
+	}
+}
+
+func (me *parser) parseValueRef() (*attrValue, error) {
+	t := me.next()
+	if t.kind != tokValue {
+		me.pos--
+		return nil, me.syntaxError()
+	}
+	v, ok := me.ctx.values[t.text]
+	if !ok {
+		return nil, errors.Errorf("Invalid expression: An expression attribute value used in expression is not defined; attribute value: %s", t.text)
+	}
+	me.ctx.usedValues[t.text] = true
+	return v, nil
+}
+
+func resolvePath(it item, p path) *attrValue {
+	if len(p) == 0 || p[0].isIdx {
+		return nil
+	}
+	cur := it[p[0].name]
+	for _, e := range p[1:] {
+		if cur == nil {
+			return nil
+		}
+		if e.isIdx {
+			if cur.kind != "L" || e.index >= len(cur.l) {
+				return nil
+			}
+			cur = cur.l[e.index]
+			continue
+		}
+		if cur.kind != "M" {
+			return nil
+		}
+		cur = cur.m[e.name]
+	}
+	return cur
+}
+
+// ---------------------------------------------------------------------------
+// operands
+
+type operand interface {
+	eval(it item) (*attrValue, error)
+}
+
+type pathOperand struct{ p path }
+
+func (me *pathOperand) eval(it item) (*attrValue, error) {
+	return resolvePath(it, me.p), nil
+}
+
+type literalOperand struct{ v *attrValue }
+
+func (me *literalOperand) eval(it item) (*attrValue, error) {
+	return me.v, nil
+}
+
+type sizeOperand struct{ p path }
+
+func (me *sizeOperand) eval(it item) (*attrValue, error) {
+	v := resolvePath(it, me.p)
+	if v == nil {
+		return nil, nil
+	}
+	n, ok := v.count()
+	if !ok {
+		return nil, nil
+	}
+	return numValue(new(big.Rat).SetInt64(int64(n))), nil
+}
+
+type ifNotExistsOperand struct {
+	p   path
+	def operand
+}
+
+func (me *ifNotExistsOperand) eval(it item) (*attrValue, error) {
+	if v := resolvePath(it, me.p); v != nil {
+		return v, nil
+	}
+	return me.def.eval(it)
+}
+
+type listAppendOperand struct{ a, b operand }
+
+func (me *listAppendOperand) eval(it item) (*attrValue, error) {
+	a, err := me.a.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := me.b.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || b == nil || a.kind != "L" || b.kind != "L" {
+		return nil, errors.New("Invalid UpdateExpression: Incorrect operand type for operator or function; operator or function: list_append, operand type: " + kindOf(a, b))
+	}
+	out := &attrValue{kind: "L", l: make([]*attrValue, 0, len(a.l)+len(b.l))}
+	for _, v := range a.l {
+		out.l = append(out.l, v.copy())
+	}
+	for _, v := range b.l {
+		out.l = append(out.l, v.copy())
+	}
+	return out, nil
+}
+
+type arithOperand struct {
+	op   string
+	a, b operand
+}
+
+func (me *arithOperand) eval(it item) (*attrValue, error) {
+	a, err := me.a.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := me.b.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || b == nil {
+		return nil, errors.New("The provided expression refers to an attribute that does not exist in the item")
+	}
+	if a.kind != "N" || b.kind != "N" {
+		return nil, errors.New("An operand in the update expression has an incorrect data type")
+	}
+	ra, _ := parseNumber(a.s)
+	rb, _ := parseNumber(b.s)
+	if me.op == "+" {
+		return numValue(new(big.Rat).Add(ra, rb)), nil
+	}
+	return numValue(new(big.Rat).Sub(ra, rb)), nil
+}
+
+func kindOf(vs ...*attrValue) string {
+	for _, v := range vs {
+		if v == nil {
+			return "NULL"
+		}
+		if v.kind != "L" {
+			return v.kind
+		}
+	}
+	return "L"
+}
+
+// parseOperand parses a path, a value placeholder or size(path). Update expressions
+// additionally allow if_not_exists, list_append and + / -.
+func (me *parser) parseOperand(update bool) (operand, error) {
+	t := me.peek()
+	switch t.kind {
+	case tokValue:
+		v, err := me.parseValueRef()
+		if err != nil {
+			return nil, err
+		}
+		return &literalOperand{v: v}, nil
+	case tokIdent:
+		fn := strings.ToLower(t.text)
+		if me.toks[me.pos+1].kind == tokPunct && me.toks[me.pos+1].text == "(" {
+			me.next()
+			me.next()
+			switch {
+			case fn == "size" && !update:
+				p, err := me.parsePath()
+				if err != nil {
+					return nil, err
+				}
+				return &sizeOperand{p: p}, me.expectPunct(")")
+			case fn == "if_not_exists" && update:
+				p, err := me.parsePath()
+				if err != nil {
+					return nil, err
+				}
+				if err := me.expectPunct(","); err != nil {
+					return nil, err
+				}
+				def, err := me.parseOperand(update)
+				if err != nil {
+					return nil, err
+				}
+				return &ifNotExistsOperand{p: p, def: def}, me.expectPunct(")")
+			case fn == "list_append" && update:
+				a, err := me.parseOperand(update)
+				if err != nil {
+					return nil, err
+				}
+				if err := me.expectPunct(","); err != nil {
+					return nil, err
+				}
+				b, err := me.parseOperand(update)
+				if err != nil {
+					return nil, err
+				}
+				return &listAppendOperand{a: a, b: b}, me.expectPunct(")")
+			}
+			return nil, errors.Errorf("Invalid expression: The function is not allowed to be used this way in an expression; function: %s", t.text)
+		}
+	}
+	p, err := me.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return &pathOperand{p: p}, nil
+}
+
+// ---------------------------------------------------------------------------
+// conditions
+
+type condition interface {
+	eval(it item) (bool, error)
+}
+
+type andCondition struct{ a, b condition }
+
+func (me *andCondition) eval(it item) (bool, error) {
+	ok, err := me.a.eval(it)
+	if err != nil || !ok {
+		return false, err
+	}
+	return me.b.eval(it)
+}
+
+type orCondition struct{ a, b condition }
+
+func (me *orCondition) eval(it item) (bool, error) {
+	ok, err := me.a.eval(it)
+	if err != nil || ok {
+		return ok, err
+	}
+	return me.b.eval(it)
+}
+
+type notCondition struct{ a condition }
+
+func (me *notCondition) eval(it item) (bool, error) {
+	ok, err := me.a.eval(it)
+	return !ok, err
+}
+
+type compareCondition struct {
+	op   string
+	a, b operand
+}
+
+func (me *compareCondition) eval(it item) (bool, error) {
+	a, err := me.a.eval(it)
+	if err != nil {
+		return false, err
+	}
+	b, err := me.b.eval(it)
+	if err != nil {
+		return false, err
+	}
+	switch me.op {
+	case "=":
+		return equalValues(a, b), nil
+	case "<>":
+		return !equalValues(a, b), nil
+	}
+	c, ok := compareValues(a, b)
+	if !ok {
+		return false, nil
+	}
+	switch me.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, errors.Errorf("unknown comparator %s", me.op)
+}
+
+type betweenCondition struct{ v, lo, hi operand }
+
+func (me *betweenCondition) eval(it item) (bool, error) {
+	v, err := me.v.eval(it)
+	if err != nil {
+		return false, err
+	}
+	lo, err := me.lo.eval(it)
+	if err != nil {
+		return false, err
+	}
+	hi, err := me.hi.eval(it)
+	if err != nil {
+		return false, err
+	}
+	if c, ok := compareValues(lo, hi); ok && c > 0 {
+		return false, errors.New("Invalid KeyConditionExpression: The BETWEEN operator requires upper bound to be greater than or equal to lower bound")
+	}
+	c1, ok1 := compareValues(v, lo)
+	c2, ok2 := compareValues(v, hi)
+	return ok1 && ok2 && c1 >= 0 && c2 <= 0, nil
+}
+
+type inCondition struct {
+	v    operand
+	list []operand
+}
+
+func (me *inCondition) eval(it item) (bool, error) {
+	v, err := me.v.eval(it)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range me.list {
+		c, err := o.eval(it)
+		if err != nil {
+			return false, err
+		}
+		if equalValues(v, c) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type functionCondition struct {
+	name string
+	p    path
+	arg  operand
+}
+
+func (me *functionCondition) eval(it item) (bool, error) {
+	v := resolvePath(it, me.p)
+	switch me.name {
+	case "attribute_exists":
+		return v != nil, nil
+	case "attribute_not_exists":
+		return v == nil, nil
+	}
+
+	arg, err := me.arg.eval(it)
+	if err != nil {
+		return false, err
+	}
+	if v == nil || arg == nil {
+		return false, nil
+	}
+
+	switch me.name {
+	case "attribute_type":
+		if arg.kind != "S" {
+			return false, errors.New("Invalid ConditionExpression: Incorrect operand type for operator or function; operator or function: attribute_type, operand type: " + arg.kind)
+		}
+		return v.kind == arg.s, nil
+	case "begins_with":
+		switch {
+		case v.kind == "S" && arg.kind == "S":
+			return strings.HasPrefix(v.s, arg.s), nil
+		case v.kind == "B" && arg.kind == "B":
+			return bytes.HasPrefix(v.b, arg.b), nil
+		}
+		return false, nil
+	case "contains":
+		switch v.kind {
+		case "S":
+			return arg.kind == "S" && strings.Contains(v.s, arg.s), nil
+		case "B":
+			return arg.kind == "B" && bytes.Contains(v.b, arg.b), nil
+		case "SS", "NS":
+			if (v.kind == "SS" && arg.kind != "S") || (v.kind == "NS" && arg.kind != "N") {
+				return false, nil
+			}
+			for _, s := range v.ss {
+				if s == arg.s {
+					return true, nil
+				}
+			}
+		case "BS":
+			for _, b := range v.bs {
+				if arg.kind == "B" && bytes.Equal(b, arg.b) {
+					return true, nil
+				}
+			}
+		case "L":
+			for _, e := range v.l {
+				if equalValues(e, arg) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, errors.Errorf("Invalid expression: Invalid function name; function: %s", me.name)
+}
+
+func parseCondition(expr string, ctx *exprContext) (condition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, errors.New("Invalid expression: The expression can not be empty;")
+	}
+	p, err := newParser(expr, ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.syntaxError()
+	}
+	return c, nil
+}
+
+func (me *parser) parseOr() (condition, error) {
+	a, err := me.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for me.isKeyword("OR") {
+		me.next()
+		b, err := me.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		a = &orCondition{a: a, b: b}
+	}
+	return a, nil
+}
+
+func (me *parser) parseAnd() (condition, error) {
+	a, err := me.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for me.isKeyword("AND") {
+		me.next()
+		b, err := me.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		a = &andCondition{a: a, b: b}
+	}
+	return a, nil
+}
+
+func (me *parser) parseNot() (condition, error) {
+	if me.isKeyword("NOT") {
+		me.next()
+		a, err := me.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notCondition{a: a}, nil
+	}
+	return me.parsePrimary()
+}
+
+func (me *parser) parsePrimary() (condition, error) {
+	if me.isPunct("(") {
+		me.next()
+		c, err := me.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, me.expectPunct(")")
+	}
+
+	t := me.peek()
+	if t.kind == tokIdent && me.toks[me.pos+1].kind == tokPunct && me.toks[me.pos+1].text == "(" {
+		fn := strings.ToLower(t.text)
+		switch fn {
+		case "attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains":
+			me.next()
+			me.next()
+			p, err := me.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			c := &functionCondition{name: fn, p: p}
+			if fn != "attribute_exists" && fn != "attribute_not_exists" {
+				if err := me.expectPunct(","); err != nil {
+					return nil, err
+				}
+				if c.arg, err = me.parseOperand(false); err != nil {
+					return nil, err
+				}
+			}
+			return c, me.expectPunct(")")
+		}
+	}
+
+	a, err := me.parseOperand(false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case me.isKeyword("BETWEEN"):
+		me.next()
+		lo, err := me.parseOperand(false)
+		if err != nil {
+			return nil, err
+		}
+		if !me.isKeyword("AND") {
+			return nil, me.syntaxError()
+		}
+		me.next()
+		hi, err := me.parseOperand(false)
+		if err != nil {
+			return nil, err
+		}
+		return &betweenCondition{v: a, lo: lo, hi: hi}, nil
+	case me.isKeyword("IN"):
+		me.next()
+		if err := me.expectPunct("("); err != nil {
+			return nil, err
+		}
+		c := &inCondition{v: a}
+		for {
+			o, err := me.parseOperand(false)
+			if err != nil {
+				return nil, err
+			}
+			c.list = append(c.list, o)
+			if me.isPunct(",") {
+				me.next()
+				continue
+			}
+			break
+		}
+		return c, me.expectPunct(")")
+	}
+
+	t = me.peek()
+	if t.kind == tokPunct {
+		switch t.text {
+		case "=", "<>", "<", "<=", ">", ">=":
+			me.next()
+			b, err := me.parseOperand(false)
+			if err != nil {
+				return nil, err
+			}
+			return &compareCondition{op: t.text, a: a, b: b}, nil
+		}
+	}
+	return nil, me.syntaxError()
+}
+
+// ---------------------------------------------------------------------------
+// projections
+
+func parseProjection(expr string, ctx *exprContext) ([]path, error) {
+	p, err := newParser(expr, ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths := []path{}
+	for {
+		pa, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, pa)
+		if p.isPunct(",") {
+			p.next()
+			continue
+		}
+		break
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.syntaxError()
+	}
+	return paths, nil
+}
+
+func project(it item, paths []path) item {
+	if paths == nil {
+		return it.copy()
+	}
+	out := item{}
+	for _, p := range paths {
+		v := resolvePath(it, p)
+		if v == nil {
+			continue
+		}
+		if len(p) == 1 {
+			out[p[0].name] = v.copy()
+			continue
+		}
+		// rebuild the containers along the path
+		root, ok := out[p[0].name]
+		src := it[p[0].name]
+		if !ok {
+			root = &attrValue{kind: src.kind}
+			if src.kind == "M" {
+				root.m = map[string]*attrValue{}
+			} else {
+				root.l = []*attrValue{}
+			}
+			out[p[0].name] = root
+		}
+		cur := root
+		for i, e := range p[1:] {
+			last := i == len(p)-2
+			srcChild := resolvePath(it, p[:i+2])
+			var next *attrValue
+			if last {
+				next = srcChild.copy()
+			} else {
+				next = &attrValue{kind: srcChild.kind}
+				if srcChild.kind == "M" {
+					next.m = map[string]*attrValue{}
+				} else {
+					next.l = []*attrValue{}
+				}
+			}
+			if e.isIdx {
+				cur.l = append(cur.l, next)
+				cur = next
+				continue
+			}
+			if existing, ok := cur.m[e.name]; ok && !last {
+				cur = existing
+				continue
+			}
+			cur.m[e.name] = next
+			cur = next
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// updates
+
+type updateAction struct {
+	kind  string // SET REMOVE ADD DELETE
+	p     path
+	value operand
+}
+
+func parseUpdate(expr string, ctx *exprContext) ([]updateAction, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, errors.New("Invalid UpdateExpression: The expression can not be empty;")
+	}
+	p, err := newParser(expr, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []updateAction{}
+	seen := map[string]bool{}
+	for p.peek().kind != tokEOF {
+		t := p.next()
+		kind := strings.ToUpper(t.text)
+		if t.kind != tokIdent || (kind != "SET" && kind != "REMOVE" && kind != "ADD" && kind != "DELETE") {
+			p.pos--
+			return nil, p.syntaxError()
+		}
+		if seen[kind] {
+			return nil, errors.Errorf("Invalid UpdateExpression: The \"%s\" section can only be used once in an update expression;", kind)
+		}
+		seen[kind] = true
+
+		for {
+			pa, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			a := updateAction{kind: kind, p: pa}
+			switch kind {
+			case "SET":
+				if err := p.expectPunct("="); err != nil {
+					return nil, err
+				}
+				left, err := p.parseOperand(true)
+				if err != nil {
+					return nil, err
+				}
+				if p.isPunct("+") || p.isPunct("-") {
+					op := p.next().text
+					right, err := p.parseOperand(true)
+					if err != nil {
+						return nil, err
+					}
+					left = &arithOperand{op: op, a: left, b: right}
+				}
+				a.value = left
+			case "ADD", "DELETE":
+				v, err := p.parseValueRef()
+				if err != nil {
+					return nil, err
+				}
+				a.value = &literalOperand{v: v}
+			}
+			actions = append(actions, a)
+			if p.isPunct(",") {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+
+	// two actions may not touch overlapping paths
+	for i := range actions {
+		for j := i + 1; j < len(actions); j++ {
+			a, b := actions[i].p.String(), actions[j].p.String()
+			if a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".") || strings.HasPrefix(a, b+"[") || strings.HasPrefix(b, a+"[") {
+				return nil, errors.Errorf("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]", a, b)
+			}
+		}
+	}
+
+	return actions, nil
+}
+
+// applyUpdate evaluates every action against the original item and then applies
+// them in order, returning the new item and the top level attributes it touched.
+func applyUpdate(orig item, actions []updateAction) (item, []string, error) {
+	values := make([]*attrValue, len(actions))
+	for i, a := range actions {
+		if a.value == nil {
+			continue
+		}
+		v, err := a.value.eval(orig)
+		if err != nil {
+			return nil, nil, err
+		}
+		if v == nil {
+			return nil, nil, errors.New("The provided expression refers to an attribute that does not exist in the item")
+		}
+		values[i] = v.copy()
+	}
+
+	it := orig.copy()
+	if it == nil {
+		it = item{}
+	}
+	touched := map[string]bool{}
+
+	// REMOVE on list elements shifts later elements, so remove from the back
+	removeOrder := make([]int, 0)
+	for i, a := range actions {
+		touched[a.p[0].name] = true
+		if a.kind == "REMOVE" {
+			removeOrder = append(removeOrder, i)
+			continue
+		}
+		if err := applyAction(it, a, values[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.SliceStable(removeOrder, func(i, j int) bool {
+		pi, pj := actions[removeOrder[i]].p, actions[removeOrder[j]].p
+		return pi[len(pi)-1].index > pj[len(pj)-1].index
+	})
+	for _, i := range removeOrder {
+		if err := removePath(it, actions[i].p); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	names := make([]string, 0, len(touched))
+	for k := range touched {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return it, names, nil
+}
+
+func applyAction(it item, a updateAction, v *attrValue) error {
+	cur := resolvePath(it, a.p)
+	switch a.kind {
+	case "SET":
+		return setPath(it, a.p, v)
+	case "ADD":
+		switch {
+		case cur == nil && (v.kind == "N" || v.kind == "SS" || v.kind == "NS" || v.kind == "BS"):
+			return setPath(it, a.p, v)
+		case cur != nil && cur.kind == "N" && v.kind == "N":
+			ra, _ := parseNumber(cur.s)
+			rb, _ := parseNumber(v.s)
+			return setPath(it, a.p, numValue(new(big.Rat).Add(ra, rb)))
+		case cur != nil && cur.kind == v.kind && (v.kind == "SS" || v.kind == "NS"):
+			out := cur.copy()
+			for _, s := range v.ss {
+				found := false
+				for _, e := range out.ss {
+					found = found || e == s
+				}
+				if !found {
+					out.ss = append(out.ss, s)
+				}
+			}
+			return setPath(it, a.p, out)
+		case cur != nil && cur.kind == "BS" && v.kind == "BS":
+			out := cur.copy()
+			for _, b := range v.bs {
+				found := false
+				for _, e := range out.bs {
+					found = found || bytes.Equal(e, b)
+				}
+				if !found {
+					out.bs = append(out.bs, b)
+				}
+			}
+			return setPath(it, a.p, out)
+		}
+		return errors.New("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: " + v.kind)
+	case "DELETE":
+		if v.kind != "SS" && v.kind != "NS" && v.kind != "BS" {
+			return errors.New("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: DELETE, operand type: " + v.kind)
+		}
+		if cur == nil {
+			return nil
+		}
+		if cur.kind != v.kind {
+			return errors.New("An operand in the update expression has an incorrect data type")
+		}
+		out := &attrValue{kind: cur.kind}
+		if cur.kind == "BS" {
+			for _, e := range cur.bs {
+				keep := true
+				for _, b := range v.bs {
+					keep = keep && !bytes.Equal(e, b)
+				}
+				if keep {
+					out.bs = append(out.bs, e)
+				}
+			}
+			if len(out.bs) == 0 {
+				return removePath(it, a.p)
+			}
+		} else {
+			for _, e := range cur.ss {
+				keep := true
+				for _, s := range v.ss {
+					keep = keep && e != s
+				}
+				if keep {
+					out.ss = append(out.ss, e)
+				}
+			}
+			if len(out.ss) == 0 {
+				return removePath(it, a.p)
+			}
+		}
+		return setPath(it, a.p, out)
+	}
+	return errors.Errorf("unknown update action %s", a.kind)
+}
+
+var errInvalidDocumentPath = errors.New("The document path provided in the update expression is invalid for update")
+
+func setPath(it item, p path, v *attrValue) error {
+	if len(p) == 1 {
+		it[p[0].name] = v
+		return nil
+	}
+	parent := resolvePath(it, p[:len(p)-1])
+	if parent == nil {
+		return errInvalidDocumentPath
+	}
+	last := p[len(p)-1]
+	if last.isIdx {
+		if parent.kind != "L" {
+			return errInvalidDocumentPath
+		}
+		if last.index >= len(parent.l) {
+			parent.l = append(parent.l, v)
+			return nil
+		}
+		parent.l[last.index] = v
+		return nil
+	}
+	if parent.kind != "M" {
+		return errInvalidDocumentPath
+	}
+	parent.m[last.name] = v
+	return nil
+}
+
+func removePath(it item, p path) error {
+	if len(p) == 1 {
+		delete(it, p[0].name)
+		return nil
+	}
+	parent := resolvePath(it, p[:len(p)-1])
+	if parent == nil {
+		return nil
+	}
+	last := p[len(p)-1]
+	if last.isIdx {
+		if parent.kind != "L" {
+			return errInvalidDocumentPath
+		}
+		if last.index < len(parent.l) {
+			parent.l = append(parent.l[:last.index], parent.l[last.index+1:]...)
+		}
+		return nil
+	}
+	if parent.kind != "M" {
+		return errInvalidDocumentPath
+	}
+	delete(parent.m, last.name)
+	return nil
+}
+
+// only the reserved words that are likely to collide with attribute names; the full
+// list is several hundred words long.
+var reservedWords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "BETWEEN": true, "IN": true, "SET": true, "REMOVE": true,
+	"ADD": true, "DELETE": true, "NAME": true, "STATUS": true, "DATA": true, "DATE": true, "TIME": true,
+	"TIMESTAMP": true, "TYPE": true, "VALUE": true, "VALUES": true, "KEY": true, "KEYS": true,
+	"COUNT": true, "SIZE": true, "USER": true, "USERS": true, "ORDER": true, "GROUP": true, "TABLE": true,
+	"INDEX": true, "COMMENT": true, "YEAR": true, "MONTH": true, "DAY": true, "HOUR": true, "STATE": true,
+	"SOURCE": true, "TTL": true, "LIMIT": true, "CONNECTION": true, "DOMAIN": true, "LEVEL": true,
+	"ROLE": true, "OWNER": true, "PATH": true, "REGION": true, "SCHEMA": true, "SESSION": true, "ZONE": true,
+}
+
+func isReservedWord(s string) bool {
+	return reservedWords[strings.ToUpper(s)]
+}
@@ -0,0 +1,1400 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	maxItemSize     = 400 * 1024
+	maxPageSize     = 1024 * 1024
+	maxBatchWrite   = 25
+	maxBatchGet     = 100
+	maxTransactions = 100
+)
+
+// emuError is an error that is returned to the client with its own __type.
+type emuError struct {
+	code    string
+	message string
+	item    item
+	reasons []cancellationReason
+}
+
+func (me *emuError) Error() string {
+	return me.code + ": " + me.message
+}
+
+func validationError(format string, args ...any) *emuError {
+	return &emuError{code: "ValidationException", message: fmt.Sprintf(format, args...)}
+}
+
+func tableNotFound(name string) *emuError {
+	return &emuError{code: "ResourceNotFoundException", message: "Requested resource not found: Table: " + name + " not found"}
+}
+
+type cancellationReason struct {
+	Code    string
+	Message string `json:",omitempty"`
+	Item    item   `json:",omitempty"`
+}
+
+// legacyParams are the pre-expression parameters, which the emulator does not support.
+type legacyParams struct {
+	AttributesToGet     json.RawMessage
+	Expected            json.RawMessage
+	AttributeUpdates    json.RawMessage
+	KeyConditions       json.RawMessage
+	QueryFilter         json.RawMessage
+	ScanFilter          json.RawMessage
+	ConditionalOperator json.RawMessage
+}
+
+func (me *legacyParams) check() error {
+	if me.AttributesToGet != nil || me.Expected != nil || me.AttributeUpdates != nil || me.KeyConditions != nil ||
+		me.QueryFilter != nil || me.ScanFilter != nil || me.ConditionalOperator != nil {
+		return validationError("legacy parameters (AttributesToGet, Expected, AttributeUpdates, KeyConditions, QueryFilter, ScanFilter, ConditionalOperator) are not supported by the emulator, use expressions instead")
+	}
+	return nil
+}
+
+type expressionParams struct {
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]*attrValue
+}
+
+func (me *expressionParams) context() *exprContext {
+	return newExprContext(me.ExpressionAttributeNames, me.ExpressionAttributeValues)
+}
+
+func (me *Emulator) table(name string) (*emuTable, error) {
+	if name == "" {
+		return nil, validationError("1 validation error detected: Value null at 'tableName' failed to satisfy constraint: Member must not be null")
+	}
+	t, ok := me.tables[name]
+	if !ok {
+		return nil, tableNotFound(name)
+	}
+	return t, nil
+}
+
+// ---------------------------------------------------------------------------
+// consumed capacity
+
+type capacity struct {
+	CapacityUnits float64
+}
+
+type consumedCapacity struct {
+	TableName          string
+	CapacityUnits      float64
+	ReadCapacityUnits  float64   `json:",omitempty"`
+	WriteCapacityUnits float64   `json:",omitempty"`
+	Table              *capacity `json:",omitempty"`
+}
+
+func newConsumedCapacity(mode types.ReturnConsumedCapacity, table string, read, write float64) *consumedCapacity {
+	if mode != types.ReturnConsumedCapacityTotal && mode != types.ReturnConsumedCapacityIndexes {
+		return nil
+	}
+	c := &consumedCapacity{
+		TableName:          table,
+		CapacityUnits:      read + write,
+		ReadCapacityUnits:  read,
+		WriteCapacityUnits: write,
+	}
+	if mode == types.ReturnConsumedCapacityIndexes {
+		c.Table = &capacity{CapacityUnits: read + write}
+	}
+	return c
+}
+
+// capacityList collects per table capacity for the batch and transaction apis.
+type capacityList struct {
+	mode   types.ReturnConsumedCapacity
+	order  []string
+	tables map[string]*consumedCapacity
+}
+
+func newCapacityList(mode types.ReturnConsumedCapacity) *capacityList {
+	return &capacityList{mode: mode, tables: map[string]*consumedCapacity{}}
+}
+
+func (me *capacityList) add(table string, read, write float64) {
+	c := newConsumedCapacity(me.mode, table, read, write)
+	if c == nil {
+		return
+	}
+	prev, ok := me.tables[table]
+	if !ok {
+		me.tables[table] = c
+		me.order = append(me.order, table)
+		return
+	}
+	prev.CapacityUnits += c.CapacityUnits
+	prev.ReadCapacityUnits += c.ReadCapacityUnits
+	prev.WriteCapacityUnits += c.WriteCapacityUnits
+	if prev.Table != nil {
+		prev.Table.CapacityUnits += c.CapacityUnits
+	}
+}
+
+func (me *capacityList) list() []*consumedCapacity {
+	if me.mode != types.ReturnConsumedCapacityTotal && me.mode != types.ReturnConsumedCapacityIndexes {
+		return nil
+	}
+	out := make([]*consumedCapacity, 0, len(me.order))
+	for _, t := range me.order {
+		out = append(out, me.tables[t])
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// tables
+
+type createTableRequest struct {
+	TableName              string
+	KeySchema              []types.KeySchemaElement
+	AttributeDefinitions   []types.AttributeDefinition
+	BillingMode            types.BillingMode
+	ProvisionedThroughput  *types.ProvisionedThroughput
+	GlobalSecondaryIndexes []types.GlobalSecondaryIndex
+	LocalSecondaryIndexes  []types.LocalSecondaryIndex
+	StreamSpecification    *types.StreamSpecification
+	Tags                   []types.Tag
+}
+
+type throughputDescription struct {
+	ReadCapacityUnits      int64
+	WriteCapacityUnits     int64
+	NumberOfDecreasesToday int64
+}
+
+type billingModeSummary struct {
+	BillingMode                       types.BillingMode
+	LastUpdateToPayPerRequestDateTime float64
+}
+
+type indexDescription struct {
+	IndexName             string
+	IndexArn              string
+	IndexStatus           string `json:",omitempty"`
+	KeySchema             []types.KeySchemaElement
+	Projection            types.Projection
+	ProvisionedThroughput *throughputDescription `json:",omitempty"`
+	ItemCount             int64
+	IndexSizeBytes        int64
+}
+
+type tableDescription struct {
+	TableName              string
+	TableArn               string
+	TableId                string
+	TableStatus            string
+	CreationDateTime       float64
+	KeySchema              []types.KeySchemaElement
+	AttributeDefinitions   []types.AttributeDefinition
+	ItemCount              int64
+	TableSizeBytes         int64
+	ProvisionedThroughput  *throughputDescription     `json:",omitempty"`
+	BillingModeSummary     *billingModeSummary        `json:",omitempty"`
+	GlobalSecondaryIndexes []indexDescription         `json:",omitempty"`
+	LocalSecondaryIndexes  []indexDescription         `json:",omitempty"`
+	StreamSpecification    *types.StreamSpecification `json:",omitempty"`
+	LatestStreamArn        string                     `json:",omitempty"`
+	LatestStreamLabel      string                     `json:",omitempty"`
+}
+
+func describeThroughput(tp *types.ProvisionedThroughput) *throughputDescription {
+	d := &throughputDescription{}
+	if tp != nil && tp.ReadCapacityUnits != nil {
+		d.ReadCapacityUnits = *tp.ReadCapacityUnits
+	}
+	if tp != nil && tp.WriteCapacityUnits != nil {
+		d.WriteCapacityUnits = *tp.WriteCapacityUnits
+	}
+	return d
+}
+
+func (me *emuTable) describe(status string) *tableDescription {
+	d := &tableDescription{
+		TableName:             me.name,
+		TableArn:              me.arn(),
+		TableId:               fmt.Sprintf("%x", me.created.UnixNano()),
+		TableStatus:           status,
+		CreationDateTime:      float64(me.created.UnixMilli()) / 1000,
+		KeySchema:             me.keySchema,
+		AttributeDefinitions:  me.attrDefs,
+		ItemCount:             int64(len(me.items)),
+		ProvisionedThroughput: describeThroughput(me.throughput),
+		StreamSpecification:   me.stream,
+	}
+	for _, it := range me.items {
+		d.TableSizeBytes += int64(it.size())
+	}
+	if me.billingMode == types.BillingModePayPerRequest {
+		d.BillingModeSummary = &billingModeSummary{
+			BillingMode:                       me.billingMode,
+			LastUpdateToPayPerRequestDateTime: d.CreationDateTime,
+		}
+	}
+	if me.streamLabel != "" {
+		d.LatestStreamLabel = me.streamLabel
+		d.LatestStreamArn = me.arn() + "/stream/" + me.streamLabel
+	}
+
+	for _, idx := range me.indexes {
+		id := indexDescription{
+			IndexName:  idx.name,
+			IndexArn:   me.arn() + "/index/" + idx.name,
+			KeySchema:  idx.keySchema,
+			Projection: idx.projection,
+		}
+		for _, it := range me.ordered(idx) {
+			id.ItemCount++
+			id.IndexSizeBytes += int64(me.projectIndex(idx, it).size())
+		}
+		if idx.global {
+			id.IndexStatus = "ACTIVE"
+			id.ProvisionedThroughput = describeThroughput(idx.throughput)
+			d.GlobalSecondaryIndexes = append(d.GlobalSecondaryIndexes, id)
+		} else {
+			d.LocalSecondaryIndexes = append(d.LocalSecondaryIndexes, id)
+		}
+	}
+	return d
+}
+
+type tableDescriptionResponse struct {
+	TableDescription *tableDescription
+}
+
+func (me *Emulator) createTable(in *createTableRequest) (any, error) {
+	if _, ok := me.tables[in.TableName]; ok {
+		return nil, &emuError{code: "ResourceInUseException", message: "Table already exists: " + in.TableName}
+	}
+	t, err := newEmuTable(in)
+	if err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	me.tables[t.name] = t
+	me.openStream(t)
+	return &tableDescriptionResponse{TableDescription: t.describe("ACTIVE")}, nil
+}
+
+type tableNameRequest struct {
+	TableName string
+}
+
+func (me *Emulator) describeTable(in *tableNameRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Table *tableDescription }{Table: t.describe("ACTIVE")}, nil
+}
+
+func (me *Emulator) deleteTable(in *tableNameRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(me.tables, t.name)
+	closeStream(t)
+	return &tableDescriptionResponse{TableDescription: t.describe("DELETING")}, nil
+}
+
+type updateTableRequest struct {
+	TableName                   string
+	BillingMode                 types.BillingMode
+	ProvisionedThroughput       *types.ProvisionedThroughput
+	StreamSpecification         *types.StreamSpecification
+	AttributeDefinitions        []types.AttributeDefinition
+	GlobalSecondaryIndexUpdates []json.RawMessage
+}
+
+// updateTable changes the billing mode, throughput and stream of a table. Index changes
+// are not supported.
+func (me *Emulator) updateTable(in *updateTableRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(in.GlobalSecondaryIndexUpdates) > 0 || len(in.AttributeDefinitions) > 0 {
+		return nil, validationError("the emulator does not support changing indexes with UpdateTable")
+	}
+
+	mode := t.billingMode
+	if in.BillingMode != "" {
+		mode = in.BillingMode
+	}
+	throughput := t.throughput
+	if in.ProvisionedThroughput != nil {
+		throughput = in.ProvisionedThroughput
+	}
+	if mode == types.BillingModeProvisioned && throughput == nil {
+		return nil, validationError("One or more parameter values were invalid: ProvisionedThroughput must be specified when BillingMode is PROVISIONED")
+	}
+	if mode == types.BillingModePayPerRequest && in.ProvisionedThroughput != nil {
+		return nil, validationError("One or more parameter values were invalid: Neither ReadCapacityUnits nor WriteCapacityUnits can be specified when BillingMode is PAY_PER_REQUEST")
+	}
+
+	if spec := in.StreamSpecification; spec != nil {
+		enable := spec.StreamEnabled != nil && *spec.StreamEnabled
+		switch {
+		case enable && t.changes != nil:
+			return nil, validationError("Table already has an enabled stream: %s", t.changes.arn())
+		case !enable && t.changes == nil:
+			return nil, validationError("Table does not have an enabled stream")
+		case enable && spec.StreamViewType == "":
+			return nil, validationError("StreamViewType is required when enabling a stream")
+		}
+	}
+
+	t.billingMode = mode
+	t.throughput = throughput
+	if mode == types.BillingModePayPerRequest {
+		t.throughput = nil
+	}
+	if spec := in.StreamSpecification; spec != nil {
+		if spec.StreamEnabled != nil && *spec.StreamEnabled {
+			t.stream = spec
+			me.openStream(t)
+		} else {
+			t.stream = nil
+			closeStream(t)
+		}
+	}
+
+	return &tableDescriptionResponse{TableDescription: t.describe("ACTIVE")}, nil
+}
+
+type updateTimeToLiveRequest struct {
+	TableName               string
+	TimeToLiveSpecification *types.TimeToLiveSpecification
+}
+
+func (me *Emulator) updateTimeToLive(in *updateTimeToLiveRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	spec := in.TimeToLiveSpecification
+	if spec == nil || spec.AttributeName == nil || spec.Enabled == nil {
+		return nil, validationError("TimeToLiveSpecification requires AttributeName and Enabled")
+	}
+	enabled := t.ttl != nil && *t.ttl.Enabled
+	if *spec.Enabled == enabled {
+		return nil, validationError("TimeToLive is already %s", map[bool]string{true: "enabled", false: "disabled"}[enabled])
+	}
+	t.ttl = spec
+	return &struct {
+		TimeToLiveSpecification *types.TimeToLiveSpecification
+	}{TimeToLiveSpecification: spec}, nil
+}
+
+func (me *Emulator) describeTimeToLive(in *tableNameRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	desc := map[string]string{"TimeToLiveStatus": string(types.TimeToLiveStatusDisabled)}
+	if t.ttl != nil && *t.ttl.Enabled {
+		desc["TimeToLiveStatus"] = string(types.TimeToLiveStatusEnabled)
+		desc["AttributeName"] = *t.ttl.AttributeName
+	}
+	return map[string]any{"TimeToLiveDescription": desc}, nil
+}
+
+func (me *Emulator) tableByArn(arn string) (*emuTable, error) {
+	i := strings.LastIndex(arn, ":table/")
+	if i < 0 {
+		return nil, validationError("Invalid TableArn: %s", arn)
+	}
+	name := arn[i+len(":table/"):]
+	if t, ok := me.tables[name]; ok {
+		return t, nil
+	}
+	return nil, &emuError{code: "ResourceNotFoundException", message: "Requested resource not found: ResourcArn: " + arn + " not found"}
+}
+
+type tagResourceRequest struct {
+	ResourceArn string
+	Tags        []types.Tag
+	TagKeys     []string
+}
+
+func (me *Emulator) tagResource(in *tagResourceRequest) (any, error) {
+	t, err := me.tableByArn(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range in.Tags {
+		if tag.Key == nil || tag.Value == nil {
+			return nil, validationError("One or more parameter values were invalid: tags need a Key and a Value")
+		}
+		t.tags[*tag.Key] = *tag.Value
+	}
+	return struct{}{}, nil
+}
+
+func (me *Emulator) untagResource(in *tagResourceRequest) (any, error) {
+	t, err := me.tableByArn(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range in.TagKeys {
+		delete(t.tags, k)
+	}
+	return struct{}{}, nil
+}
+
+func (me *Emulator) listTagsOfResource(in *tagResourceRequest) (any, error) {
+	t, err := me.tableByArn(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	tags := []map[string]string{}
+	for _, k := range sortedKeysOf(t.tags) {
+		tags = append(tags, map[string]string{"Key": k, "Value": t.tags[k]})
+	}
+	return map[string]any{"Tags": tags}, nil
+}
+
+type updateContinuousBackupsRequest struct {
+	TableName                        string
+	PointInTimeRecoverySpecification *types.PointInTimeRecoverySpecification
+}
+
+func (me *emuTable) continuousBackups() map[string]any {
+	pitr := map[string]any{"PointInTimeRecoveryStatus": types.PointInTimeRecoveryStatusDisabled}
+	if me.pitr {
+		now := float64(time.Now().Unix())
+		pitr = map[string]any{
+			"PointInTimeRecoveryStatus":  types.PointInTimeRecoveryStatusEnabled,
+			"EarliestRestorableDateTime": float64(me.created.Unix()),
+			"LatestRestorableDateTime":   now,
+		}
+	}
+	return map[string]any{"ContinuousBackupsDescription": map[string]any{
+		"ContinuousBackupsStatus":        types.ContinuousBackupsStatusEnabled,
+		"PointInTimeRecoveryDescription": pitr,
+	}}
+}
+
+func (me *Emulator) updateContinuousBackups(in *updateContinuousBackupsRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if in.PointInTimeRecoverySpecification == nil || in.PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled == nil {
+		return nil, validationError("PointInTimeRecoverySpecification requires PointInTimeRecoveryEnabled")
+	}
+	t.pitr = *in.PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled
+	return t.continuousBackups(), nil
+}
+
+func (me *Emulator) describeContinuousBackups(in *tableNameRequest) (any, error) {
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return t.continuousBackups(), nil
+}
+
+type listTablesRequest struct {
+	ExclusiveStartTableName string
+	Limit                   int
+}
+
+type listTablesResponse struct {
+	TableNames             []string
+	LastEvaluatedTableName string `json:",omitempty"`
+}
+
+func (me *Emulator) listTables(in *listTablesRequest) (any, error) {
+	names := sortedKeysOf(me.tables)
+	out := &listTablesResponse{TableNames: []string{}}
+	limit := in.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	for _, n := range names {
+		if in.ExclusiveStartTableName != "" && n <= in.ExclusiveStartTableName {
+			continue
+		}
+		if len(out.TableNames) == limit {
+			out.LastEvaluatedTableName = out.TableNames[len(out.TableNames)-1]
+			break
+		}
+		out.TableNames = append(out.TableNames, n)
+	}
+	return out, nil
+}
+
+func (me *Emulator) describeLimits(_ *struct{}) (any, error) {
+	return map[string]int64{
+		"AccountMaxReadCapacityUnits":  80000,
+		"AccountMaxWriteCapacityUnits": 80000,
+		"TableMaxReadCapacityUnits":    40000,
+		"TableMaxWriteCapacityUnits":   40000,
+	}, nil
+}
+
+// ---------------------------------------------------------------------------
+// writes
+
+// emuWrite is a validated change to a single item that has not been applied yet, so
+// that transactions can check every item before changing any of them.
+type emuWrite struct {
+	table   *emuTable
+	key     string
+	old     item
+	new     item
+	deleted bool
+	touched []string
+}
+
+func (me *emuWrite) commit() {
+	if me.table.changes != nil {
+		me.table.changes.record(me)
+	}
+	if me.deleted {
+		delete(me.table.items, me.key)
+		return
+	}
+	me.table.items[me.key] = me.new
+}
+
+func (me *emuWrite) units() float64 {
+	size := 0
+	if me.old != nil {
+		size = me.old.size()
+	}
+	if me.new != nil && me.new.size() > size {
+		size = me.new.size()
+	}
+	return writeUnits(size)
+}
+
+func conditionFailed(old item, returnOld types.ReturnValuesOnConditionCheckFailure) *emuError {
+	e := &emuError{code: "ConditionalCheckFailedException", message: "The conditional request failed"}
+	if returnOld == types.ReturnValuesOnConditionCheckFailureAllOld && old != nil {
+		e.item = old.copy()
+	}
+	return e
+}
+
+// checkCondition evaluates a parsed condition against the current item, which may be nil.
+func checkCondition(c condition, old item, returnOld types.ReturnValuesOnConditionCheckFailure) error {
+	if c == nil {
+		return nil
+	}
+	ok, err := c.eval(old)
+	if err != nil {
+		return validationError("%s", err.Error())
+	}
+	if !ok {
+		return conditionFailed(old, returnOld)
+	}
+	return nil
+}
+
+func parseOptionalCondition(expr string, ctx *exprContext) (condition, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	c, err := parseCondition(expr, ctx)
+	if err != nil {
+		return nil, validationError("Invalid ConditionExpression: %s", strings.TrimPrefix(err.Error(), "Invalid expression: "))
+	}
+	return c, nil
+}
+
+func checkItemSize(it item) error {
+	if it.size() > maxItemSize {
+		return validationError("Item size has exceeded the maximum allowed size")
+	}
+	return nil
+}
+
+type putItemRequest struct {
+	legacyParams
+	expressionParams
+	TableName                           string
+	Item                                item
+	ConditionExpression                 string
+	ReturnValues                        types.ReturnValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	ReturnConsumedCapacity              types.ReturnConsumedCapacity
+}
+
+func (me *Emulator) preparePut(in *putItemRequest) (*emuWrite, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := in.context()
+	cond, err := parseOptionalCondition(in.ConditionExpression, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.checkUnused(); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	if err := t.validateItem(in.Item); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	if err := checkItemSize(in.Item); err != nil {
+		return nil, err
+	}
+	key := t.keyString(in.Item)
+	old := t.items[key]
+	if err := checkCondition(cond, old, in.ReturnValuesOnConditionCheckFailure); err != nil {
+		return nil, err
+	}
+	return &emuWrite{table: t, key: key, old: old, new: in.Item.copy()}, nil
+}
+
+type itemResponse struct {
+	Attributes       item              `json:",omitempty"`
+	ConsumedCapacity *consumedCapacity `json:",omitempty"`
+}
+
+func (me *Emulator) putItem(in *putItemRequest) (any, error) {
+	switch in.ReturnValues {
+	case "", types.ReturnValueNone, types.ReturnValueAllOld:
+	default:
+		return nil, validationError("ReturnValues can only be ALL_OLD or NONE")
+	}
+	w, err := me.preparePut(in)
+	if err != nil {
+		return nil, err
+	}
+	w.commit()
+	out := &itemResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, w.table.name, 0, w.units())}
+	if in.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = w.old
+	}
+	return out, nil
+}
+
+type deleteItemRequest struct {
+	legacyParams
+	expressionParams
+	TableName                           string
+	Key                                 item
+	ConditionExpression                 string
+	ReturnValues                        types.ReturnValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	ReturnConsumedCapacity              types.ReturnConsumedCapacity
+}
+
+func (me *Emulator) prepareDelete(in *deleteItemRequest) (*emuWrite, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := in.context()
+	cond, err := parseOptionalCondition(in.ConditionExpression, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.checkUnused(); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	key, err := t.keyFromKey(in.Key)
+	if err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	old := t.items[key]
+	if err := checkCondition(cond, old, in.ReturnValuesOnConditionCheckFailure); err != nil {
+		return nil, err
+	}
+	return &emuWrite{table: t, key: key, old: old, deleted: true}, nil
+}
+
+func (me *Emulator) deleteItem(in *deleteItemRequest) (any, error) {
+	switch in.ReturnValues {
+	case "", types.ReturnValueNone, types.ReturnValueAllOld:
+	default:
+		return nil, validationError("ReturnValues can only be ALL_OLD or NONE")
+	}
+	w, err := me.prepareDelete(in)
+	if err != nil {
+		return nil, err
+	}
+	w.commit()
+	out := &itemResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, w.table.name, 0, w.units())}
+	if in.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = w.old
+	}
+	return out, nil
+}
+
+type updateItemRequest struct {
+	legacyParams
+	expressionParams
+	TableName                           string
+	Key                                 item
+	UpdateExpression                    string
+	ConditionExpression                 string
+	ReturnValues                        types.ReturnValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	ReturnConsumedCapacity              types.ReturnConsumedCapacity
+}
+
+func (me *Emulator) prepareUpdate(in *updateItemRequest) (*emuWrite, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := in.context()
+	cond, err := parseOptionalCondition(in.ConditionExpression, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	if in.UpdateExpression != "" {
+		if actions, err = parseUpdate(in.UpdateExpression, ctx); err != nil {
+			return nil, validationError("%s", err.Error())
+		}
+	}
+	if err := ctx.checkUnused(); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	key, err := t.keyFromKey(in.Key)
+	if err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	for _, a := range actions {
+		if t.isKeyAttr(a.p[0].name) {
+			return nil, validationError("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", a.p[0].name)
+		}
+	}
+
+	old := t.items[key]
+	if err := checkCondition(cond, old, in.ReturnValuesOnConditionCheckFailure); err != nil {
+		return nil, err
+	}
+
+	base := old
+	if base == nil {
+		base = in.Key
+	}
+	updated, touched, err := applyUpdate(base, actions)
+	if err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	if err := t.validateItem(updated); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	if err := checkItemSize(updated); err != nil {
+		return nil, err
+	}
+	return &emuWrite{table: t, key: key, old: old, new: updated, touched: touched}, nil
+}
+
+func (me *Emulator) updateItem(in *updateItemRequest) (any, error) {
+	w, err := me.prepareUpdate(in)
+	if err != nil {
+		return nil, err
+	}
+	w.commit()
+
+	out := &itemResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, w.table.name, 0, w.units())}
+	pick := func(it item) item {
+		sub := item{}
+		for _, n := range w.touched {
+			if v, ok := it[n]; ok {
+				sub[n] = v.copy()
+			}
+		}
+		return sub
+	}
+	switch in.ReturnValues {
+	case "", types.ReturnValueNone:
+	case types.ReturnValueAllOld:
+		out.Attributes = w.old.copy()
+	case types.ReturnValueAllNew:
+		out.Attributes = w.new.copy()
+	case types.ReturnValueUpdatedOld:
+		out.Attributes = pick(w.old)
+	case types.ReturnValueUpdatedNew:
+		out.Attributes = pick(w.new)
+	default:
+		return nil, validationError("ReturnValues can only be NONE, ALL_OLD, UPDATED_OLD, ALL_NEW or UPDATED_NEW")
+	}
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------
+// reads
+
+type getItemRequest struct {
+	legacyParams
+	expressionParams
+	TableName              string
+	Key                    item
+	ProjectionExpression   string
+	ConsistentRead         bool
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+func parseOptionalProjection(expr string, ctx *exprContext) ([]path, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	paths, err := parseProjection(expr, ctx)
+	if err != nil {
+		return nil, validationError("Invalid ProjectionExpression: %s", strings.TrimPrefix(err.Error(), "Invalid expression: "))
+	}
+	return paths, nil
+}
+
+func (me *Emulator) getItem(in *getItemRequest) (any, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := in.context()
+	paths, err := parseOptionalProjection(in.ProjectionExpression, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.checkUnused(); err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+	key, err := t.keyFromKey(in.Key)
+	if err != nil {
+		return nil, validationError("%s", err.Error())
+	}
+
+	out := &struct {
+		Item             item              `json:",omitempty"`
+		ConsumedCapacity *consumedCapacity `json:",omitempty"`
+	}{}
+	size := 0
+	if it, ok := t.items[key]; ok {
+		out.Item = project(it, paths)
+		size = it.size()
+	}
+	out.ConsumedCapacity = newConsumedCapacity(in.ReturnConsumedCapacity, t.name, readUnits(size, in.ConsistentRead), 0)
+	return out, nil
+}
+
+type readRequest struct {
+	legacyParams
+	expressionParams
+	TableName              string
+	IndexName              string
+	FilterExpression       string
+	ProjectionExpression   string
+	Select                 types.Select
+	Limit                  int
+	ExclusiveStartKey      item
+	ConsistentRead         bool
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+type queryRequest struct {
+	readRequest
+	KeyConditionExpression string
+	ScanIndexForward       *bool
+}
+
+type scanRequest struct {
+	readRequest
+	Segment       *int
+	TotalSegments *int
+}
+
+type readResponse struct {
+	Items            []item `json:",omitempty"`
+	Count            int
+	ScannedCount     int
+	LastEvaluatedKey item              `json:",omitempty"`
+	ConsumedCapacity *consumedCapacity `json:",omitempty"`
+}
+
+// prepared is the parsed, validated form shared by Query and Scan.
+type prepared struct {
+	table  *emuTable
+	index  *emuIndex
+	filter condition
+	paths  []path
+}
+
+func (me *Emulator) prepareRead(in *readRequest, keyCondition string, ctx *exprContext) (*prepared, condition, error) {
+	if err := in.check(); err != nil {
+		return nil, nil, err
+	}
+	t, err := me.table(in.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &prepared{table: t}
+	if in.IndexName != "" {
+		if p.index, err = t.index(in.IndexName); err != nil {
+			return nil, nil, validationError("%s", err.Error())
+		}
+		if p.index.global && in.ConsistentRead {
+			return nil, nil, validationError("Consistent reads are not supported on global secondary indexes")
+		}
+	}
+	if in.Limit < 0 {
+		return nil, nil, validationError("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", in.Limit)
+	}
+
+	var keyCond condition
+	if keyCondition != "" {
+		if keyCond, err = parseCondition(keyCondition, ctx); err != nil {
+			return nil, nil, validationError("Invalid KeyConditionExpression: %s", strings.TrimPrefix(err.Error(), "Invalid expression: "))
+		}
+	}
+	if in.FilterExpression != "" {
+		if p.filter, err = parseCondition(in.FilterExpression, ctx); err != nil {
+			return nil, nil, validationError("Invalid FilterExpression: %s", strings.TrimPrefix(err.Error(), "Invalid expression: "))
+		}
+	}
+	if p.paths, err = parseOptionalProjection(in.ProjectionExpression, ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.checkUnused(); err != nil {
+		return nil, nil, validationError("%s", err.Error())
+	}
+
+	switch in.Select {
+	case "", types.SelectAllAttributes, types.SelectCount:
+		if in.Select != "" && p.paths != nil {
+			return nil, nil, validationError("Cannot specify the ProjectionExpression when choosing to get %s", in.Select)
+		}
+	case types.SelectAllProjectedAttributes:
+		if p.index == nil {
+			return nil, nil, validationError("ALL_PROJECTED_ATTRIBUTES can be used only when Querying using an IndexName")
+		}
+	case types.SelectSpecificAttributes:
+		if p.paths == nil {
+			return nil, nil, validationError("SPECIFIC_ATTRIBUTES requires a ProjectionExpression")
+		}
+	default:
+		return nil, nil, validationError("invalid Select %q", in.Select)
+	}
+	return p, keyCond, nil
+}
+
+// run walks the candidates after the start key, applying Limit, the 1MB page size,
+// the filter and the projection.
+func (me *prepared) run(in *readRequest, candidates []item, forward bool) (*readResponse, error) {
+	out := &readResponse{}
+	if in.Select != types.SelectCount {
+		out.Items = []item{}
+	}
+
+	start := 0
+	if in.ExclusiveStartKey != nil {
+		for start < len(candidates) {
+			c := me.table.compareOrder(me.index, candidates[start], in.ExclusiveStartKey)
+			if (forward && c > 0) || (!forward && c < 0) {
+				break
+			}
+			start++
+		}
+	}
+
+	size := 0
+	for i := start; i < len(candidates); i++ {
+		it := me.table.projectIndex(me.index, candidates[i])
+		out.ScannedCount++
+		size += it.size()
+
+		ok := true
+		if me.filter != nil {
+			var err error
+			if ok, err = me.filter.eval(it); err != nil {
+				return nil, validationError("%s", err.Error())
+			}
+		}
+		if ok {
+			out.Count++
+			if in.Select != types.SelectCount {
+				out.Items = append(out.Items, project(it, me.paths))
+			}
+		}
+
+		if i < len(candidates)-1 && ((in.Limit > 0 && out.ScannedCount == in.Limit) || size >= maxPageSize) {
+			out.LastEvaluatedKey = me.table.lastEvaluatedKey(me.index, candidates[i])
+			break
+		}
+	}
+
+	out.ConsumedCapacity = newConsumedCapacity(in.ReturnConsumedCapacity, me.table.name, readUnits(size, in.ConsistentRead), 0)
+	return out, nil
+}
+
+// partitionValue finds the `hash = :v` term of a key condition.
+func partitionValue(c condition, hash string) *attrValue {
+	switch c := c.(type) {
+	case *andCondition:
+		if v := partitionValue(c.a, hash); v != nil {
+			return v
+		}
+		return partitionValue(c.b, hash)
+	case *compareCondition:
+		if c.op != "=" {
+			return nil
+		}
+		for _, pair := range [][2]operand{{c.a, c.b}, {c.b, c.a}} {
+			p, ok := pair[0].(*pathOperand)
+			if !ok || len(p.p) != 1 || p.p[0].name != hash {
+				continue
+			}
+			if lit, ok := pair[1].(*literalOperand); ok {
+				return lit.v
+			}
+		}
+	}
+	return nil
+}
+
+func (me *Emulator) query(in *queryRequest) (any, error) {
+	ctx := in.context()
+	if in.KeyConditionExpression == "" {
+		if in.KeyConditions != nil {
+			return nil, in.check()
+		}
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	p, keyCond, err := me.prepareRead(&in.readRequest, in.KeyConditionExpression, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := p.table.hashKey
+	if p.index != nil {
+		hash = p.index.hashKey
+	}
+	pv := partitionValue(keyCond, hash)
+	if pv == nil {
+		return nil, validationError("Query condition missed key schema element: %s", hash)
+	}
+
+	candidates := []item{}
+	for _, it := range p.table.ordered(p.index) {
+		if !equalValues(it[hash], pv) {
+			continue
+		}
+		ok, err := keyCond.eval(it)
+		if err != nil {
+			return nil, validationError("%s", err.Error())
+		}
+		if ok {
+			candidates = append(candidates, it)
+		}
+	}
+
+	forward := in.ScanIndexForward == nil || *in.ScanIndexForward
+	if !forward {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+
+	return p.run(&in.readRequest, candidates, forward)
+}
+
+func (me *Emulator) scan(in *scanRequest) (any, error) {
+	p, _, err := me.prepareRead(&in.readRequest, "", in.context())
+	if err != nil {
+		return nil, err
+	}
+
+	if (in.Segment == nil) != (in.TotalSegments == nil) {
+		return nil, validationError("The TotalSegments parameter is required but was not present in the request when Segment parameter is present")
+	}
+	candidates := p.table.ordered(p.index)
+	if in.TotalSegments != nil {
+		if *in.TotalSegments < 1 || *in.TotalSegments > 1000000 || *in.Segment < 0 || *in.Segment >= *in.TotalSegments {
+			return nil, validationError("The Segment parameter is zero-based and must be less than parameter TotalSegments: Segment: %d is not less than TotalSegments: %d", *in.Segment, *in.TotalSegments)
+		}
+		hash := p.table.hashKey
+		if p.index != nil {
+			hash = p.index.hashKey
+		}
+		seg := make([]item, 0, len(candidates))
+		for _, it := range candidates {
+			if segmentOf(it[hash], *in.TotalSegments) == *in.Segment {
+				seg = append(seg, it)
+			}
+		}
+		candidates = seg
+	}
+
+	return p.run(&in.readRequest, candidates, true)
+}
+
+// ---------------------------------------------------------------------------
+// batches
+
+type batchWriteRequest struct {
+	RequestItems           map[string][]writeRequest
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+type writeRequest struct {
+	PutRequest    *struct{ Item item }
+	DeleteRequest *struct{ Key item }
+}
+
+func (me *Emulator) batchWriteItem(in *batchWriteRequest) (any, error) {
+	total := 0
+	for _, reqs := range in.RequestItems {
+		total += len(reqs)
+	}
+	if total == 0 {
+		return nil, validationError("1 validation error detected: Value at 'requestItems' failed to satisfy constraint: Member must have length greater than or equal to 1")
+	}
+	if total > maxBatchWrite {
+		return nil, validationError("Too many items requested for the BatchWriteItem call")
+	}
+
+	writes := []*emuWrite{}
+	caps := newCapacityList(in.ReturnConsumedCapacity)
+	for _, name := range sortedKeysOf(in.RequestItems) {
+		seen := map[string]bool{}
+		for _, r := range in.RequestItems[name] {
+			var w *emuWrite
+			var err error
+			switch {
+			case r.PutRequest != nil && r.DeleteRequest == nil:
+				w, err = me.preparePut(&putItemRequest{TableName: name, Item: r.PutRequest.Item})
+			case r.DeleteRequest != nil && r.PutRequest == nil:
+				w, err = me.prepareDelete(&deleteItemRequest{TableName: name, Key: r.DeleteRequest.Key})
+			default:
+				err = validationError("Supplied WriteRequest must contain exactly one of PutRequest or DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if seen[w.key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[w.key] = true
+			writes = append(writes, w)
+		}
+	}
+
+	for _, w := range writes {
+		w.commit()
+		caps.add(w.table.name, 0, w.units())
+	}
+
+	return &struct {
+		UnprocessedItems map[string][]writeRequest
+		ConsumedCapacity []*consumedCapacity `json:",omitempty"`
+	}{UnprocessedItems: map[string][]writeRequest{}, ConsumedCapacity: caps.list()}, nil
+}
+
+type keysAndAttributes struct {
+	legacyParams
+	expressionParams
+	Keys                 []item
+	ProjectionExpression string
+	ConsistentRead       bool
+}
+
+type batchGetRequest struct {
+	RequestItems           map[string]*keysAndAttributes
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+func (me *Emulator) batchGetItem(in *batchGetRequest) (any, error) {
+	total := 0
+	for _, ka := range in.RequestItems {
+		total += len(ka.Keys)
+	}
+	if total == 0 {
+		return nil, validationError("1 validation error detected: Value at 'requestItems' failed to satisfy constraint: Member must have length greater than or equal to 1")
+	}
+	if total > maxBatchGet {
+		return nil, validationError("Too many items requested for the BatchGetItem call")
+	}
+
+	responses := map[string][]item{}
+	caps := newCapacityList(in.ReturnConsumedCapacity)
+	for _, name := range sortedKeysOf(in.RequestItems) {
+		ka := in.RequestItems[name]
+		if err := ka.check(); err != nil {
+			return nil, err
+		}
+		t, err := me.table(name)
+		if err != nil {
+			return nil, err
+		}
+		ctx := ka.context()
+		paths, err := parseOptionalProjection(ka.ProjectionExpression, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.checkUnused(); err != nil {
+			return nil, validationError("%s", err.Error())
+		}
+
+		seen := map[string]bool{}
+		items := []item{}
+		read := 0.0
+		for _, k := range ka.Keys {
+			key, err := t.keyFromKey(k)
+			if err != nil {
+				return nil, validationError("%s", err.Error())
+			}
+			if seen[key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[key] = true
+			if it, ok := t.items[key]; ok {
+				items = append(items, project(it, paths))
+				read += readUnits(it.size(), ka.ConsistentRead)
+			}
+		}
+		responses[name] = items
+		caps.add(name, read, 0)
+	}
+
+	return &struct {
+		Responses        map[string][]item
+		UnprocessedKeys  map[string]*keysAndAttributes
+		ConsumedCapacity []*consumedCapacity `json:",omitempty"`
+	}{Responses: responses, UnprocessedKeys: map[string]*keysAndAttributes{}, ConsumedCapacity: caps.list()}, nil
+}
+
+// ---------------------------------------------------------------------------
+// transactions
+
+type transactWriteRequest struct {
+	TransactItems []struct {
+		ConditionCheck *struct {
+			expressionParams
+			TableName                           string
+			Key                                 item
+			ConditionExpression                 string
+			ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+		}
+		Put    *putItemRequest
+		Delete *deleteItemRequest
+		Update *updateItemRequest
+	}
+	ClientRequestToken     string
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+func (me *Emulator) transactWriteItems(in *transactWriteRequest) (any, error) {
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > maxTransactions {
+		return nil, validationError("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d", maxTransactions)
+	}
+
+	writes := make([]*emuWrite, len(in.TransactItems))
+	reasons := make([]cancellationReason, len(in.TransactItems))
+	failed := false
+	seen := map[string]bool{}
+
+	for i, ti := range in.TransactItems {
+		var w *emuWrite
+		var err error
+		switch {
+		case ti.ConditionCheck != nil:
+			cc := ti.ConditionCheck
+			if cc.ConditionExpression == "" {
+				return nil, validationError("The ConditionCheck operation requires a ConditionExpression")
+			}
+			// a condition check is a delete that is never committed
+			w, err = me.prepareDelete(&deleteItemRequest{
+				expressionParams:                    cc.expressionParams,
+				TableName:                           cc.TableName,
+				Key:                                 cc.Key,
+				ConditionExpression:                 cc.ConditionExpression,
+				ReturnValuesOnConditionCheckFailure: cc.ReturnValuesOnConditionCheckFailure,
+			})
+			if w != nil {
+				w = &emuWrite{table: w.table, key: w.key, old: w.old, new: w.old, deleted: false}
+			}
+		case ti.Put != nil:
+			w, err = me.preparePut(ti.Put)
+		case ti.Delete != nil:
+			w, err = me.prepareDelete(ti.Delete)
+		case ti.Update != nil:
+			w, err = me.prepareUpdate(ti.Update)
+		default:
+			return nil, validationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+
+		var ee *emuError
+		if err != nil {
+			var ok bool
+			if ee, ok = err.(*emuError); !ok || ee.code != "ConditionalCheckFailedException" {
+				return nil, err
+			}
+			failed = true
+			reasons[i] = cancellationReason{Code: "ConditionalCheckFailed", Message: ee.message, Item: ee.item}
+			continue
+		}
+
+		id := w.table.name + "/" + w.key
+		if seen[id] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		writes[i] = w
+		reasons[i] = cancellationReason{Code: "None"}
+	}
+
+	if failed {
+		codes := make([]string, len(reasons))
+		for i, r := range reasons {
+			codes[i] = r.Code
+		}
+		return nil, &emuError{
+			code:    "TransactionCanceledException",
+			message: "Transaction cancelled, please refer cancellation reasons for specific reasons [" + strings.Join(codes, ", ") + "]",
+			reasons: reasons,
+		}
+	}
+
+	caps := newCapacityList(in.ReturnConsumedCapacity)
+	for i, w := range writes {
+		if in.TransactItems[i].ConditionCheck != nil {
+			caps.add(w.table.name, readUnits(sizeOf(w.old), true)*2, 0)
+			continue
+		}
+		w.commit()
+		caps.add(w.table.name, 0, w.units()*2)
+	}
+
+	return &struct {
+		ConsumedCapacity []*consumedCapacity `json:",omitempty"`
+	}{ConsumedCapacity: caps.list()}, nil
+}
+
+func sizeOf(it item) int {
+	if it == nil {
+		return 0
+	}
+	return it.size()
+}
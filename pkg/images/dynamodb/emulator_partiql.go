@@ -0,0 +1,646 @@
+package dynamodb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+// The emulator runs the PartiQL statements tests write by hand by translating them to
+// the expression apis:
+//
+//	SELECT * | a, b FROM "table"[."index"] [WHERE ...]
+//	INSERT INTO "table" VALUE {'pk': ?, 'a': 'x'}
+//	UPDATE "table" SET a = ?, b = 'x' REMOVE c WHERE ...
+//	DELETE FROM "table" WHERE ...
+//
+// WHERE takes what a condition expression takes, with PartiQL names and literals. Unlike
+// DynamoDB, UPDATE and DELETE apply to every item WHERE matches instead of requiring the
+// full key.
+
+type executeStatementRequest struct {
+	Statement              string
+	Parameters             []*attrValue
+	ConsistentRead         bool
+	Limit                  int
+	NextToken              string
+	ReturnConsumedCapacity types.ReturnConsumedCapacity
+}
+
+type executeStatementResponse struct {
+	Items            []item            `json:",omitempty"`
+	NextToken        string            `json:",omitempty"`
+	LastEvaluatedKey item              `json:",omitempty"`
+	ConsumedCapacity *consumedCapacity `json:",omitempty"`
+}
+
+func (me *Emulator) executeStatement(in *executeStatementRequest) (any, error) {
+	toks, err := lexPartiQL(in.Statement)
+	if err != nil {
+		return nil, validationError("Statement wasn't well formed, can't be processed: %s", err.Error())
+	}
+	p := &partiqlParser{toks: toks, params: in.Parameters}
+	p.reset()
+
+	var out *executeStatementResponse
+	switch {
+	case p.keyword("SELECT"):
+		out, err = me.partiqlSelect(p, in)
+	case p.keyword("INSERT"):
+		out, err = me.partiqlInsert(p, in)
+	case p.keyword("UPDATE"):
+		out, err = me.partiqlUpdate(p, in)
+	case p.keyword("DELETE"):
+		out, err = me.partiqlDelete(p, in)
+	default:
+		err = errors.Errorf("unsupported statement %q", p.peek().text)
+	}
+	if err != nil {
+		if ee, ok := err.(*emuError); ok {
+			return nil, ee
+		}
+		return nil, validationError("Statement wasn't well formed, can't be processed: %s", err.Error())
+	}
+	if p.param != len(p.params) {
+		return nil, validationError("Number of parameters in request and statement don't match.")
+	}
+	return out, nil
+}
+
+func (me *Emulator) partiqlSelect(p *partiqlParser, in *executeStatementRequest) (*executeStatementResponse, error) {
+	var projection []string
+	if p.punct("*") {
+		p.next()
+	} else {
+		for {
+			name, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			projection = append(projection, name)
+			if !p.punct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if !p.keyword("FROM") {
+		return nil, errors.New("expected FROM")
+	}
+	table, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	index := ""
+	if p.punct(".") {
+		p.next()
+		if index, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	filter, err := p.where(false)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &scanRequest{readRequest: readRequest{
+		expressionParams:       p.expression(),
+		TableName:              table,
+		IndexName:              index,
+		FilterExpression:       filter,
+		ProjectionExpression:   strings.Join(projection, ", "),
+		Limit:                  in.Limit,
+		ConsistentRead:         in.ConsistentRead,
+		ReturnConsumedCapacity: in.ReturnConsumedCapacity,
+	}}
+	if in.NextToken != "" {
+		b, err := base64.StdEncoding.DecodeString(in.NextToken)
+		if err == nil {
+			err = json.Unmarshal(b, &req.ExclusiveStartKey)
+		}
+		if err != nil {
+			return nil, validationError("Given NextToken is not valid")
+		}
+	}
+	res, err := me.scan(req)
+	if err != nil {
+		return nil, err
+	}
+	read := res.(*readResponse)
+
+	out := &executeStatementResponse{Items: read.Items, LastEvaluatedKey: read.LastEvaluatedKey, ConsumedCapacity: read.ConsumedCapacity}
+	if read.LastEvaluatedKey != nil {
+		b, err := json.Marshal(read.LastEvaluatedKey)
+		if err != nil {
+			return nil, err
+		}
+		out.NextToken = base64.StdEncoding.EncodeToString(b)
+	}
+	return out, nil
+}
+
+func (me *Emulator) partiqlInsert(p *partiqlParser, in *executeStatementRequest) (*executeStatementResponse, error) {
+	if !p.keyword("INTO") {
+		return nil, errors.New("expected INTO")
+	}
+	table, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword("VALUE") {
+		return nil, errors.New("expected VALUE")
+	}
+	v, err := p.literal()
+	if err != nil {
+		return nil, err
+	}
+	if v.kind != "M" {
+		return nil, errors.New("VALUE must be a tuple")
+	}
+	if !p.done() {
+		return nil, errors.Errorf("unexpected %q", p.peek().text)
+	}
+
+	w, err := me.preparePut(&putItemRequest{TableName: table, Item: v.m})
+	if err != nil {
+		return nil, err
+	}
+	if w.old != nil {
+		return nil, &emuError{code: "DuplicateItemException", message: "Duplicate primary key exists in table"}
+	}
+	w.commit()
+	return &executeStatementResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, w.table.name, 0, w.units())}, nil
+}
+
+func (me *Emulator) partiqlUpdate(p *partiqlParser, in *executeStatementRequest) (*executeStatementResponse, error) {
+	table, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	var sets, removes []string
+	for {
+		switch {
+		case p.keyword("SET"):
+			for {
+				name, err := p.path()
+				if err != nil {
+					return nil, err
+				}
+				if !p.punct("=") {
+					return nil, errors.New("expected = in SET")
+				}
+				p.next()
+				v, err := p.literal()
+				if err != nil {
+					return nil, err
+				}
+				sets = append(sets, name+" = "+p.value(v))
+				if !p.punct(",") {
+					break
+				}
+				p.next()
+			}
+			continue
+		case p.keyword("REMOVE"):
+			for {
+				name, err := p.path()
+				if err != nil {
+					return nil, err
+				}
+				removes = append(removes, name)
+				if !p.punct(",") {
+					break
+				}
+				p.next()
+			}
+			continue
+		}
+		break
+	}
+	if len(sets) == 0 && len(removes) == 0 {
+		return nil, errors.New("expected SET or REMOVE")
+	}
+	update := ""
+	if len(sets) > 0 {
+		update = "SET " + strings.Join(sets, ", ")
+	}
+	if len(removes) > 0 {
+		update = strings.TrimSpace(update + " REMOVE " + strings.Join(removes, ", "))
+	}
+	params := p.expression()
+	p.reset()
+
+	matches, err := me.partiqlMatches(p, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, &emuError{code: "ConditionalCheckFailedException", message: "The conditional request failed"}
+	}
+	units := 0.0
+	for _, it := range matches {
+		w, err := me.prepareUpdate(&updateItemRequest{expressionParams: params, TableName: table, Key: it, UpdateExpression: update})
+		if err != nil {
+			return nil, err
+		}
+		w.commit()
+		units += w.units()
+	}
+	return &executeStatementResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, table, 0, units)}, nil
+}
+
+func (me *Emulator) partiqlDelete(p *partiqlParser, in *executeStatementRequest) (*executeStatementResponse, error) {
+	if !p.keyword("FROM") {
+		return nil, errors.New("expected FROM")
+	}
+	table, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := me.partiqlMatches(p, table)
+	if err != nil {
+		return nil, err
+	}
+	units := 0.0
+	for _, it := range matches {
+		w, err := me.prepareDelete(&deleteItemRequest{TableName: table, Key: it})
+		if err != nil {
+			return nil, err
+		}
+		w.commit()
+		units += w.units()
+	}
+	return &executeStatementResponse{ConsumedCapacity: newConsumedCapacity(in.ReturnConsumedCapacity, table, 0, units)}, nil
+}
+
+// partiqlMatches returns the keys of the items the WHERE clause of an UPDATE or DELETE
+// matches.
+func (me *Emulator) partiqlMatches(p *partiqlParser, table string) ([]item, error) {
+	filter, err := p.where(true)
+	if err != nil {
+		return nil, err
+	}
+	t, err := me.table(table)
+	if err != nil {
+		return nil, err
+	}
+	res, err := me.scan(&scanRequest{readRequest: readRequest{expressionParams: p.expression(), TableName: table, FilterExpression: filter}})
+	if err != nil {
+		return nil, err
+	}
+	keys := []item{}
+	for _, it := range res.(*readResponse).Items {
+		keys = append(keys, t.keyItem(it))
+	}
+	return keys, nil
+}
+
+// ---------------------------------------------------------------------------
+// lexer
+
+type partiqlToken struct {
+	kind byte // i identifier, q quoted name, s string, n number, ? parameter, p punctuation, e end
+	text string
+}
+
+func lexPartiQL(stmt string) ([]partiqlToken, error) {
+	toks := []partiqlToken{}
+	for i := 0; i < len(stmt); {
+		c := rune(stmt[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(stmt) {
+					return nil, errors.Errorf("unterminated %c", c)
+				}
+				if rune(stmt[j]) == c {
+					// quotes are escaped by doubling them
+					if j+1 < len(stmt) && rune(stmt[j+1]) == c {
+						b.WriteRune(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(stmt[j])
+				j++
+			}
+			kind := byte('s')
+			if c == '"' {
+				kind = 'q'
+			}
+			toks = append(toks, partiqlToken{kind: kind, text: b.String()})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(stmt) && unicode.IsDigit(rune(stmt[i+1]))):
+			j := i + 1
+			for j < len(stmt) && (unicode.IsDigit(rune(stmt[j])) || strings.ContainsRune(".eE", rune(stmt[j])) ||
+				(strings.ContainsRune("+-", rune(stmt[j])) && strings.ContainsRune("eE", rune(stmt[j-1])))) {
+				j++
+			}
+			toks = append(toks, partiqlToken{kind: 'n', text: stmt[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(stmt) && isIdentChar(rune(stmt[j])) {
+				j++
+			}
+			toks = append(toks, partiqlToken{kind: 'i', text: stmt[i:j]})
+			i = j
+		case c == '?':
+			toks = append(toks, partiqlToken{kind: '?', text: "?"})
+			i++
+		default:
+			two := ""
+			if i+1 < len(stmt) {
+				two = stmt[i : i+2]
+			}
+			switch two {
+			case "<>", "<=", ">=", "<<", ">>", "!=":
+				if two == "!=" {
+					two = "<>"
+				}
+				toks = append(toks, partiqlToken{kind: 'p', text: two})
+				i += 2
+				continue
+			}
+			if !strings.ContainsRune("()[]{},.:=<>*", c) {
+				return nil, errors.Errorf("unexpected %q", string(c))
+			}
+			toks = append(toks, partiqlToken{kind: 'p', text: string(c)})
+			i++
+		}
+	}
+	return append(toks, partiqlToken{kind: 'e'}), nil
+}
+
+// ---------------------------------------------------------------------------
+// parser
+
+type partiqlParser struct {
+	toks   []partiqlToken
+	pos    int
+	params []*attrValue
+	param  int
+
+	// the names and values of the expression being built
+	names  map[string]string
+	values map[string]*attrValue
+	byName map[string]string
+}
+
+func (me *partiqlParser) reset() {
+	me.names, me.values, me.byName = map[string]string{}, map[string]*attrValue{}, map[string]string{}
+}
+
+// expression returns the names and values of the expression built so far.
+func (me *partiqlParser) expression() expressionParams {
+	out := expressionParams{}
+	if len(me.names) > 0 {
+		out.ExpressionAttributeNames = me.names
+	}
+	if len(me.values) > 0 {
+		out.ExpressionAttributeValues = me.values
+	}
+	return out
+}
+
+func (me *partiqlParser) peek() partiqlToken {
+	return me.toks[me.pos]
+}
+
+func (me *partiqlParser) next() partiqlToken {
+	t := me.toks[me.pos]
+	if t.kind != 'e' {
+		me.pos++
+	}
+	return t
+}
+
+func (me *partiqlParser) done() bool {
+	return me.peek().kind == 'e'
+}
+
+// keyword consumes kw if it is next.
+func (me *partiqlParser) keyword(kw string) bool {
+	if t := me.peek(); t.kind == 'i' && strings.EqualFold(t.text, kw) {
+		me.next()
+		return true
+	}
+	return false
+}
+
+func (me *partiqlParser) punct(p string) bool {
+	t := me.peek()
+	return t.kind == 'p' && t.text == p
+}
+
+// name reads a table, index or attribute name.
+func (me *partiqlParser) name() (string, error) {
+	t := me.next()
+	if t.kind != 'i' && t.kind != 'q' {
+		return "", errors.Errorf("expected a name, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+// attr returns the #placeholder of an attribute name.
+func (me *partiqlParser) attr(name string) string {
+	if n, ok := me.byName[name]; ok {
+		return n
+	}
+	n := fmt.Sprintf("#n%d", len(me.names))
+	me.names[n] = name
+	me.byName[name] = n
+	return n
+}
+
+// value returns the :placeholder of a value.
+func (me *partiqlParser) value(v *attrValue) string {
+	n := fmt.Sprintf(":v%d", len(me.values))
+	me.values[n] = v
+	return n
+}
+
+// path reads an attribute path like a.b[1] as an expression path.
+func (me *partiqlParser) path() (string, error) {
+	name, err := me.name()
+	if err != nil {
+		return "", err
+	}
+	out := me.attr(name)
+	for {
+		switch {
+		case me.punct("."):
+			me.next()
+			if name, err = me.name(); err != nil {
+				return "", err
+			}
+			out += "." + me.attr(name)
+		case me.punct("["):
+			me.next()
+			t := me.next()
+			if t.kind != 'n' || !me.punct("]") {
+				return "", errors.New("expected a list index")
+			}
+			me.next()
+			out += "[" + t.text + "]"
+		default:
+			return out, nil
+		}
+	}
+}
+
+// literal reads a value: a parameter, string, number, boolean, null, tuple, list or set.
+func (me *partiqlParser) literal() (*attrValue, error) {
+	t := me.next()
+	switch t.kind {
+	case '?':
+		if me.param >= len(me.params) {
+			return nil, validationError("Number of parameters in request and statement don't match.")
+		}
+		me.param++
+		return me.params[me.param-1], nil
+	case 's':
+		return strValue(t.text), nil
+	case 'n':
+		norm, err := normalizeNumber(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &attrValue{kind: "N", s: norm}, nil
+	case 'i':
+		switch strings.ToLower(t.text) {
+		case "true", "false":
+			return &attrValue{kind: "BOOL", bl: strings.EqualFold(t.text, "true")}, nil
+		case "null":
+			return &attrValue{kind: "NULL", bl: true}, nil
+		}
+	case 'p':
+		switch t.text {
+		case "{":
+			m := map[string]*attrValue{}
+			for !me.punct("}") {
+				k := me.next()
+				if k.kind != 's' && k.kind != 'q' {
+					return nil, errors.Errorf("expected an attribute name, got %q", k.text)
+				}
+				if !me.punct(":") {
+					return nil, errors.New("expected :")
+				}
+				me.next()
+				v, err := me.literal()
+				if err != nil {
+					return nil, err
+				}
+				m[k.text] = v
+				if me.punct(",") {
+					me.next()
+				}
+			}
+			me.next()
+			return &attrValue{kind: "M", m: m}, nil
+		case "[", "<<":
+			end := "]"
+			if t.text == "<<" {
+				end = ">>"
+			}
+			l := []*attrValue{}
+			for !me.punct(end) {
+				if me.done() {
+					return nil, errors.Errorf("expected %s", end)
+				}
+				v, err := me.literal()
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, v)
+				if me.punct(",") {
+					me.next()
+				}
+			}
+			me.next()
+			if end == "]" {
+				return &attrValue{kind: "L", l: l}, nil
+			}
+			return setValue(l)
+		}
+	}
+	return nil, errors.Errorf("expected a value, got %q", t.text)
+}
+
+func setValue(l []*attrValue) (*attrValue, error) {
+	if len(l) == 0 {
+		return nil, errors.New("sets may not be empty")
+	}
+	out := &attrValue{kind: l[0].kind + "S"}
+	for _, v := range l {
+		if v.kind != l[0].kind || (v.kind != "S" && v.kind != "N") {
+			return nil, errors.New("sets hold strings or numbers of one type")
+		}
+		out.ss = append(out.ss, v.s)
+	}
+	return out, nil
+}
+
+var (
+	partiqlOperators   = map[string]bool{"AND": true, "OR": true, "NOT": true, "BETWEEN": true, "IN": true}
+	partiqlComparators = map[string]bool{"(": true, ")": true, ",": true, "=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+)
+
+// where translates an optional WHERE clause to a condition expression.
+func (me *partiqlParser) where(required bool) (string, error) {
+	if !me.keyword("WHERE") {
+		if required {
+			return "", errors.New("expected WHERE")
+		}
+		if !me.done() {
+			return "", errors.Errorf("unexpected %q", me.peek().text)
+		}
+		return "", nil
+	}
+
+	parts := []string{}
+	for !me.done() {
+		t := me.peek()
+		switch {
+		case t.kind == 'i' && partiqlOperators[strings.ToUpper(t.text)]:
+			me.next()
+			parts = append(parts, strings.ToUpper(t.text))
+		case t.kind == 'i' && me.pos+1 < len(me.toks) && me.toks[me.pos+1].kind == 'p' && me.toks[me.pos+1].text == "(":
+			me.next()
+			parts = append(parts, strings.ToLower(t.text))
+		case t.kind == 'i' && strings.EqualFold(t.text, "IS"):
+			return "", errors.New("IS is not supported by the emulator, use attribute_exists")
+		case t.kind == 'i' && !strings.EqualFold(t.text, "true") && !strings.EqualFold(t.text, "false") && !strings.EqualFold(t.text, "null"),
+			t.kind == 'q':
+			p, err := me.path()
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, p)
+		case t.kind == 'p' && partiqlComparators[t.text]:
+			me.next()
+			parts = append(parts, t.text)
+		default:
+			v, err := me.literal()
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, me.value(v))
+		}
+	}
+	if len(parts) == 0 {
+		return "", errors.New("empty WHERE")
+	}
+	return strings.Join(parts, " "), nil
+}
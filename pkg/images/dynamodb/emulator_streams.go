@@ -0,0 +1,336 @@
+package dynamodb
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const emulatorStreamsTargetPrefix = "DynamoDBStreams_20120810."
+
+// emuStream is a table's change log. Every stream has a single shard, which is closed
+// when the stream is disabled or its table deleted. Records are never trimmed.
+type emuStream struct {
+	table     *emuTable
+	label     string
+	viewType  types.StreamViewType
+	created   time.Time
+	enabled   bool
+	records   []*streamRecord
+	keySchema []types.KeySchemaElement
+}
+
+type streamRecordData struct {
+	ApproximateCreationDateTime float64
+	Keys                        item
+	NewImage                    item `json:",omitempty"`
+	OldImage                    item `json:",omitempty"`
+	SequenceNumber              string
+	SizeBytes                   int
+	StreamViewType              types.StreamViewType
+}
+
+type streamRecord struct {
+	AwsRegion    string           `json:"awsRegion"`
+	Dynamodb     streamRecordData `json:"dynamodb"`
+	EventID      string           `json:"eventID"`
+	EventName    string           `json:"eventName"`
+	EventSource  string           `json:"eventSource"`
+	EventVersion string           `json:"eventVersion"`
+}
+
+func (me *Emulator) openStream(t *emuTable) {
+	if t.stream == nil || t.stream.StreamEnabled == nil || !*t.stream.StreamEnabled {
+		return
+	}
+	now := time.Now()
+	label := now.UTC().Format("2006-01-02T15:04:05.000")
+	for me.streams[t.arn()+"/stream/"+label] != nil {
+		now = now.Add(time.Millisecond)
+		label = now.UTC().Format("2006-01-02T15:04:05.000")
+	}
+
+	s := &emuStream{table: t, label: label, viewType: t.stream.StreamViewType, created: now, enabled: true, keySchema: t.keySchema}
+	t.streamLabel = label
+	t.changes = s
+	me.streams[s.arn()] = s
+}
+
+func closeStream(t *emuTable) {
+	if t.changes != nil {
+		t.changes.enabled = false
+		t.changes = nil
+	}
+}
+
+func (me *emuStream) arn() string {
+	return me.table.arn() + "/stream/" + me.label
+}
+
+func (me *emuStream) shardID() string {
+	return fmt.Sprintf("shardId-00000001-%x", me.created.UnixNano())
+}
+
+func sequenceNumber(n int) string {
+	return fmt.Sprintf("%021d", n)
+}
+
+// record appends the change made by a committed write. Writes that did not change the
+// item do not produce a record, like in DynamoDB.
+func (me *emuStream) record(w *emuWrite) {
+	var name string
+	switch {
+	case w.deleted && w.old == nil:
+		return
+	case w.deleted:
+		name = "REMOVE"
+	case w.old == nil:
+		name = "INSERT"
+	case equalItems(w.old, w.new):
+		return
+	default:
+		name = "MODIFY"
+	}
+
+	var newImage item
+	if !w.deleted {
+		newImage = w.new
+	}
+	keys := me.table.keyItem(w.old)
+	if newImage != nil {
+		keys = me.table.keyItem(newImage)
+	}
+
+	data := streamRecordData{
+		ApproximateCreationDateTime: float64(time.Now().Unix()),
+		Keys:                        keys,
+		SequenceNumber:              sequenceNumber(len(me.records) + 1),
+		StreamViewType:              me.viewType,
+	}
+	switch me.viewType {
+	case types.StreamViewTypeNewImage:
+		data.NewImage = newImage
+	case types.StreamViewTypeOldImage:
+		data.OldImage = w.old
+	case types.StreamViewTypeNewAndOldImages:
+		data.NewImage = newImage
+		data.OldImage = w.old
+	}
+	data.SizeBytes = keys.size() + data.NewImage.size() + data.OldImage.size()
+
+	me.records = append(me.records, &streamRecord{
+		AwsRegion:    "ddblocal",
+		Dynamodb:     data,
+		EventID:      fmt.Sprintf("%x-%d", me.created.UnixNano(), len(me.records)+1),
+		EventName:    name,
+		EventSource:  "aws:dynamodb",
+		EventVersion: "1.1",
+	})
+}
+
+func equalItems(a, b item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !equalValues(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func streamNotFound(arn string) *emuError {
+	return &emuError{code: "ResourceNotFoundException", message: "Requested resource not found: Stream: " + arn + " not found"}
+}
+
+func (me *Emulator) stream(arn string) (*emuStream, error) {
+	s, ok := me.streams[arn]
+	if !ok {
+		return nil, streamNotFound(arn)
+	}
+	return s, nil
+}
+
+type listStreamsRequest struct {
+	TableName               string
+	ExclusiveStartStreamArn string
+	Limit                   int
+}
+
+type streamSummary struct {
+	StreamArn   string
+	StreamLabel string
+	TableName   string
+}
+
+func (me *Emulator) listStreams(in *listStreamsRequest) (any, error) {
+	limit := in.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	out := struct {
+		Streams                []streamSummary
+		LastEvaluatedStreamArn string `json:",omitempty"`
+	}{Streams: []streamSummary{}}
+
+	for _, arn := range sortedKeysOf(me.streams) {
+		s := me.streams[arn]
+		if (in.TableName != "" && s.table.name != in.TableName) || arn <= in.ExclusiveStartStreamArn {
+			continue
+		}
+		if len(out.Streams) == limit {
+			out.LastEvaluatedStreamArn = out.Streams[len(out.Streams)-1].StreamArn
+			break
+		}
+		out.Streams = append(out.Streams, streamSummary{StreamArn: arn, StreamLabel: s.label, TableName: s.table.name})
+	}
+	return &out, nil
+}
+
+type streamArnRequest struct {
+	StreamArn string
+}
+
+type sequenceNumberRange struct {
+	StartingSequenceNumber string
+	EndingSequenceNumber   string `json:",omitempty"`
+}
+
+type shardDescription struct {
+	ShardId             string
+	SequenceNumberRange sequenceNumberRange
+}
+
+type streamDescription struct {
+	StreamArn               string
+	StreamLabel             string
+	StreamStatus            string
+	StreamViewType          types.StreamViewType
+	CreationRequestDateTime float64
+	TableName               string
+	KeySchema               []types.KeySchemaElement
+	Shards                  []shardDescription
+}
+
+func (me *Emulator) describeStream(in *streamArnRequest) (any, error) {
+	s, err := me.stream(in.StreamArn)
+	if err != nil {
+		return nil, err
+	}
+
+	shard := shardDescription{ShardId: s.shardID(), SequenceNumberRange: sequenceNumberRange{StartingSequenceNumber: sequenceNumber(1)}}
+	status := "ENABLED"
+	if !s.enabled {
+		status = "DISABLED"
+		shard.SequenceNumberRange.EndingSequenceNumber = sequenceNumber(len(s.records))
+	}
+
+	return &struct{ StreamDescription *streamDescription }{StreamDescription: &streamDescription{
+		StreamArn:               s.arn(),
+		StreamLabel:             s.label,
+		StreamStatus:            status,
+		StreamViewType:          s.viewType,
+		CreationRequestDateTime: float64(s.created.UnixMilli()) / 1000,
+		TableName:               s.table.name,
+		KeySchema:               s.keySchema,
+		Shards:                  []shardDescription{shard},
+	}}, nil
+}
+
+type getShardIteratorRequest struct {
+	StreamArn         string
+	ShardId           string
+	ShardIteratorType streamtypes.ShardIteratorType
+	SequenceNumber    string
+}
+
+// shard iterators are the stream arn and a position in its records.
+func encodeShardIterator(arn string, pos int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(pos) + "|" + arn))
+}
+
+func decodeShardIterator(it string) (string, int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(it)
+	if err != nil {
+		return "", 0, false
+	}
+	pos, arn, ok := strings.Cut(string(b), "|")
+	n, err := strconv.Atoi(pos)
+	return arn, n, ok && err == nil
+}
+
+func (me *Emulator) getShardIterator(in *getShardIteratorRequest) (any, error) {
+	s, err := me.stream(in.StreamArn)
+	if err != nil {
+		return nil, err
+	}
+	if in.ShardId != s.shardID() {
+		return nil, &emuError{code: "ResourceNotFoundException", message: "Requested resource not found: Shard does not exist"}
+	}
+
+	var pos int
+	switch in.ShardIteratorType {
+	case streamtypes.ShardIteratorTypeTrimHorizon:
+		pos = 0
+	case streamtypes.ShardIteratorTypeLatest:
+		pos = len(s.records)
+	case streamtypes.ShardIteratorTypeAtSequenceNumber, streamtypes.ShardIteratorTypeAfterSequenceNumber:
+		n, err := strconv.Atoi(in.SequenceNumber)
+		if err != nil || n < 1 || n > len(s.records) {
+			return nil, validationError("Invalid SequenceNumber: %s", in.SequenceNumber)
+		}
+		pos = n - 1
+		if in.ShardIteratorType == streamtypes.ShardIteratorTypeAfterSequenceNumber {
+			pos = n
+		}
+	default:
+		return nil, validationError("Invalid ShardIteratorType: %s", in.ShardIteratorType)
+	}
+
+	return &struct{ ShardIterator string }{ShardIterator: encodeShardIterator(s.arn(), pos)}, nil
+}
+
+type getRecordsRequest struct {
+	ShardIterator string
+	Limit         int
+}
+
+func (me *Emulator) getRecords(in *getRecordsRequest) (any, error) {
+	arn, pos, ok := decodeShardIterator(in.ShardIterator)
+	if !ok {
+		return nil, validationError("Invalid ShardIterator")
+	}
+	s, err := me.stream(arn)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := in.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	end := pos + limit
+	if end > len(s.records) {
+		end = len(s.records)
+	}
+	if pos > end {
+		pos = end
+	}
+
+	out := struct {
+		Records           []*streamRecord
+		NextShardIterator string `json:",omitempty"`
+	}{Records: s.records[pos:end]}
+
+	if s.enabled || end < len(s.records) {
+		out.NextShardIterator = encodeShardIterator(arn, end)
+	}
+	return &out, nil
+}
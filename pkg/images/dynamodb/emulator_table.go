@@ -0,0 +1,395 @@
+package dynamodb
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+type emuIndex struct {
+	name       string
+	global     bool
+	keySchema  []types.KeySchemaElement
+	hashKey    string
+	rangeKey   string
+	projection types.Projection
+	throughput *types.ProvisionedThroughput
+}
+
+type emuTable struct {
+	name        string
+	created     time.Time
+	keySchema   []types.KeySchemaElement
+	attrDefs    []types.AttributeDefinition
+	attrTypes   map[string]string
+	hashKey     string
+	rangeKey    string
+	indexes     []*emuIndex
+	billingMode types.BillingMode
+	throughput  *types.ProvisionedThroughput
+	stream      *types.StreamSpecification
+	streamLabel string
+	changes     *emuStream
+	ttl         *types.TimeToLiveSpecification
+	pitr        bool
+	tags        map[string]string
+	items       map[string]item
+}
+
+func splitKeySchema(ks []types.KeySchemaElement) (string, string, error) {
+	var hash, rng string
+	for _, k := range ks {
+		if k.AttributeName == nil {
+			return "", "", errors.New("1 validation error detected: Value null at 'keySchema.member.attributeName' failed to satisfy constraint: Member must not be null")
+		}
+		switch k.KeyType {
+		case types.KeyTypeHash:
+			if hash != "" {
+				return "", "", errors.New("Invalid KeySchema: Too many hash keys")
+			}
+			hash = *k.AttributeName
+		case types.KeyTypeRange:
+			if rng != "" {
+				return "", "", errors.New("Invalid KeySchema: Too many range keys")
+			}
+			rng = *k.AttributeName
+		default:
+			return "", "", errors.Errorf("Invalid KeySchema: unknown key type %q", k.KeyType)
+		}
+	}
+	if hash == "" {
+		return "", "", errors.New("Invalid KeySchema: The first KeySchemaElement is not a HASH key type")
+	}
+	return hash, rng, nil
+}
+
+func newEmuTable(in *createTableRequest) (*emuTable, error) {
+	if len(in.TableName) < 3 || len(in.TableName) > 255 {
+		return nil, errors.New("TableName must be at least 3 characters long and at most 255 characters long")
+	}
+	if len(in.KeySchema) == 0 || len(in.KeySchema) > 2 {
+		return nil, errors.New("1 validation error detected: Value at 'keySchema' failed to satisfy constraint: Member must have length less than or equal to 2")
+	}
+
+	t := &emuTable{
+		name:        in.TableName,
+		created:     time.Now(),
+		keySchema:   in.KeySchema,
+		attrDefs:    in.AttributeDefinitions,
+		attrTypes:   map[string]string{},
+		billingMode: in.BillingMode,
+		throughput:  in.ProvisionedThroughput,
+		stream:      in.StreamSpecification,
+		tags:        map[string]string{},
+		items:       map[string]item{},
+	}
+
+	for _, tag := range in.Tags {
+		if tag.Key == nil || tag.Value == nil {
+			return nil, errors.New("One or more parameter values were invalid: tags need a Key and a Value")
+		}
+		t.tags[*tag.Key] = *tag.Value
+	}
+
+	if t.billingMode == "" {
+		t.billingMode = types.BillingModeProvisioned
+	}
+	if t.billingMode == types.BillingModeProvisioned && t.throughput == nil {
+		return nil, errors.New("One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be specified when BillingMode is PROVISIONED")
+	}
+
+	for _, d := range in.AttributeDefinitions {
+		if d.AttributeName == nil {
+			return nil, errors.New("One or more parameter values were invalid: AttributeName must not be null")
+		}
+		switch d.AttributeType {
+		case types.ScalarAttributeTypeS, types.ScalarAttributeTypeN, types.ScalarAttributeTypeB:
+		default:
+			return nil, errors.Errorf("One or more parameter values were invalid: invalid AttributeType %q", d.AttributeType)
+		}
+		t.attrTypes[*d.AttributeName] = string(d.AttributeType)
+	}
+
+	var err error
+	if t.hashKey, t.rangeKey, err = splitKeySchema(in.KeySchema); err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	requireDef := func(name string) error {
+		if name == "" {
+			return nil
+		}
+		if _, ok := t.attrTypes[name]; !ok {
+			return errors.New("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [" + name + "], AttributeDefinitions: [" + strings.Join(sortedKeysOf(t.attrTypes), ", ") + "]")
+		}
+		used[name] = true
+		return nil
+	}
+	if err := requireDef(t.hashKey); err != nil {
+		return nil, err
+	}
+	if err := requireDef(t.rangeKey); err != nil {
+		return nil, err
+	}
+
+	addIndex := func(name *string, ks []types.KeySchemaElement, proj *types.Projection, tp *types.ProvisionedThroughput, global bool) error {
+		if name == nil || *name == "" {
+			return errors.New("One or more parameter values were invalid: IndexName must not be empty")
+		}
+		for _, idx := range t.indexes {
+			if idx.name == *name {
+				return errors.New("One or more parameter values were invalid: Duplicate index name: " + *name)
+			}
+		}
+		hash, rng, err := splitKeySchema(ks)
+		if err != nil {
+			return err
+		}
+		if !global && (hash != t.hashKey || rng == "") {
+			return errors.New("One or more parameter values were invalid: Index KeySchema does not have the same leading hash key as table KeySchema for index: " + *name)
+		}
+		if err := requireDef(hash); err != nil {
+			return err
+		}
+		if err := requireDef(rng); err != nil {
+			return err
+		}
+		idx := &emuIndex{name: *name, global: global, keySchema: ks, hashKey: hash, rangeKey: rng, throughput: tp}
+		if proj != nil {
+			idx.projection = *proj
+		}
+		if idx.projection.ProjectionType == "" {
+			idx.projection.ProjectionType = types.ProjectionTypeAll
+		}
+		t.indexes = append(t.indexes, idx)
+		return nil
+	}
+
+	for _, g := range in.GlobalSecondaryIndexes {
+		if err := addIndex(g.IndexName, g.KeySchema, g.Projection, g.ProvisionedThroughput, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range in.LocalSecondaryIndexes {
+		if err := addIndex(l.IndexName, l.KeySchema, l.Projection, nil, false); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range t.attrTypes {
+		if !used[name] {
+			return nil, errors.New("One or more parameter values were invalid: Number of attributes in KeySchema does not exactly match number of attributes defined in AttributeDefinitions")
+		}
+	}
+
+	return t, nil
+}
+
+func sortedKeysOf[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (me *emuTable) arn() string {
+	return "arn:aws:dynamodb:ddblocal:000000000000:table/" + me.name
+}
+
+func (me *emuTable) index(name string) (*emuIndex, error) {
+	for _, idx := range me.indexes {
+		if idx.name == name {
+			return idx, nil
+		}
+	}
+	return nil, errors.Errorf("The table does not have the specified index: %s", name)
+}
+
+// validateItem checks the key and index key attributes of a full item.
+func (me *emuTable) validateItem(it item) error {
+	if err := me.validateKeyAttr(it, me.hashKey, true); err != nil {
+		return err
+	}
+	if err := me.validateKeyAttr(it, me.rangeKey, true); err != nil {
+		return err
+	}
+	for _, idx := range me.indexes {
+		if err := me.validateKeyAttr(it, idx.hashKey, false); err != nil {
+			return err
+		}
+		if err := me.validateKeyAttr(it, idx.rangeKey, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (me *emuTable) validateKeyAttr(it item, name string, required bool) error {
+	if name == "" {
+		return nil
+	}
+	v, ok := it[name]
+	if !ok {
+		if required {
+			return errors.Errorf("One or more parameter values were invalid: Missing the key %s in the item", name)
+		}
+		return nil
+	}
+	want := me.attrTypes[name]
+	if v.kind != want {
+		return errors.Errorf("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s", name, want, v.kind)
+	}
+	if (v.kind == "S" && v.s == "") || (v.kind == "B" && len(v.b) == 0) {
+		return errors.Errorf("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", name)
+	}
+	return nil
+}
+
+// keyFromKey validates a Key parameter: it must contain exactly the key attributes.
+func (me *emuTable) keyFromKey(key item) (string, error) {
+	want := 1
+	if me.rangeKey != "" {
+		want = 2
+	}
+	if len(key) != want {
+		return "", errors.New("The provided key element does not match the schema")
+	}
+	if err := me.validateKeyAttr(key, me.hashKey, true); err != nil {
+		return "", errors.New("The provided key element does not match the schema")
+	}
+	if err := me.validateKeyAttr(key, me.rangeKey, true); err != nil {
+		return "", errors.New("The provided key element does not match the schema")
+	}
+	return me.keyString(key), nil
+}
+
+func (me *emuTable) keyString(it item) string {
+	k := keyString(it[me.hashKey])
+	if me.rangeKey != "" {
+		k += "|" + keyString(it[me.rangeKey])
+	}
+	return k
+}
+
+func (me *emuTable) keyItem(it item) item {
+	k := item{me.hashKey: it[me.hashKey].copy()}
+	if me.rangeKey != "" {
+		k[me.rangeKey] = it[me.rangeKey].copy()
+	}
+	return k
+}
+
+func (me *emuTable) isKeyAttr(name string) bool {
+	return name == me.hashKey || name == me.rangeKey
+}
+
+// projectIndex applies the index projection to a table item.
+func (me *emuTable) projectIndex(idx *emuIndex, it item) item {
+	if idx == nil || idx.projection.ProjectionType == types.ProjectionTypeAll {
+		return it
+	}
+	out := me.keyItem(it)
+	out[idx.hashKey] = it[idx.hashKey]
+	if idx.rangeKey != "" {
+		out[idx.rangeKey] = it[idx.rangeKey]
+	}
+	if idx.projection.ProjectionType == types.ProjectionTypeInclude {
+		for _, n := range idx.projection.NonKeyAttributes {
+			if v, ok := it[n]; ok {
+				out[n] = v
+			}
+		}
+	}
+	return out
+}
+
+// lastEvaluatedKey returns the key attributes needed to resume after it.
+func (me *emuTable) lastEvaluatedKey(idx *emuIndex, it item) item {
+	k := me.keyItem(it)
+	if idx != nil {
+		k[idx.hashKey] = it[idx.hashKey].copy()
+		if idx.rangeKey != "" {
+			k[idx.rangeKey] = it[idx.rangeKey].copy()
+		}
+	}
+	return k
+}
+
+// ordered returns the items visible through idx (or the table) in key order.
+func (me *emuTable) ordered(idx *emuIndex) []item {
+	hash, rng := me.hashKey, me.rangeKey
+	if idx != nil {
+		hash, rng = idx.hashKey, idx.rangeKey
+	}
+
+	out := make([]item, 0, len(me.items))
+	for _, it := range me.items {
+		if idx != nil {
+			if _, ok := it[hash]; !ok {
+				continue
+			}
+			if _, ok := it[rng]; rng != "" && !ok {
+				continue
+			}
+		}
+		out = append(out, it)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return me.compareOrder(idx, out[i], out[j]) < 0
+	})
+
+	return out
+}
+
+func (me *emuTable) compareOrder(idx *emuIndex, a, b item) int {
+	hash, rng := me.hashKey, me.rangeKey
+	if idx != nil {
+		hash, rng = idx.hashKey, idx.rangeKey
+	}
+	if c := strings.Compare(keyString(a[hash]), keyString(b[hash])); c != 0 {
+		return c
+	}
+	if rng != "" {
+		if c, _ := compareValues(a[rng], b[rng]); c != 0 {
+			return c
+		}
+	}
+	if idx != nil {
+		return strings.Compare(me.keyString(a), me.keyString(b))
+	}
+	return 0
+}
+
+func segmentOf(v *attrValue, total int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(keyString(v)))
+	return int(h.Sum32() % uint32(total))
+}
+
+func readUnits(size int, consistent bool) float64 {
+	units := math.Ceil(float64(maxInt(size, 1)) / 4096)
+	if !consistent {
+		units /= 2
+	}
+	return units
+}
+
+func writeUnits(size int) float64 {
+	return math.Ceil(float64(maxInt(size, 1)) / 1024)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,377 @@
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// attrValue is the emulator's own representation of a DynamoDB attribute value as it
+// appears on the wire. It is separate from types.AttributeValue because the sdk does
+// not export its json codec.
+type attrValue struct {
+	kind string // S N B BOOL NULL SS NS BS L M
+	s    string // S, and the normalized form of N
+	b    []byte
+	bl   bool
+	ss   []string // SS, and the normalized forms of NS
+	bs   [][]byte
+	l    []*attrValue
+	m    map[string]*attrValue
+}
+
+type item map[string]*attrValue
+
+func (me *attrValue) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 1 {
+		return errors.New("Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes")
+	}
+
+	for k, v := range raw {
+		me.kind = k
+		switch k {
+		case "S":
+			return json.Unmarshal(v, &me.s)
+		case "N":
+			var n string
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			norm, err := normalizeNumber(n)
+			if err != nil {
+				return err
+			}
+			me.s = norm
+		case "B":
+			return json.Unmarshal(v, &me.b)
+		case "BOOL":
+			return json.Unmarshal(v, &me.bl)
+		case "NULL":
+			if err := json.Unmarshal(v, &me.bl); err != nil {
+				return err
+			}
+			if !me.bl {
+				return errors.New("One or more parameter values were invalid: Null attribute value types must have the value of true")
+			}
+		case "SS", "NS":
+			if err := json.Unmarshal(v, &me.ss); err != nil {
+				return err
+			}
+			if len(me.ss) == 0 {
+				return errors.New("One or more parameter values were invalid: An string set  may not be empty")
+			}
+			seen := map[string]bool{}
+			for i, s := range me.ss {
+				if k == "NS" {
+					norm, err := normalizeNumber(s)
+					if err != nil {
+						return err
+					}
+					me.ss[i] = norm
+				}
+				if seen[me.ss[i]] {
+					return errors.New("One or more parameter values were invalid: Input collection contains duplicates")
+				}
+				seen[me.ss[i]] = true
+			}
+		case "BS":
+			if err := json.Unmarshal(v, &me.bs); err != nil {
+				return err
+			}
+			if len(me.bs) == 0 {
+				return errors.New("One or more parameter values were invalid: Binary sets may not be empty")
+			}
+		case "L":
+			me.l = []*attrValue{}
+			return json.Unmarshal(v, &me.l)
+		case "M":
+			me.m = map[string]*attrValue{}
+			return json.Unmarshal(v, &me.m)
+		default:
+			return errors.Errorf("Supplied AttributeValue has an unsupported datatype %q", k)
+		}
+	}
+	return nil
+}
+
+func (me *attrValue) MarshalJSON() ([]byte, error) {
+	var v any
+	switch me.kind {
+	case "S", "N":
+		v = me.s
+	case "B":
+		v = me.b
+	case "BOOL", "NULL":
+		v = me.bl
+	case "SS", "NS":
+		v = me.ss
+	case "BS":
+		v = me.bs
+	case "L":
+		v = me.l
+	case "M":
+		v = me.m
+	default:
+		return nil, errors.Errorf("invalid attribute value kind %q", me.kind)
+	}
+	return json.Marshal(map[string]any{me.kind: v})
+}
+
+func strValue(s string) *attrValue {
+	return &attrValue{kind: "S", s: s}
+}
+
+func numValue(r *big.Rat) *attrValue {
+	return &attrValue{kind: "N", s: formatNumber(r)}
+}
+
+func (me *attrValue) copy() *attrValue {
+	if me == nil {
+		return nil
+	}
+	c := *me
+	if me.b != nil {
+		c.b = append([]byte{}, me.b...)
+	}
+	if me.ss != nil {
+		c.ss = append([]string{}, me.ss...)
+	}
+	if me.bs != nil {
+		c.bs = make([][]byte, len(me.bs))
+		for i, b := range me.bs {
+			c.bs[i] = append([]byte{}, b...)
+		}
+	}
+	if me.l != nil {
+		c.l = make([]*attrValue, len(me.l))
+		for i, v := range me.l {
+			c.l[i] = v.copy()
+		}
+	}
+	if me.m != nil {
+		c.m = make(map[string]*attrValue, len(me.m))
+		for k, v := range me.m {
+			c.m[k] = v.copy()
+		}
+	}
+	return &c
+}
+
+func (me item) copy() item {
+	if me == nil {
+		return nil
+	}
+	c := make(item, len(me))
+	for k, v := range me {
+		c[k] = v.copy()
+	}
+	return c
+}
+
+// size approximates the storage size of the value the way DynamoDB documents it.
+func (me *attrValue) size() int {
+	switch me.kind {
+	case "S":
+		return len(me.s)
+	case "N":
+		return (len(strings.TrimLeft(strings.Replace(me.s, ".", "", 1), "-0"))+1)/2 + 1
+	case "B":
+		return len(me.b)
+	case "BOOL", "NULL":
+		return 1
+	case "SS", "NS":
+		n := 0
+		for _, s := range me.ss {
+			n += len(s)
+		}
+		return n
+	case "BS":
+		n := 0
+		for _, b := range me.bs {
+			n += len(b)
+		}
+		return n
+	case "L":
+		n := 3
+		for _, v := range me.l {
+			n += v.size() + 1
+		}
+		return n
+	case "M":
+		n := 3
+		for k, v := range me.m {
+			n += len(k) + v.size() + 1
+		}
+		return n
+	}
+	return 0
+}
+
+func (me item) size() int {
+	n := 0
+	for k, v := range me {
+		n += len(k) + v.size()
+	}
+	return n
+}
+
+// count is the value of size() in expressions.
+func (me *attrValue) count() (int, bool) {
+	switch me.kind {
+	case "S":
+		return len(me.s), true
+	case "B":
+		return len(me.b), true
+	case "SS", "NS":
+		return len(me.ss), true
+	case "BS":
+		return len(me.bs), true
+	case "L":
+		return len(me.l), true
+	case "M":
+		return len(me.m), true
+	}
+	return 0, false
+}
+
+// compare orders two scalar values of the same type. ok is false when they are not comparable.
+func compareValues(a, b *attrValue) (int, bool) {
+	if a == nil || b == nil || a.kind != b.kind {
+		return 0, false
+	}
+	switch a.kind {
+	case "S":
+		return strings.Compare(a.s, b.s), true
+	case "N":
+		ra, _ := parseNumber(a.s)
+		rb, _ := parseNumber(b.s)
+		return ra.Cmp(rb), true
+	case "B":
+		return bytes.Compare(a.b, b.b), true
+	}
+	return 0, false
+}
+
+func equalValues(a, b *attrValue) bool {
+	if a == nil || b == nil || a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case "S", "N", "B":
+		c, _ := compareValues(a, b)
+		return c == 0
+	case "BOOL", "NULL":
+		return a.bl == b.bl
+	case "SS", "NS":
+		return equalStringSets(a.ss, b.ss)
+	case "BS":
+		as, bs := make([]string, len(a.bs)), make([]string, len(b.bs))
+		for i := range a.bs {
+			as[i] = string(a.bs[i])
+		}
+		for i := range b.bs {
+			bs[i] = string(b.bs[i])
+		}
+		return equalStringSets(as, bs)
+	case "L":
+		if len(a.l) != len(b.l) {
+			return false
+		}
+		for i := range a.l {
+			if !equalValues(a.l[i], b.l[i]) {
+				return false
+			}
+		}
+		return true
+	case "M":
+		if len(a.m) != len(b.m) {
+			return false
+		}
+		for k, v := range a.m {
+			if !equalValues(v, b.m[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func equalStringSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyString encodes a key attribute so it can be used as a map key and sorted.
+func keyString(v *attrValue) string {
+	if v == nil {
+		return ""
+	}
+	if v.kind == "B" {
+		return "B:" + base64.StdEncoding.EncodeToString(v.b)
+	}
+	return v.kind + ":" + v.s
+}
+
+func parseNumber(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, errors.Errorf("A value provided cannot be converted into a number")
+	}
+	return r, nil
+}
+
+func normalizeNumber(s string) (string, error) {
+	r, err := parseNumber(s)
+	if err != nil {
+		return "", err
+	}
+	return formatNumber(r), nil
+}
+
+var ten = big.NewInt(10)
+
+// formatNumber prints a rational that came from decimal input as the shortest exact decimal.
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	pow := big.NewInt(1)
+	mod := new(big.Int)
+	for digits := 1; digits <= 200; digits++ {
+		pow.Mul(pow, ten)
+		if mod.Mod(pow, r.Denom()).Sign() == 0 {
+			return r.FloatString(digits)
+		}
+	}
+	return strings.TrimRight(strings.TrimRight(r.FloatString(38), "0"), ".")
+}
+
+func sortedNames(it item) []string {
+	names := make([]string, 0, len(it))
+	for k := range it {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,409 @@
+package dynamodb
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/aws/smithy-go/ptr"
+)
+
+// FaultRule injects an error or latency into matching calls. Every attempt of a call is
+// a call of its own, since faults are injected after the retry middleware, so the
+// sdk retries injected throttling like it would real throttling.
+type FaultRule struct {
+	// Operations and Tables restrict the rule, e.g. "PutItem" and "orders". Empty
+	// matches every operation or table.
+	Operations []string
+	Tables     []string
+
+	// Err is returned instead of calling the service, nil only adds Latency.
+	Err error
+	// Probability of injecting into a matching call, 0 means always.
+	Probability float64
+	// FirstN only injects into the first N matching calls, 0 means all of them.
+	FirstN int
+	// Latency delays matching calls, also the ones that do not fail.
+	Latency time.Duration
+
+	calls int
+}
+
+// InjectedFault is an error or delay a Faults injected.
+type InjectedFault struct {
+	Operation string
+	Tables    []string
+	Err       error
+	Latency   time.Duration
+}
+
+// Faults injects errors and latency into clients, to exercise retry and backoff paths
+// that dynamodb-local never takes. Next to its rules it can model the provisioned
+// capacity of tables, throttling calls once a table consumed its read or write units of
+// the current second.
+type Faults struct {
+	mu       sync.Mutex
+	rules    []*FaultRule
+	capacity map[string]*capacityWindow
+	rand     *rand.Rand
+	injected []*InjectedFault
+}
+
+type capacityWindow struct {
+	read, write          float64
+	start                time.Time
+	consumedR, consumedW float64
+}
+
+// NewFaults returns a fault injector with the rules. Probabilities use a fixed seed, so
+// runs are reproducible.
+func NewFaults(rules ...*FaultRule) *Faults {
+	return &Faults{rules: rules, capacity: map[string]*capacityWindow{}, rand: rand.New(rand.NewSource(1))}
+}
+
+// Seed reseeds the random source of probabilities.
+func (me *Faults) Seed(seed int64) *Faults {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.rand = rand.New(rand.NewSource(seed))
+	return me
+}
+
+// Add adds rules after the existing ones. The first matching rule with an error wins.
+func (me *Faults) Add(rules ...*FaultRule) *Faults {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.rules = append(me.rules, rules...)
+	return me
+}
+
+// Capacity throttles calls to table with a ProvisionedThroughputExceededException once
+// it consumed rcu read or wcu write units in the current second. Units are estimated
+// from item sizes like DynamoDB bills them, 0 leaves that side unlimited.
+func (me *Faults) Capacity(table string, rcu, wcu float64) *Faults {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.capacity[table] = &capacityWindow{read: rcu, write: wcu}
+	return me
+}
+
+// Injected returns the faults injected so far.
+func (me *Faults) Injected() []*InjectedFault {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]*InjectedFault{}, me.injected...)
+}
+
+// APIOption adds the injector to a stack, e.g. appended to aws.Config.APIOptions.
+func (me *Faults) APIOption() func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("testrc.faults.capacity", me.charge), middleware.Before); err != nil {
+			return err
+		}
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("testrc.faults", me.inject), middleware.After)
+	}
+}
+
+// ClientOption adds the injector to a client, e.g. img.NewClient(faults.ClientOption()).
+func (me *Faults) ClientOption() func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, me.APIOption())
+	}
+}
+
+// ProvisionedThroughputExceeded is the error DynamoDB returns when a table or index is
+// over its provisioned capacity.
+func ProvisionedThroughputExceeded() error {
+	return &types.ProvisionedThroughputExceededException{Message: ptr.String("The level of configured provisioned throughput for the table was exceeded. (injected)")}
+}
+
+// ThrottlingError is the error DynamoDB returns when control plane or account limits are
+// exceeded.
+func ThrottlingError() error {
+	return &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate of requests exceeds the allowed throughput. (injected)", Fault: smithy.FaultServer}
+}
+
+// TransactionConflict is the error of a single item call that conflicts with an ongoing
+// transaction.
+func TransactionConflict() error {
+	return &types.TransactionConflictException{Message: ptr.String("Transaction is ongoing for the item. (injected)")}
+}
+
+// TransactionCanceled is the error of a transaction with one cancellation reason per
+// item, e.g. TransactionCanceled("None", "TransactionConflict").
+func TransactionCanceled(codes ...string) error {
+	reasons := make([]types.CancellationReason, len(codes))
+	for i, c := range codes {
+		reasons[i] = types.CancellationReason{Code: ptr.String(c)}
+	}
+	return &types.TransactionCanceledException{Message: ptr.String("Transaction cancelled. (injected)"), CancellationReasons: reasons}
+}
+
+// InternalServerError is the error of a failure inside DynamoDB.
+func InternalServerError() error {
+	return &types.InternalServerError{Message: ptr.String("Internal server error. (injected)")}
+}
+
+type faultTablesKey struct{}
+
+func (me *Faults) inject(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	op := awsmiddleware.GetOperationName(ctx)
+	tables, _ := middleware.GetStackValue(ctx, faultTablesKey{}).([]string)
+
+	fault := me.fault(op, tables)
+	if fault == nil {
+		return next.HandleFinalize(ctx, in)
+	}
+	if fault.Latency > 0 {
+		t := time.NewTimer(fault.Latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, ctx.Err()
+		case <-t.C:
+		}
+	}
+	if fault.Err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fault.Err
+	}
+	return next.HandleFinalize(ctx, in)
+}
+
+func (me *Faults) fault(op string, tables []string) *InjectedFault {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	fault := &InjectedFault{Operation: op, Tables: tables}
+	for _, r := range me.rules {
+		if !matchesAny(r.Operations, op) || !matchesAnyOf(r.Tables, tables) {
+			continue
+		}
+		r.calls++
+		if r.FirstN > 0 && r.calls > r.FirstN {
+			continue
+		}
+		if r.Probability > 0 && me.rand.Float64() >= r.Probability {
+			continue
+		}
+		fault.Latency += r.Latency
+		if r.Err != nil {
+			fault.Err = r.Err
+			break
+		}
+	}
+
+	if fault.Err == nil {
+		now := time.Now()
+		for _, t := range tables {
+			if w := me.capacity[t]; w != nil && w.exceeded(op, now) {
+				fault.Err = ProvisionedThroughputExceeded()
+				break
+			}
+		}
+	}
+
+	if fault.Err == nil && fault.Latency == 0 {
+		return nil
+	}
+	me.injected = append(me.injected, fault)
+	return fault
+}
+
+func matchesAny(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyOf(list []string, ss []string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range ss {
+		if matchesAny(list, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var writeOperations = map[string]bool{"PutItem": true, "UpdateItem": true, "DeleteItem": true, "BatchWriteItem": true, "TransactWriteItems": true}
+
+func (me *capacityWindow) roll(now time.Time) {
+	if now.Sub(me.start) >= time.Second {
+		me.start, me.consumedR, me.consumedW = now, 0, 0
+	}
+}
+
+func (me *capacityWindow) exceeded(op string, now time.Time) bool {
+	me.roll(now)
+	if writeOperations[op] {
+		return me.write > 0 && me.consumedW >= me.write
+	}
+	return me.read > 0 && me.consumedR >= me.read
+}
+
+// charge collects the tables of a call for inject and, once it succeeded, bills its
+// capacity to them. Like Usage it asks for the consumed capacity of every call and bills
+// what the service reports, estimating only when it reports nothing.
+func (me *Faults) charge(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	ctx = middleware.WithStackValue(ctx, faultTablesKey{}, tablesOf(in.Parameters))
+
+	var asked bool
+	in.Parameters, asked = askConsumedCapacity(in.Parameters)
+
+	out, md, err := next.HandleInitialize(ctx, in)
+	if err != nil {
+		return out, md, err
+	}
+
+	var read, write map[string]float64
+	if caps := consumedCapacityOf(out.Result, !asked); len(caps) > 0 {
+		op := awsmiddleware.GetOperationName(ctx)
+		read, write = map[string]float64{}, map[string]float64{}
+		for _, cc := range caps {
+			r, w := capacityUnits(op, cc)
+			read[ptr.ToString(cc.TableName)] += r
+			write[ptr.ToString(cc.TableName)] += w
+		}
+	} else {
+		read, write = consumedUnits(in.Parameters, out.Result)
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	now := time.Now()
+	for t, w := range me.capacity {
+		w.roll(now)
+		w.consumedR += read[t]
+		w.consumedW += write[t]
+	}
+	return out, md, err
+}
+
+// tablesOf lists the tables an operation input refers to, in order.
+func tablesOf(params any) []string {
+	tables := []string{}
+	seen := map[string]bool{}
+	visitTableNames(reflect.ValueOf(params), func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			tables = append(tables, s)
+		}
+	})
+	return tables
+}
+
+// scanned scales the size of the items a query or scan returned to the items it read
+// before filtering.
+func scanned(size int, count, scannedCount int32) int {
+	if count == 0 || scannedCount <= count {
+		return size
+	}
+	return int(int64(size) * int64(scannedCount) / int64(count))
+}
+
+func sdkItemSize(av map[string]types.AttributeValue) int {
+	it, err := itemFromSDK(av)
+	if err != nil {
+		return 0
+	}
+	return sizeOf(it)
+}
+
+// consumedUnits estimates the read and write units of a call per table from the items it
+// sent and got back. The figures are a lower bound: updates and deletes without return
+// values are billed by their key, and a query or scan without items by nothing.
+func consumedUnits(params, result any) (read, write map[string]float64) {
+	read, write = map[string]float64{}, map[string]float64{}
+	items := func(list []map[string]types.AttributeValue) int {
+		n := 0
+		for _, it := range list {
+			n += sdkItemSize(it)
+		}
+		return n
+	}
+
+	switch in := params.(type) {
+	case *dynamodb.GetItemInput:
+		if out, ok := result.(*dynamodb.GetItemOutput); ok {
+			read[ptr.ToString(in.TableName)] += readUnits(sdkItemSize(out.Item), ptr.ToBool(in.ConsistentRead))
+		}
+	case *dynamodb.QueryInput:
+		if out, ok := result.(*dynamodb.QueryOutput); ok {
+			read[ptr.ToString(in.TableName)] += readUnits(scanned(items(out.Items), out.Count, out.ScannedCount), ptr.ToBool(in.ConsistentRead))
+		}
+	case *dynamodb.ScanInput:
+		if out, ok := result.(*dynamodb.ScanOutput); ok {
+			read[ptr.ToString(in.TableName)] += readUnits(scanned(items(out.Items), out.Count, out.ScannedCount), ptr.ToBool(in.ConsistentRead))
+		}
+	case *dynamodb.BatchGetItemInput:
+		if out, ok := result.(*dynamodb.BatchGetItemOutput); ok {
+			for t, list := range out.Responses {
+				for _, it := range list {
+					read[t] += readUnits(sdkItemSize(it), ptr.ToBool(in.RequestItems[t].ConsistentRead))
+				}
+			}
+		}
+	case *dynamodb.TransactGetItemsInput:
+		if out, ok := result.(*dynamodb.TransactGetItemsOutput); ok {
+			for i, r := range out.Responses {
+				if i < len(in.TransactItems) && in.TransactItems[i].Get != nil {
+					read[ptr.ToString(in.TransactItems[i].Get.TableName)] += 2 * readUnits(sdkItemSize(r.Item), true)
+				}
+			}
+		}
+	case *dynamodb.PutItemInput:
+		write[ptr.ToString(in.TableName)] += writeUnits(sdkItemSize(in.Item))
+	case *dynamodb.UpdateItemInput:
+		size := sdkItemSize(in.Key)
+		if out, ok := result.(*dynamodb.UpdateItemOutput); ok {
+			size = max(size, sdkItemSize(out.Attributes))
+		}
+		write[ptr.ToString(in.TableName)] += writeUnits(size)
+	case *dynamodb.DeleteItemInput:
+		size := sdkItemSize(in.Key)
+		if out, ok := result.(*dynamodb.DeleteItemOutput); ok {
+			size = max(size, sdkItemSize(out.Attributes))
+		}
+		write[ptr.ToString(in.TableName)] += writeUnits(size)
+	case *dynamodb.BatchWriteItemInput:
+		for t, reqs := range in.RequestItems {
+			for _, r := range reqs {
+				switch {
+				case r.PutRequest != nil:
+					write[t] += writeUnits(sdkItemSize(r.PutRequest.Item))
+				case r.DeleteRequest != nil:
+					write[t] += writeUnits(sdkItemSize(r.DeleteRequest.Key))
+				}
+			}
+		}
+	case *dynamodb.TransactWriteItemsInput:
+		for _, ti := range in.TransactItems {
+			switch {
+			case ti.Put != nil:
+				write[ptr.ToString(ti.Put.TableName)] += 2 * writeUnits(sdkItemSize(ti.Put.Item))
+			case ti.Update != nil:
+				write[ptr.ToString(ti.Update.TableName)] += 2 * writeUnits(sdkItemSize(ti.Update.Key))
+			case ti.Delete != nil:
+				write[ptr.ToString(ti.Delete.TableName)] += 2 * writeUnits(sdkItemSize(ti.Delete.Key))
+			case ti.ConditionCheck != nil:
+				read[ptr.ToString(ti.ConditionCheck.TableName)] += 2 * readUnits(sdkItemSize(ti.ConditionCheck.Key), true)
+			}
+		}
+	}
+	return read, write
+}
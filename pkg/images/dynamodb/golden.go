@@ -0,0 +1,349 @@
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// UpdateGoldenEnv rewrites golden files instead of comparing against them when set to a
+// true value. A boolean -update flag defined by the test binary does the same.
+const UpdateGoldenEnv = "TESTRC_UPDATE_GOLDEN"
+
+// GoldenIgnored replaces the value of ignored attributes in golden files. The attribute
+// still has to exist, only its value is not compared.
+const GoldenIgnored = "<ignored>"
+
+// GoldenOption configures AssertTableMatchesGolden.
+type GoldenOption func(*goldenConfig)
+
+type goldenConfig struct {
+	ignore []func(path string, value any) bool
+}
+
+// IgnoreAttributes ignores the values of attributes by path. Nested map attributes and
+// list elements are separated by dots and * matches any single segment, so "created",
+// "meta.*" and "lines.*.id" all work.
+func IgnoreAttributes(paths ...string) GoldenOption {
+	return func(c *goldenConfig) {
+		c.ignore = append(c.ignore, func(p string, _ any) bool {
+			for _, pattern := range paths {
+				if matchAttributePath(pattern, p) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+}
+
+// IgnoreAttributeFunc ignores every attribute the function returns true for. value is
+// the DynamoDB JSON of the attribute decoded into plain go values, e.g.
+// map[string]any{"S": "abc"}.
+func IgnoreAttributeFunc(fn func(path string, value any) bool) GoldenOption {
+	return func(c *goldenConfig) {
+		c.ignore = append(c.ignore, fn)
+	}
+}
+
+func matchAttributePath(pattern, p string) bool {
+	ps, ss := strings.Split(pattern, "."), strings.Split(p, ".")
+	if len(ps) != len(ss) {
+		return false
+	}
+	for i := range ps {
+		if ps[i] != "*" && ps[i] != ss[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func updateGolden() bool {
+	if v, err := strconv.ParseBool(os.Getenv(UpdateGoldenEnv)); err == nil && v {
+		return true
+	}
+	if f := flag.Lookup("update"); f != nil {
+		if v, err := strconv.ParseBool(f.Value.String()); err == nil && v {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertTableMatchesGolden scans the table and compares its items with the golden file
+// at path. Items are stored as DynamoDB JSON with sorted attributes and sets, ordered by
+// key, so the file diffs well in review. On mismatch the test fails with a per-item,
+// per-attribute diff.
+func AssertTableMatchesGolden(t testing.TB, cli *dynamodb.Client, table string, path string, opts ...GoldenOption) bool {
+	t.Helper()
+
+	cfg := &goldenConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	got, err := goldenItems(context.Background(), cli, table, cfg)
+	if err != nil {
+		t.Fatalf("dynamodb: reading %s for golden comparison: %s", table, err)
+		return false
+	}
+
+	if updateGolden() {
+		if err := writeGolden(path, got); err != nil {
+			t.Fatalf("dynamodb: updating golden %s: %s", path, err)
+			return false
+		}
+		t.Logf("dynamodb: updated golden %s with %d items from %s", path, len(got.items), table)
+		return true
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("dynamodb: reading golden %s (set %s=1 or run with -update to create it): %s", path, UpdateGoldenEnv, err)
+		return false
+	}
+	want, err := parseGolden(b, got.keys)
+	if err != nil {
+		t.Fatalf("dynamodb: parsing golden %s: %s", path, err)
+		return false
+	}
+
+	if diff := diffGolden(want, got.items); diff != "" {
+		t.Errorf("dynamodb: table %s does not match golden %s (set %s=1 or run with -update to rewrite it):\n%s", table, path, UpdateGoldenEnv, diff)
+		return false
+	}
+	return true
+}
+
+type goldenItem struct {
+	key   string
+	attrs map[string]any
+}
+
+type goldenSet struct {
+	keys  []string
+	items []*goldenItem
+}
+
+func goldenItems(ctx context.Context, cli *dynamodb.Client, table string, cfg *goldenConfig) (*goldenSet, error) {
+	keys, err := keyNames(ctx, cli, table)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &goldenSet{keys: keys}
+	pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{TableName: ptr.String(table), ConsistentRead: ptr.Bool(true)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning %s", table)
+		}
+		for _, av := range page.Items {
+			it, err := normalizeGoldenItem(av, cfg)
+			if err != nil {
+				return nil, err
+			}
+			set.items = append(set.items, &goldenItem{key: goldenKey(it, keys), attrs: it})
+		}
+	}
+
+	sort.SliceStable(set.items, func(i, j int) bool {
+		if set.items[i].key != set.items[j].key {
+			return set.items[i].key < set.items[j].key
+		}
+		return canonicalJSON(set.items[i].attrs) < canonicalJSON(set.items[j].attrs)
+	})
+	return set, nil
+}
+
+// normalizeGoldenItem turns an item into plain go values with sorted sets and ignored
+// values masked.
+func normalizeGoldenItem(av map[string]types.AttributeValue, cfg *goldenConfig) (map[string]any, error) {
+	b, err := MarshalItemJSON(av)
+	if err != nil {
+		return nil, err
+	}
+	it, err := decodeGoldenJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	m := it.(map[string]any)
+	for name, v := range m {
+		m[name] = normalizeGoldenValue(name, v, cfg)
+	}
+	return m, nil
+}
+
+func normalizeGoldenValue(p string, v any, cfg *goldenConfig) any {
+	for _, ignore := range cfg.ignore {
+		if ignore(p, v) {
+			return GoldenIgnored
+		}
+	}
+
+	typed, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	for kind, inner := range typed {
+		switch kind {
+		case "SS", "NS", "BS":
+			list, _ := inner.([]any)
+			sort.Slice(list, func(i, j int) bool { return fmt.Sprint(list[i]) < fmt.Sprint(list[j]) })
+		case "L":
+			list, _ := inner.([]any)
+			for i, e := range list {
+				list[i] = normalizeGoldenValue(p+"."+strconv.Itoa(i), e, cfg)
+			}
+		case "M":
+			m, _ := inner.(map[string]any)
+			for k, e := range m {
+				m[k] = normalizeGoldenValue(p+"."+k, e, cfg)
+			}
+		}
+	}
+	return typed
+}
+
+func goldenKey(it map[string]any, keys []string) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+canonicalJSON(it[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func decodeGoldenJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// canonicalJSON is compact json with sorted map keys, which encoding/json already does.
+func canonicalJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func writeGolden(path string, set *goldenSet) error {
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i, it := range set.items {
+		buf.WriteString("  ")
+		buf.WriteString(canonicalJSON(it.attrs))
+		if i < len(set.items)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+func parseGolden(b []byte, keys []string) ([]*goldenItem, error) {
+	v, err := decodeGoldenJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.Errorf("expected a list of items, got %T", v)
+	}
+	items := make([]*goldenItem, 0, len(list))
+	for i, e := range list {
+		m, ok := e.(map[string]any)
+		if !ok {
+			return nil, errors.Errorf("item %d: expected an object, got %T", i, e)
+		}
+		items = append(items, &goldenItem{key: goldenKey(m, keys), attrs: m})
+	}
+	return items, nil
+}
+
+// diffGolden matches items by key, in order for items whose keys are the same after
+// masking, and describes the differences. It returns "" if there are none.
+func diffGolden(want, got []*goldenItem) string {
+	byKey := map[string][]*goldenItem{}
+	order := []string{}
+	for _, it := range got {
+		if _, ok := byKey[it.key]; !ok {
+			order = append(order, it.key)
+		}
+		byKey[it.key] = append(byKey[it.key], it)
+	}
+
+	var out strings.Builder
+	for _, w := range want {
+		candidates := byKey[w.key]
+		if len(candidates) == 0 {
+			fmt.Fprintf(&out, "- item %s: missing from table\n", w.key)
+			continue
+		}
+		g := candidates[0]
+		byKey[w.key] = candidates[1:]
+		if lines := diffGoldenAttrs(w.attrs, g.attrs); len(lines) > 0 {
+			fmt.Fprintf(&out, "~ item %s:\n", w.key)
+			for _, l := range lines {
+				fmt.Fprintf(&out, "    %s\n", l)
+			}
+		}
+	}
+	for _, k := range order {
+		for _, g := range byKey[k] {
+			fmt.Fprintf(&out, "+ item %s: not in golden\n    %s\n", g.key, canonicalJSON(g.attrs))
+		}
+	}
+	return out.String()
+}
+
+func diffGoldenAttrs(want, got map[string]any) []string {
+	names := map[string]bool{}
+	for k := range want {
+		names[k] = true
+	}
+	for k := range got {
+		names[k] = true
+	}
+
+	lines := []string{}
+	for _, name := range sortedKeysOf(names) {
+		w, inWant := want[name]
+		g, inGot := got[name]
+		switch {
+		case !inGot:
+			lines = append(lines, fmt.Sprintf("- %s: %s", name, canonicalJSON(w)))
+		case !inWant:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", name, canonicalJSON(g)))
+		case canonicalJSON(w) != canonicalJSON(g):
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", name, canonicalJSON(w), canonicalJSON(g)))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,237 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
+)
+
+// GroupByAttribute makes FprintScan print one table per value of attr, e.g. the type
+// attribute of a single table design, with only the attributes of that entity. Items
+// without attr are printed last.
+func GroupByAttribute(attr string) PrintOption {
+	return GroupBy(func(item map[string]types.AttributeValue, _ []string) string {
+		if v, ok := item[attr]; ok {
+			return fmt.Sprint(cellValue(v))
+		}
+		return ""
+	})
+}
+
+// GroupByKeyPrefix groups items by the prefixes of their key values up to sep, so with
+// "#" pk USER#1 and sk ORDER#7 are entity USER/ORDER. Key values without sep are used
+// whole, so constant sort keys like PROFILE name their entity too.
+func GroupByKeyPrefix(sep string) PrintOption {
+	return GroupBy(func(item map[string]types.AttributeValue, keys []string) string {
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			v, ok := item[k]
+			if !ok {
+				continue
+			}
+			s := fmt.Sprint(cellValue(v))
+			if prefix, _, found := strings.Cut(s, sep); found {
+				s = prefix
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, "/")
+	})
+}
+
+// GroupBy groups items by the entity fn names for each, given the key attribute names of
+// the table with the hash key first. An empty name groups the item with the ones that
+// match no entity.
+func GroupBy(fn func(item map[string]types.AttributeValue, keys []string) string) PrintOption {
+	return func(c *printConfig) { c.group = fn }
+}
+
+const ungroupedEntity = "(other)"
+
+type entityGroup struct {
+	name  string
+	items []map[string]types.AttributeValue
+}
+
+// groups splits items by entity, ordered by name with ungrouped items last.
+func (me *printConfig) groups(items []map[string]types.AttributeValue, keys []string) []*entityGroup {
+	byName := map[string]*entityGroup{}
+	for _, it := range items {
+		name := me.group(it, keys)
+		g, ok := byName[name]
+		if !ok {
+			g = &entityGroup{name: name}
+			byName[name] = g
+		}
+		g.items = append(g.items, it)
+	}
+
+	out := make([]*entityGroup, 0, len(byName))
+	for _, n := range sortedKeysOf(byName) {
+		if n != "" {
+			out = append(out, byName[n])
+		}
+	}
+	if g, ok := byName[""]; ok {
+		g.name = ungroupedEntity
+		out = append(out, g)
+	}
+	return out
+}
+
+// writeGroups writes one table per entity and the access patterns of the table.
+func (me *printConfig) writeGroups(ctx context.Context, cli *dynamodb.Client, w io.Writer, tbl string, items []map[string]types.AttributeValue, keys []string) error {
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(tbl)})
+	if err != nil {
+		return errors.Wrapf(err, "describing %s", tbl)
+	}
+
+	groups := me.groups(items, keys)
+	for i, g := range groups {
+		title := fmt.Sprintf("Table Data: %s / %s (%d items)", tbl, g.name, len(g.items))
+		if err := me.section(w, title, i == 0); err != nil {
+			return err
+		}
+		if err := me.writeEntity(w, title, g, keys); err != nil {
+			return err
+		}
+	}
+	if err := me.section(w, fmt.Sprintf("Access Patterns: %s", tbl), len(groups) == 0); err != nil {
+		return err
+	}
+	return me.writeAccessPatterns(w, tbl, desc.Table, groups)
+}
+
+// section separates the tables of a grouped scan in the formats that would otherwise run
+// them together: markdown drops titles and csv has no titles at all.
+func (me *printConfig) section(w io.Writer, title string, first bool) error {
+	var err error
+	switch me.format {
+	case FormatMarkdown:
+		if !first {
+			_, err = io.WriteString(w, "\n")
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(w, "### %s\n\n", title)
+		}
+	case FormatCSV:
+		if !first {
+			_, err = io.WriteString(w, "\n")
+		}
+	}
+	return err
+}
+
+// writeEntity writes the items of one entity. In jsonl and csv, which have no titles,
+// every row names its entity.
+func (me *printConfig) writeEntity(w io.Writer, title string, g *entityGroup, keys []string) error {
+	cols := me.itemColumns(g.items, keys)
+	switch me.format {
+	case FormatJSONL:
+		for _, item := range g.items {
+			if err := writeJSONLine(w, &entityItem{Kind: "item", Entity: g.name, Item: jsonRow(item, cols)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		rows := make([]table.Row, 0, len(g.items))
+		for _, item := range g.items {
+			rows = append(rows, append(table.Row{g.name}, tableRow(item, cols)...))
+		}
+		return me.render(w, title, append([]string{"entity"}, cols...), rows)
+	default:
+		return me.writeItems(w, title, g.items, keys)
+	}
+}
+
+// entityItem is an item line of a grouped jsonl scan. The lines of the access patterns
+// that follow have kind access_pattern.
+type entityItem struct {
+	Kind   string         `json:"kind"`
+	Entity string         `json:"entity"`
+	Item   map[string]any `json:"item"`
+}
+
+type accessPattern struct {
+	Kind       string   `json:"kind"`
+	Index      string   `json:"index"`
+	HashKey    string   `json:"hash_key"`
+	RangeKey   string   `json:"range_key,omitempty"`
+	Projection string   `json:"projection"`
+	Entities   []string `json:"entities"`
+}
+
+// writeAccessPatterns writes the key schema and projection of the table and its indexes,
+// with the entities that have items in each.
+func (me *printConfig) writeAccessPatterns(w io.Writer, tbl string, desc *types.TableDescription, groups []*entityGroup) error {
+	patterns := []*accessPattern{newAccessPattern("(table)", desc.KeySchema, &types.Projection{ProjectionType: types.ProjectionTypeAll}, groups)}
+	for _, idx := range desc.GlobalSecondaryIndexes {
+		patterns = append(patterns, newAccessPattern(ptr.ToString(idx.IndexName), idx.KeySchema, idx.Projection, groups))
+	}
+	for _, idx := range desc.LocalSecondaryIndexes {
+		patterns = append(patterns, newAccessPattern(ptr.ToString(idx.IndexName)+" (local)", idx.KeySchema, idx.Projection, groups))
+	}
+
+	if me.format == FormatJSONL {
+		for _, p := range patterns {
+			if err := writeJSONLine(w, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rows := make([]table.Row, 0, len(patterns))
+	for _, p := range patterns {
+		rows = append(rows, table.Row{p.Index, p.HashKey, p.RangeKey, p.Projection, strings.Join(p.Entities, ", ")})
+	}
+	return me.render(w, fmt.Sprintf("Access Patterns: %s", tbl), []string{"Index", "Hash Key", "Range Key", "Projection", "Entities"}, rows)
+}
+
+func newAccessPattern(name string, schema []types.KeySchemaElement, proj *types.Projection, groups []*entityGroup) *accessPattern {
+	p := &accessPattern{Kind: "access_pattern", Index: name, Entities: []string{}}
+	keys := []string{}
+	for _, k := range schema {
+		if k.KeyType == types.KeyTypeHash {
+			p.HashKey = ptr.ToString(k.AttributeName)
+		} else {
+			p.RangeKey = ptr.ToString(k.AttributeName)
+		}
+		keys = append(keys, ptr.ToString(k.AttributeName))
+	}
+
+	if proj != nil {
+		p.Projection = string(proj.ProjectionType)
+		if len(proj.NonKeyAttributes) > 0 {
+			p.Projection += ": " + strings.Join(proj.NonKeyAttributes, ", ")
+		}
+	}
+
+	for _, g := range groups {
+		n := 0
+		for _, it := range g.items {
+			indexed := true
+			for _, k := range keys {
+				if _, ok := it[k]; !ok {
+					indexed = false
+					break
+				}
+			}
+			if indexed {
+				n++
+			}
+		}
+		if n > 0 {
+			p.Entities = append(p.Entities, fmt.Sprintf("%s (%d)", g.name, n))
+		}
+	}
+	return p
+}
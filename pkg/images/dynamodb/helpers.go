@@ -2,162 +2,466 @@ package dynamodb
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
-func (me *DockerImage) PrintScanAsTable(ctx context.Context, tbl string) {
-	zerolog.Ctx(ctx).Info().Msg("Scanning table " + tbl)
+// Format is an output format of the table writers.
+type Format string
 
-	input := &dynamodb.ScanInput{TableName: aws.String(tbl)}
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatCSV      Format = "csv"
+	FormatJSONL    Format = "jsonl"
+	FormatHTML     Format = "html"
+)
 
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
+// Formats lists every supported format.
+var Formats = []Format{FormatText, FormatMarkdown, FormatCSV, FormatJSONL, FormatHTML}
 
-	header := map[string]int{}
-	rows := make([]map[string]interface{}, 0)
+// ParseFormat accepts a format name, case insensitive. "" is FormatText.
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return FormatText, nil
+	}
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	switch strings.ToLower(s) {
+	case "table":
+		return FormatText, nil
+	case "md":
+		return FormatMarkdown, nil
+	case "json", "ndjson":
+		return FormatJSONL, nil
+	}
+	return "", errors.Errorf("unknown format %q, expected one of %v", s, Formats)
+}
 
-	cli, err := me.NewClient()
+// PrintOption configures the table writers.
+type PrintOption func(*printConfig)
+
+type printConfig struct {
+	format    Format
+	include   []string
+	exclude   map[string]bool
+	sortByKey bool
+	limit     int
+	filter    string
+	names     map[string]string
+	values    map[string]types.AttributeValue
+	group     func(map[string]types.AttributeValue, []string) string
+}
+
+func newPrintConfig(opts []PrintOption) *printConfig {
+	cfg := &printConfig{format: FormatText, exclude: map[string]bool{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithFormat sets the output format, FormatText by default.
+func WithFormat(f Format) PrintOption {
+	return func(c *printConfig) { c.format = f }
+}
+
+// WithAttributes only shows these attributes, in this order.
+func WithAttributes(names ...string) PrintOption {
+	return func(c *printConfig) { c.include = append(c.include, names...) }
+}
+
+// WithoutAttributes hides these attributes.
+func WithoutAttributes(names ...string) PrintOption {
+	return func(c *printConfig) {
+		for _, n := range names {
+			c.exclude[n] = true
+		}
+	}
+}
+
+// SortByKey orders rows by the table's hash and range key and shows the key columns first.
+func SortByKey() PrintOption {
+	return func(c *printConfig) { c.sortByKey = true }
+}
+
+// WithLimit stops after n items, 0 means all of them.
+func WithLimit(n int) PrintOption {
+	return func(c *printConfig) { c.limit = n }
+}
+
+// WithFilter only reads items matching a scan filter expression, e.g.
+// WithFilter("#s = :s", map[string]string{"#s": "status"}, map[string]types.AttributeValue{":s": ...}).
+func WithFilter(expr string, names map[string]string, values map[string]types.AttributeValue) PrintOption {
+	return func(c *printConfig) {
+		c.filter = expr
+		c.names = names
+		c.values = values
+	}
+}
+
+// scan reads the items selected by the config, together with the table's key names.
+func (me *printConfig) scan(ctx context.Context, cli *dynamodb.Client, tbl string) ([]map[string]types.AttributeValue, []string, error) {
+	zerolog.Ctx(ctx).Info().Msg("Scanning table " + tbl)
+
+	keys, err := keyNames(ctx, cli, tbl)
 	if err != nil {
-		fmt.Printf("failed to create client: %v", err)
-		return
+		return nil, nil, err
+	}
+
+	input := &dynamodb.ScanInput{TableName: aws.String(tbl)}
+	if me.filter != "" {
+		input.FilterExpression = aws.String(me.filter)
+		input.ExpressionAttributeNames = me.names
+		input.ExpressionAttributeValues = me.values
 	}
 
+	items := []map[string]types.AttributeValue{}
 	paginator := dynamodb.NewScanPaginator(cli, input)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			fmt.Printf("failed to get page: %v", err)
-			return
+			return nil, nil, errors.Wrapf(err, "scanning %s", tbl)
 		}
+		for _, item := range page.Items {
+			if me.limit > 0 && len(items) == me.limit && !me.sortByKey {
+				return items, keys, nil
+			}
+			items = append(items, item)
+		}
+	}
 
-		if len(page.Items) == 0 {
-			continue
+	if me.sortByKey {
+		sortItemsByKey(items, keys)
+	}
+	if me.limit > 0 && len(items) > me.limit {
+		items = items[:me.limit]
+	}
+	return items, keys, nil
+}
+
+func sortItemsByKey(items []map[string]types.AttributeValue, keys []string) {
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, k := range keys {
+			a, _ := attrFromSDK(items[i][k])
+			b, _ := attrFromSDK(items[j][k])
+			if c, ok := compareValues(a, b); ok && c != 0 {
+				return c < 0
+			}
 		}
+		return false
+	})
+}
 
-		for _, item := range page.Items {
-			row := map[string]interface{}{}
-			for i, h := range item {
-				header[i]++
-				var marsh interface{}
-				err := attributevalue.Unmarshal(h, &marsh)
-				if err != nil {
-					row[i] = h
-				}
-				switch m := marsh.(type) {
-				case []byte:
-					row[i] = string(m)
-				case []interface{}:
-					rat := make([]string, 0)
-					for _, v := range m {
-						switch vv := v.(type) {
-						case float64:
-							rat = append(rat, fmt.Sprintf("%f", vv))
-						default:
-							rat = append(rat, fmt.Sprintf("%v", vv))
-						}
-					}
-					row[i] = rat
-				case float64:
-					row[i] = fmt.Sprintf("%f", m)
-				default:
-					row[i] = marsh
+// columns picks the header: the included attributes, or every attribute seen sorted by
+// name with the keys first when sorting by key.
+func (me *printConfig) columns(seen map[string]int, keys []string) []string {
+	var cols []string
+	if len(me.include) > 0 {
+		cols = me.include
+	} else {
+		first := map[string]bool{}
+		if me.sortByKey {
+			for _, k := range keys {
+				if seen[k] > 0 {
+					cols = append(cols, k)
+					first[k] = true
 				}
 			}
-			rows = append(rows, row)
+		}
+		for _, k := range sortedKeysOf(seen) {
+			if !first[k] {
+				cols = append(cols, k)
+			}
 		}
 	}
 
-	str := make([]string, 0)
-	for k := range header {
-		str = append(str, k)
+	out := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if !me.exclude[c] {
+			out = append(out, c)
+		}
 	}
+	return out
+}
 
-	sort.Strings(str)
+// FprintScan writes the items of the table to w. With GroupBy and friends it writes a
+// table per entity, followed by the access patterns of the table and its indexes.
+func FprintScan(ctx context.Context, cli *dynamodb.Client, w io.Writer, tbl string, opts ...PrintOption) error {
+	cfg := newPrintConfig(opts)
+	items, keys, err := cfg.scan(ctx, cli, tbl)
+	if err != nil {
+		return err
+	}
+	if cfg.group != nil {
+		return cfg.writeGroups(ctx, cli, w, tbl, items, keys)
+	}
+	return cfg.writeItems(w, fmt.Sprintf("Table Data: %s", tbl), items, keys)
+}
 
-	tmp := make([]interface{}, len(str))
-	for i, v := range str {
-		tmp[i] = v
+// FprintItems writes items that were already read, e.g. from a query, to w. keys are the
+// key attribute names used by SortByKey.
+func FprintItems(w io.Writer, title string, items []map[string]types.AttributeValue, keys []string, opts ...PrintOption) error {
+	cfg := newPrintConfig(opts)
+	if cfg.sortByKey {
+		items = append([]map[string]types.AttributeValue{}, items...)
+		sortItemsByKey(items, keys)
+	}
+	if cfg.limit > 0 && len(items) > cfg.limit {
+		items = items[:cfg.limit]
 	}
+	return cfg.writeItems(w, title, items, keys)
+}
 
-	t.AppendHeader(table.Row(tmp))
+func (me *printConfig) writeItems(w io.Writer, title string, items []map[string]types.AttributeValue, keys []string) error {
+	cols := me.itemColumns(items, keys)
 
-	for _, d := range rows {
-		row := make([]interface{}, len(str))
-		for i, v := range str {
-			row[i] = d[v]
+	if me.format == FormatJSONL {
+		for _, item := range items {
+			if err := writeJSONLine(w, jsonRow(item, cols)); err != nil {
+				return err
+			}
 		}
-		t.AppendRow(row)
+		return nil
 	}
 
-	t.SetTitle(fmt.Sprintf("Table Data: %s", tbl))
+	rows := make([]table.Row, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, tableRow(item, cols))
+	}
+	return me.render(w, title, cols, rows)
+}
 
-	t.Render()
+// itemColumns picks the header for the attributes the items have.
+func (me *printConfig) itemColumns(items []map[string]types.AttributeValue, keys []string) []string {
+	seen := map[string]int{}
+	for _, item := range items {
+		for k := range item {
+			seen[k]++
+		}
+	}
+	return me.columns(seen, keys)
 }
 
-func (me *DockerImage) PrintTableCounts(ctx context.Context, tbl string) {
-	zerolog.Ctx(ctx).Info().Msg("Scanning table " + tbl)
+func jsonRow(item map[string]types.AttributeValue, cols []string) map[string]any {
+	row := map[string]any{}
+	for _, c := range cols {
+		if v, ok := item[c]; ok {
+			row[c] = plainValue(v)
+		}
+	}
+	return row
+}
 
-	cli, err := me.NewClient()
-	if err != nil {
-		fmt.Printf("failed to create client: %v", err)
-		return
+func tableRow(item map[string]types.AttributeValue, cols []string) table.Row {
+	row := make(table.Row, len(cols))
+	for i, c := range cols {
+		if v, ok := item[c]; ok {
+			row[i] = cellValue(v)
+		}
 	}
+	return row
+}
 
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(tbl),
+// FprintCounts writes how many items have each attribute, and how many of those are NULL.
+func FprintCounts(ctx context.Context, cli *dynamodb.Client, w io.Writer, tbl string, opts ...PrintOption) error {
+	cfg := newPrintConfig(opts)
+	items, keys, err := cfg.scan(ctx, cli, tbl)
+	if err != nil {
+		return err
 	}
 
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
+	header := map[string]int{}
+	headerNull := map[string]int{}
+	for _, item := range items {
+		for k, v := range item {
+			header[k]++
+			if _, ok := v.(*types.AttributeValueMemberNULL); ok {
+				headerNull[k]++
+			}
+		}
+	}
 
-	header := make(map[string]int, 0)
-	headerNull := make(map[string]int, 0)
+	attrs := cfg.columns(header, keys)
 
-	paginator := dynamodb.NewScanPaginator(cli, input)
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			fmt.Printf("failed to get page: %v", err)
-			return
+	if cfg.format == FormatJSONL {
+		for _, k := range attrs {
+			if err := writeJSONLine(w, map[string]any{"attribute": k, "count": header[k], "null": headerNull[k]}); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		if len(page.Items) == 0 {
-			continue
-		}
+	rows := make([]table.Row, 0, len(attrs))
+	for _, k := range attrs {
+		rows = append(rows, table.Row{k, header[k], headerNull[k]})
+	}
+	return cfg.render(w, fmt.Sprintf("Table Counts: %s", tbl), []string{"Attribute", "Count", "Null Count"}, rows)
+}
 
-		for _, item := range page.Items {
-			for i, h := range item {
-				var marsh interface{}
-				err := attributevalue.Unmarshal(h, &marsh)
-				if err != nil {
-					headerNull[i]++
+func (me *printConfig) render(w io.Writer, title string, cols []string, rows []table.Row) error {
+	if me.format == FormatCSV {
+		// go-pretty escapes commas with backslashes, which spreadsheets do not read
+		cw := csv.NewWriter(w)
+		if err := cw.Write(cols); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			rec := make([]string, len(row))
+			for i, c := range row {
+				if c != nil {
+					rec[i] = fmt.Sprint(c)
 				}
-				header[i]++
 			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	t := table.NewWriter()
+
+	header := make(table.Row, len(cols))
+	for i, c := range cols {
+		header[i] = c
+	}
+	t.AppendHeader(header)
+	t.AppendRows(rows)
+
+	var out string
+	switch me.format {
+	case FormatText, "":
+		t.SetTitle(title)
+		out = t.Render()
+	case FormatMarkdown:
+		out = t.RenderMarkdown()
+	case FormatHTML:
+		out = t.RenderHTML()
+	default:
+		return errors.Errorf("unknown format %q", me.format)
+	}
 
+	_, err := io.WriteString(w, out+"\n")
+	return err
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// plainValue converts an attribute to plain json values. Numbers stay json.Number so
+// they keep their precision and binary values are base64, like encoding/json does.
+func plainValue(av types.AttributeValue) any {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return json.Number(v.Value)
+	case *types.AttributeValueMemberB:
+		return v.Value
+	case *types.AttributeValueMemberBOOL:
+		return v.Value
+	case *types.AttributeValueMemberNULL:
+		return nil
+	case *types.AttributeValueMemberSS:
+		return v.Value
+	case *types.AttributeValueMemberNS:
+		ns := make([]json.Number, len(v.Value))
+		for i, n := range v.Value {
+			ns[i] = json.Number(n)
 		}
+		return ns
+	case *types.AttributeValueMemberBS:
+		return v.Value
+	case *types.AttributeValueMemberL:
+		l := make([]any, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = plainValue(e)
+		}
+		return l
+	case *types.AttributeValueMemberM:
+		m := make(map[string]any, len(v.Value))
+		for k, e := range v.Value {
+			m[k] = plainValue(e)
+		}
+		return m
 	}
+	return nil
+}
 
-	t.SetTitle(fmt.Sprintf("Table Counts: %s", tbl))
+// cellValue renders an attribute for a table cell: scalars as themselves, binary as
+// base64, sets as lists and documents as json.
+func cellValue(av types.AttributeValue) any {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	case *types.AttributeValueMemberB:
+		return base64.StdEncoding.EncodeToString(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return v.Value
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberSS:
+		return v.Value
+	case *types.AttributeValueMemberNS:
+		return v.Value
+	}
+	b, err := json.Marshal(plainValue(av))
+	if err != nil {
+		return fmt.Sprintf("%v", av)
+	}
+	return string(b)
+}
 
-	t.AppendHeader(table.Row{"Attribute", "Count", "Null Count"})
+// FprintScanAsTable writes the items of the table to w.
+func (me *DockerImage) FprintScanAsTable(ctx context.Context, w io.Writer, tbl string, opts ...PrintOption) error {
+	cli, err := me.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	return FprintScan(ctx, cli, w, tbl, opts...)
+}
 
-	str := make([]string, 0)
-	for k := range header {
-		str = append(str, k)
+// FprintTableCounts writes the attribute counts of the table to w.
+func (me *DockerImage) FprintTableCounts(ctx context.Context, w io.Writer, tbl string, opts ...PrintOption) error {
+	cli, err := me.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
 	}
-	sort.Strings(str)
-	for _, k := range str {
-		t.AppendRow([]interface{}{k, header[k], headerNull[k]})
+	return FprintCounts(ctx, cli, w, tbl, opts...)
+}
+
+func (me *DockerImage) PrintScanAsTable(ctx context.Context, tbl string, opts ...PrintOption) {
+	if err := me.FprintScanAsTable(ctx, os.Stdout, tbl, opts...); err != nil {
+		fmt.Printf("failed to print table: %v", err)
 	}
+}
 
-	t.Render()
+func (me *DockerImage) PrintTableCounts(ctx context.Context, tbl string) {
+	if err := me.FprintTableCounts(ctx, os.Stdout, tbl); err != nil {
+		fmt.Printf("failed to print table counts: %v", err)
+	}
 }
@@ -0,0 +1,579 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// MigrationTable is the default table Migrate records applied migrations, transform
+// checkpoints and its lock in.
+var MigrationTable = "testrc-migrations"
+
+// MigrationLockTimeout is how long a lock is held without progress before another
+// Migrate may take it over, e.g. after a crash.
+var MigrationLockTimeout = 15 * time.Minute
+
+// TransformPageSize is how many items Transform reads per page, and so how often it
+// saves a checkpoint.
+var TransformPageSize int32 = 100
+
+// ErrMigrationLocked is returned when another Migrate holds the lock.
+var ErrMigrationLocked = errors.New("migrations are locked by another run")
+
+// MigrationFunc applies one migration. It should do its writes through run.Transform
+// or honour run.DryRun itself.
+type MigrationFunc func(ctx context.Context, run *MigrationRun) error
+
+// Migration is a registered migration. Migrations run ordered by ID, so IDs like
+// "0007-split-address" keep them in the order they were written.
+type Migration struct {
+	ID string
+	Up MigrationFunc
+}
+
+var migrations = struct {
+	sync.Mutex
+	list map[string]*Migration
+}{list: map[string]*Migration{}}
+
+// RegisterMigration adds a migration to the ones Migrate runs by default. Packages
+// holding migrations call it from init. Registering an ID twice panics.
+func RegisterMigration(id string, up MigrationFunc) {
+	migrations.Lock()
+	defer migrations.Unlock()
+	if _, ok := migrations.list[id]; ok {
+		panic(fmt.Sprintf("dynamodb: migration %s is registered twice", id))
+	}
+	migrations.list[id] = &Migration{ID: id, Up: up}
+}
+
+// MigrateOption configures Migrate.
+type MigrateOption func(*migrateConfig)
+
+type migrateConfig struct {
+	table      string
+	dryRun     bool
+	to         string
+	lock       time.Duration
+	migrations []*Migration
+}
+
+// WithMigrations runs these migrations instead of the registered ones.
+func WithMigrations(ms ...*Migration) MigrateOption {
+	return func(c *migrateConfig) { c.migrations = ms }
+}
+
+// WithMigrationTable records state in another table than MigrationTable.
+func WithMigrationTable(name string) MigrateOption {
+	return func(c *migrateConfig) { c.table = name }
+}
+
+// DryRun runs the migrations without writing anything: transforms only count what they
+// would change and nothing is recorded as applied.
+func DryRun() MigrateOption {
+	return func(c *migrateConfig) { c.dryRun = true }
+}
+
+// MigrateTo stops after the migration with this ID. Migrate fails if there is no
+// migration with this ID.
+func MigrateTo(id string) MigrateOption {
+	return func(c *migrateConfig) { c.to = id }
+}
+
+// MigrationReport lists what Migrate did.
+type MigrationReport struct {
+	DryRun  bool
+	Applied []*AppliedMigration
+	// Skipped are the migrations that were already applied before.
+	Skipped []string
+}
+
+// AppliedMigration is one migration applied by Migrate, or that would have been in a
+// dry run.
+type AppliedMigration struct {
+	ID         string
+	Elapsed    time.Duration
+	Transforms []*TransformResult
+}
+
+func (me *MigrationReport) String() string {
+	mode := ""
+	if me.DryRun {
+		mode = " (dry run)"
+	}
+	out := fmt.Sprintf("applied %d migrations%s, %d already applied", len(me.Applied), mode, len(me.Skipped))
+	for _, a := range me.Applied {
+		out += fmt.Sprintf("\n  %s in %s", a.ID, a.Elapsed.Round(time.Millisecond))
+		for _, t := range a.Transforms {
+			out += "\n    " + t.String()
+		}
+	}
+	return out
+}
+
+// Migrate applies the migrations that are not yet recorded as applied, in ID order.
+// A lock item in the metadata table, taken with a conditional write, keeps two runs
+// from migrating at once. A migration that fails is not recorded, so the next run
+// retries it, and its transforms continue from their last checkpoint.
+func Migrate(ctx context.Context, cli *dynamodb.Client, opts ...MigrateOption) (*MigrationReport, error) {
+	cfg := &migrateConfig{table: MigrationTable, lock: MigrationLockTimeout}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.migrations == nil {
+		migrations.Lock()
+		for _, m := range migrations.list {
+			cfg.migrations = append(cfg.migrations, m)
+		}
+		migrations.Unlock()
+	}
+	ms := append([]*Migration{}, cfg.migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	if cfg.to != "" {
+		found := false
+		for _, m := range ms {
+			found = found || m.ID == cfg.to
+		}
+		if !found {
+			return nil, errors.Errorf("migration %s is not registered", cfg.to)
+		}
+	}
+
+	meta := &migrationMeta{cli: cli, table: cfg.table, owner: newUUID(), lock: cfg.lock}
+	report := &MigrationReport{DryRun: cfg.dryRun}
+
+	exists, err := meta.exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.dryRun {
+		if !exists {
+			if err := meta.create(ctx); err != nil {
+				return nil, err
+			}
+			exists = true
+		}
+		if err := meta.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := meta.release(context.WithoutCancel(ctx)); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("releasing migration lock")
+			}
+		}()
+	}
+
+	for _, m := range ms {
+		applied := false
+		if exists {
+			if applied, err = meta.applied(ctx, m.ID); err != nil {
+				return report, err
+			}
+		}
+		if applied {
+			report.Skipped = append(report.Skipped, m.ID)
+		} else {
+			if !cfg.dryRun {
+				// transforms extend the lock as they go, other migrations only here
+				if err := meta.extend(ctx); err != nil {
+					return report, err
+				}
+			}
+			start := time.Now()
+			run := &MigrationRun{ID: m.ID, Client: cli, DryRun: cfg.dryRun, meta: meta, exists: exists}
+			if err := m.Up(ctx, run); err != nil {
+				return report, errors.Wrapf(err, "migration %s", m.ID)
+			}
+			done := &AppliedMigration{ID: m.ID, Elapsed: time.Since(start), Transforms: run.results}
+			if !cfg.dryRun {
+				if err := meta.markApplied(ctx, done); err != nil {
+					return report, err
+				}
+			}
+			report.Applied = append(report.Applied, done)
+			zerolog.Ctx(ctx).Info().Str("migration", m.ID).Bool("dry_run", cfg.dryRun).Dur("elapsed", done.Elapsed).Msg("applied migration")
+		}
+		if m.ID == cfg.to {
+			break
+		}
+	}
+
+	return report, nil
+}
+
+// MigrationRun is handed to a running migration.
+type MigrationRun struct {
+	ID     string
+	Client *dynamodb.Client
+	DryRun bool
+
+	meta    *migrationMeta
+	exists  bool
+	results []*TransformResult
+}
+
+// TransformFunc changes one item. It returns the item to write, which may be the same
+// map changed in place, or nil to delete the item. Returning an item with another key
+// writes it and deletes the old one.
+type TransformFunc func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error)
+
+// TransformResult counts what a transform did.
+type TransformResult struct {
+	Table     string
+	Scanned   int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	// Resumed is set when the transform continued from a checkpoint.
+	Resumed bool
+}
+
+func (me *TransformResult) String() string {
+	resumed := ""
+	if me.Resumed {
+		resumed = ", resumed"
+	}
+	return fmt.Sprintf("%s: scanned %d, updated %d, deleted %d, unchanged %d%s", me.Table, me.Scanned, me.Updated, me.Deleted, me.Unchanged, resumed)
+}
+
+// Transform scans the table and applies fn to every item. Progress is checkpointed in
+// the metadata table after every page, so a migration that failed halfway continues
+// where it stopped; fn must give the same result when applied to an item twice, since
+// the last page may be seen again. Items fn leaves unchanged are not written.
+func (me *MigrationRun) Transform(ctx context.Context, table string, fn TransformFunc) (*TransformResult, error) {
+	checkpoint := fmt.Sprintf("checkpoint#%s#%d", me.ID, len(me.results))
+	res := &TransformResult{Table: table}
+	me.results = append(me.results, res)
+
+	keys, err := keyNames(ctx, me.Client, table)
+	if err != nil {
+		return res, err
+	}
+
+	var start map[string]types.AttributeValue
+	if me.exists {
+		cp, err := me.meta.get(ctx, checkpoint)
+		if err != nil {
+			return res, err
+		}
+		if cp != nil {
+			if err := cp.restore(res, &start); err != nil {
+				return res, errors.Wrapf(err, "reading checkpoint %s", checkpoint)
+			}
+			res.Resumed = true
+			if cp.done {
+				return res, nil
+			}
+		}
+	}
+
+	for {
+		page, err := me.Client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         ptr.String(table),
+			ExclusiveStartKey: start,
+			ConsistentRead:    ptr.Bool(true),
+			Limit:             ptr.Int32(TransformPageSize),
+		})
+		if err != nil {
+			return res, errors.Wrapf(err, "scanning %s", table)
+		}
+
+		reqs := []types.WriteRequest{}
+		for _, it := range page.Items {
+			res.Scanned++
+			before, err := itemKey(it, keys)
+			if err != nil {
+				return res, err
+			}
+			orig, key := diffJSON(it), keyOf(it, keys)
+			out, err := fn(it)
+			if err != nil {
+				return res, errors.Wrapf(err, "transforming %s item %s", table, diffKey(key, keys))
+			}
+			if out == nil {
+				res.Deleted++
+				reqs = append(reqs, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}})
+				continue
+			}
+			if diffJSON(out) == orig {
+				res.Unchanged++
+				continue
+			}
+			after, err := itemKey(out, keys)
+			if err != nil {
+				return res, errors.Wrapf(err, "transforming %s item %s", table, before)
+			}
+			res.Updated++
+			reqs = append(reqs, types.WriteRequest{PutRequest: &types.PutRequest{Item: out}})
+			if after != before {
+				reqs = append(reqs, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}})
+			}
+		}
+
+		if !me.DryRun {
+			for i := 0; i < len(reqs); i += seedBatchSize {
+				end := i + seedBatchSize
+				if end > len(reqs) {
+					end = len(reqs)
+				}
+				if err := writeBatch(ctx, me.Client, table, reqs[i:end]); err != nil {
+					return res, err
+				}
+			}
+		}
+
+		start = page.LastEvaluatedKey
+		if !me.DryRun {
+			if err := me.meta.saveCheckpoint(ctx, checkpoint, res, start); err != nil {
+				return res, err
+			}
+		}
+		if len(start) == 0 {
+			return res, nil
+		}
+	}
+}
+
+func keyOf(it map[string]types.AttributeValue, keys []string) map[string]types.AttributeValue {
+	out := make(map[string]types.AttributeValue, len(keys))
+	for _, k := range keys {
+		out[k] = it[k]
+	}
+	return out
+}
+
+// migrationMeta is the metadata table, keyed by a string id: "lock", "migration#<id>"
+// and "checkpoint#<id>#<n>".
+type migrationMeta struct {
+	cli   *dynamodb.Client
+	table string
+	owner string
+	lock  time.Duration
+}
+
+func (me *migrationMeta) exists(ctx context.Context) (bool, error) {
+	_, err := me.cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(me.table)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "describing %s", me.table)
+	}
+	return true, nil
+}
+
+func (me *migrationMeta) create(ctx context.Context) error {
+	_, _, err := Provision(ctx, me.cli, &TableDefinition{Input: &dynamodb.CreateTableInput{
+		TableName:            ptr.String(me.table),
+		BillingMode:          types.BillingModePayPerRequest,
+		KeySchema:            keySchema("id", ""),
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("id"), AttributeType: types.ScalarAttributeTypeS}},
+	}})
+	var inUse *types.ResourceInUseException
+	if errors.As(err, &inUse) {
+		// another run created it first
+		return nil
+	}
+	return err
+}
+
+func (me *migrationMeta) acquire(ctx context.Context) error {
+	now := time.Now()
+	_, err := me.cli.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: ptr.String(me.table),
+		Item: map[string]types.AttributeValue{
+			"id":       &types.AttributeValueMemberS{Value: "lock"},
+			"owner":    &types.AttributeValueMemberS{Value: me.owner},
+			"acquired": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			"expires":  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(me.lock).Unix(), 10)},
+		},
+		ConditionExpression:       ptr.String("attribute_not_exists(id) OR expires < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errors.Wrapf(ErrMigrationLocked, "lock in %s", me.table)
+	}
+	return errors.Wrap(err, "taking the migration lock")
+}
+
+// extend pushes the lock expiry out, failing if another run took the lock over.
+func (me *migrationMeta) extend(ctx context.Context) error {
+	_, err := me.cli.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           ptr.String(me.table),
+		Key:                 map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "lock"}},
+		UpdateExpression:    ptr.String("SET expires = :expires"),
+		ConditionExpression: ptr.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner":   &types.AttributeValueMemberS{Value: me.owner},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(me.lock).Unix(), 10)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errors.Wrap(ErrMigrationLocked, "lost the lock while migrating")
+	}
+	return err
+}
+
+func (me *migrationMeta) release(ctx context.Context) error {
+	_, err := me.cli.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 ptr.String(me.table),
+		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "lock"}},
+		ConditionExpression:       ptr.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: me.owner}},
+	})
+	return err
+}
+
+func (me *migrationMeta) get(ctx context.Context, id string) (*migrationCheckpoint, error) {
+	out, err := me.cli.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      ptr.String(me.table),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead: ptr.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", id)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return &migrationCheckpoint{item: out.Item}, nil
+}
+
+func (me *migrationMeta) applied(ctx context.Context, id string) (bool, error) {
+	it, err := me.get(ctx, "migration#"+id)
+	return it != nil, err
+}
+
+func (me *migrationMeta) markApplied(ctx context.Context, m *AppliedMigration) error {
+	_, err := me.cli.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: ptr.String(me.table),
+		Item: map[string]types.AttributeValue{
+			"id":         &types.AttributeValueMemberS{Value: "migration#" + m.ID},
+			"applied_at": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			"elapsed_ms": &types.AttributeValueMemberN{Value: strconv.FormatInt(m.Elapsed.Milliseconds(), 10)},
+			"owner":      &types.AttributeValueMemberS{Value: me.owner},
+		},
+		ConditionExpression: ptr.String("attribute_not_exists(id)"),
+	})
+	return errors.Wrapf(err, "recording migration %s", m.ID)
+}
+
+func (me *migrationMeta) saveCheckpoint(ctx context.Context, id string, res *TransformResult, cursor map[string]types.AttributeValue) error {
+	if err := me.extend(ctx); err != nil {
+		return err
+	}
+	item := map[string]types.AttributeValue{
+		"id":        &types.AttributeValueMemberS{Value: id},
+		"table":     &types.AttributeValueMemberS{Value: res.Table},
+		"done":      &types.AttributeValueMemberBOOL{Value: len(cursor) == 0},
+		"scanned":   &types.AttributeValueMemberN{Value: strconv.Itoa(res.Scanned)},
+		"updated":   &types.AttributeValueMemberN{Value: strconv.Itoa(res.Updated)},
+		"deleted":   &types.AttributeValueMemberN{Value: strconv.Itoa(res.Deleted)},
+		"unchanged": &types.AttributeValueMemberN{Value: strconv.Itoa(res.Unchanged)},
+	}
+	if len(cursor) > 0 {
+		item["cursor"] = &types.AttributeValueMemberM{Value: cursor}
+	}
+	_, err := me.cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String(me.table), Item: item})
+	return errors.Wrapf(err, "saving %s", id)
+}
+
+type migrationCheckpoint struct {
+	item map[string]types.AttributeValue
+	done bool
+}
+
+func (me *migrationCheckpoint) restore(res *TransformResult, cursor *map[string]types.AttributeValue) error {
+	for name, dst := range map[string]*int{"scanned": &res.Scanned, "updated": &res.Updated, "deleted": &res.Deleted, "unchanged": &res.Unchanged} {
+		n, ok := me.item[name].(*types.AttributeValueMemberN)
+		if !ok {
+			return errors.Errorf("%s is missing", name)
+		}
+		v, err := strconv.Atoi(n.Value)
+		if err != nil {
+			return err
+		}
+		*dst = v
+	}
+	if done, ok := me.item["done"].(*types.AttributeValueMemberBOOL); ok {
+		me.done = done.Value
+	}
+	if c, ok := me.item["cursor"].(*types.AttributeValueMemberM); ok {
+		*cursor = c.Value
+	}
+	return nil
+}
+
+// AssertMigrationT loads before into the table, runs the migrations with a metadata
+// table of its own and fails the test with a diff if the table does not hold exactly
+// after. It also checks that running again applies nothing.
+func AssertMigrationT(t testing.TB, ctx context.Context, cli *dynamodb.Client, table string, before, after []map[string]types.AttributeValue, opts ...MigrateOption) *MigrationReport {
+	t.Helper()
+
+	meta := fmt.Sprintf("%s-migrations-%s", table, newUUID()[:8])
+	opts = append(opts, WithMigrationTable(meta))
+	t.Cleanup(func() {
+		if _, err := cli.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: ptr.String(meta)}); err != nil {
+			var notFound *types.ResourceNotFoundException
+			if !errors.As(err, &notFound) {
+				t.Errorf("dynamodb: deleting %s: %s", meta, err)
+			}
+		}
+	})
+
+	if _, err := Truncate(ctx, cli, table); err != nil {
+		t.Fatalf("dynamodb: %s", err)
+	}
+	if _, err := SeedItems(ctx, cli, table, before); err != nil {
+		t.Fatalf("dynamodb: loading the items before the migration: %s", err)
+	}
+	start := CaptureT(t, ctx, cli, table)
+
+	report, err := Migrate(ctx, cli, opts...)
+	if err != nil {
+		t.Fatalf("dynamodb: migrating: %s", err)
+	}
+	t.Logf("dynamodb: %s", report)
+
+	got := CaptureT(t, ctx, cli, table)
+	want := &Snapshot{Table: table, Keys: got.Keys, items: map[string]map[string]types.AttributeValue{}}
+	for _, it := range after {
+		k, err := itemKey(it, got.Keys)
+		if err != nil {
+			t.Fatalf("dynamodb: expected item: %s", err)
+		}
+		want.items[k] = it
+	}
+
+	if diff := Diff(want, got); !diff.Empty() {
+		t.Errorf("dynamodb: %s does not hold the expected items after migrating (- expected, + got):\n%s\nchanges made by the migration:\n%s", table, diff, Diff(start, got))
+		return report
+	}
+
+	again, err := Migrate(ctx, cli, opts...)
+	if err != nil {
+		t.Fatalf("dynamodb: migrating again: %s", err)
+	}
+	if len(again.Applied) > 0 {
+		t.Errorf("dynamodb: running the migrations again applied %d of them", len(again.Applied))
+	}
+	return report
+}
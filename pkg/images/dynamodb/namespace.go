@@ -0,0 +1,252 @@
+package dynamodb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Namespace maps logical table names to physical ones with a unique prefix, so tests
+// sharing one dynamodb-local can use the same table names without seeing each other's
+// tables.
+//
+// Clients built with ClientOption rewrite table names in requests (TableName, the keys of
+// batch RequestItems, transact items, table arns) and strip the prefix again from
+// responses, so code under test only sees logical names. ListTables only returns the
+// namespace's tables. PartiQL statements are sent as they are.
+type Namespace struct {
+	Prefix string
+}
+
+var namespaceUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+const maxNamespaceName = 48
+
+// NewNamespace derives a prefix from name, e.g. a test name, plus a random part.
+func NewNamespace(name string) *Namespace {
+	name = strings.Trim(namespaceUnsafe.ReplaceAllString(name, "-"), "-")
+	if len(name) > maxNamespaceName {
+		name = name[:maxNamespaceName]
+	}
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	if name == "" {
+		name = "ns"
+	}
+	return &Namespace{Prefix: name + "-" + hex.EncodeToString(b[:]) + "-"}
+}
+
+// Table returns the physical name of a logical table.
+func (me *Namespace) Table(logical string) string {
+	if strings.HasPrefix(logical, me.Prefix) {
+		return logical
+	}
+	return me.Prefix + logical
+}
+
+// Logical returns the logical name of a physical table, ok is false for tables outside
+// the namespace.
+func (me *Namespace) Logical(physical string) (string, bool) {
+	if !strings.HasPrefix(physical, me.Prefix) {
+		return physical, false
+	}
+	return strings.TrimPrefix(physical, me.Prefix), true
+}
+
+// ClientOption adds the rewriting middleware to a client, e.g.
+// dynamodb.NewFromConfig(cfg, ns.ClientOption()) or img.NewClient(ns.ClientOption()).
+func (me *Namespace) ClientOption() func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("testrc.namespace", me.handle), middleware.Before)
+		})
+	}
+}
+
+func (me *Namespace) handle(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	if in.Parameters != nil {
+		in.Parameters = rewriteTableNames(reflect.ValueOf(in.Parameters), me.Table).Interface()
+	}
+
+	out, md, err := next.HandleInitialize(ctx, in)
+	if err != nil {
+		return out, md, err
+	}
+
+	if list, ok := out.Result.(*dynamodb.ListTablesOutput); ok {
+		names := []string{}
+		for _, n := range list.TableNames {
+			if logical, ok := me.Logical(n); ok {
+				names = append(names, logical)
+			}
+		}
+		list.TableNames = names
+	}
+	if out.Result != nil {
+		out.Result = rewriteTableNames(reflect.ValueOf(out.Result), func(s string) string {
+			logical, _ := me.Logical(s)
+			return logical
+		}).Interface()
+	}
+	return out, md, err
+}
+
+var (
+	tableNameFields = map[string]bool{"TableName": true, "ExclusiveStartTableName": true, "LastEvaluatedTableName": true}
+	tableMapFields  = map[string]bool{"RequestItems": true, "Responses": true, "UnprocessedItems": true, "UnprocessedKeys": true, "ItemCollectionMetrics": true}
+	tableArn        = regexp.MustCompile(`:table/([^/]+)`)
+	stringPtrType   = reflect.TypeOf((*string)(nil))
+)
+
+type tableField int
+
+const (
+	notTableField tableField = iota
+	tableNameField
+	tableArnField
+	tableMapField
+	nestedField
+)
+
+func tableFieldOf(f reflect.StructField, fv reflect.Value) tableField {
+	if !f.IsExported() {
+		return notTableField
+	}
+	switch {
+	case tableNameFields[f.Name] && f.Type == stringPtrType:
+		return tableNameField
+	case strings.HasSuffix(f.Name, "Arn") && f.Type == stringPtrType:
+		return tableArnField
+	case tableMapFields[f.Name] && fv.Kind() == reflect.Map:
+		return tableMapField
+	case fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Slice || fv.Kind() == reflect.Struct:
+		return nestedField
+	}
+	return notTableField
+}
+
+// visitTableNames walks an operation input or output and calls fn with every table name
+// in it, without modifying it. Attribute values are not visited.
+func visitTableNames(v reflect.Value, fn func(string)) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			visitTableNames(v.Elem(), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			visitTableNames(v.Index(i), fn)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f, fv := t.Field(i), v.Field(i)
+			switch tableFieldOf(f, fv) {
+			case tableNameField:
+				if !fv.IsNil() {
+					fn(fv.Elem().String())
+				}
+			case tableArnField:
+				if !fv.IsNil() {
+					for _, m := range tableArn.FindAllStringSubmatch(fv.Elem().String(), -1) {
+						fn(m[1])
+					}
+				}
+			case tableMapField:
+				iter := fv.MapRange()
+				for iter.Next() {
+					fn(iter.Key().String())
+				}
+			case nestedField:
+				visitTableNames(fv, fn)
+			}
+		}
+	}
+}
+
+func hasTableNames(v reflect.Value) bool {
+	found := false
+	visitTableNames(v, func(string) { found = true })
+	return found
+}
+
+// rewriteTableNames returns a copy of an operation input or output with every table name
+// in it mapped by fn. Only the parts leading to table names are copied, so the original,
+// e.g. an input shared by parallel tests, is left alone.
+func rewriteTableNames(v reflect.Value, fn func(string) string) reflect.Value {
+	if !hasTableNames(v) {
+		return v
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		cp := reflect.New(v.Type().Elem())
+		cp.Elem().Set(rewriteTableNames(v.Elem(), fn))
+		return cp
+	case reflect.Slice:
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(rewriteTableNames(v.Index(i), fn))
+		}
+		return cp
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f, fv := t.Field(i), cp.Field(i)
+			switch tableFieldOf(f, fv) {
+			case tableNameField:
+				if !fv.IsNil() {
+					s := fn(fv.Elem().String())
+					fv.Set(reflect.ValueOf(&s))
+				}
+			case tableArnField:
+				if !fv.IsNil() {
+					s := tableArn.ReplaceAllStringFunc(fv.Elem().String(), func(m string) string {
+						return ":table/" + fn(strings.TrimPrefix(m, ":table/"))
+					})
+					fv.Set(reflect.ValueOf(&s))
+				}
+			case tableMapField:
+				if !fv.IsNil() {
+					m := reflect.MakeMapWithSize(fv.Type(), fv.Len())
+					iter := fv.MapRange()
+					for iter.Next() {
+						m.SetMapIndex(reflect.ValueOf(fn(iter.Key().String())).Convert(fv.Type().Key()), iter.Value())
+					}
+					fv.Set(m)
+				}
+			case nestedField:
+				fv.Set(rewriteTableNames(fv, fn))
+			}
+		}
+		return cp
+	}
+	return v
+}
+
+// NamespaceT creates a namespace for the test and a client of img using it, and
+// provisions defs under their logical names for the lifetime of the test.
+func NamespaceT(t testing.TB, ctx context.Context, img *DockerImage, defs ...*TableDefinition) (*Namespace, *dynamodb.Client) {
+	t.Helper()
+
+	ns := NewNamespace(t.Name())
+	cli, err := img.NewClient(ns.ClientOption())
+	if err != nil {
+		t.Fatalf("dynamodb: creating namespaced client: %s", err)
+	}
+
+	for _, def := range defs {
+		ProvisionT(t, ctx, cli, def)
+	}
+
+	return ns, cli
+}
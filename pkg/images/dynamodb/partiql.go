@@ -0,0 +1,48 @@
+package dynamodb
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+var partiqlPlaceholder = regexp.MustCompile(`^:[A-Za-z_][A-Za-z0-9_]*`)
+
+// BindPartiQL replaces the :name placeholders of a PartiQL statement with positional
+// parameters taken from vars, leaving strings and quoted names alone, e.g.
+// `SELECT * FROM "t" WHERE pk = :pk AND note = ':pk'` becomes
+// `SELECT * FROM "t" WHERE pk = ? AND note = ':pk'` with the value of :pk.
+func BindPartiQL(stmt string, vars map[string]types.AttributeValue) (string, []types.AttributeValue, error) {
+	var b strings.Builder
+	var params []types.AttributeValue
+	var quote byte
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':':
+			if name := partiqlPlaceholder.FindString(stmt[i:]); name != "" {
+				v, ok := vars[name]
+				if !ok {
+					return "", nil, errors.Errorf(`placeholder %s is not set`, name)
+				}
+				params = append(params, v)
+				b.WriteByte('?')
+				i += len(name) - 1
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	if quote != 0 {
+		return "", nil, errors.Errorf("unterminated %c in statement", quote)
+	}
+	return b.String(), params, nil
+}
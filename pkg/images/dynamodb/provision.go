@@ -0,0 +1,180 @@
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// ProvisionTimeout bounds how long Provision and its teardown wait for the table to
+// become ACTIVE or to disappear.
+var ProvisionTimeout = 2 * time.Minute
+
+// ProvisionedTable describes a table after Provision applied every setting.
+type ProvisionedTable struct {
+	Table               *types.TableDescription
+	TimeToLive          *types.TimeToLiveDescription
+	PointInTimeRecovery *types.PointInTimeRecoveryDescription
+	Tags                []types.Tag
+	Elapsed             time.Duration
+}
+
+func (me *ProvisionedTable) Name() string {
+	return *me.Table.TableName
+}
+
+// Provision creates the table, waits for it to be ACTIVE and then applies the settings
+// CreateTable cannot take (TTL and point in time recovery). The returned teardown
+// deletes the table and waits until it is gone, so the name can be reused right away.
+func Provision(ctx context.Context, cli *dynamodb.Client, def *TableDefinition) (*ProvisionedTable, func() error, error) {
+	if def == nil || def.Input == nil || def.Input.TableName == nil {
+		return nil, nil, errors.New("TableName is nil")
+	}
+
+	start := time.Now()
+	name := def.Input.TableName
+
+	if _, err := cli.CreateTable(ctx, def.Input); err != nil {
+		return nil, nil, errors.Wrapf(err, "creating table %s", *name)
+	}
+
+	teardown := func() error {
+		if _, err := cli.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: name}); err != nil {
+			return errors.Wrapf(err, "deleting table %s", *name)
+		}
+		w := dynamodb.NewTableNotExistsWaiter(cli, func(o *dynamodb.TableNotExistsWaiterOptions) {
+			o.MinDelay = 100 * time.Millisecond
+			o.MaxDelay = 2 * time.Second
+		})
+		if err := w.Wait(ctx, &dynamodb.DescribeTableInput{TableName: name}, ProvisionTimeout); err != nil {
+			return errors.Wrapf(err, "waiting for table %s to be deleted", *name)
+		}
+		return nil
+	}
+
+	fail := func(err error, msg string) (*ProvisionedTable, func() error, error) {
+		_ = teardown()
+		return nil, nil, errors.Wrapf(err, "%s on table %s", msg, *name)
+	}
+
+	if err := waitForActive(ctx, cli, name); err != nil {
+		return fail(err, "waiting for ACTIVE")
+	}
+
+	// new tables start with ttl disabled, and disabling it again is an error
+	if def.TimeToLive != nil && ptr.ToBool(def.TimeToLive.Enabled) {
+		_, err := cli.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName:               name,
+			TimeToLiveSpecification: def.TimeToLive,
+		})
+		if err != nil {
+			return fail(err, "enabling ttl")
+		}
+	}
+
+	if def.PointInTimeRecovery {
+		_, err := cli.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName:                        name,
+			PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{PointInTimeRecoveryEnabled: ptr.Bool(true)},
+		})
+		if err != nil {
+			return fail(err, "enabling point in time recovery")
+		}
+	}
+
+	out, err := DescribeProvisioned(ctx, cli, *name)
+	if err != nil {
+		return fail(err, "describing")
+	}
+	out.Elapsed = time.Since(start)
+
+	return out, teardown, nil
+}
+
+func waitForActive(ctx context.Context, cli *dynamodb.Client, name *string) error {
+	w := dynamodb.NewTableExistsWaiter(cli, func(o *dynamodb.TableExistsWaiterOptions) {
+		o.MinDelay = 100 * time.Millisecond
+		o.MaxDelay = 2 * time.Second
+	})
+	return w.Wait(ctx, &dynamodb.DescribeTableInput{TableName: name}, ProvisionTimeout)
+}
+
+// DescribeProvisioned collects the table, ttl, backup and tag descriptions of a table.
+func DescribeProvisioned(ctx context.Context, cli *dynamodb.Client, name string) (*ProvisionedTable, error) {
+	out := &ProvisionedTable{}
+
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	out.Table = desc.Table
+
+	ttl, err := cli.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: ptr.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	out.TimeToLive = ttl.TimeToLiveDescription
+
+	// older dynamodb-local images reject the backup and tag calls, which just leaves those fields empty
+	backups, err := cli.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: ptr.String(name)})
+	if err != nil && !unsupportedOperation(err) {
+		return nil, err
+	}
+	if err == nil && backups.ContinuousBackupsDescription != nil {
+		out.PointInTimeRecovery = backups.ContinuousBackupsDescription.PointInTimeRecoveryDescription
+	}
+
+	var next *string
+	for {
+		tags, err := cli.ListTagsOfResource(ctx, &dynamodb.ListTagsOfResourceInput{ResourceArn: desc.Table.TableArn, NextToken: next})
+		if unsupportedOperation(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.Tags = append(out.Tags, tags.Tags...)
+		if next = tags.NextToken; next == nil {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// unsupportedOperation reports whether err is how dynamodb-local rejects an operation it
+// does not implement.
+func unsupportedOperation(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "UnknownOperationException"
+}
+
+// ProvisionT provisions the table for the lifetime of the test and seeds it from
+// SeedDirectory if there are seed files for it. Use DiffOnFailureT to log what a failing
+// test changed in the table after seeding.
+func ProvisionT(t testing.TB, ctx context.Context, cli *dynamodb.Client, def *TableDefinition) *ProvisionedTable {
+	t.Helper()
+
+	out, teardown, err := Provision(ctx, cli, def)
+	if err != nil {
+		t.Fatalf("dynamodb: provision failed: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := teardown(); err != nil {
+			t.Errorf("dynamodb: teardown failed: %s", err)
+		}
+	})
+
+	if _, err := SeedFromTestdata(ctx, cli, out.Name()); err != nil {
+		t.Fatalf("dynamodb: seeding %s failed: %s", out.Name(), err)
+	}
+
+	return out
+}
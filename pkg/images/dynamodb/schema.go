@@ -0,0 +1,284 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
+)
+
+// Schema is the shape of a table inferred from its items.
+type Schema struct {
+	Table         string          `json:"table"`
+	Items         int             `json:"items"`
+	Discriminator string          `json:"discriminator,omitempty"`
+	Entities      []*EntitySchema `json:"entities"`
+}
+
+// EntitySchema describes the items sharing one discriminator value. Without a
+// discriminator there is a single entity with an empty name, which is also where items
+// missing the discriminator end up.
+type EntitySchema struct {
+	Name       string             `json:"name"`
+	Items      int                `json:"items"`
+	Attributes []*AttributeSchema `json:"attributes"`
+}
+
+// AttributeSchema lists the types an attribute was seen with.
+type AttributeSchema struct {
+	Name  string       `json:"name"`
+	Count int          `json:"count"`
+	Types []*TypeStats `json:"types"`
+}
+
+// TypeStats are the observations of one attribute type. Sizes are in bytes, computed
+// the way DynamoDB bills them.
+type TypeStats struct {
+	Type    string   `json:"type"`
+	Count   int      `json:"count"`
+	MinSize int      `json:"min_size"`
+	MaxSize int      `json:"max_size"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+// SchemaOption configures schema inference.
+type SchemaOption func(*schemaConfig)
+
+type schemaConfig struct {
+	discriminator string
+	samples       int
+}
+
+// WithDiscriminator groups items by the value of this attribute, e.g. "type".
+func WithDiscriminator(attr string) SchemaOption {
+	return func(c *schemaConfig) { c.discriminator = attr }
+}
+
+// WithSamples keeps up to n distinct sample values per type, 3 by default.
+func WithSamples(n int) SchemaOption {
+	return func(c *schemaConfig) { c.samples = n }
+}
+
+const maxSampleLength = 40
+
+// InferSchema scans the table and infers its schema.
+func InferSchema(ctx context.Context, cli *dynamodb.Client, tbl string, opts ...SchemaOption) (*Schema, error) {
+	items := []map[string]types.AttributeValue{}
+	paginator := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{TableName: aws.String(tbl)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning %s", tbl)
+		}
+		items = append(items, page.Items...)
+	}
+	return InferSchemaFromItems(tbl, items, opts...)
+}
+
+// InferSchemaFromItems infers a schema from items read elsewhere, e.g. with LoadSeed
+// from an export, so fixtures and exports can be compared with Drift.
+func InferSchemaFromItems(tbl string, items []map[string]types.AttributeValue, opts ...SchemaOption) (*Schema, error) {
+	cfg := &schemaConfig{samples: 3}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	type typeAcc struct {
+		stats *TypeStats
+		seen  map[string]bool
+	}
+	type entityAcc struct {
+		items int
+		attrs map[string]int
+		types map[string]map[string]*typeAcc
+	}
+	entities := map[string]*entityAcc{}
+
+	for _, av := range items {
+		it, err := itemFromSDK(av)
+		if err != nil {
+			return nil, err
+		}
+
+		name := ""
+		if d, ok := it[cfg.discriminator]; ok && cfg.discriminator != "" {
+			name = fmt.Sprint(cellValue(d.toSDK()))
+		}
+		e, ok := entities[name]
+		if !ok {
+			e = &entityAcc{attrs: map[string]int{}, types: map[string]map[string]*typeAcc{}}
+			entities[name] = e
+		}
+		e.items++
+
+		for attr, v := range it {
+			e.attrs[attr]++
+			if e.types[attr] == nil {
+				e.types[attr] = map[string]*typeAcc{}
+			}
+			acc, ok := e.types[attr][v.kind]
+			size := v.size()
+			if !ok {
+				acc = &typeAcc{stats: &TypeStats{Type: v.kind, MinSize: size, MaxSize: size}, seen: map[string]bool{}}
+				e.types[attr][v.kind] = acc
+			}
+			acc.stats.Count++
+			if size < acc.stats.MinSize {
+				acc.stats.MinSize = size
+			}
+			if size > acc.stats.MaxSize {
+				acc.stats.MaxSize = size
+			}
+			if len(acc.stats.Samples) < cfg.samples {
+				s := sampleValue(v)
+				if !acc.seen[s] {
+					acc.seen[s] = true
+					acc.stats.Samples = append(acc.stats.Samples, s)
+				}
+			}
+		}
+	}
+
+	schema := &Schema{Table: tbl, Items: len(items), Discriminator: cfg.discriminator, Entities: []*EntitySchema{}}
+	for _, name := range sortedKeysOf(entities) {
+		e := entities[name]
+		es := &EntitySchema{Name: name, Items: e.items}
+		for _, attr := range sortedKeysOf(e.attrs) {
+			as := &AttributeSchema{Name: attr, Count: e.attrs[attr]}
+			for _, kind := range sortedKeysOf(e.types[attr]) {
+				as.Types = append(as.Types, e.types[attr][kind].stats)
+			}
+			// most common type first
+			sort.SliceStable(as.Types, func(i, j int) bool { return as.Types[i].Count > as.Types[j].Count })
+			es.Attributes = append(es.Attributes, as)
+		}
+		schema.Entities = append(schema.Entities, es)
+	}
+	return schema, nil
+}
+
+func sampleValue(v *attrValue) string {
+	s := fmt.Sprint(cellValue(v.toSDK()))
+	if len(s) > maxSampleLength {
+		s = s[:maxSampleLength-3] + "..."
+	}
+	return s
+}
+
+// Entity returns the entity with this discriminator value, or nil.
+func (me *Schema) Entity(name string) *EntitySchema {
+	for _, e := range me.Entities {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Attribute returns the attribute with this name, or nil.
+func (me *EntitySchema) Attribute(name string) *AttributeSchema {
+	for _, a := range me.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Drift describes the entities, attributes and attribute types that only one of the
+// schemas has, from the point of view of me. Counts and sizes are not compared. It
+// returns nothing when both have the same shape.
+func (me *Schema) Drift(other *Schema) []string {
+	out := []string{}
+	label := func(e string) string {
+		if e == "" {
+			return "items"
+		}
+		return fmt.Sprintf("entity %q", e)
+	}
+
+	for _, e := range me.Entities {
+		o := other.Entity(e.Name)
+		if o == nil {
+			out = append(out, fmt.Sprintf("- %s", label(e.Name)))
+			continue
+		}
+		for _, a := range e.Attributes {
+			oa := o.Attribute(a.Name)
+			if oa == nil {
+				out = append(out, fmt.Sprintf("- %s: attribute %s", label(e.Name), a.Name))
+				continue
+			}
+			for _, kind := range typeDifference(a, oa) {
+				out = append(out, fmt.Sprintf("- %s: attribute %s as %s", label(e.Name), a.Name, kind))
+			}
+			for _, kind := range typeDifference(oa, a) {
+				out = append(out, fmt.Sprintf("+ %s: attribute %s as %s", label(e.Name), a.Name, kind))
+			}
+		}
+		for _, oa := range o.Attributes {
+			if e.Attribute(oa.Name) == nil {
+				out = append(out, fmt.Sprintf("+ %s: attribute %s", label(e.Name), oa.Name))
+			}
+		}
+	}
+	for _, o := range other.Entities {
+		if me.Entity(o.Name) == nil {
+			out = append(out, fmt.Sprintf("+ %s", label(o.Name)))
+		}
+	}
+	return out
+}
+
+func typeDifference(a, b *AttributeSchema) []string {
+	out := []string{}
+	for _, t := range a.Types {
+		found := false
+		for _, o := range b.Types {
+			found = found || o.Type == t.Type
+		}
+		if !found {
+			out = append(out, t.Type)
+		}
+	}
+	return out
+}
+
+// FprintSchema writes the schema with one row per entity, attribute and type. With
+// FormatJSONL every row is a json object; use encoding/json on the Schema for the nested
+// form.
+func FprintSchema(w io.Writer, schema *Schema, opts ...PrintOption) error {
+	cfg := newPrintConfig(opts)
+
+	cols := []string{"Entity", "Attribute", "Present", "Type", "Count", "Min Size", "Max Size", "Samples"}
+	rows := []table.Row{}
+	for _, e := range schema.Entities {
+		for _, a := range e.Attributes {
+			for _, t := range a.Types {
+				if cfg.format == FormatJSONL {
+					if err := writeJSONLine(w, map[string]any{
+						"entity": e.Name, "attribute": a.Name, "present": a.Count, "items": e.Items,
+						"type": t.Type, "count": t.Count, "min_size": t.MinSize, "max_size": t.MaxSize, "samples": t.Samples,
+					}); err != nil {
+						return err
+					}
+					continue
+				}
+				rows = append(rows, table.Row{
+					e.Name, a.Name, fmt.Sprintf("%d/%d", a.Count, e.Items),
+					t.Type, t.Count, t.MinSize, t.MaxSize, t.Samples,
+				})
+			}
+		}
+	}
+	if cfg.format == FormatJSONL {
+		return nil
+	}
+	return cfg.render(w, fmt.Sprintf("Table Schema: %s (%d items)", schema.Table, schema.Items), cols, rows)
+}
@@ -0,0 +1,643 @@
+package dynamodb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SeedDirectory is where SeedFromTestdata (and so ProvisionT) looks for seed files,
+	// named after the table: testdata/dynamodb/orders.json, or a directory of files
+	// testdata/dynamodb/orders/.
+	SeedDirectory = "testdata/dynamodb"
+
+	seedBatchSize = 25
+)
+
+// SeedMaxAttempts bounds how often a batch with unprocessed items is retried.
+var SeedMaxAttempts = 8
+
+var seedExtensions = []string{".json", ".jsonl", ".yaml", ".yml", ".csv"}
+
+// Seed writes the items of a seed file, or of every seed file in a directory, to the
+// table and returns how many were written.
+//
+// Supported formats, picked by extension:
+//   - .json: an array of items, a single item, aws cli scan output ({"Items": [...]}) or
+//     a batch-write-item request file. Items can be DynamoDB JSON ({"pk": {"S": "a"}})
+//     or plain JSON, which is marshalled with attributevalue.
+//   - .jsonl: one item per line, including the {"Item": ...} lines of an S3 export.
+//   - .yaml/.yml: like .json.
+//   - .csv: a header of name:TYPE columns (TYPE defaults to S). Set members are
+//     separated by ';', L and M cells hold plain JSON, B cells base64 and empty cells
+//     leave the attribute out.
+//
+// String values can contain templates, evaluated once per Seed call:
+// {{uuid}} (a new uuid each time), {{uuid:name}} (the same uuid for the same name),
+// {{now}} / {{now-24h}} (RFC3339), {{date+7d}} (YYYY-MM-DD), {{unix+1h}} and {{unixms}}
+// (epoch numbers) and {{index}} (the item's position in its file). A plain value that is
+// only a numeric template becomes a number, so TTL attributes can be seeded directly.
+func Seed(ctx context.Context, cli *dynamodb.Client, table string, source string) (int, error) {
+	items, err := LoadSeed(source)
+	if err != nil {
+		return 0, err
+	}
+	return SeedItems(ctx, cli, table, items)
+}
+
+// LoadSeed reads the items of a seed file or directory without writing them.
+func LoadSeed(source string) ([]map[string]types.AttributeValue, error) {
+	files, err := seedFiles(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := newSeedTemplate(time.Now())
+	items := []map[string]types.AttributeValue{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", f)
+		}
+		tmpl.index = 0
+		its, err := parseSeed(filepath.Ext(f), b, tmpl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", f)
+		}
+		items = append(items, its...)
+	}
+	return items, nil
+}
+
+func seedFiles(source string) ([]string, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading seed source")
+	}
+	if !fi.IsDir() {
+		return []string{source}, nil
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading seed directory %s", source)
+	}
+	files := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && isSeedFile(e.Name()) {
+			files = append(files, filepath.Join(source, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isSeedFile(name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range seedExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedFromTestdata seeds the table from SeedDirectory if a file or directory named
+// after it exists. It is not an error if there is none.
+func SeedFromTestdata(ctx context.Context, cli *dynamodb.Client, table string) (int, error) {
+	source := filepath.Join(SeedDirectory, table)
+	if fi, err := os.Stat(source); err == nil && fi.IsDir() {
+		return Seed(ctx, cli, table, source)
+	}
+	for _, ext := range seedExtensions {
+		if _, err := os.Stat(source + ext); err == nil {
+			return Seed(ctx, cli, table, source+ext)
+		}
+	}
+	return 0, nil
+}
+
+// SeedItems writes items with BatchWriteItem in groups of 25, retrying unprocessed
+// items with backoff. Items with the same key are written in separate batches, so the
+// last one wins like it would with PutItem.
+func SeedItems(ctx context.Context, cli *dynamodb.Client, table string, items []map[string]types.AttributeValue) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	keys, err := keyNames(ctx, cli, table)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	batch := make([]types.WriteRequest, 0, seedBatchSize)
+	seen := map[string]bool{}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := writeBatch(ctx, cli, table, batch); err != nil {
+			return err
+		}
+		written += len(batch)
+		batch = batch[:0]
+		seen = map[string]bool{}
+		return nil
+	}
+
+	for _, it := range items {
+		k, err := itemKey(it, keys)
+		if err != nil {
+			return written, err
+		}
+		if seen[k] || len(batch) == seedBatchSize {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+		seen[k] = true
+		batch = append(batch, types.WriteRequest{PutRequest: &types.PutRequest{Item: it}})
+	}
+
+	if err := flush(); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+func keyNames(ctx context.Context, cli *dynamodb.Client, table string) ([]string, error) {
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(table)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing %s", table)
+	}
+	keys := []string{}
+	for _, k := range desc.Table.KeySchema {
+		keys = append(keys, *k.AttributeName)
+	}
+	return keys, nil
+}
+
+func itemKey(it map[string]types.AttributeValue, keys []string) (string, error) {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, ok := it[k]
+		if !ok {
+			return "", errors.Errorf("item is missing key attribute %s", k)
+		}
+		a, err := attrFromSDK(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, keyString(a))
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+func writeBatch(ctx context.Context, cli *dynamodb.Client, table string, reqs []types.WriteRequest) error {
+	pending := map[string][]types.WriteRequest{table: reqs}
+	backoff := 50 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		out, err := cli.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return errors.Wrapf(err, "writing batch to %s", table)
+		}
+		if len(out.UnprocessedItems[table]) == 0 {
+			return nil
+		}
+		if attempt == SeedMaxAttempts {
+			return errors.Errorf("%d items still unprocessed after %d attempts", len(out.UnprocessedItems[table]), attempt)
+		}
+
+		pending = out.UnprocessedItems
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// formats
+
+func parseSeed(ext string, b []byte, tmpl *seedTemplate) ([]map[string]types.AttributeValue, error) {
+	switch ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return seedDocument(v, tmpl)
+	case ".jsonl":
+		items := []map[string]types.AttributeValue{}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			if strings.TrimSpace(sc.Text()) == "" {
+				continue
+			}
+			dec := json.NewDecoder(strings.NewReader(sc.Text()))
+			dec.UseNumber()
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				return nil, errors.Wrapf(err, "line %d", line)
+			}
+			it, err := seedItem(v, tmpl)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", line)
+			}
+			items = append(items, it)
+		}
+		return items, sc.Err()
+	case ".yaml", ".yml":
+		var v any
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		return seedDocument(v, tmpl)
+	case ".csv":
+		return seedCSV(b, tmpl)
+	}
+	return nil, errors.Errorf("unknown seed format %q", ext)
+}
+
+// seedDocument unwraps the container shapes a json or yaml seed file can have.
+func seedDocument(v any, tmpl *seedTemplate) ([]map[string]types.AttributeValue, error) {
+	var list []any
+	switch d := v.(type) {
+	case []any:
+		list = d
+	case map[string]any:
+		if items, ok := d["Items"].([]any); ok {
+			list = items
+			break
+		}
+		if reqs := batchWriteItems(d); reqs != nil {
+			list = reqs
+			break
+		}
+		list = []any{d}
+	case nil:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("expected a list of items, got %T", v)
+	}
+
+	items := make([]map[string]types.AttributeValue, 0, len(list))
+	for i, e := range list {
+		it, err := seedItem(e, tmpl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "item %d", i)
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// batchWriteItems returns the put requests of a batch-write-item request file.
+func batchWriteItems(d map[string]any) []any {
+	if len(d) != 1 {
+		return nil
+	}
+	for _, reqs := range d {
+		list, ok := reqs.([]any)
+		if !ok || len(list) == 0 {
+			return nil
+		}
+		items := []any{}
+		for _, r := range list {
+			rm, _ := r.(map[string]any)
+			put, _ := rm["PutRequest"].(map[string]any)
+			if put == nil {
+				return nil
+			}
+			items = append(items, put["Item"])
+		}
+		return items
+	}
+	return nil
+}
+
+var dynamoTypes = map[string]bool{"S": true, "N": true, "B": true, "BOOL": true, "NULL": true, "SS": true, "NS": true, "BS": true, "L": true, "M": true}
+
+// isDynamoJSON reports whether every attribute is a single key type descriptor.
+func isDynamoJSON(m map[string]any) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for _, v := range m {
+		d, ok := v.(map[string]any)
+		if !ok || len(d) != 1 {
+			return false
+		}
+		for k := range d {
+			if !dynamoTypes[k] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParseItem decodes a single item given as DynamoDB JSON or plain JSON, with the same
+// templates as seed files.
+func ParseItem(b []byte) (map[string]types.AttributeValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decoding item")
+	}
+	return seedItem(v, newSeedTemplate(time.Now()))
+}
+
+func seedItem(v any, tmpl *seedTemplate) (map[string]types.AttributeValue, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("expected an item, got %T", v)
+	}
+	if inner, ok := m["Item"].(map[string]any); ok && len(m) == 1 {
+		m = inner
+	}
+
+	defer func() { tmpl.index++ }()
+
+	if isDynamoJSON(m) {
+		expanded, err := tmpl.expandTree(m, false)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(expanded)
+		if err != nil {
+			return nil, err
+		}
+		return UnmarshalItemJSON(b)
+	}
+
+	expanded, err := tmpl.expandTree(m, true)
+	if err != nil {
+		return nil, err
+	}
+	return attributevalue.MarshalMap(expanded)
+}
+
+func seedCSV(b []byte, tmpl *seedTemplate) ([]map[string]types.AttributeValue, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	type column struct{ name, typ string }
+	cols := make([]column, len(rows[0]))
+	for i, h := range rows[0] {
+		name, typ, _ := strings.Cut(strings.TrimSpace(h), ":")
+		if typ == "" {
+			typ = "S"
+		}
+		typ = strings.ToUpper(typ)
+		if !dynamoTypes[typ] {
+			return nil, errors.Errorf("column %s has unknown type %s", name, typ)
+		}
+		cols[i] = column{name: name, typ: typ}
+	}
+
+	items := make([]map[string]types.AttributeValue, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		it := map[string]types.AttributeValue{}
+		for i, cell := range row {
+			if cell == "" {
+				continue
+			}
+			cell, err := tmpl.expand(cell)
+			if err != nil {
+				return nil, errors.Wrapf(err, "row %d", n+2)
+			}
+			av, err := csvValue(cols[i].typ, cell)
+			if err != nil {
+				return nil, errors.Wrapf(err, "row %d column %s", n+2, cols[i].name)
+			}
+			it[cols[i].name] = av
+		}
+		items = append(items, it)
+		tmpl.index++
+	}
+	return items, nil
+}
+
+func csvValue(typ, cell string) (types.AttributeValue, error) {
+	split := func() []string {
+		parts := strings.Split(cell, ";")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts
+	}
+
+	switch typ {
+	case "S":
+		return &types.AttributeValueMemberS{Value: cell}, nil
+	case "N":
+		if _, err := parseNumber(cell); err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberN{Value: strings.TrimSpace(cell)}, nil
+	case "B":
+		b, err := base64.StdEncoding.DecodeString(cell)
+		return &types.AttributeValueMemberB{Value: b}, err
+	case "BOOL":
+		bl, err := strconv.ParseBool(strings.TrimSpace(cell))
+		return &types.AttributeValueMemberBOOL{Value: bl}, err
+	case "NULL":
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case "SS":
+		return &types.AttributeValueMemberSS{Value: split()}, nil
+	case "NS":
+		return &types.AttributeValueMemberNS{Value: split()}, nil
+	case "BS":
+		bs := [][]byte{}
+		for _, p := range split() {
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil {
+				return nil, err
+			}
+			bs = append(bs, b)
+		}
+		return &types.AttributeValueMemberBS{Value: bs}, nil
+	case "L", "M":
+		dec := json.NewDecoder(strings.NewReader(cell))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		av, err := attributevalue.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := av.(*types.AttributeValueMemberL); typ == "L" && !ok {
+			return nil, errors.New("expected a json list")
+		}
+		if _, ok := av.(*types.AttributeValueMemberM); typ == "M" && !ok {
+			return nil, errors.New("expected a json object")
+		}
+		return av, nil
+	}
+	return nil, errors.Errorf("unknown type %s", typ)
+}
+
+// ---------------------------------------------------------------------------
+// templates
+
+var seedTemplateExpr = regexp.MustCompile(`\{\{\s*([a-z]+)(?::([\w.-]+))?\s*(?:([+-])\s*([0-9.]+[a-z]+))?\s*\}\}`)
+
+type seedTemplate struct {
+	now   time.Time
+	uuids map[string]string
+	index int
+}
+
+func newSeedTemplate(now time.Time) *seedTemplate {
+	return &seedTemplate{now: now, uuids: map[string]string{}}
+}
+
+func (me *seedTemplate) expand(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var err error
+	out := seedTemplateExpr.ReplaceAllStringFunc(s, func(m string) string {
+		r, e := me.eval(seedTemplateExpr.FindStringSubmatch(m))
+		if e != nil && err == nil {
+			err = e
+		}
+		return r
+	})
+	return out, err
+}
+
+// expandTree expands every string in a decoded json or yaml value. With numbers set, a
+// string that is exactly one numeric template becomes a number.
+func (me *seedTemplate) expandTree(v any, numbers bool) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if m := seedTemplateExpr.FindStringSubmatch(v); numbers && m != nil && m[0] == strings.TrimSpace(v) {
+			switch m[1] {
+			case "unix", "unixms", "index":
+				r, err := me.eval(m)
+				return json.Number(r), err
+			}
+		}
+		return me.expand(v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			x, err := me.expandTree(e, numbers)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = x
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			x, err := me.expandTree(e, numbers)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func (me *seedTemplate) eval(m []string) (string, error) {
+	fn, label, sign, offset := m[1], m[2], m[3], m[4]
+
+	t := me.now
+	if offset != "" {
+		d, err := parseSeedDuration(offset)
+		if err != nil {
+			return "", err
+		}
+		if sign == "-" {
+			d = -d
+		}
+		t = t.Add(d)
+	}
+
+	switch fn {
+	case "uuid":
+		if label == "" {
+			return newUUID(), nil
+		}
+		if _, ok := me.uuids[label]; !ok {
+			me.uuids[label] = newUUID()
+		}
+		return me.uuids[label], nil
+	case "now":
+		return t.UTC().Format(time.RFC3339), nil
+	case "date":
+		return t.UTC().Format("2006-01-02"), nil
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "unixms":
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	case "index":
+		return strconv.Itoa(me.index), nil
+	}
+	return "", errors.Errorf("unknown template {{%s}}", fn)
+}
+
+// parseSeedDuration is time.ParseDuration plus a d suffix for days.
+func parseSeedDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,486 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// StreamPollInterval is how long a StreamWatcher waits before polling again when there
+// were no new records.
+var StreamPollInterval = 50 * time.Millisecond
+
+// EventType is the kind of change of a stream record.
+type EventType string
+
+const (
+	EventInsert EventType = "INSERT"
+	EventModify EventType = "MODIFY"
+	EventRemove EventType = "REMOVE"
+)
+
+// StreamEvent is a stream record with its images converted to regular attribute values.
+// Which images are set depends on the stream view type.
+type StreamEvent struct {
+	Type           EventType
+	Table          string
+	Keys           map[string]types.AttributeValue
+	NewImage       map[string]types.AttributeValue
+	OldImage       map[string]types.AttributeValue
+	SequenceNumber string
+	Time           time.Time
+}
+
+func (me StreamEvent) String() string {
+	keys, err := MarshalItemJSON(me.Keys)
+	if err != nil {
+		keys = []byte("?")
+	}
+	return fmt.Sprintf("%s %s", me.Type, keys)
+}
+
+// StreamWatcher delivers the changes of a table, in order, from the moment it was
+// created.
+type StreamWatcher struct {
+	Table     string
+	StreamArn string
+
+	cli    *dynamodbstreams.Client
+	events chan StreamEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// WatchStream starts reading the stream of the table, enabling it with
+// NEW_AND_OLD_IMAGES first if the table has none. Only changes made after WatchStream
+// returns are delivered. The watcher stops when ctx is done or Close is called.
+func WatchStream(ctx context.Context, img *DockerImage, table string) (*StreamWatcher, error) {
+	cli, err := img.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	scli, err := img.NewStreamsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	arn, err := enableStream(ctx, cli, table)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	me := &StreamWatcher{
+		Table:     table,
+		StreamArn: arn,
+		cli:       scli,
+		events:    make(chan StreamEvent, 100),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	shards, err := me.shards(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	iterators := map[string]*string{}
+	known := map[string]bool{}
+	for _, s := range shards {
+		known[*s.ShardId] = true
+		if s.SequenceNumberRange != nil && s.SequenceNumberRange.EndingSequenceNumber != nil {
+			continue
+		}
+		it, err := me.iterator(ctx, s.ShardId, streamtypes.ShardIteratorTypeLatest)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		iterators[*s.ShardId] = it
+	}
+
+	go me.poll(ctx, iterators, known)
+
+	return me, nil
+}
+
+// WatchStreamT watches the table for the lifetime of the test.
+func WatchStreamT(t testing.TB, ctx context.Context, img *DockerImage, table string) *StreamWatcher {
+	t.Helper()
+
+	me, err := WatchStream(ctx, img, table)
+	if err != nil {
+		t.Fatalf("dynamodb: watching stream of %s: %s", table, err)
+	}
+
+	t.Cleanup(func() {
+		if err := me.Close(); err != nil {
+			t.Errorf("dynamodb: stream of %s: %s", table, err)
+		}
+	})
+
+	return me
+}
+
+func enableStream(ctx context.Context, cli *dynamodb.Client, table string) (string, error) {
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(table)})
+	if err != nil {
+		return "", errors.Wrapf(err, "describing %s", table)
+	}
+	if spec := desc.Table.StreamSpecification; spec != nil && spec.StreamEnabled != nil && *spec.StreamEnabled && desc.Table.LatestStreamArn != nil {
+		return *desc.Table.LatestStreamArn, nil
+	}
+
+	_, err = cli.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: ptr.String(table),
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  ptr.Bool(true),
+			StreamViewType: types.StreamViewTypeNewAndOldImages,
+		},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "enabling stream of %s", table)
+	}
+	if err := waitForActive(ctx, cli, ptr.String(table)); err != nil {
+		return "", errors.Wrapf(err, "waiting for %s", table)
+	}
+
+	desc, err = cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String(table)})
+	if err != nil {
+		return "", errors.Wrapf(err, "describing %s", table)
+	}
+	if desc.Table.LatestStreamArn == nil {
+		return "", errors.Errorf("%s has no stream after enabling it", table)
+	}
+	return *desc.Table.LatestStreamArn, nil
+}
+
+func (me *StreamWatcher) shards(ctx context.Context) ([]streamtypes.Shard, error) {
+	shards := []streamtypes.Shard{}
+	var start *string
+	for {
+		out, err := me.cli.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{StreamArn: ptr.String(me.StreamArn), ExclusiveStartShardId: start})
+		if err != nil {
+			return nil, errors.Wrapf(err, "describing stream of %s", me.Table)
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if start = out.StreamDescription.LastEvaluatedShardId; start == nil {
+			return shards, nil
+		}
+	}
+}
+
+func (me *StreamWatcher) iterator(ctx context.Context, shard *string, typ streamtypes.ShardIteratorType) (*string, error) {
+	out, err := me.cli.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         ptr.String(me.StreamArn),
+		ShardId:           shard,
+		ShardIteratorType: typ,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting iterator of shard %s", *shard)
+	}
+	return out.ShardIterator, nil
+}
+
+// poll reads every open shard until ctx is done. Shards that appear later, when
+// dynamodb-local rolls them over, are read from their start.
+func (me *StreamWatcher) poll(ctx context.Context, iterators map[string]*string, known map[string]bool) {
+	defer close(me.done)
+	defer close(me.events)
+
+	for {
+		received := 0
+		for _, id := range sortedKeysOf(iterators) {
+			out, err := me.cli.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterators[id]})
+			if err != nil {
+				me.fail(ctx, errors.Wrapf(err, "reading shard %s", id))
+				return
+			}
+			for _, r := range out.Records {
+				select {
+				case me.events <- me.event(r):
+				case <-ctx.Done():
+					return
+				}
+			}
+			received += len(out.Records)
+			if out.NextShardIterator == nil {
+				delete(iterators, id)
+			} else {
+				iterators[id] = out.NextShardIterator
+			}
+		}
+
+		if received > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(StreamPollInterval):
+		}
+
+		shards, err := me.shards(ctx)
+		if err != nil {
+			me.fail(ctx, err)
+			return
+		}
+		for _, s := range shards {
+			if known[*s.ShardId] {
+				continue
+			}
+			it, err := me.iterator(ctx, s.ShardId, streamtypes.ShardIteratorTypeTrimHorizon)
+			if err != nil {
+				me.fail(ctx, err)
+				return
+			}
+			known[*s.ShardId] = true
+			iterators[*s.ShardId] = it
+		}
+		if len(iterators) == 0 {
+			// the stream was disabled or the table deleted
+			return
+		}
+	}
+}
+
+func (me *StreamWatcher) fail(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.err = err
+}
+
+func (me *StreamWatcher) event(r streamtypes.Record) StreamEvent {
+	e := StreamEvent{Type: EventType(r.EventName), Table: me.Table}
+	if r.Dynamodb != nil {
+		e.Keys = fromStreamItem(r.Dynamodb.Keys)
+		e.NewImage = fromStreamItem(r.Dynamodb.NewImage)
+		e.OldImage = fromStreamItem(r.Dynamodb.OldImage)
+		e.SequenceNumber = ptr.ToString(r.Dynamodb.SequenceNumber)
+		e.Time = ptr.ToTime(r.Dynamodb.ApproximateCreationDateTime)
+	}
+	return e
+}
+
+// Events is closed when the watcher stops, check Err for why.
+func (me *StreamWatcher) Events() <-chan StreamEvent {
+	return me.events
+}
+
+// Err is the error that stopped the watcher, if any.
+func (me *StreamWatcher) Err() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.err
+}
+
+// Close stops the watcher and returns Err.
+func (me *StreamWatcher) Close() error {
+	me.cancel()
+	<-me.done
+	return me.Err()
+}
+
+// Next waits for the next event. ok is false on timeout or when the watcher stopped.
+func (me *StreamWatcher) Next(timeout time.Duration) (StreamEvent, bool) {
+	select {
+	case e, ok := <-me.events:
+		return e, ok
+	case <-time.After(timeout):
+		return StreamEvent{}, false
+	}
+}
+
+// ExpectEvent fails the test unless the next event matches, so consecutive calls check
+// the order of changes.
+func (me *StreamWatcher) ExpectEvent(t testing.TB, m EventMatcher, timeout time.Duration) StreamEvent {
+	t.Helper()
+
+	e, ok := me.Next(timeout)
+	if !ok {
+		t.Errorf("dynamodb: expected %s on %s within %s, got no event%s", m, me.Table, timeout, me.stopped())
+		return e
+	}
+	if !m.Match(e) {
+		t.Errorf("dynamodb: expected %s on %s, got %s", m, me.Table, e)
+	}
+	return e
+}
+
+// WaitForEvent skips events until one matches and fails the test if none does in time.
+func (me *StreamWatcher) WaitForEvent(t testing.TB, m EventMatcher, timeout time.Duration) StreamEvent {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	skipped := []string{}
+	for {
+		e, ok := me.Next(time.Until(deadline))
+		if !ok {
+			t.Errorf("dynamodb: expected %s on %s within %s, skipped [%s]%s", m, me.Table, timeout, strings.Join(skipped, ", "), me.stopped())
+			return e
+		}
+		if m.Match(e) {
+			return e
+		}
+		skipped = append(skipped, e.String())
+	}
+}
+
+// ExpectNoEvent fails the test if an event arrives within d.
+func (me *StreamWatcher) ExpectNoEvent(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	if e, ok := me.Next(d); ok {
+		t.Errorf("dynamodb: expected no event on %s, got %s", me.Table, e)
+	}
+}
+
+func (me *StreamWatcher) stopped() string {
+	if err := me.Err(); err != nil {
+		return fmt.Sprintf(" (watcher stopped: %s)", err)
+	}
+	return ""
+}
+
+// EventMatcher selects stream events. Zero fields match anything and the attribute maps
+// only need to be contained in the event, e.g.
+// Modified(key).WithNewImage(map[string]types.AttributeValue{"status": ...}).
+type EventMatcher struct {
+	Type     EventType
+	Keys     map[string]types.AttributeValue
+	NewImage map[string]types.AttributeValue
+	OldImage map[string]types.AttributeValue
+	// Where is an additional check, described by Description in failures.
+	Where       func(StreamEvent) bool
+	Description string
+}
+
+func Inserted(keys map[string]types.AttributeValue) EventMatcher {
+	return EventMatcher{Type: EventInsert, Keys: keys}
+}
+
+func Modified(keys map[string]types.AttributeValue) EventMatcher {
+	return EventMatcher{Type: EventModify, Keys: keys}
+}
+
+func Removed(keys map[string]types.AttributeValue) EventMatcher {
+	return EventMatcher{Type: EventRemove, Keys: keys}
+}
+
+func (me EventMatcher) WithNewImage(attrs map[string]types.AttributeValue) EventMatcher {
+	me.NewImage = attrs
+	return me
+}
+
+func (me EventMatcher) WithOldImage(attrs map[string]types.AttributeValue) EventMatcher {
+	me.OldImage = attrs
+	return me
+}
+
+func (me EventMatcher) Match(e StreamEvent) bool {
+	if me.Type != "" && me.Type != e.Type {
+		return false
+	}
+	if !containsAttributes(e.Keys, me.Keys) || !containsAttributes(e.NewImage, me.NewImage) || !containsAttributes(e.OldImage, me.OldImage) {
+		return false
+	}
+	return me.Where == nil || me.Where(e)
+}
+
+func (me EventMatcher) String() string {
+	typ := string(me.Type)
+	if typ == "" {
+		typ = "any event"
+	}
+	parts := []string{typ}
+	for _, p := range []struct {
+		name  string
+		attrs map[string]types.AttributeValue
+	}{{"", me.Keys}, {"new ", me.NewImage}, {"old ", me.OldImage}} {
+		if len(p.attrs) > 0 {
+			b, err := MarshalItemJSON(p.attrs)
+			if err != nil {
+				b = []byte("?")
+			}
+			parts = append(parts, p.name+string(b))
+		}
+	}
+	if me.Description != "" {
+		parts = append(parts, "where "+me.Description)
+	}
+	return strings.Join(parts, " ")
+}
+
+func containsAttributes(have, want map[string]types.AttributeValue) bool {
+	for k, w := range want {
+		h, ok := have[k]
+		if !ok {
+			return false
+		}
+		a, errA := attrFromSDK(h)
+		b, errB := attrFromSDK(w)
+		if errA != nil || errB != nil || !equalValues(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+func fromStreamItem(m map[string]streamtypes.AttributeValue) map[string]types.AttributeValue {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]types.AttributeValue, len(m))
+	for k, v := range m {
+		out[k] = fromStreamValue(v)
+	}
+	return out
+}
+
+func fromStreamValue(av streamtypes.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &types.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberL:
+		l := make([]types.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = fromStreamValue(e)
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	case *streamtypes.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: fromStreamItem(v.Value)}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package dynamodb
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// StructTag is the struct tag TableFromStruct reads the key schema from, next to the
+// attribute names in the dynamodbav tag. It holds comma separated roles:
+//
+//	hash, range              key of the table
+//	gsi:<index>:hash         hash key of a global secondary index
+//	gsi:<index>:range        range key of a global secondary index
+//	lsi:<index>              range key of a local secondary index
+//	ttl                      time to live attribute
+//
+// For example:
+//
+//	type Order struct {
+//		ID       string    `dynamodbav:"pk" dynamo:"hash"`
+//		Created  time.Time `dynamodbav:"created,unixtime" dynamo:"range,gsi:by-status:range"`
+//		Status   string    `dynamodbav:"status" dynamo:"gsi:by-status:hash"`
+//		Expires  int64     `dynamodbav:"expires" dynamo:"ttl"`
+//	}
+const StructTag = "dynamo"
+
+// StructOption configures TableFromStruct.
+type StructOption func(*structConfig)
+
+type structConfig struct {
+	throughput  *types.ProvisionedThroughput
+	stream      types.StreamViewType
+	projections map[string]*types.Projection
+}
+
+// WithProvisionedCapacity makes the table and its global indexes provisioned instead of
+// pay per request.
+func WithProvisionedCapacity(read, write int64) StructOption {
+	return func(c *structConfig) {
+		c.throughput = &types.ProvisionedThroughput{ReadCapacityUnits: ptr.Int64(read), WriteCapacityUnits: ptr.Int64(write)}
+	}
+}
+
+// WithStreamView enables the stream of the table.
+func WithStreamView(view types.StreamViewType) StructOption {
+	return func(c *structConfig) { c.stream = view }
+}
+
+// WithIndexProjection sets the projection of an index, ALL by default.
+func WithIndexProjection(index string, typ types.ProjectionType, nonKey ...string) StructOption {
+	return func(c *structConfig) {
+		p := &types.Projection{ProjectionType: typ}
+		if len(nonKey) > 0 {
+			p.NonKeyAttributes = nonKey
+		}
+		c.projections[index] = p
+	}
+}
+
+type structIndex struct {
+	name              string
+	local             bool
+	hashKey, rangeKey string
+}
+
+// TableFromStruct builds the definition of a table storing T, from the attribute names
+// of its dynamodbav tags and the roles in its StructTag tags. Key attribute types are
+// inferred from the field types, following how attributevalue marshals them.
+func TableFromStruct[T any](name string, opts ...StructOption) (*TableDefinition, error) {
+	cfg := &structConfig{projections: map[string]*types.Projection{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, errors.Errorf("%s is not a struct", typ)
+	}
+
+	def := &TableDefinition{Resource: typ.Name(), Input: &dynamodb.CreateTableInput{TableName: ptr.String(name)}}
+	attrs := map[string]types.ScalarAttributeType{}
+	indexes := map[string]*structIndex{}
+	var hash, rng string
+
+	keyAttr := func(f reflect.StructField, attr string, role string) error {
+		t, err := scalarType(f)
+		if err != nil {
+			return errors.Wrapf(err, "%s.%s is a %s key", typ.Name(), f.Name, role)
+		}
+		if prev, ok := attrs[attr]; ok && prev != t {
+			return errors.Errorf("%s.%s: attribute %s is used as %s and %s", typ.Name(), f.Name, attr, prev, t)
+		}
+		attrs[attr] = t
+		return nil
+	}
+	set := func(dst *string, f reflect.StructField, attr string, role string) error {
+		if *dst != "" && *dst != attr {
+			return errors.Errorf("%s.%s: %s key is already %s", typ.Name(), f.Name, role, *dst)
+		}
+		*dst = attr
+		return keyAttr(f, attr, role)
+	}
+	index := func(name string, local bool, f reflect.StructField) (*structIndex, error) {
+		idx, ok := indexes[name]
+		if !ok {
+			idx = &structIndex{name: name, local: local}
+			indexes[name] = idx
+		}
+		if idx.local != local {
+			return nil, errors.Errorf("%s.%s: index %s is used as both a global and a local index", typ.Name(), f.Name, name)
+		}
+		return idx, nil
+	}
+
+	err := structFields(typ, func(f reflect.StructField, attr string) error {
+		roles := f.Tag.Get(StructTag)
+		if roles == "" {
+			return nil
+		}
+		for _, role := range strings.Split(roles, ",") {
+			parts := strings.Split(strings.TrimSpace(role), ":")
+			var err error
+			switch {
+			case len(parts) == 1 && parts[0] == "hash":
+				err = set(&hash, f, attr, "hash")
+			case len(parts) == 1 && parts[0] == "range":
+				err = set(&rng, f, attr, "range")
+			case len(parts) == 1 && parts[0] == "ttl":
+				if t, err := scalarType(f); err != nil || t != types.ScalarAttributeTypeN {
+					return errors.Errorf("%s.%s: the ttl attribute must marshal to a number, like an int64 or a time.Time with unixtime", typ.Name(), f.Name)
+				}
+				def.TimeToLive = &types.TimeToLiveSpecification{AttributeName: ptr.String(attr), Enabled: ptr.Bool(true)}
+			case len(parts) == 3 && parts[0] == "gsi" && (parts[2] == "hash" || parts[2] == "range"):
+				var idx *structIndex
+				if idx, err = index(parts[1], false, f); err == nil {
+					if parts[2] == "hash" {
+						err = set(&idx.hashKey, f, attr, parts[1]+" hash")
+					} else {
+						err = set(&idx.rangeKey, f, attr, parts[1]+" range")
+					}
+				}
+			case len(parts) == 2 && parts[0] == "lsi":
+				var idx *structIndex
+				if idx, err = index(parts[1], true, f); err == nil {
+					err = set(&idx.rangeKey, f, attr, parts[1]+" range")
+				}
+			default:
+				err = errors.Errorf("%s.%s: unknown %s tag %q", typ.Name(), f.Name, StructTag, role)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hash == "" {
+		return nil, errors.Errorf("%s has no field tagged %s:\"hash\"", typ.Name(), StructTag)
+	}
+
+	in := def.Input
+	in.KeySchema = keySchema(hash, rng)
+	for _, a := range sortedKeysOf(attrs) {
+		in.AttributeDefinitions = append(in.AttributeDefinitions, types.AttributeDefinition{AttributeName: ptr.String(a), AttributeType: attrs[a]})
+	}
+
+	in.BillingMode = types.BillingModePayPerRequest
+	if cfg.throughput != nil {
+		in.BillingMode = types.BillingModeProvisioned
+		in.ProvisionedThroughput = cfg.throughput
+	}
+
+	for _, n := range sortedKeysOf(indexes) {
+		idx := indexes[n]
+		proj := cfg.projections[n]
+		if proj == nil {
+			proj = &types.Projection{ProjectionType: types.ProjectionTypeAll}
+		}
+		delete(cfg.projections, n)
+
+		if idx.local {
+			if rng == "" {
+				return nil, errors.Errorf("local index %s needs a table with a range key", n)
+			}
+			in.LocalSecondaryIndexes = append(in.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+				IndexName:  ptr.String(n),
+				KeySchema:  keySchema(hash, idx.rangeKey),
+				Projection: proj,
+			})
+			continue
+		}
+		if idx.hashKey == "" {
+			return nil, errors.Errorf("global index %s has no hash key", n)
+		}
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:             ptr.String(n),
+			KeySchema:             keySchema(idx.hashKey, idx.rangeKey),
+			Projection:            proj,
+			ProvisionedThroughput: cfg.throughput,
+		})
+	}
+	if unknown := sortedKeysOf(cfg.projections); len(unknown) > 0 {
+		return nil, errors.Errorf("projection for unknown index %s", unknown[0])
+	}
+
+	if cfg.stream != "" {
+		in.StreamSpecification = &types.StreamSpecification{StreamEnabled: ptr.Bool(true), StreamViewType: cfg.stream}
+	}
+
+	return def, nil
+}
+
+// structFields calls fn for every field attributevalue would marshal, with its attribute
+// name. Embedded structs without a name are flattened like attributevalue does.
+func structFields(typ reflect.Type, fn func(f reflect.StructField, attr string) error) error {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("dynamodbav"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := structFields(ft, fn); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if err := fn(f, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var marshalerType = reflect.TypeOf((*attributevalue.Marshaler)(nil)).Elem()
+
+// scalarType is the key attribute type attributevalue marshals the field to.
+func scalarType(f reflect.StructField) (types.ScalarAttributeType, error) {
+	t := f.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	opts := strings.Split(f.Tag.Get("dynamodbav"), ",")[1:]
+	has := func(opt string) bool {
+		for _, o := range opts {
+			if o == opt {
+				return true
+			}
+		}
+		return false
+	}
+
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		av, err := attributevalue.Marshal(reflect.New(t).Interface())
+		if err != nil {
+			return "", errors.Wrapf(err, "marshalling a zero %s", t)
+		}
+		switch av.(type) {
+		case *types.AttributeValueMemberS:
+			return types.ScalarAttributeTypeS, nil
+		case *types.AttributeValueMemberN:
+			return types.ScalarAttributeTypeN, nil
+		case *types.AttributeValueMemberB:
+			return types.ScalarAttributeTypeB, nil
+		}
+		return "", errors.Errorf("%s does not marshal to a string, number or binary", t)
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		if has("unixtime") {
+			return types.ScalarAttributeTypeN, nil
+		}
+		return types.ScalarAttributeTypeS, nil
+	case t.Kind() == reflect.String:
+		return types.ScalarAttributeTypeS, nil
+	case isNumberKind(t.Kind()):
+		if has("string") {
+			return types.ScalarAttributeTypeS, nil
+		}
+		return types.ScalarAttributeTypeN, nil
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8:
+		return types.ScalarAttributeTypeB, nil
+	}
+	return "", errors.Errorf("key attributes must be strings, numbers or binary, not %s", f.Type)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
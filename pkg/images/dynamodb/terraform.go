@@ -0,0 +1,687 @@
+package dynamodb
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// ParseTerraform returns the aws_dynamodb_table resources of a terraform file. It is a
+// small hcl reader rather than a full implementation: literals, lists, objects, blocks
+// and var./local. references (including ${} interpolation) are understood, anything
+// else is kept as an opaque expression and is only an error when a table field needs
+// its value. Variables resolve to their defaults unless overridden in vars.
+func ParseTerraform(b []byte, vars map[string]string) ([]*TableDefinition, error) {
+	p, err := newHCLParser(string(b))
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody(false)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &hclEvaluator{vars: map[string]*hclValue{}, locals: map[string]*hclValue{}, resolving: map[string]bool{}}
+	for _, blk := range body.blocks {
+		switch {
+		case blk.typ == "variable" && len(blk.labels) == 1:
+			if def, ok := blk.body.attrs["default"]; ok {
+				ev.vars[blk.labels[0]] = def
+			}
+		case blk.typ == "locals":
+			for k, v := range blk.body.attrs {
+				ev.locals[k] = v
+			}
+		}
+	}
+	for k, v := range vars {
+		ev.vars[k] = &hclValue{kind: hclLiteral, text: v}
+	}
+
+	defs := []*TableDefinition{}
+	for _, blk := range body.blocks {
+		if blk.typ != "resource" || len(blk.labels) != 2 || blk.labels[0] != "aws_dynamodb_table" {
+			continue
+		}
+		def, err := ev.table(blk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "aws_dynamodb_table.%s", blk.labels[1])
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// ---------------------------------------------------------------------------
+// lexer
+
+type hclTokenKind int
+
+const (
+	hclEOF hclTokenKind = iota
+	hclNewline
+	hclIdent
+	hclNumber
+	hclString
+	hclPunct
+)
+
+type hclToken struct {
+	kind       hclTokenKind
+	text       string
+	start, end int
+	line       int
+}
+
+func lexHCL(src string) ([]hclToken, error) {
+	toks := []hclToken{}
+	line := 1
+	i := 0
+	emit := func(kind hclTokenKind, text string, start int) {
+		toks = append(toks, hclToken{kind: kind, text: text, start: start, end: i, line: line})
+	}
+
+	for i < len(src) {
+		c := src[i]
+		start := i
+		switch {
+		case c == '\n':
+			i++
+			emit(hclNewline, "\n", start)
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || (c == '/' && i+1 < len(src) && src[i+1] == '/'):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			s, n, err := lexHCLString(src[i:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", line)
+			}
+			i += n
+			emit(hclString, s, start)
+			line += strings.Count(src[start:i], "\n")
+		case c == '<' && strings.HasPrefix(src[i:], "<<"):
+			nl := strings.IndexByte(src[i:], '\n')
+			if nl < 0 {
+				return nil, errors.Errorf("line %d: unterminated heredoc", line)
+			}
+			marker := strings.TrimPrefix(strings.TrimSpace(src[i+2:i+nl]), "-")
+			rest := src[i+nl+1:]
+			lines := strings.SplitAfter(rest, "\n")
+			consumed := 0
+			content := []string{}
+			found := false
+			for _, l := range lines {
+				consumed += len(l)
+				if strings.TrimSpace(l) == marker {
+					found = true
+					break
+				}
+				content = append(content, l)
+			}
+			if !found {
+				return nil, errors.Errorf("line %d: unterminated heredoc %s", line, marker)
+			}
+			i += nl + 1 + consumed
+			emit(hclString, strings.TrimSuffix(strings.Join(content, ""), "\n"), start)
+			line += strings.Count(src[start:i], "\n")
+			if strings.HasSuffix(src[start:i], "\n") {
+				// the heredoc ends its line, keep the attribute terminated
+				toks = append(toks, hclToken{kind: hclNewline, text: "\n", start: i - 1, end: i, line: line - 1})
+			}
+		case c >= '0' && c <= '9':
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.' || src[i] == 'e' || src[i] == 'E') {
+				i++
+			}
+			emit(hclNumber, src[start:i], start)
+		case c == '_' || unicode.IsLetter(rune(c)):
+			for i < len(src) && (src[i] == '_' || src[i] == '-' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			emit(hclIdent, src[start:i], start)
+		default:
+			n := 1
+			for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "=>", "..."} {
+				if strings.HasPrefix(src[i:], op) {
+					n = len(op)
+					break
+				}
+			}
+			i += n
+			emit(hclPunct, src[start:i], start)
+		}
+	}
+	toks = append(toks, hclToken{kind: hclEOF, start: len(src), end: len(src), line: line})
+	return toks, nil
+}
+
+// lexHCLString reads a quoted template, keeping ${...} sequences verbatim so they can
+// be interpolated later. It returns the decoded text and the bytes consumed.
+func lexHCLString(src string) (string, int, error) {
+	var sb strings.Builder
+	i := 1
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '"':
+			return sb.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, errors.New("unterminated string")
+		case c == '\\' && i+1 < len(src):
+			switch src[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				sb.WriteByte(src[i+1])
+			}
+			i += 2
+		case (c == '$' || c == '%') && i+1 < len(src) && src[i+1] == '{':
+			// copy the interpolation, including any nested strings, up to its closing brace
+			depth := 0
+			j := i + 1
+			for j < len(src) {
+				switch src[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				case '"':
+					if _, n, err := lexHCLString(src[j:]); err == nil {
+						j += n - 1
+					}
+				}
+				j++
+				if depth == 0 {
+					break
+				}
+			}
+			if depth != 0 {
+				return "", 0, errors.New("unterminated interpolation")
+			}
+			sb.WriteString(src[i:j])
+			i = j
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// ---------------------------------------------------------------------------
+// parser
+
+type hclKind int
+
+const (
+	hclLiteral hclKind = iota // numbers, bools, null and variable overrides
+	hclTemplate
+	hclList
+	hclObject
+	hclRef
+	hclRaw
+)
+
+type hclValue struct {
+	kind hclKind
+	text string
+	list []*hclValue
+	obj  map[string]*hclValue
+	line int
+}
+
+type hclBody struct {
+	attrs  map[string]*hclValue
+	blocks []*hclBlock
+}
+
+type hclBlock struct {
+	typ    string
+	labels []string
+	body   *hclBody
+	line   int
+}
+
+type hclParser struct {
+	src  string
+	toks []hclToken
+	pos  int
+}
+
+func newHCLParser(src string) (*hclParser, error) {
+	toks, err := lexHCL(src)
+	if err != nil {
+		return nil, err
+	}
+	return &hclParser{src: src, toks: toks}, nil
+}
+
+func (me *hclParser) peek() hclToken {
+	return me.toks[me.pos]
+}
+
+func (me *hclParser) next() hclToken {
+	t := me.toks[me.pos]
+	if t.kind != hclEOF {
+		me.pos++
+	}
+	return t
+}
+
+func (me *hclParser) skipNewlines() {
+	for me.peek().kind == hclNewline {
+		me.pos++
+	}
+}
+
+func (me *hclParser) isPunct(p string) bool {
+	t := me.peek()
+	return t.kind == hclPunct && t.text == p
+}
+
+func (me *hclParser) parseBody(nested bool) (*hclBody, error) {
+	body := &hclBody{attrs: map[string]*hclValue{}}
+	for {
+		me.skipNewlines()
+		t := me.peek()
+		switch {
+		case t.kind == hclEOF:
+			if nested {
+				return nil, errors.Errorf("line %d: missing closing brace", t.line)
+			}
+			return body, nil
+		case nested && t.kind == hclPunct && t.text == "}":
+			me.next()
+			return body, nil
+		case t.kind != hclIdent:
+			return nil, errors.Errorf("line %d: unexpected %q", t.line, t.text)
+		}
+
+		name := me.next()
+		if me.isPunct("=") {
+			me.next()
+			v, err := me.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			body.attrs[name.text] = v
+			continue
+		}
+
+		blk := &hclBlock{typ: name.text, line: name.line}
+		for !me.isPunct("{") {
+			l := me.next()
+			if l.kind != hclString && l.kind != hclIdent {
+				return nil, errors.Errorf("line %d: unexpected %q in block header", l.line, l.text)
+			}
+			blk.labels = append(blk.labels, l.text)
+		}
+		me.next()
+		b, err := me.parseBody(true)
+		if err != nil {
+			return nil, err
+		}
+		blk.body = b
+		body.blocks = append(body.blocks, blk)
+	}
+}
+
+func (me *hclParser) atTerminator() bool {
+	t := me.peek()
+	if t.kind == hclEOF || t.kind == hclNewline {
+		return true
+	}
+	return t.kind == hclPunct && (t.text == "," || t.text == "]" || t.text == "}" || t.text == ")")
+}
+
+// parseExpr reads one expression. Expressions that are not simple values become raw
+// values holding their source text.
+func (me *hclParser) parseExpr() (*hclValue, error) {
+	start := me.pos
+	v, err := me.parsePrimary()
+	if err == nil && me.atTerminator() {
+		return v, nil
+	}
+	me.pos = start
+	return me.parseRaw()
+}
+
+func (me *hclParser) parseRaw() (*hclValue, error) {
+	first := me.peek()
+	depth := 0
+	for {
+		t := me.peek()
+		if t.kind == hclEOF {
+			if depth > 0 {
+				return nil, errors.Errorf("line %d: unbalanced brackets", first.line)
+			}
+			break
+		}
+		if depth == 0 && me.atTerminator() {
+			break
+		}
+		if t.kind == hclPunct {
+			switch t.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+		}
+		me.next()
+	}
+	end := me.toks[me.pos].start
+	if me.pos > 0 {
+		end = me.toks[me.pos-1].end
+	}
+	text := strings.TrimSpace(me.src[first.start:end])
+	if text == "" {
+		return nil, errors.Errorf("line %d: expected an expression", first.line)
+	}
+	return &hclValue{kind: hclRaw, text: text, line: first.line}, nil
+}
+
+func (me *hclParser) parsePrimary() (*hclValue, error) {
+	t := me.next()
+	switch t.kind {
+	case hclString:
+		return &hclValue{kind: hclTemplate, text: t.text, line: t.line}, nil
+	case hclNumber:
+		return &hclValue{kind: hclLiteral, text: t.text, line: t.line}, nil
+	case hclIdent:
+		switch t.text {
+		case "true", "false":
+			return &hclValue{kind: hclLiteral, text: t.text, line: t.line}, nil
+		case "null":
+			return &hclValue{kind: hclLiteral, line: t.line}, nil
+		}
+		ref := t.text
+		for me.isPunct(".") {
+			me.next()
+			n := me.next()
+			if n.kind != hclIdent {
+				return nil, errors.Errorf("line %d: bad reference", n.line)
+			}
+			ref += "." + n.text
+		}
+		return &hclValue{kind: hclRef, text: ref, line: t.line}, nil
+	case hclPunct:
+		switch t.text {
+		case "[":
+			v := &hclValue{kind: hclList, line: t.line}
+			for {
+				me.skipNewlines()
+				if me.isPunct("]") {
+					me.next()
+					return v, nil
+				}
+				e, err := me.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				v.list = append(v.list, e)
+				me.skipNewlines()
+				if me.isPunct(",") {
+					me.next()
+				} else if !me.isPunct("]") {
+					return nil, errors.Errorf("line %d: expected , or ]", me.peek().line)
+				}
+			}
+		case "{":
+			v := &hclValue{kind: hclObject, obj: map[string]*hclValue{}, line: t.line}
+			for {
+				me.skipNewlines()
+				if me.isPunct("}") {
+					me.next()
+					return v, nil
+				}
+				k := me.next()
+				if k.kind != hclIdent && k.kind != hclString {
+					return nil, errors.Errorf("line %d: bad object key", k.line)
+				}
+				if !me.isPunct("=") && !me.isPunct(":") {
+					return nil, errors.Errorf("line %d: expected = or :", k.line)
+				}
+				me.next()
+				e, err := me.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				v.obj[k.text] = e
+				if me.isPunct(",") {
+					me.next()
+				}
+			}
+		}
+	}
+	return nil, errors.Errorf("line %d: unexpected %q", t.line, t.text)
+}
+
+// ---------------------------------------------------------------------------
+// evaluation
+
+type hclEvaluator struct {
+	vars      map[string]*hclValue
+	locals    map[string]*hclValue
+	resolving map[string]bool
+}
+
+func (me *hclEvaluator) ref(name string, line int) (*hclValue, error) {
+	var v *hclValue
+	var ok bool
+	switch {
+	case strings.HasPrefix(name, "var."):
+		v, ok = me.vars[strings.TrimPrefix(name, "var.")]
+	case strings.HasPrefix(name, "local."):
+		v, ok = me.locals[strings.TrimPrefix(name, "local.")]
+	}
+	if !ok {
+		return nil, errors.Errorf("line %d: cannot resolve %s", line, name)
+	}
+	if me.resolving[name] {
+		return nil, errors.Errorf("line %d: %s refers to itself", line, name)
+	}
+	return v, nil
+}
+
+func (me *hclEvaluator) str(v *hclValue) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	switch v.kind {
+	case hclLiteral:
+		return v.text, nil
+	case hclRef:
+		target, err := me.ref(v.text, v.line)
+		if err != nil {
+			return "", err
+		}
+		me.resolving[v.text] = true
+		defer delete(me.resolving, v.text)
+		return me.str(target)
+	case hclTemplate:
+		var sb strings.Builder
+		s := v.text
+		for {
+			i := strings.Index(s, "${")
+			if i < 0 {
+				sb.WriteString(s)
+				break
+			}
+			if i > 0 && s[i-1] == '$' {
+				sb.WriteString(s[:i-1] + "${")
+				s = s[i+2:]
+				continue
+			}
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", errors.Errorf("line %d: unterminated interpolation", v.line)
+			}
+			inner := strings.TrimSpace(s[i+2 : i+end])
+			r, err := me.str(&hclValue{kind: hclRef, text: inner, line: v.line})
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s[:i] + r)
+			s = s[i+end+1:]
+		}
+		return sb.String(), nil
+	}
+	return "", errors.Errorf("line %d: cannot evaluate %s", v.line, v.text)
+}
+
+func (me *hclEvaluator) list(v *hclValue) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if v.kind == hclRef {
+		target, err := me.ref(v.text, v.line)
+		if err != nil {
+			return nil, err
+		}
+		return me.list(target)
+	}
+	if v.kind != hclList {
+		return nil, errors.Errorf("line %d: expected a list", v.line)
+	}
+	out := make([]string, 0, len(v.list))
+	for _, e := range v.list {
+		s, err := me.str(e)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (me *hclEvaluator) attr(b *hclBody, name string) (string, error) {
+	s, err := me.str(b.attrs[name])
+	return s, errors.Wrap(err, name)
+}
+
+func (me *hclEvaluator) table(blk *hclBlock) (*TableDefinition, error) {
+	b := &tableBuilder{resource: blk.labels[1], attrs: map[string]string{}, tags: map[string]string{}}
+	body := blk.body
+	var err error
+
+	for _, f := range []struct {
+		name string
+		dst  *string
+	}{
+		{"name", &b.name},
+		{"billing_mode", &b.billingMode},
+		{"read_capacity", &b.read},
+		{"write_capacity", &b.write},
+		{"hash_key", &b.hashKey},
+		{"range_key", &b.rangeKey},
+	} {
+		if *f.dst, err = me.attr(body, f.name); err != nil {
+			return nil, err
+		}
+	}
+
+	streamEnabled, err := me.attr(body, "stream_enabled")
+	if err != nil {
+		return nil, err
+	}
+	if streamEnabled == "true" {
+		if b.streamView, err = me.attr(body, "stream_view_type"); err != nil {
+			return nil, err
+		}
+		if b.streamView == "" {
+			return nil, errors.New("stream_view_type is required when stream_enabled is true")
+		}
+	}
+
+	// tags are informational, so ones built with functions like merge() are skipped
+	if tags := body.attrs["tags"]; tags != nil && tags.kind == hclObject {
+		for k, v := range tags.obj {
+			if s, err := me.str(v); err == nil {
+				b.tags[k] = s
+			}
+		}
+	}
+
+	for _, sub := range body.blocks {
+		sb := sub.body
+		switch sub.typ {
+		case "attribute":
+			name, err := me.attr(sb, "name")
+			if err != nil {
+				return nil, err
+			}
+			typ, err := me.attr(sb, "type")
+			if err != nil {
+				return nil, err
+			}
+			b.attrs[name] = typ
+		case "ttl":
+			if b.ttlAttr, err = me.attr(sb, "attribute_name"); err != nil {
+				return nil, err
+			}
+			enabled, err := me.attr(sb, "enabled")
+			if err != nil {
+				return nil, err
+			}
+			b.ttlEnabled = enabled != "false"
+		case "point_in_time_recovery":
+			enabled, err := me.attr(sb, "enabled")
+			if err != nil {
+				return nil, err
+			}
+			b.pitr = enabled == "true"
+		case "global_secondary_index", "local_secondary_index":
+			vals := map[string]string{}
+			for _, f := range []string{"name", "hash_key", "range_key", "read_capacity", "write_capacity", "projection_type"} {
+				if vals[f], err = me.attr(sb, f); err != nil {
+					return nil, errors.Wrapf(err, "%s line %d", sub.typ, sub.line)
+				}
+			}
+			nonKey, err := me.list(sb.attrs["non_key_attributes"])
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s", sub.typ, vals["name"])
+			}
+			proj := projection(vals["projection_type"], nonKey)
+			if sub.typ == "local_secondary_index" {
+				b.lsis = append(b.lsis, types.LocalSecondaryIndex{
+					IndexName:  ptr.String(vals["name"]),
+					KeySchema:  keySchema(b.hashKey, vals["range_key"]),
+					Projection: proj,
+				})
+				continue
+			}
+			tp, err := throughput(vals["read_capacity"], vals["write_capacity"])
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s", sub.typ, vals["name"])
+			}
+			b.gsis = append(b.gsis, types.GlobalSecondaryIndex{
+				IndexName:             ptr.String(vals["name"]),
+				KeySchema:             keySchema(vals["hash_key"], vals["range_key"]),
+				Projection:            proj,
+				ProvisionedThroughput: tp,
+			})
+		case "dynamic":
+			return nil, errors.Errorf("line %d: dynamic blocks are not supported", sub.line)
+		}
+	}
+
+	return b.build()
+}
@@ -0,0 +1,226 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+)
+
+// TruncateParallelism is the default number of scan segments Truncate deletes from at once.
+var TruncateParallelism = 4
+
+// ResetResult reports what Truncate and ResetToSeed did.
+type ResetResult struct {
+	Table   string
+	Deleted int
+	Seeded  int
+	Elapsed time.Duration
+}
+
+func (me *ResetResult) String() string {
+	return fmt.Sprintf("%s: deleted %d, seeded %d in %s", me.Table, me.Deleted, me.Seeded, me.Elapsed.Round(time.Millisecond))
+}
+
+// TruncateOption configures Truncate and ResetToSeed.
+type TruncateOption func(*truncateConfig)
+
+type truncateConfig struct {
+	parallelism int
+}
+
+// WithParallelism sets how many scan segments are read and deleted concurrently.
+func WithParallelism(n int) TruncateOption {
+	return func(c *truncateConfig) { c.parallelism = n }
+}
+
+// Truncate deletes every item of the table, which is much faster than deleting and
+// recreating it on dynamodb-local. The table is read with a parallel scan of only the key
+// attributes and every segment deletes what it read in batches of 25.
+func Truncate(ctx context.Context, cli *dynamodb.Client, table string, opts ...TruncateOption) (*ResetResult, error) {
+	cfg := &truncateConfig{parallelism: TruncateParallelism}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.parallelism < 1 {
+		cfg.parallelism = 1
+	}
+
+	start := time.Now()
+
+	keys, err := keyNames(ctx, cli, table)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	projection := ""
+	for i, k := range keys {
+		placeholder := fmt.Sprintf("#k%d", i)
+		names[placeholder] = k
+		if projection != "" {
+			projection += ", "
+		}
+		projection += placeholder
+	}
+
+	var deleted atomic.Int64
+	grp, gctx := errgroup.WithContext(ctx)
+	for segment := 0; segment < cfg.parallelism; segment++ {
+		segment := segment
+		grp.Go(func() error {
+			pages := dynamodb.NewScanPaginator(cli, &dynamodb.ScanInput{
+				TableName:                ptr.String(table),
+				ProjectionExpression:     ptr.String(projection),
+				ExpressionAttributeNames: names,
+				Segment:                  ptr.Int32(int32(segment)),
+				TotalSegments:            ptr.Int32(int32(cfg.parallelism)),
+			})
+			batch := make([]types.WriteRequest, 0, seedBatchSize)
+			flush := func() error {
+				if len(batch) == 0 {
+					return nil
+				}
+				if err := writeBatch(gctx, cli, table, batch); err != nil {
+					return err
+				}
+				deleted.Add(int64(len(batch)))
+				batch = batch[:0]
+				return nil
+			}
+			for pages.HasMorePages() {
+				page, err := pages.NextPage(gctx)
+				if err != nil {
+					return errors.Wrapf(err, "scanning segment %d of %s", segment, table)
+				}
+				for _, key := range page.Items {
+					batch = append(batch, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}})
+					if len(batch) == seedBatchSize {
+						if err := flush(); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return flush()
+		})
+	}
+
+	err = grp.Wait()
+	res := &ResetResult{Table: table, Deleted: int(deleted.Load()), Elapsed: time.Since(start)}
+	if err != nil {
+		return res, errors.Wrapf(err, "truncating %s", table)
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("table", table).Int("deleted", res.Deleted).Dur("elapsed", res.Elapsed).Msg("truncated table")
+	return res, nil
+}
+
+// seedKey scopes a registered seed to the client it was registered for, so tests that
+// share a table name but not an emulator or namespace do not see each other's seeds.
+type seedKey struct {
+	cli   *dynamodb.Client
+	table string
+}
+
+type registeredSeed struct {
+	fromFile bool
+	source   string
+	items    []map[string]types.AttributeValue
+}
+
+var seeds = struct {
+	sync.Mutex
+	byKey map[seedKey]*registeredSeed
+}{byKey: map[seedKey]*registeredSeed{}}
+
+func registerSeed(cli *dynamodb.Client, table string, seed *registeredSeed) func() {
+	key := seedKey{cli: cli, table: table}
+	seeds.Lock()
+	defer seeds.Unlock()
+	seeds.byKey[key] = seed
+	return func() {
+		seeds.Lock()
+		defer seeds.Unlock()
+		if seeds.byKey[key] == seed {
+			delete(seeds.byKey, key)
+		}
+	}
+}
+
+// RegisterSeed makes ResetToSeed with cli load the table from a seed file or directory.
+// It is read again on every reset, so templates like {{uuid}} give new values each
+// time. The returned function removes the registration.
+func RegisterSeed(cli *dynamodb.Client, table string, source string) (unregister func()) {
+	return registerSeed(cli, table, &registeredSeed{fromFile: true, source: source})
+}
+
+// RegisterSeedItems makes ResetToSeed with cli load the table with these items. The
+// returned function removes the registration.
+func RegisterSeedItems(cli *dynamodb.Client, table string, items []map[string]types.AttributeValue) (unregister func()) {
+	return registerSeed(cli, table, &registeredSeed{items: items})
+}
+
+// RegisterSeedT registers the seed for the lifetime of the test.
+func RegisterSeedT(t testing.TB, cli *dynamodb.Client, table string, source string) {
+	t.Helper()
+	t.Cleanup(RegisterSeed(cli, table, source))
+}
+
+// RegisterSeedItemsT registers the seed items for the lifetime of the test.
+func RegisterSeedItemsT(t testing.TB, cli *dynamodb.Client, table string, items []map[string]types.AttributeValue) {
+	t.Helper()
+	t.Cleanup(RegisterSeedItems(cli, table, items))
+}
+
+// ResetToSeed truncates the table and seeds it again with the seed registered for cli,
+// or from SeedDirectory if none was registered.
+func ResetToSeed(ctx context.Context, cli *dynamodb.Client, table string, opts ...TruncateOption) (*ResetResult, error) {
+	start := time.Now()
+
+	res, err := Truncate(ctx, cli, table, opts...)
+	if err != nil {
+		return res, err
+	}
+
+	seeds.Lock()
+	seed := seeds.byKey[seedKey{cli: cli, table: table}]
+	seeds.Unlock()
+
+	switch {
+	case seed != nil && seed.fromFile:
+		res.Seeded, err = Seed(ctx, cli, table, seed.source)
+	case seed != nil:
+		res.Seeded, err = SeedItems(ctx, cli, table, seed.items)
+	default:
+		res.Seeded, err = SeedFromTestdata(ctx, cli, table)
+	}
+	res.Elapsed = time.Since(start)
+	if err != nil {
+		return res, errors.Wrapf(err, "seeding %s", table)
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("table", table).Int("deleted", res.Deleted).Int("seeded", res.Seeded).Dur("elapsed", res.Elapsed).Msg("reset table")
+	return res, nil
+}
+
+// ResetToSeedT resets the table and fails the test if that does not work.
+func ResetToSeedT(t testing.TB, ctx context.Context, cli *dynamodb.Client, table string, opts ...TruncateOption) *ResetResult {
+	t.Helper()
+
+	res, err := ResetToSeed(ctx, cli, table, opts...)
+	if err != nil {
+		t.Fatalf("dynamodb: resetting %s: %s", table, err)
+	}
+	t.Logf("dynamodb: %s", res)
+	return res
+}
@@ -0,0 +1,329 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/walteh/testrc/pkg/docker"
+)
+
+// Usage counts the calls of clients per operation and table and adds up the capacity
+// units they consumed. Its middleware asks for ReturnConsumedCapacity=TOTAL on every
+// call and removes the consumed capacity from outputs again unless the caller asked for
+// it, so code under test sees the responses it expects.
+type Usage struct {
+	mu    sync.Mutex
+	ops   map[usageKey]*OperationUsage
+	calls map[string]int
+}
+
+type usageKey struct {
+	operation, table string
+}
+
+// OperationUsage is the usage of one operation on one table. A call on several tables,
+// e.g. a BatchWriteItem, is a call on each of them.
+type OperationUsage struct {
+	Operation  string  `json:"operation"`
+	Table      string  `json:"table,omitempty"`
+	Calls      int     `json:"calls"`
+	ReadUnits  float64 `json:"read_units,omitempty"`
+	WriteUnits float64 `json:"write_units,omitempty"`
+}
+
+// UsageReport is the usage of clients, ordered by operation and table. Calls counts
+// every call once, however many tables it touched.
+type UsageReport struct {
+	Test       string            `json:"test,omitempty"`
+	Calls      int               `json:"calls"`
+	ReadUnits  float64           `json:"read_units"`
+	WriteUnits float64           `json:"write_units"`
+	Operations []*OperationUsage `json:"operations"`
+
+	calls map[string]int
+}
+
+// NewUsage returns an empty usage.
+func NewUsage() *Usage {
+	return &Usage{ops: map[usageKey]*OperationUsage{}, calls: map[string]int{}}
+}
+
+// APIOption adds the accounting to a stack, e.g. appended to aws.Config.APIOptions.
+func (me *Usage) APIOption() func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("testrc.usage", me.handle), middleware.After)
+	}
+}
+
+// ClientOption adds the accounting to a client, e.g. img.NewClient(usage.ClientOption()).
+func (me *Usage) ClientOption() func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, me.APIOption())
+	}
+}
+
+// Reset forgets the usage so far.
+func (me *Usage) Reset() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.ops = map[usageKey]*OperationUsage{}
+	me.calls = map[string]int{}
+}
+
+// Report returns the usage so far.
+func (me *Usage) Report() *UsageReport {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	rep := &UsageReport{Operations: make([]*OperationUsage, 0, len(me.ops)), calls: map[string]int{}}
+	for op, n := range me.calls {
+		rep.calls[op] = n
+		rep.Calls += n
+	}
+	for _, u := range me.ops {
+		c := *u
+		rep.Operations = append(rep.Operations, &c)
+		rep.ReadUnits += c.ReadUnits
+		rep.WriteUnits += c.WriteUnits
+	}
+	sort.Slice(rep.Operations, func(i, j int) bool {
+		a, b := rep.Operations[i], rep.Operations[j]
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		return a.Table < b.Table
+	})
+	return rep
+}
+
+// Count returns the calls of an operation, on any of tables or on all tables when none
+// are given.
+func (me *UsageReport) Count(operation string, tables ...string) int {
+	if n, ok := me.calls[operation]; ok && len(tables) == 0 {
+		return n
+	}
+	n := 0
+	for _, u := range me.Operations {
+		if u.Operation == operation && matchesAny(tables, u.Table) {
+			n += u.Calls
+		}
+	}
+	return n
+}
+
+func (me *UsageReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d calls, %g read units, %g write units", me.Calls, me.ReadUnits, me.WriteUnits)
+	for _, u := range me.Operations {
+		fmt.Fprintf(&b, "\n    %s", u.Operation)
+		if u.Table != "" {
+			fmt.Fprintf(&b, " %s", u.Table)
+		}
+		fmt.Fprintf(&b, ": %d calls", u.Calls)
+		if u.ReadUnits > 0 {
+			fmt.Fprintf(&b, ", %g read units", u.ReadUnits)
+		}
+		if u.WriteUnits > 0 {
+			fmt.Fprintf(&b, ", %g write units", u.WriteUnits)
+		}
+	}
+	return b.String()
+}
+
+var consumedCapacityType = reflect.TypeOf(types.ReturnConsumedCapacity(""))
+
+// askConsumedCapacity returns params asking for the total consumed capacity, copying them
+// when the caller did not ask for it so the caller's input is left alone, and whether the
+// caller asked.
+func askConsumedCapacity(params any) (any, bool) {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return params, true
+	}
+	f := v.Elem().FieldByName("ReturnConsumedCapacity")
+	if !f.IsValid() || f.Type() != consumedCapacityType {
+		return params, true
+	}
+	if mode := types.ReturnConsumedCapacity(f.String()); mode != "" && mode != types.ReturnConsumedCapacityNone {
+		return params, true
+	}
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	cp.Elem().FieldByName("ReturnConsumedCapacity").Set(reflect.ValueOf(types.ReturnConsumedCapacityTotal))
+	return cp.Interface(), false
+}
+
+// consumedCapacityOf returns the consumed capacity of an output and, with strip, removes
+// it from the output.
+func consumedCapacityOf(result any, strip bool) []*types.ConsumedCapacity {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.Elem().FieldByName("ConsumedCapacity")
+	if !f.IsValid() {
+		return nil
+	}
+	var caps []*types.ConsumedCapacity
+	switch cc := f.Interface().(type) {
+	case *types.ConsumedCapacity:
+		if cc != nil {
+			caps = append(caps, cc)
+		}
+	case []types.ConsumedCapacity:
+		for i := range cc {
+			caps = append(caps, &cc[i])
+		}
+	}
+	if strip {
+		f.Set(reflect.Zero(f.Type()))
+	}
+	return caps
+}
+
+// capacityUnits splits consumed capacity into read and write units, attributing the
+// total to the kind of operation when the service does not split it.
+func capacityUnits(op string, cc *types.ConsumedCapacity) (read, write float64) {
+	read, write = ptr.ToFloat64(cc.ReadCapacityUnits), ptr.ToFloat64(cc.WriteCapacityUnits)
+	if read == 0 && write == 0 {
+		if writeOperations[op] {
+			write = ptr.ToFloat64(cc.CapacityUnits)
+		} else {
+			read = ptr.ToFloat64(cc.CapacityUnits)
+		}
+	}
+	return read, write
+}
+
+func (me *Usage) handle(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	op := awsmiddleware.GetOperationName(ctx)
+	tables := tablesOf(in.Parameters)
+
+	var asked bool
+	in.Parameters, asked = askConsumedCapacity(in.Parameters)
+
+	out, md, err := next.HandleInitialize(ctx, in)
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.calls[op]++
+	if len(tables) == 0 {
+		tables = []string{""}
+	}
+	for _, t := range tables {
+		me.operation(op, t).Calls++
+	}
+	if err != nil {
+		return out, md, err
+	}
+
+	for _, cc := range consumedCapacityOf(out.Result, !asked) {
+		u := me.operation(op, ptr.ToString(cc.TableName))
+		read, write := capacityUnits(op, cc)
+		u.ReadUnits += read
+		u.WriteUnits += write
+	}
+	return out, md, err
+}
+
+func (me *Usage) operation(op, table string) *OperationUsage {
+	k := usageKey{op, table}
+	u, ok := me.ops[k]
+	if !ok {
+		u = &OperationUsage{Operation: op, Table: table}
+		me.ops[k] = u
+	}
+	return u
+}
+
+// UsageLimit is an assertion on a usage report, returning why it failed.
+type UsageLimit func(*UsageReport) error
+
+// MaxCalls allows at most n calls of an operation, on any of tables or on all tables
+// when none are given. MaxCalls("GetItem", 10) catches n+1 access patterns.
+func MaxCalls(operation string, n int, tables ...string) UsageLimit {
+	return func(r *UsageReport) error {
+		if got := r.Count(operation, tables...); got > n {
+			return errors.Errorf("%d %s calls, at most %d allowed", got, operation, n)
+		}
+		return nil
+	}
+}
+
+// MaxScans allows at most n Scan calls, counting every page.
+func MaxScans(n int, tables ...string) UsageLimit {
+	return MaxCalls("Scan", n, tables...)
+}
+
+// MaxRCU allows at most units read capacity units in total.
+func MaxRCU(units float64) UsageLimit {
+	return func(r *UsageReport) error {
+		if r.ReadUnits > units {
+			return errors.Errorf("%g read units consumed, at most %g allowed", r.ReadUnits, units)
+		}
+		return nil
+	}
+}
+
+// MaxWCU allows at most units write capacity units in total.
+func MaxWCU(units float64) UsageLimit {
+	return func(r *UsageReport) error {
+		if r.WriteUnits > units {
+			return errors.Errorf("%g write units consumed, at most %g allowed", r.WriteUnits, units)
+		}
+		return nil
+	}
+}
+
+// AssertUsage fails the test for every limit the usage so far is over.
+func (me *Usage) AssertUsage(t testing.TB, limits ...UsageLimit) bool {
+	t.Helper()
+
+	rep := me.Report()
+	ok := true
+	for _, l := range limits {
+		if err := l(rep); err != nil {
+			t.Errorf("dynamodb: %s\n%s", err, rep)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// UsageResultName is the name of the usage of a test in the timing report.
+const UsageResultName = "dynamodb_usage"
+
+// UsageT counts the usage of clients built with the returned usage's ClientOption for
+// the lifetime of the test. On cleanup the report is written to the test log as json and
+// recorded as the test's "dynamodb_usage" in the timing report docker.FinishRun writes to
+// TESTRC_TIMINGS_FILE, and checked against limits.
+func UsageT(t testing.TB, limits ...UsageLimit) *Usage {
+	t.Helper()
+
+	usage := NewUsage()
+	t.Cleanup(func() {
+		rep := usage.Report()
+		rep.Test = t.Name()
+		if b, err := json.Marshal(rep); err == nil {
+			t.Logf("dynamodb usage: %s", b)
+		}
+		if err := docker.RecordTestResult(t.Name(), UsageResultName, rep); err != nil {
+			t.Errorf("dynamodb: recording usage: %s", err)
+		}
+		usage.AssertUsage(t, limits...)
+	})
+	return usage
+}
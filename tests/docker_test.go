@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	dockerapi "github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/testrc/pkg/docker"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitContainerRequestAdapter(t *testing.T) {
+
+	req := docker.ContainerRequest{
+		Image:        "localhost:5000/redis:7",
+		ExposedPorts: []string{"6379/tcp", "8001/tcp"},
+		Env:          map[string]string{"B": "2", "A": "1"},
+		Cmd:          []string{"redis-server", "--appendonly yes"},
+		WaitingFor:   docker.ForListeningPort("6379/tcp"),
+	}
+
+	img, err := docker.FromRequest(req)
+	require.NoError(t, err)
+
+	require.Equal(t, "localhost:5000/redis:7", img.Tag())
+	require.Equal(t, 6379, img.HttpPort())
+	require.Equal(t, 8001, img.HttpsPort())
+	require.Equal(t, []string{"A=1", "B=2"}, img.EnvVars())
+	require.Equal(t, req.Cmd, img.Cmd())
+
+	back := docker.ToRequest(img)
+	require.Equal(t, req.Image, back.Image)
+	require.Equal(t, req.Env, back.Env)
+	require.Equal(t, req.Cmd, back.Cmd)
+	require.Equal(t, req.ExposedPorts, back.ExposedPorts)
+	require.NotNil(t, back.WaitingFor)
+
+	dyn := docker.ToRequest(&dynamodb_image.DockerImage{})
+	require.Equal(t, "amazon/dynamodb-local:latest", dyn.Image)
+	require.Equal(t, []string{"8000/tcp"}, dyn.ExposedPorts)
+
+	_, err = docker.FromRequest(docker.ContainerRequest{Image: "redis", ExposedPorts: []string{"abc/tcp"}})
+	require.Error(t, err)
+
+	_, err = docker.FromRequest(docker.ContainerRequest{Image: "redis"})
+	require.ErrorContains(t, err, "no ports")
+}
+
+type staticTarget struct{}
+
+func (staticTarget) Host(ctx context.Context) (string, error) { return "localhost", nil }
+
+func (staticTarget) MappedPort(ctx context.Context, port string) (string, error) { return "1", nil }
+
+// startedImage keeps the store the wait strategy of ToRequest hands to OnStart.
+type startedImage struct {
+	*docker.RequestImage
+	store *docker.ContainerStore
+}
+
+func (me *startedImage) OnStart(z *docker.ContainerStore) {
+	me.store = z
+	me.RequestImage.OnStart(z)
+}
+
+func TestUnitContainerRequestStrategy(t *testing.T) {
+	ctx := context.Background()
+
+	inner, err := docker.FromRequest(docker.ContainerRequest{Image: "redis", ExposedPorts: []string{"6379/tcp"}})
+	require.NoError(t, err)
+	img := &startedImage{RequestImage: inner}
+
+	require.NoError(t, docker.ToRequest(img).WaitingFor.WaitUntilReady(ctx, staticTarget{}))
+	require.NotNil(t, img.store)
+	require.Equal(t, "http://localhost:1", img.store.GetHttpHost())
+
+	require.NotPanics(t, func() {
+		img.store.SampleStats(ctx, time.Millisecond)
+		require.Zero(t, img.store.Stats().Samples)
+		require.Zero(t, img.store.Timings().Ready)
+		require.NoError(t, img.store.Ready())
+		require.NoError(t, img.store.Close())
+	})
+
+	failing, err := docker.FromRequest(docker.ContainerRequest{
+		Image:        "redis",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   docker.ForListeningPort("6379/tcp"),
+	})
+	require.NoError(t, err)
+	failing.OnStart(&docker.ContainerStore{})
+
+	start := time.Now()
+	require.Error(t, failing.Ping(ctx))
+	require.Less(t, time.Since(start), 5*time.Second, "Ping leaves retrying to Roll")
+}
+
+func TestUnitLoadBudgets(t *testing.T) {
+
+	err := docker.LoadBudgets(strings.NewReader(`{"redis:7": {"start": "2s", "ready": 500000000}}`))
+	require.NoError(t, err)
+
+	err = docker.LoadBudgets(strings.NewReader(`{"redis:7": {"start": "two seconds"}}`))
+	require.Error(t, err)
+
+	tm := docker.ContainerTimings{
+		Create: time.Second,
+		Start:  1500 * time.Millisecond,
+		Ready:  100 * time.Millisecond,
+		Budget: docker.Budget{Start: 2 * time.Second, Ready: time.Second},
+	}
+	require.True(t, tm.OverStartBudget())
+	require.False(t, tm.OverReadyBudget())
+}
+
+func TestUnitWriteEnv(t *testing.T) {
+
+	env := map[string]string{
+		"AWS_ENDPOINT_URL_DYNAMODB": "http://localhost:8000",
+		"QUOTED":                    "it's a $value",
+	}
+
+	var buf strings.Builder
+
+	require.NoError(t, docker.WriteEnv(&buf, env, docker.EnvFormatShell))
+	require.Equal(t, "export AWS_ENDPOINT_URL_DYNAMODB='http://localhost:8000'\nexport QUOTED='it'\\''s a $value'\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, docker.WriteEnv(&buf, env, docker.EnvFormatDotenv))
+	require.Equal(t, "AWS_ENDPOINT_URL_DYNAMODB=http://localhost:8000\nQUOTED=\"it's a \\$value\"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, docker.WriteEnv(&buf, env, docker.EnvFormatJSON))
+	require.JSONEq(t, `{"AWS_ENDPOINT_URL_DYNAMODB":"http://localhost:8000","QUOTED":"it's a $value"}`, buf.String())
+
+	require.Error(t, docker.WriteEnv(&buf, env, "yaml"))
+}
+
+func TestUnitStatsSummary(t *testing.T) {
+	var raw dockerapi.Stats
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"read": "2026-01-01T00:00:02Z",
+		"cpu_stats": {"cpu_usage": {"total_usage": 300, "percpu_usage": [150, 150]}, "system_cpu_usage": 2000},
+		"precpu_stats": {"cpu_usage": {"total_usage": 100}, "system_cpu_usage": 1000},
+		"memory_stats": {"usage": 64, "limit": 1024},
+		"networks": {"eth0": {"rx_bytes": 10, "tx_bytes": 20}, "eth1": {"rx_bytes": 1, "tx_bytes": 2}},
+		"blkio_stats": {"io_service_bytes_recursive": [{"op": "Read", "value": 5}, {"op": "Write", "value": 7}, {"op": "Total", "value": 12}]}
+	}`), &raw))
+
+	sample := docker.NewStatsSample(&raw)
+	require.Equal(t, docker.StatsSample{
+		Time:        time.Date(2026, 1, 1, 0, 0, 2, 0, time.UTC),
+		CPUPercent:  40,
+		MemoryUsage: 64,
+		MemoryLimit: 1024,
+		NetworkRx:   11,
+		NetworkTx:   22,
+		BlockRead:   5,
+		BlockWrite:  7,
+	}, sample)
+
+	raw.PreCPUStats.CPUUsage.TotalUsage = raw.CPUStats.CPUUsage.TotalUsage
+	require.Zero(t, docker.NewStatsSample(&raw).CPUPercent, "no cpu used since the previous reading")
+
+	require.Equal(t, docker.StatsSummary{}, docker.SummarizeStats(nil))
+
+	first := sample
+	first.Time = first.Time.Add(-2 * time.Second)
+	first.CPUPercent = 10
+	first.MemoryUsage = 128
+	first.NetworkRx = 1
+
+	sum := docker.SummarizeStats([]docker.StatsSample{first, sample})
+	require.Equal(t, docker.StatsSummary{
+		Samples:       2,
+		Duration:      2 * time.Second,
+		CPUAvgPercent: 25,
+		CPUMaxPercent: 40,
+		MemoryAvg:     96,
+		MemoryMax:     128,
+		MemoryLimit:   1024,
+		NetworkRx:     11,
+		NetworkTx:     22,
+		BlockRead:     5,
+		BlockWrite:    7,
+	}, sum)
+}
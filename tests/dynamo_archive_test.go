@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoArchive(t *testing.T) {
+	ctx := context.Background()
+
+	src, err := dynamodb_image.EmulateT(t).NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, src, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("orders"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema:   []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("status"), AttributeType: types.ScalarAttributeTypeS},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName:  ptr.String("by-status"),
+				KeySchema:  []types.KeySchemaElement{{AttributeName: ptr.String("status"), KeyType: types.KeyTypeHash}},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+		TimeToLive: &types.TimeToLiveSpecification{AttributeName: ptr.String("expires"), Enabled: ptr.Bool(true)},
+	})
+
+	items := []map[string]types.AttributeValue{}
+	for i := 0; i < 75; i++ {
+		items = append(items, map[string]types.AttributeValue{
+			"pk":     avS(fmt.Sprintf("order#%d", i)),
+			"status": avS([]string{"open", "paid", "sent"}[i%3]),
+			"lines":  &types.AttributeValueMemberL{Value: []types.AttributeValue{avN(fmt.Sprint(i))}},
+		})
+	}
+	_, err = dynamodb_image.SeedItems(ctx, src, "orders", items)
+	require.NoError(t, err)
+
+	count := func(cli *dynamodb.Client, table string) int32 {
+		out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String(table), Select: types.SelectCount})
+		require.NoError(t, err)
+		return out.Count
+	}
+
+	for _, format := range []dynamodb_image.ArchiveFormat{dynamodb_image.ArchiveTar, dynamodb_image.ArchiveTarGzip, dynamodb_image.ArchiveZip} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, dynamodb_image.Dump(ctx, src, []string{"orders"}, &buf, dynamodb_image.DumpFormat(format), dynamodb_image.DumpSegments(3)))
+
+			name := filepath.Join(t.TempDir(), "orders."+string(format))
+			require.NoError(t, os.WriteFile(name, buf.Bytes(), 0o644))
+
+			dst, err := dynamodb_image.EmulateT(t).NewClient()
+			require.NoError(t, err)
+
+			restored := dynamodb_image.RestoreT(t, ctx, dst, name, dynamodb_image.RestoreAs("orders", "copy"))
+			require.Equal(t, []*dynamodb_image.RestoredTable{{Table: "copy", Created: true, Items: 75}}, restored)
+			require.EqualValues(t, 75, count(dst, "copy"))
+
+			desc, err := dynamodb_image.DescribeProvisioned(ctx, dst, "copy")
+			require.NoError(t, err)
+			require.Equal(t, "by-status", *desc.Table.GlobalSecondaryIndexes[0].IndexName)
+			require.Equal(t, "expires", *desc.TimeToLive.AttributeName)
+
+			out, err := dst.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("copy"), Key: map[string]types.AttributeValue{"pk": avS("order#7")}})
+			require.NoError(t, err)
+			require.Equal(t, items[7], out.Item)
+		})
+	}
+
+	t.Run("s3 export", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "AWSDynamoDB", "01700000000000-abcdef12")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0o755))
+
+		var data bytes.Buffer
+		gz := gzip.NewWriter(&data)
+		fmt.Fprintln(gz, `{"Item":{"pk":{"S":"order#1"},"status":{"S":"open"}}}`)
+		fmt.Fprintln(gz, `{"Item":{"pk":{"S":"order#2"},"status":{"S":"paid"},"total":{"N":"12.5"}}}`)
+		require.NoError(t, gz.Close())
+
+		sum := md5.Sum(data.Bytes())
+		files := map[string]string{
+			"manifest-summary.json": `{"version":"2020-06-30","exportArn":"arn:aws:dynamodb:us-east-1:123456789012:table/prod-orders/export/01700000000000-abcdef12",` +
+				`"tableArn":"arn:aws:dynamodb:us-east-1:123456789012:table/prod-orders","itemCount":2,"outputFormat":"DYNAMODB_JSON","exportType":"FULL_EXPORT"}`,
+			"manifest-files.json": `{"itemCount":2,"md5Checksum":"` + base64.StdEncoding.EncodeToString(sum[:]) + `","etag":"y",` +
+				`"dataFileS3Key":"exports/AWSDynamoDB/01700000000000-abcdef12/data/hbhn4ykvqe3ivljnoxaqgrbwzy.json.gz"}` + "\n",
+			"data/hbhn4ykvqe3ivljnoxaqgrbwzy.json.gz": data.String(),
+		}
+		for name, body := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644))
+		}
+
+		dst, err := dynamodb_image.EmulateT(t).NewClient()
+		require.NoError(t, err)
+
+		_, err = dynamodb_image.RestoreFile(ctx, dst, filepath.Dir(filepath.Dir(dir)))
+		require.ErrorContains(t, err, "no definition")
+
+		restored := dynamodb_image.RestoreT(t, ctx, dst, filepath.Dir(filepath.Dir(dir)),
+			dynamodb_image.RestoreAs("prod-orders", "orders"),
+			dynamodb_image.RestoreDefinitions(&dynamodb_image.TableDefinition{Input: &dynamodb.CreateTableInput{
+				TableName:            ptr.String("orders"),
+				BillingMode:          types.BillingModePayPerRequest,
+				KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+				AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+			}}),
+		)
+		require.Equal(t, 2, restored[0].Items)
+		require.EqualValues(t, 2, count(dst, "orders"))
+
+		// packed the way S3 lists the export, with the data files before the manifests
+		var packed bytes.Buffer
+		tw := tar.NewWriter(&packed)
+		for _, name := range []string{"data/hbhn4ykvqe3ivljnoxaqgrbwzy.json.gz", "manifest-files.json", "manifest-summary.json"} {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: "AWSDynamoDB/01700000000000-abcdef12/" + name, Mode: 0o644, Size: int64(len(files[name]))}))
+			_, err := tw.Write([]byte(files[name]))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+
+		restored, err = dynamodb_image.Restore(ctx, dst, &packed, dynamodb_image.RestoreAs("prod-orders", "orders"))
+		require.NoError(t, err)
+		require.Equal(t, []*dynamodb_image.RestoredTable{{Table: "orders", Items: 2}}, restored)
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, dynamodb_image.Dump(ctx, src, []string{"orders"}, &buf, dynamodb_image.DumpSegments(1)))
+		corrupt := bytes.Replace(buf.Bytes(), []byte(`"order#7"`), []byte(`"order#X"`), 1)
+		require.NotEqual(t, buf.Bytes(), corrupt)
+
+		dst, err := dynamodb_image.EmulateT(t).NewClient()
+		require.NoError(t, err)
+
+		_, err = dynamodb_image.Restore(ctx, dst, bytes.NewReader(corrupt))
+		require.ErrorContains(t, err, "does not match the md5 checksum")
+	})
+}
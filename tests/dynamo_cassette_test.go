@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func cassetteCalls(t *testing.T, ctx context.Context, cli *dynamodb.Client) *dynamodb.GetItemOutput {
+	_, err := cli.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            ptr.String("taped"),
+		BillingMode:          types.BillingModePayPerRequest,
+		KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+	})
+	require.NoError(t, err)
+	_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("taped"), Item: map[string]types.AttributeValue{"pk": avS("a"), "n": avN("1")}})
+	require.NoError(t, err)
+
+	_, err = cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("missing"), Key: map[string]types.AttributeValue{"pk": avS("a")}})
+	var notFound *types.ResourceNotFoundException
+	require.ErrorAs(t, err, &notFound)
+
+	out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("taped"), Key: map[string]types.AttributeValue{"pk": avS("a")}})
+	require.NoError(t, err)
+	return out
+}
+
+func TestUnitDynamoCassette(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdata", "taped.cassette.json")
+
+	rec, err := dynamodb_image.NewCassette(path)
+	require.NoError(t, err)
+	require.True(t, rec.Recording())
+
+	cli, err := dynamodb_image.EmulateT(t).NewClient(rec.ClientOption())
+	require.NoError(t, err)
+	recorded := cassetteCalls(t, ctx, cli)
+	require.NoError(t, rec.Save())
+	require.Len(t, rec.Interactions, 4)
+	require.Equal(t, "DynamoDB.CreateTable", rec.Interactions[0].Operation)
+
+	play := dynamodb_image.CassetteT(t, path, dynamodb_image.WithCassetteMatch(dynamodb_image.MatchStrict))
+	require.False(t, play.Recording())
+	replayed := cassetteCalls(t, ctx, play.ReplayClient())
+	require.Equal(t, recorded.Item, replayed.Item)
+	require.Empty(t, play.Unused())
+
+	lenient, err := dynamodb_image.NewCassette(path)
+	require.NoError(t, err)
+	cli = lenient.ReplayClient()
+
+	for i := 0; i < 2; i++ {
+		out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("taped"), Key: map[string]types.AttributeValue{"pk": avS("a")}})
+		require.NoError(t, err)
+		require.Equal(t, recorded.Item, out.Item)
+	}
+	require.Len(t, lenient.Unused(), 3)
+
+	_, err = cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("taped"), Key: map[string]types.AttributeValue{"pk": avS("b")}})
+	var mismatch *dynamodb_image.CassetteMismatch
+	require.True(t, errors.As(err, &mismatch))
+	require.Equal(t, "DynamoDB.GetItem", mismatch.Operation)
+	require.Equal(t, []string{`~ Key.pk.S: "a" -> "b"`}, mismatch.Differences)
+	require.Contains(t, err.Error(), "no recorded DynamoDB.GetItem matches")
+	require.Len(t, lenient.Mismatches(), 1)
+
+	ignoring, err := dynamodb_image.NewCassette(path, dynamodb_image.IgnoreRequestFields("Key.*.S"))
+	require.NoError(t, err)
+	_, err = ignoring.ReplayClient().GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("taped"), Key: map[string]types.AttributeValue{"pk": avS("b")}})
+	require.NoError(t, err)
+
+	strict, err := dynamodb_image.NewCassette(path, dynamodb_image.WithCassetteMatch(dynamodb_image.MatchStrict))
+	require.NoError(t, err)
+	_, err = strict.ReplayClient().PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("taped"), Item: map[string]types.AttributeValue{"pk": avS("a")}})
+	require.ErrorContains(t, err, "expected DynamoDB.CreateTable, got DynamoDB.PutItem")
+
+	_, err = dynamodb_image.NewCassette(filepath.Join(t.TempDir(), "none.json"), dynamodb_image.WithCassetteMode(dynamodb_image.CassetteReplay))
+	require.ErrorContains(t, err, dynamodb_image.RecordCassettesEnv)
+}
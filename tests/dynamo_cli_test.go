@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/snake"
+	"github.com/walteh/testrc/cmd/root"
+	"github.com/walteh/testrc/pkg/docker"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoCLI(t *testing.T) {
+	ctx := context.Background()
+
+	emu := dynamodb_image.NewEmulator()
+	endpoint, err := emu.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = emu.Close() })
+
+	cli, err := emu.Image().NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("cli"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+			},
+		},
+	})
+
+	t.Setenv(dynamodb_image.EndpointURLEnv, endpoint)
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := snake.NewRootCommand(ctx, &root.Root{})
+		cmd.SetOut(&out)
+		cmd.SetArgs(append([]string{"--quiet", "dynamo"}, args...))
+		require.NoError(t, cmd.ExecuteContext(ctx), args)
+		return out.String()
+	}
+
+	require.Equal(t, "put 3 items into cli\n", run("put", "cli",
+		`{"pk": "user#1", "sk": "profile", "name": "ada"}`,
+		`{"pk": "user#1", "sk": "order#1", "total": 3}`,
+		`{"pk": {"S": "user#2"}, "sk": {"S": "profile"}}`,
+	))
+
+	require.Contains(t, run("tables"), "pk, sk")
+
+	require.Equal(t, "{\"name\":\"ada\",\"pk\":\"user#1\",\"sk\":\"profile\"}\n",
+		run("get", "cli", "--key", `{"pk": "user#1", "sk": "profile"}`, "--format", "jsonl"))
+
+	require.Equal(t, "pk,sk,total\nuser#1,order#1,3\n", run("query", "cli", "--format", "csv", "--sort",
+		"--key-condition", "#pk = :pk AND begins_with(#sk, :order)", "--values", `{":pk": "user#1", ":order": "order#"}`))
+
+	require.Equal(t, "pk,sk\nuser#2,profile\n", run("scan", "cli", "--format", "csv", "--sort",
+		"--filter", "attribute_not_exists(#name) AND attribute_not_exists(#total)", "-a", "pk,sk"))
+
+	dump := run("dump", "cli")
+	require.Contains(t, dump, `{"name":{"S":"ada"},"pk":{"S":"user#1"},"sk":{"S":"profile"}}`)
+
+	seed := filepath.Join(t.TempDir(), "cli.yaml")
+	require.NoError(t, os.WriteFile(seed, []byte("- pk: user#3\n  sk: profile\n  name: grace\n- pk: user#3\n  sk: order#1\n  total: null\n"), 0o644))
+	require.Equal(t, "seeded 2 items into cli\n", run("seed", "cli", seed))
+
+	require.Equal(t, "Attribute,Count,Null Count\nname,2,0\npk,5,0\nsk,5,0\ntotal,2,1\n", run("counts", "cli", "--format", "csv"))
+
+	var desc struct {
+		Table struct {
+			TableName string
+			KeySchema []struct{ AttributeName string }
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(run("describe", "cli", "--format", "jsonl")), &desc))
+	require.Equal(t, "cli", desc.Table.TableName)
+	require.Len(t, desc.Table.KeySchema, 2)
+
+	require.Contains(t, run("truncate", "cli"), "deleted 5")
+	require.Empty(t, run("scan", "cli", "--format", "jsonl"))
+}
+
+// fakeDocker serves the parts of the docker api that docker.Attach uses, listing one
+// dynamodb fixture of session published on the port of endpoint.
+func fakeDocker(t *testing.T, session, endpoint string) string {
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(endpoint, "http://"))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			var filters map[string][]string
+			if f := r.URL.Query().Get("filters"); f != "" {
+				require.NoError(t, json.Unmarshal([]byte(f), &filters))
+			}
+			if !slices.Contains(filters["label"], docker.LabelSession+"="+session) && !slices.Contains(filters["name"], "^/dynamodb-1$") {
+				_, _ = w.Write([]byte("[]"))
+				return
+			}
+			_ = json.NewEncoder(w).Encode([]map[string]any{{
+				"Id":     "c1",
+				"Names":  []string{"/dynamodb-1"},
+				"Labels": map[string]string{docker.LabelManaged: "true", docker.LabelSession: session, docker.LabelService: "dynamodb"},
+			}})
+		case strings.HasSuffix(r.URL.Path, "/containers/c1/json"):
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"Id":              "c1",
+				"NetworkSettings": map[string]any{"Ports": map[string]any{"8000/tcp": []map[string]string{{"HostIp": "127.0.0.1", "HostPort": port}}}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestUnitDynamoCLISession(t *testing.T) {
+	ctx := context.Background()
+
+	emu := dynamodb_image.NewEmulator()
+	endpoint, err := emu.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = emu.Close() })
+
+	cli, err := emu.Image().NewClient()
+	require.NoError(t, err)
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("attached"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	t.Setenv(dynamodb_image.EndpointURLEnv, "")
+	t.Setenv(docker.SessionEnv, "")
+	t.Setenv("DOCKER_CERT_PATH", "")
+	t.Setenv("DOCKER_HOST", fakeDocker(t, "s1", endpoint))
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		cmd := snake.NewRootCommand(ctx, &root.Root{})
+		cmd.SetOut(&out)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"--quiet", "dynamo"}, args...))
+		err := cmd.ExecuteContext(ctx)
+		return out.String(), err
+	}
+
+	out, err := run("tables", "--session", "s1", "--format", "csv")
+	require.NoError(t, err)
+	require.Equal(t, "table,status,keys,items,indexes,stream\nattached,ACTIVE,pk,0,,\n", out)
+
+	_, err = run("tables", "--session", "s2")
+	require.ErrorContains(t, err, "no running dynamodb fixture found")
+
+	t.Setenv(docker.SessionEnv, "s1")
+	out, err = run("counts", "attached", "--format", "csv")
+	require.NoError(t, err, "the session defaults to $%s", docker.SessionEnv)
+	require.Equal(t, "Attribute,Count,Null Count\n", out)
+}
+
+func TestUnitDynamoRepl(t *testing.T) {
+	ctx := context.Background()
+
+	emu := dynamodb_image.NewEmulator()
+	endpoint, err := emu.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = emu.Close() })
+
+	cli, err := emu.Image().NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("repl"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+			},
+		},
+	})
+	_, err = dynamodb_image.SeedItems(ctx, cli, "repl", []map[string]types.AttributeValue{
+		{"pk": avS("user#1"), "sk": avS("order#1"), "status": avS("open")},
+		{"pk": avS("user#1"), "sk": avS("order#2"), "status": avS("closed")},
+		{"pk": avS("user#2"), "sk": avS("order#1"), "status": avS("open")},
+	})
+	require.NoError(t, err)
+
+	t.Setenv(dynamodb_image.EndpointURLEnv, endpoint)
+	history := filepath.Join(t.TempDir(), "history")
+
+	var out bytes.Buffer
+	cmd := snake.NewRootCommand(ctx, &root.Root{})
+	cmd.SetOut(&out)
+	cmd.SetIn(strings.NewReader(strings.Join([]string{
+		`\set :pk user#1`,
+		`\set :s "open"`,
+		`query repl -k '#pk = :pk' \`,
+		`  --filter '#status = :s'`,
+		`scan repl --filter '#status = :missing'`,
+		`\vars`,
+		`!3`,
+		`SELECT sk, "status" FROM "repl" WHERE pk = :pk AND "status" <> ':s'`,
+		`SELECT * FROM "repl" WHERE pk = :nope`,
+		`\history`,
+		`\quit`,
+	}, "\n")))
+	cmd.SetArgs([]string{"--quiet", "dynamo", "repl", "--format", "csv", "--history", history})
+	require.NoError(t, cmd.ExecuteContext(ctx))
+
+	got := out.String()
+	require.Contains(t, got, "pk,sk,status\nuser#1,order#1,open\n1 items, 1 calls, 0.5 read units, 0 write units")
+	require.Contains(t, got, `error: placeholder :missing is not set, use \set :missing <value>`)
+	require.Contains(t, got, `{":pk":{"S":"user#1"},":s":{"S":"open"}}`)
+	require.Contains(t, got, "   3  query repl -k '#pk = :pk' --filter '#status = :s'\n")
+	require.Equal(t, 2, strings.Count(got, "user#1,order#1,open"), "!3 runs the query again")
+	require.Contains(t, got, "sk,status\norder#1,open\norder#2,closed\n2 items, 1 calls")
+	require.Contains(t, got, "error: placeholder :nope is not set\n")
+
+	b, err := os.ReadFile(history)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 8)
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+const cloudFormationTemplate = `
+AWSTemplateFormatVersion: "2010-09-09"
+Transform: AWS::Serverless-2016-10-31
+Parameters:
+  Env:
+    Type: String
+    Default: dev
+Resources:
+  Orders:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: !Sub "${Env}-orders"
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions:
+        - { AttributeName: pk, AttributeType: S }
+        - { AttributeName: sk, AttributeType: S }
+        - { AttributeName: gsi1pk, AttributeType: S }
+        - { AttributeName: created, AttributeType: N }
+      KeySchema:
+        - { AttributeName: pk, KeyType: HASH }
+        - { AttributeName: sk, KeyType: RANGE }
+      GlobalSecondaryIndexes:
+        - IndexName: gsi1
+          KeySchema:
+            - { AttributeName: gsi1pk, KeyType: HASH }
+          Projection:
+            ProjectionType: INCLUDE
+            NonKeyAttributes: [status]
+      LocalSecondaryIndexes:
+        - IndexName: by-created
+          KeySchema:
+            - { AttributeName: pk, KeyType: HASH }
+            - { AttributeName: created, KeyType: RANGE }
+          Projection: { ProjectionType: KEYS_ONLY }
+      StreamSpecification:
+        StreamViewType: NEW_AND_OLD_IMAGES
+      TimeToLiveSpecification:
+        AttributeName: expires
+        Enabled: true
+      PointInTimeRecoverySpecification:
+        PointInTimeRecoveryEnabled: true
+      Tags:
+        - { Key: team, Value: core }
+  Sessions:
+    Type: AWS::Serverless::SimpleTable
+    Properties:
+      TableName: !Join ["-", [!Ref Env, sessions]]
+      PrimaryKey: { Name: token, Type: String }
+  Bucket:
+    Type: AWS::S3::Bucket
+`
+
+const terraformFile = `
+variable "env" {
+  default = "dev"
+}
+
+locals {
+  prefix = "${var.env}-app"
+  tags   = { team = "core" }
+}
+
+resource "aws_s3_bucket" "b" {
+  bucket = format("%s-bucket", local.prefix) # not evaluated
+  policy = jsonencode({
+    Statement = [{ Effect = "Allow" }]
+  })
+}
+
+resource "aws_dynamodb_table" "users" {
+  name           = "${local.prefix}-users"
+  billing_mode   = "PROVISIONED"
+  read_capacity  = 5
+  write_capacity = 5
+  hash_key       = "id"
+  range_key      = "version"
+
+  stream_enabled   = true
+  stream_view_type = "KEYS_ONLY"
+
+  attribute {
+    name = "id"
+    type = "S"
+  }
+
+  attribute {
+    name = "version"
+    type = "N"
+  }
+
+  attribute {
+    name = "email"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires"
+    enabled        = true
+  }
+
+  global_secondary_index {
+    name            = "by-email"
+    hash_key        = "email"
+    read_capacity   = 1
+    write_capacity  = 1
+    projection_type = "ALL"
+  }
+
+  tags = merge(local.tags, { Name = "users" })
+}
+`
+
+func TestUnitDynamoDefinitions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfnPath := filepath.Join(dir, "template.yaml")
+	tfPath := filepath.Join(dir, "main.tf")
+	require.NoError(t, os.WriteFile(cfnPath, []byte(cloudFormationTemplate), 0o644))
+	require.NoError(t, os.WriteFile(tfPath, []byte(terraformFile), 0o644))
+
+	cfn, err := dynamodb_image.LoadDefinitions(cfnPath, map[string]string{"Env": "test"})
+	require.NoError(t, err)
+	require.Len(t, cfn, 2)
+	require.Equal(t, "test-orders", *cfn[0].Input.TableName)
+	require.Equal(t, types.BillingModePayPerRequest, cfn[0].Input.BillingMode)
+	require.Len(t, cfn[0].Input.GlobalSecondaryIndexes, 1)
+	require.Equal(t, []string{"status"}, cfn[0].Input.GlobalSecondaryIndexes[0].Projection.NonKeyAttributes)
+	require.Len(t, cfn[0].Input.LocalSecondaryIndexes, 1)
+	require.Equal(t, types.StreamViewTypeNewAndOldImages, cfn[0].Input.StreamSpecification.StreamViewType)
+	require.Equal(t, "expires", *cfn[0].TimeToLive.AttributeName)
+	require.Equal(t, "test-sessions", *cfn[1].Input.TableName)
+
+	tf, err := dynamodb_image.LoadDefinitions(tfPath, nil)
+	require.NoError(t, err)
+	require.Len(t, tf, 1)
+	require.Equal(t, "dev-app-users", *tf[0].Input.TableName)
+	require.EqualValues(t, 5, *tf[0].Input.ProvisionedThroughput.ReadCapacityUnits)
+	require.EqualValues(t, 1, *tf[0].Input.GlobalSecondaryIndexes[0].ProvisionedThroughput.WriteCapacityUnits)
+	require.Len(t, tf[0].Input.AttributeDefinitions, 3)
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	provisioned, teardown, err := dynamodb_image.ProvisionAll(ctx, cli, append(cfn, tf...)...)
+	require.NoError(t, err)
+	require.Len(t, provisioned, 3)
+
+	orders := provisioned[0]
+	require.Equal(t, "test-orders", orders.Name())
+	require.Equal(t, types.TableStatusActive, orders.Table.TableStatus)
+	require.NotNil(t, orders.Table.LatestStreamArn)
+	require.Equal(t, types.TimeToLiveStatusEnabled, orders.TimeToLive.TimeToLiveStatus)
+	require.Equal(t, types.PointInTimeRecoveryStatusEnabled, orders.PointInTimeRecovery.PointInTimeRecoveryStatus)
+	require.Equal(t, []types.Tag{{Key: ptr.String("team"), Value: ptr.String("core")}}, orders.Tags)
+
+	ttl, err := cli.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: ptr.String("dev-app-users")})
+	require.NoError(t, err)
+	require.Equal(t, types.TimeToLiveStatusEnabled, ttl.TimeToLiveDescription.TimeToLiveStatus)
+
+	tables, err := cli.ListTables(ctx, &dynamodb.ListTablesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dev-app-users", "test-orders", "test-sessions"}, tables.TableNames)
+
+	require.NoError(t, teardown())
+
+	tables, err = cli.ListTables(ctx, &dynamodb.ListTablesInput{})
+	require.NoError(t, err)
+	require.Empty(t, tables.TableNames)
+
+	t.Run("disabled ttl", func(t *testing.T) {
+		files := map[string]string{
+			"template.yaml": `
+Resources:
+  Off:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: cfn-off
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions: [{ AttributeName: pk, AttributeType: S }]
+      KeySchema: [{ AttributeName: pk, KeyType: HASH }]
+      TimeToLiveSpecification:
+        AttributeName: expires
+        Enabled: false
+  Unset:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: cfn-unset
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions: [{ AttributeName: pk, AttributeType: S }]
+      KeySchema: [{ AttributeName: pk, KeyType: HASH }]
+      TimeToLiveSpecification:
+        AttributeName: expires
+`,
+			"main.tf": `
+resource "aws_dynamodb_table" "off" {
+  name         = "tf-off"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "pk"
+
+  attribute {
+    name = "pk"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires"
+    enabled        = false
+  }
+}
+`,
+		}
+
+		defs := []*dynamodb_image.TableDefinition{}
+		for name, body := range files {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+			loaded, err := dynamodb_image.LoadDefinitions(path, nil)
+			require.NoError(t, err)
+			defs = append(defs, loaded...)
+		}
+		require.Len(t, defs, 3)
+		for _, def := range defs {
+			require.Nil(t, def.TimeToLive, *def.Input.TableName)
+		}
+
+		provisioned, teardown, err := dynamodb_image.ProvisionAll(ctx, cli, defs...)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, teardown()) })
+		for _, p := range provisioned {
+			require.NotEqual(t, types.TimeToLiveStatusEnabled, p.TimeToLive.TimeToLiveStatus, p.Name())
+		}
+	})
+}
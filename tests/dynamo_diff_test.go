@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+// failedTB is a test that has already failed, collecting its cleanups and logs.
+type failedTB struct {
+	testing.TB
+	cleanups []func()
+	logs     []string
+}
+
+func (me *failedTB) Failed() bool      { return true }
+func (me *failedTB) Cleanup(fn func()) { me.cleanups = append(me.cleanups, fn) }
+func (me *failedTB) Logf(format string, args ...any) {
+	me.logs = append(me.logs, fmt.Sprintf(format, args...))
+}
+
+func TestUnitDynamoDiff(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("diffed"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	put := func(item map[string]types.AttributeValue) {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("diffed"), Item: item})
+		require.NoError(t, err)
+	}
+
+	put(map[string]types.AttributeValue{"pk": avS("a"), "n": avN("1"), "tags": &types.AttributeValueMemberSS{Value: []string{"x", "y"}}, "at": avS("t1")})
+	put(map[string]types.AttributeValue{"pk": avS("b"), "n": avN("2")})
+	put(map[string]types.AttributeValue{"pk": avS("c")})
+
+	before := dynamodb_image.CaptureT(t, ctx, cli, "diffed")
+	require.Equal(t, 3, before.Len())
+
+	ftb := &failedTB{TB: t}
+	dynamodb_image.DiffOnFailureT(ftb, ctx, cli, "diffed")
+
+	put(map[string]types.AttributeValue{"pk": avS("a"), "n": avN("1"), "tags": &types.AttributeValueMemberSS{Value: []string{"y", "x"}}, "at": avS("t2")})
+	put(map[string]types.AttributeValue{"pk": avS("b"), "n": avN("3"), "flag": &types.AttributeValueMemberBOOL{Value: true}})
+	put(map[string]types.AttributeValue{"pk": avS("d")})
+	_, err = cli.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: ptr.String("diffed"), Key: map[string]types.AttributeValue{"pk": avS("c")}})
+	require.NoError(t, err)
+
+	after := dynamodb_image.CaptureT(t, ctx, cli, "diffed")
+
+	diff := dynamodb_image.Diff(before, after, dynamodb_image.IgnoreAttributes("at"))
+	require.Equal(t, 1, diff.Count(dynamodb_image.ItemAdded))
+	require.Equal(t, 1, diff.Count(dynamodb_image.ItemRemoved))
+	require.Equal(t, 1, diff.Count(dynamodb_image.ItemModified))
+	require.Equal(t, `diffed: 1 added, 1 removed, 1 modified
+~ item pk={"S":"b"}:
+    + flag: {"BOOL":true}
+    ~ n: {"N":"2"} -> {"N":"3"}
+- item pk={"S":"c"}
+    {"pk":{"S":"c"}}
++ item pk={"S":"d"}
+    {"pk":{"S":"d"}}
+`, diff.String())
+
+	require.Equal(t, dynamodb_image.ItemModified, dynamodb_image.Diff(before, after).Changes[0].Type)
+	require.True(t, dynamodb_image.Diff(after, after).Empty())
+
+	for i := len(ftb.cleanups) - 1; i >= 0; i-- {
+		ftb.cleanups[i]()
+	}
+	require.Len(t, ftb.logs, 1)
+	require.Contains(t, ftb.logs[0], `~ at: {"S":"t1"} -> {"S":"t2"}`)
+}
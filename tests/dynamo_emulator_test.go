@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func avS(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+func avN(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+
+func TestUnitDynamoEmulator(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	require.NoError(t, img.Ping(ctx))
+
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	_, err = cli.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: ptr.String("orders"),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+			{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: ptr.String("status"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  ptr.String("by-status"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: ptr.String("status"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	require.NoError(t, err)
+
+	desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String("orders")})
+	require.NoError(t, err)
+	require.Equal(t, types.TableStatusActive, desc.Table.TableStatus)
+	require.Len(t, desc.Table.GlobalSecondaryIndexes, 1)
+
+	for i, st := range []string{"open", "open", "closed", "open"} {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: ptr.String("orders"),
+			Item: map[string]types.AttributeValue{
+				"pk":     avS("user#1"),
+				"sk":     avN([]string{"1", "2", "10", "20"}[i]),
+				"status": avS(st),
+				"total":  avN("5"),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// numeric sort keys sort as numbers, and Limit pages through them
+	q := &dynamodb.QueryInput{
+		TableName:                 ptr.String("orders"),
+		KeyConditionExpression:    ptr.String("pk = :pk AND sk > :min"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": avS("user#1"), ":min": avN("1")},
+		Limit:                     ptr.Int32(2),
+	}
+	sks := []string{}
+	for p := dynamodb.NewQueryPaginator(cli, q); p.HasMorePages(); {
+		page, err := p.NextPage(ctx)
+		require.NoError(t, err)
+		for _, it := range page.Items {
+			sks = append(sks, it["sk"].(*types.AttributeValueMemberN).Value)
+		}
+	}
+	require.Equal(t, []string{"2", "10", "20"}, sks)
+
+	// filter and projection on an index
+	qi, err := cli.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 ptr.String("orders"),
+		IndexName:                 ptr.String("by-status"),
+		KeyConditionExpression:    ptr.String("#s = :s"),
+		FilterExpression:          ptr.String("sk >= :ten"),
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":s": avS("open"), ":ten": avN("10")},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, qi.Count)
+	require.EqualValues(t, 3, qi.ScannedCount)
+	require.NotContains(t, qi.Items[0], "total")
+
+	// update expressions and return values
+	up, err := cli.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 ptr.String("orders"),
+		Key:                       map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("1")},
+		UpdateExpression:          ptr.String("SET total = total + :inc, tags = list_append(if_not_exists(tags, :empty), :tag) REMOVE #s"),
+		ConditionExpression:       ptr.String("attribute_exists(pk)"),
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":inc": avN("2.5"), ":empty": &types.AttributeValueMemberL{}, ":tag": &types.AttributeValueMemberL{Value: []types.AttributeValue{avS("rush")}}},
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "7.5", up.Attributes["total"].(*types.AttributeValueMemberN).Value)
+	require.NotContains(t, up.Attributes, "status")
+
+	// conditional failures come back as the typed sdk error
+	_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           ptr.String("orders"),
+		Item:                map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("1")},
+		ConditionExpression: ptr.String("attribute_not_exists(pk)"),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	require.True(t, errors.As(err, &ccf), "got %v", err)
+
+	// transactions are all or nothing
+	_, err = cli.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: ptr.String("orders"), Key: map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("2")}}},
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:                 ptr.String("orders"),
+				Key:                       map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("10")},
+				ConditionExpression:       ptr.String("#s = :open"),
+				ExpressionAttributeNames:  map[string]string{"#s": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":open": avS("open")},
+			}},
+		},
+	})
+	var tce *types.TransactionCanceledException
+	require.True(t, errors.As(err, &tce), "got %v", err)
+	require.Len(t, tce.CancellationReasons, 2)
+	require.Equal(t, "ConditionalCheckFailed", *tce.CancellationReasons[1].Code)
+
+	got, err := cli.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: ptr.String("orders"),
+		Key:       map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("2")},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, got.Item)
+
+	// batches
+	_, err = cli.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]types.WriteRequest{"orders": {
+			{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": avS("user#2"), "sk": avN("1")}}},
+			{DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{"pk": avS("user#1"), "sk": avN("20")}}},
+		}},
+	})
+	require.NoError(t, err)
+
+	bg, err := cli.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{"orders": {
+			Keys: []map[string]types.AttributeValue{
+				{"pk": avS("user#2"), "sk": avN("1")},
+				{"pk": avS("user#1"), "sk": avN("20")},
+			},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, bg.Responses["orders"], 1)
+
+	// parallel scan segments cover the table exactly once
+	total := 0
+	for seg := int32(0); seg < 3; seg++ {
+		out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("orders"), Segment: ptr.Int32(seg), TotalSegments: ptr.Int32(3)})
+		require.NoError(t, err)
+		total += int(out.Count)
+	}
+	require.Equal(t, 4, total)
+
+	_, err = cli.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: ptr.String("orders")})
+	require.NoError(t, err)
+
+	_, err = cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String("orders")})
+	var rnf *types.ResourceNotFoundException
+	require.True(t, errors.As(err, &rnf), "got %v", err)
+}
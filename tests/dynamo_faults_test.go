@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoFaults(t *testing.T) {
+	ctx := context.Background()
+	img := dynamodb_image.EmulateT(t)
+
+	faults := dynamodb_image.NewFaults(
+		&dynamodb_image.FaultRule{Operations: []string{"PutItem"}, Tables: []string{"orders"}, Err: dynamodb_image.ProvisionedThroughputExceeded(), FirstN: 2},
+		&dynamodb_image.FaultRule{Operations: []string{"GetItem"}, Err: dynamodb_image.ThrottlingError(), FirstN: 3},
+		&dynamodb_image.FaultRule{Operations: []string{"TransactWriteItems"}, Err: dynamodb_image.TransactionCanceled("None", "TransactionConflict")},
+		&dynamodb_image.FaultRule{Operations: []string{"Query"}, Latency: 50 * time.Millisecond},
+	)
+	cli, err := img.NewClient(faults.ClientOption(), func(o *dynamodb.Options) {
+		o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		})
+	})
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("orders"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	put := &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("a")}}
+	name := put.TableName
+	_, err = cli.PutItem(ctx, put)
+	require.NoError(t, err, "the sdk retries the two throttled attempts")
+	require.Len(t, faults.Injected(), 2)
+	require.Same(t, name, put.TableName, "the input is left alone")
+
+	_, err = cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("orders"), Key: map[string]types.AttributeValue{"pk": avS("a")}})
+	var api smithy.APIError
+	require.ErrorAs(t, err, &api)
+	require.Equal(t, "ThrottlingException", api.ErrorCode())
+	_, err = cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("orders"), Key: map[string]types.AttributeValue{"pk": avS("a")}})
+	require.NoError(t, err)
+
+	_, err = cli.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("b")}}},
+		{Put: &types.Put{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("c")}}},
+	}})
+	var canceled *types.TransactionCanceledException
+	require.ErrorAs(t, err, &canceled)
+	require.Equal(t, "TransactionConflict", *canceled.CancellationReasons[1].Code)
+
+	start := time.Now()
+	_, err = cli.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 ptr.String("orders"),
+		KeyConditionExpression:    ptr.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": avS("a")},
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	t.Run("probability", func(t *testing.T) {
+		faults := dynamodb_image.NewFaults(&dynamodb_image.FaultRule{Operations: []string{"Scan"}, Err: dynamodb_image.InternalServerError(), Probability: 0.5})
+		cli, err := img.NewClient(faults.ClientOption(), func(o *dynamodb.Options) { o.Retryer = aws.NopRetryer{} })
+		require.NoError(t, err)
+
+		failed := 0
+		for i := 0; i < 100; i++ {
+			if _, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("orders")}); err != nil {
+				failed++
+			}
+		}
+		require.InDelta(t, 50, failed, 20)
+		require.Len(t, faults.Injected(), failed)
+	})
+
+	t.Run("capacity", func(t *testing.T) {
+		faults := dynamodb_image.NewFaults().Capacity("orders", 0, 3)
+		cli, err := img.NewClient(faults.ClientOption(), func(o *dynamodb.Options) { o.Retryer = aws.NopRetryer{} })
+		require.NoError(t, err)
+
+		put := func(i int) error {
+			_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS(fmt.Sprintf("cap%d", i))}})
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			require.NoError(t, put(i))
+		}
+		var exceeded *types.ProvisionedThroughputExceededException
+		require.ErrorAs(t, put(3), &exceeded)
+
+		_, err = cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("orders"), Key: map[string]types.AttributeValue{"pk": avS("a")}, ConsistentRead: aws.Bool(true)})
+		require.NoError(t, err, "reads are not limited")
+
+		time.Sleep(time.Second)
+		require.NoError(t, put(4))
+	})
+
+	t.Run("capacity of updates", func(t *testing.T) {
+		faults := dynamodb_image.NewFaults().Capacity("orders", 0, 3)
+		cli, err := img.NewClient(faults.ClientOption(), func(o *dynamodb.Options) { o.Retryer = aws.NopRetryer{} })
+		require.NoError(t, err)
+
+		// the key is tiny, the item it leaves behind is three write units
+		update := &dynamodb.UpdateItemInput{
+			TableName:                 ptr.String("orders"),
+			Key:                       map[string]types.AttributeValue{"pk": avS("big")},
+			UpdateExpression:          ptr.String("SET body = :b"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":b": avS(strings.Repeat("x", 2500))},
+		}
+		out, err := cli.UpdateItem(ctx, update)
+		require.NoError(t, err)
+		require.Nil(t, out.ConsumedCapacity, "capacity is only returned when asked for")
+		require.Empty(t, update.ReturnConsumedCapacity, "the input is left alone")
+
+		_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("small")}})
+		var exceeded *types.ProvisionedThroughputExceededException
+		require.ErrorAs(t, err, &exceeded)
+	})
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+// recordingTB captures failures instead of failing the outer test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (me *recordingTB) Errorf(format string, args ...any) {
+	me.errors = append(me.errors, fmt.Sprintf(format, args...))
+}
+
+func TestUnitDynamoGolden(t *testing.T) {
+	ctx := context.Background()
+	golden := filepath.Join(t.TempDir(), "testdata", "orders.golden.json")
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("orders"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	put := func(item map[string]types.AttributeValue) {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: item})
+		require.NoError(t, err)
+	}
+
+	put(map[string]types.AttributeValue{"pk": avS("b"), "status": avS("open"), "created": avN("1")})
+	put(map[string]types.AttributeValue{"pk": avS("a"), "status": avS("open"), "tags": &types.AttributeValueMemberSS{Value: []string{"z", "x"}}})
+
+	ignore := dynamodb_image.IgnoreAttributes("created")
+
+	t.Setenv(dynamodb_image.UpdateGoldenEnv, "1")
+	require.True(t, dynamodb_image.AssertTableMatchesGolden(t, cli, "orders", golden, ignore))
+
+	b, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.Equal(t, `[
+  {"pk":{"S":"a"},"status":{"S":"open"},"tags":{"SS":["x","z"]}},
+  {"created":"<ignored>","pk":{"S":"b"},"status":{"S":"open"}}
+]
+`, string(b))
+
+	t.Setenv(dynamodb_image.UpdateGoldenEnv, "")
+	put(map[string]types.AttributeValue{"pk": avS("b"), "status": avS("open"), "created": avN("2")})
+	require.True(t, dynamodb_image.AssertTableMatchesGolden(t, cli, "orders", golden, ignore))
+
+	put(map[string]types.AttributeValue{"pk": avS("a"), "status": avS("closed")})
+	put(map[string]types.AttributeValue{"pk": avS("c")})
+
+	rec := &recordingTB{TB: t}
+	require.False(t, dynamodb_image.AssertTableMatchesGolden(rec, cli, "orders", golden, ignore))
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], `~ status: {"S":"open"} -> {"S":"closed"}`)
+	require.Contains(t, rec.errors[0], `- tags: {"SS":["x","z"]}`)
+	require.Contains(t, rec.errors[0], `+ item pk={"S":"c"}: not in golden`)
+}
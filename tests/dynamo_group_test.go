@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoGroupedScan(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("app"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("status"), AttributeType: types.ScalarAttributeTypeS},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName:  ptr.String("by-status"),
+				KeySchema:  []types.KeySchemaElement{{AttributeName: ptr.String("status"), KeyType: types.KeyTypeHash}, {AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange}},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"total"}},
+			}},
+		},
+	})
+
+	for _, item := range []map[string]types.AttributeValue{
+		{"pk": avS("USER#1"), "sk": avS("PROFILE"), "type": avS("user"), "email": avS("a@b.c")},
+		{"pk": avS("USER#1"), "sk": avS("ORDER#1"), "type": avS("order"), "status": avS("open"), "total": avN("5")},
+		{"pk": avS("USER#1"), "sk": avS("ORDER#2"), "type": avS("order"), "total": avN("7")},
+		{"pk": avS("NOTE#1"), "sk": avS("NOTE#1")},
+	} {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("app"), Item: item})
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, dynamodb_image.FprintScan(ctx, cli, &buf, "app",
+		dynamodb_image.WithFormat(dynamodb_image.FormatMarkdown),
+		dynamodb_image.SortByKey(),
+		dynamodb_image.GroupByAttribute("type"),
+	))
+	out := buf.String()
+	require.Contains(t, out, "### Table Data: app / order (2 items)\n\n| pk | sk | status | total | type |")
+	require.Contains(t, out, "\n\n### Table Data: app / user (1 items)\n\n| pk | sk | email | type |")
+	require.Contains(t, out, "### Table Data: app / (other) (1 items)\n\n| pk | sk |\n")
+	require.Contains(t, out, "| (table) | pk | sk | ALL | order (2), user (1), (other) (1) |")
+	require.Contains(t, out, "| by-status | status | sk | INCLUDE: total | order (1) |")
+	require.Less(t, bytes.Index(buf.Bytes(), []byte("/ user")), bytes.Index(buf.Bytes(), []byte("/ (other)")), "ungrouped items come last")
+
+	buf.Reset()
+	require.NoError(t, dynamodb_image.FprintScan(ctx, cli, &buf, "app",
+		dynamodb_image.WithFormat(dynamodb_image.FormatJSONL),
+		dynamodb_image.GroupByKeyPrefix("#"),
+	))
+	out = buf.String()
+	require.Contains(t, out, `{"kind":"item","entity":"USER/PROFILE","item":{"email":"a@b.c","pk":"USER#1","sk":"PROFILE","type":"user"}}`+"\n")
+	require.Contains(t, out, `{"kind":"access_pattern","index":"(table)","hash_key":"pk","range_key":"sk","projection":"ALL","entities":["NOTE/NOTE (1)","USER/ORDER (2)","USER/PROFILE (1)"]}`)
+	require.Contains(t, out, `"kind":"access_pattern","index":"by-status","hash_key":"status","range_key":"sk","projection":"INCLUDE: total","entities":["USER/ORDER (1)"]`)
+	require.Equal(t, 6, strings.Count(out, `{"kind":`), "4 items and 2 access patterns")
+
+	buf.Reset()
+	require.NoError(t, dynamodb_image.FprintScan(ctx, cli, &buf, "app",
+		dynamodb_image.WithFormat(dynamodb_image.FormatCSV),
+		dynamodb_image.SortByKey(),
+		dynamodb_image.GroupByAttribute("type"),
+	))
+	out = buf.String()
+	require.True(t, strings.HasPrefix(out, "entity,pk,sk,status,total,type\norder,USER#1,ORDER#1,open,5,order\norder,USER#1,ORDER#2,,7,order\n\n"), out)
+	require.Contains(t, out, "\nentity,pk,sk,email,type\nuser,USER#1,PROFILE,a@b.c,user\n")
+	require.Contains(t, out, "\nentity,pk,sk\n(other),NOTE#1,NOTE#1\n")
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func splitName(failAt *int) *dynamodb_image.Migration {
+	return &dynamodb_image.Migration{ID: "0001-split-name", Up: func(ctx context.Context, run *dynamodb_image.MigrationRun) error {
+		_, err := run.Transform(ctx, "users", func(it map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+			name, ok := it["name"].(*types.AttributeValueMemberS)
+			if !ok {
+				return it, nil
+			}
+			if *failAt--; *failAt == 0 {
+				return nil, errors.New("boom")
+			}
+			first, last, _ := strings.Cut(name.Value, " ")
+			delete(it, "name")
+			it["first"], it["last"] = avS(first), avS(last)
+			return it, nil
+		})
+		return err
+	}}
+}
+
+var dropDeleted = &dynamodb_image.Migration{ID: "0002-drop-deleted", Up: func(ctx context.Context, run *dynamodb_image.MigrationRun) error {
+	_, err := run.Transform(ctx, "users", func(it map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		if _, ok := it["deleted"]; ok {
+			return nil, nil
+		}
+		return it, nil
+	})
+	return err
+}}
+
+func TestUnitDynamoMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	cli, err := dynamodb_image.EmulateT(t).NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("users"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	items := []map[string]types.AttributeValue{}
+	for i := 0; i < 250; i++ {
+		it := map[string]types.AttributeValue{"pk": avS(fmt.Sprintf("user#%03d", i)), "name": avS(fmt.Sprintf("Ada%d Lovelace", i))}
+		if i%10 == 0 {
+			it["deleted"] = &types.AttributeValueMemberBOOL{Value: true}
+		}
+		items = append(items, it)
+	}
+	_, err = dynamodb_image.SeedItems(ctx, cli, "users", items)
+	require.NoError(t, err)
+
+	never := -1
+	before := dynamodb_image.CaptureT(t, ctx, cli, "users")
+	report, err := dynamodb_image.Migrate(ctx, cli, dynamodb_image.DryRun(), dynamodb_image.WithMigrations(splitName(&never), dropDeleted))
+	require.NoError(t, err)
+	require.Len(t, report.Applied, 2)
+	require.Equal(t, 250, report.Applied[0].Transforms[0].Updated)
+	require.Equal(t, 25, report.Applied[1].Transforms[0].Deleted)
+	require.True(t, dynamodb_image.Diff(before, dynamodb_image.CaptureT(t, ctx, cli, "users")).Empty())
+
+	failAt := 150
+	_, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrations(splitName(&failAt), dropDeleted))
+	require.ErrorContains(t, err, "boom")
+
+	report, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrations(splitName(&never), dropDeleted))
+	require.NoError(t, err)
+	split := report.Applied[0].Transforms[0]
+	require.True(t, split.Resumed)
+	require.Equal(t, 250, split.Scanned)
+	require.Equal(t, 250, split.Updated)
+	require.Equal(t, 25, report.Applied[1].Transforms[0].Deleted)
+
+	out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("users"), Key: map[string]types.AttributeValue{"pk": avS("user#007")}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]types.AttributeValue{"pk": avS("user#007"), "first": avS("Ada7"), "last": avS("Lovelace")}, out.Item)
+
+	report, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrations(splitName(&never), dropDeleted))
+	require.NoError(t, err)
+	require.Empty(t, report.Applied)
+	require.Equal(t, []string{"0001-split-name", "0002-drop-deleted"}, report.Skipped)
+
+	_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String(dynamodb_image.MigrationTable), Item: map[string]types.AttributeValue{
+		"id": avS("lock"), "owner": avS("someone else"), "expires": avN("99999999999"),
+	}})
+	require.NoError(t, err)
+	_, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrations(dropDeleted))
+	require.ErrorIs(t, err, dynamodb_image.ErrMigrationLocked)
+
+	_, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrationTable("to-migrations"), dynamodb_image.MigrateTo("0003-missing"),
+		dynamodb_image.WithMigrations(splitName(&never), dropDeleted))
+	require.ErrorContains(t, err, "migration 0003-missing is not registered")
+
+	ran := false
+	steal := &dynamodb_image.Migration{ID: "0001-steal-lock", Up: func(ctx context.Context, run *dynamodb_image.MigrationRun) error {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("stolen-migrations"), Item: map[string]types.AttributeValue{
+			"id": avS("lock"), "owner": avS("someone else"), "expires": avN("99999999999"),
+		}})
+		return err
+	}}
+	after := &dynamodb_image.Migration{ID: "0002-after-steal", Up: func(ctx context.Context, run *dynamodb_image.MigrationRun) error {
+		ran = true
+		return nil
+	}}
+	report, err = dynamodb_image.Migrate(ctx, cli, dynamodb_image.WithMigrationTable("stolen-migrations"), dynamodb_image.WithMigrations(steal, after))
+	require.ErrorIs(t, err, dynamodb_image.ErrMigrationLocked, "the lock is extended between migrations")
+	require.False(t, ran)
+	require.Len(t, report.Applied, 1)
+
+	dynamodb_image.AssertMigrationT(t, ctx, cli, "users",
+		[]map[string]types.AttributeValue{
+			{"pk": avS("a"), "name": avS("Grace Hopper")},
+			{"pk": avS("b"), "name": avS("Alan Turing"), "deleted": &types.AttributeValueMemberBOOL{Value: true}},
+		},
+		[]map[string]types.AttributeValue{
+			{"pk": avS("a"), "first": avS("Grace"), "last": avS("Hopper")},
+		},
+		dynamodb_image.WithMigrations(splitName(&never), dropDeleted),
+	)
+
+	rec := &recordingTB{TB: t}
+	dynamodb_image.AssertMigrationT(rec, ctx, cli, "users",
+		[]map[string]types.AttributeValue{{"pk": avS("a"), "name": avS("Grace Hopper")}},
+		[]map[string]types.AttributeValue{{"pk": avS("a"), "first": avS("Grace"), "last": avS("Hopper"), "title": avS("RADM")}},
+		dynamodb_image.WithMigrations(splitName(&never)),
+	)
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], `- title: {"S":"RADM"}`)
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoNamespace(t *testing.T) {
+	ctx := context.Background()
+	img := dynamodb_image.EmulateT(t)
+
+	orders := func() *dynamodb_image.TableDefinition {
+		return &dynamodb_image.TableDefinition{
+			Input: &dynamodb.CreateTableInput{
+				TableName:            ptr.String("orders"),
+				BillingMode:          types.BillingModePayPerRequest,
+				KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+				AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+			},
+		}
+	}
+
+	var prefixes []string
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			ns, cli := dynamodb_image.NamespaceT(t, ctx, img, orders())
+			require.True(t, strings.HasPrefix(ns.Prefix, "TestUnitDynamoNamespace-"+name+"-"))
+			prefixes = append(prefixes, ns.Prefix)
+
+			_, err := cli.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{
+					"orders": {{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": avS(name)}}}},
+				},
+			})
+			require.NoError(t, err)
+
+			out, err := cli.Scan(ctx, &dynamodb.ScanInput{
+				TableName:              ptr.String("orders"),
+				ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+			})
+			require.NoError(t, err)
+			require.Len(t, out.Items, 1)
+			require.Equal(t, avS(name), out.Items[0]["pk"])
+			require.Equal(t, "orders", *out.ConsumedCapacity.TableName)
+
+			desc, err := cli.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: ptr.String("orders")})
+			require.NoError(t, err)
+			require.Equal(t, "orders", *desc.Table.TableName)
+			require.True(t, strings.HasSuffix(*desc.Table.TableArn, ":table/orders"))
+
+			tables, err := cli.ListTables(ctx, &dynamodb.ListTablesInput{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"orders"}, tables.TableNames)
+
+			raw, err := img.NewClient()
+			require.NoError(t, err)
+			all, err := raw.ListTables(ctx, &dynamodb.ListTablesInput{})
+			require.NoError(t, err)
+			require.Contains(t, all.TableNames, ns.Table("orders"))
+		})
+	}
+
+	require.NotEqual(t, prefixes[0], prefixes[1])
+
+	t.Run("shared input", func(t *testing.T) {
+		_, a := dynamodb_image.NamespaceT(t, ctx, img, orders())
+		_, b := dynamodb_image.NamespaceT(t, ctx, img, orders())
+
+		put := &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("put")}}
+		batch := &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+			"orders": {{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": avS("batch")}}}},
+		}}
+		transact := &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS("transact")}}},
+		}}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 12)
+		for _, cli := range []*dynamodb.Client{a, b, a, b} {
+			wg.Add(1)
+			go func(cli *dynamodb.Client) {
+				defer wg.Done()
+				_, err := cli.PutItem(ctx, put)
+				errs <- err
+				_, err = cli.BatchWriteItem(ctx, batch)
+				errs <- err
+				_, err = cli.TransactWriteItems(ctx, transact)
+				errs <- err
+			}(cli)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, "orders", *put.TableName)
+		require.Contains(t, batch.RequestItems, "orders")
+		require.Len(t, batch.RequestItems, 1)
+		require.Equal(t, "orders", *transact.TransactItems[0].Put.TableName)
+
+		for _, cli := range []*dynamodb.Client{a, b} {
+			out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("orders")})
+			require.NoError(t, err)
+			require.Len(t, out.Items, 3)
+		}
+	})
+}
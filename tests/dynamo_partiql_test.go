@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoBindPartiQL(t *testing.T) {
+	vars := map[string]types.AttributeValue{":pk": avS("user#1"), ":n": avN("2")}
+
+	for _, tc := range []struct {
+		name   string
+		stmt   string
+		want   string
+		params []types.AttributeValue
+		err    string
+	}{
+		{name: "placeholders", stmt: `SELECT * FROM "t" WHERE pk = :pk AND n > :n`, want: `SELECT * FROM "t" WHERE pk = ? AND n > ?`, params: []types.AttributeValue{avS("user#1"), avN("2")}},
+		{name: "repeated", stmt: `SELECT * FROM t WHERE a = :n OR b = :n`, want: `SELECT * FROM t WHERE a = ? OR b = ?`, params: []types.AttributeValue{avN("2"), avN("2")}},
+		{name: "quoted string", stmt: `SELECT * FROM t WHERE note = 'at :pk' AND pk = :pk`, want: `SELECT * FROM t WHERE note = 'at :pk' AND pk = ?`, params: []types.AttributeValue{avS("user#1")}},
+		{name: "escaped quote", stmt: `SELECT * FROM t WHERE note = 'it''s :pk'`, want: `SELECT * FROM t WHERE note = 'it''s :pk'`},
+		{name: "quoted name", stmt: `SELECT "a:pk" FROM "t:n" WHERE pk = :pk`, want: `SELECT "a:pk" FROM "t:n" WHERE pk = ?`, params: []types.AttributeValue{avS("user#1")}},
+		{name: "tuple", stmt: `INSERT INTO t VALUE {'pk': :pk, 'n': :n}`, want: `INSERT INTO t VALUE {'pk': ?, 'n': ?}`, params: []types.AttributeValue{avS("user#1"), avN("2")}},
+		{name: "not a placeholder", stmt: `SELECT * FROM t WHERE a = : pk`, want: `SELECT * FROM t WHERE a = : pk`},
+		{name: "unset", stmt: `SELECT * FROM t WHERE pk = :other`, err: "placeholder :other is not set"},
+		{name: "unset after string", stmt: `SELECT * FROM t WHERE a = ':pk' AND b = :x`, err: "placeholder :x is not set"},
+		{name: "unterminated", stmt: `SELECT * FROM t WHERE a = 'x`, err: "unterminated ' in statement"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, params, err := dynamodb_image.BindPartiQL(tc.stmt, vars)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+			require.Equal(t, tc.params, params)
+		})
+	}
+}
+
+func TestUnitDynamoEmulatorPartiQL(t *testing.T) {
+	ctx := context.Background()
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("orders"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+			},
+		},
+	})
+
+	exec := func(stmt string, params ...types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+		out, err := cli.ExecuteStatement(ctx, &dynamodb.ExecuteStatementInput{Statement: ptr.String(stmt), Parameters: params})
+		if err != nil {
+			return nil, err
+		}
+		return out.Items, nil
+	}
+
+	_, err = exec(`INSERT INTO "orders" VALUE {'pk': ?, 'sk': 'o#1', 'total': 5, 'tags': <<'a', 'b'>>, 'lines': [{'sku': 'x'}]}`, avS("u#1"))
+	require.NoError(t, err)
+	_, err = exec(`INSERT INTO orders VALUE {'pk': 'u#1', 'sk': 'o#2', 'total': 7, 'note': 'it''s'}`)
+	require.NoError(t, err)
+	_, err = exec(`INSERT INTO orders VALUE {'pk': 'u#1', 'sk': 'o#2'}`)
+	var dup *types.DuplicateItemException
+	require.ErrorAs(t, err, &dup)
+
+	items, err := exec(`SELECT * FROM "orders" WHERE pk = ? AND total > ?`, avS("u#1"), avN("6"))
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, avS("it's"), items[0]["note"])
+
+	items, err = exec(`SELECT sk, lines[0].sku FROM orders WHERE begins_with(sk, 'o#') AND "total" BETWEEN 1 AND 5`)
+	require.NoError(t, err)
+	require.Equal(t, []map[string]types.AttributeValue{{"sk": avS("o#1"), "lines": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+		&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"sku": avS("x")}},
+	}}}}, items)
+
+	_, err = exec(`UPDATE orders SET total = ?, status = 'paid' REMOVE tags WHERE pk = 'u#1' AND sk = 'o#1'`, avN("6"))
+	require.NoError(t, err)
+	items, err = exec(`SELECT * FROM orders WHERE sk = 'o#1'`)
+	require.NoError(t, err)
+	require.Equal(t, avN("6"), items[0]["total"])
+	require.Equal(t, avS("paid"), items[0]["status"])
+	require.NotContains(t, items[0], "tags")
+
+	_, err = exec(`DELETE FROM orders WHERE pk = 'u#1' AND sk = 'o#2'`)
+	require.NoError(t, err)
+	items, err = exec(`SELECT * FROM orders`)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	_, err = exec(`SELECT * FROM orders WHERE pk = ?`)
+	require.ErrorContains(t, err, "Number of parameters in request and statement don't match")
+	_, err = exec(`SELEC * FROM orders`)
+	require.ErrorContains(t, err, "ValidationException")
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoPrint(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("events"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("seq"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("seq"), AttributeType: types.ScalarAttributeTypeN},
+			},
+		},
+	})
+
+	for _, seq := range []string{"10", "9", "2"} {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: ptr.String("events"),
+			Item:      map[string]types.AttributeValue{"pk": avS("a"), "seq": avN(seq), "body": avS("x,y"), "gone": &types.AttributeValueMemberNULL{Value: true}},
+		})
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, dynamodb_image.FprintScan(ctx, cli, &buf, "events",
+		dynamodb_image.WithFormat(dynamodb_image.FormatCSV),
+		dynamodb_image.SortByKey(),
+		dynamodb_image.WithoutAttributes("gone"),
+		dynamodb_image.WithLimit(2),
+	))
+	require.Equal(t, "pk,seq,body\na,2,\"x,y\"\na,9,\"x,y\"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, dynamodb_image.FprintScan(ctx, cli, &buf, "events",
+		dynamodb_image.WithFormat(dynamodb_image.FormatJSONL),
+		dynamodb_image.WithAttributes("seq", "gone"),
+		dynamodb_image.WithFilter("seq > :n", nil, map[string]types.AttributeValue{":n": avN("9")}),
+	))
+	require.Equal(t, `{"gone":null,"seq":10}`+"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, dynamodb_image.FprintCounts(ctx, cli, &buf, "events", dynamodb_image.WithFormat(dynamodb_image.FormatMarkdown)))
+	require.Contains(t, buf.String(), "| gone | 3 | 3 |")
+
+	require.Error(t, dynamodb_image.FprintScan(ctx, cli, &buf, "missing"))
+}
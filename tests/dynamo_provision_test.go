@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoDescribeProvisioned(t *testing.T) {
+	ctx := context.Background()
+	img := dynamodb_image.EmulateT(t)
+
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("orders"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+			Tags:                 []types.Tag{{Key: ptr.String("team"), Value: ptr.String("billing")}},
+		},
+	})
+
+	describe := func(err error) (*dynamodb_image.ProvisionedTable, error) {
+		faults := dynamodb_image.NewFaults(&dynamodb_image.FaultRule{Operations: []string{"DescribeContinuousBackups", "ListTagsOfResource"}, Err: err})
+		cli, cerr := img.NewClient(faults.ClientOption(), func(o *dynamodb.Options) { o.Retryer = aws.NopRetryer{} })
+		require.NoError(t, cerr)
+		return dynamodb_image.DescribeProvisioned(ctx, cli, "orders")
+	}
+
+	desc, err := describe(&smithy.GenericAPIError{Code: "UnknownOperationException", Message: "old dynamodb-local"})
+	require.NoError(t, err, "operations old dynamodb-local does not implement are skipped")
+	require.Empty(t, desc.Tags)
+	require.Nil(t, desc.PointInTimeRecovery)
+
+	_, err = describe(dynamodb_image.ThrottlingError())
+	require.Error(t, err)
+	_, err = describe(&types.ResourceNotFoundException{Message: ptr.String("gone")})
+	var notFound *types.ResourceNotFoundException
+	require.ErrorAs(t, err, &notFound)
+
+	desc, err = dynamodb_image.DescribeProvisioned(ctx, cli, "orders")
+	require.NoError(t, err)
+	require.Len(t, desc.Tags, 1)
+
+	off := dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("ttl-off"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+		TimeToLive: &types.TimeToLiveSpecification{AttributeName: ptr.String("expires"), Enabled: ptr.Bool(false)},
+	})
+	require.Equal(t, types.TimeToLiveStatusDisabled, off.TimeToLive.TimeToLiveStatus)
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoSchema(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("app"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	items := []map[string]types.AttributeValue{
+		{"pk": avS("u1"), "type": avS("user"), "age": avN("30")},
+		{"pk": avS("u2"), "type": avS("user"), "age": avS("unknown")},
+		{"pk": avS("o1"), "type": avS("order"), "total": avN("12.5")},
+		{"pk": avS("x")},
+	}
+	_, err = dynamodb_image.SeedItems(ctx, cli, "app", items)
+	require.NoError(t, err)
+
+	schema, err := dynamodb_image.InferSchema(ctx, cli, "app", dynamodb_image.WithDiscriminator("type"))
+	require.NoError(t, err)
+	require.Equal(t, 4, schema.Items)
+	require.Len(t, schema.Entities, 3)
+
+	age := schema.Entity("user").Attribute("age")
+	require.Equal(t, 2, age.Count)
+	require.Len(t, age.Types, 2)
+	require.Equal(t, []string{"30"}, age.Types[0].Samples)
+	require.Equal(t, 7, age.Types[1].MaxSize)
+
+	b, err := json.Marshal(schema)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"discriminator":"type"`)
+
+	fixture, err := dynamodb_image.InferSchemaFromItems("app", items[:3], dynamodb_image.WithDiscriminator("type"))
+	require.NoError(t, err)
+	require.Empty(t, fixture.Drift(fixture))
+
+	drifted, err := dynamodb_image.InferSchemaFromItems("app", []map[string]types.AttributeValue{
+		{"pk": avS("u1"), "type": avS("user"), "age": avN("30"), "email": avS("a@b")},
+	}, dynamodb_image.WithDiscriminator("type"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`- entity "order"`,
+		`- entity "user": attribute age as S`,
+		`+ entity "user": attribute email`,
+	}, fixture.Drift(drifted))
+
+	var buf bytes.Buffer
+	require.NoError(t, dynamodb_image.FprintSchema(&buf, schema, dynamodb_image.WithFormat(dynamodb_image.FormatCSV)))
+	require.Contains(t, buf.String(), "user,age,2/2,N,1,2,2,[30]\n")
+}
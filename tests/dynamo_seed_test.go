@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoSeed(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("seeded"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	files := map[string]string{
+		"a.json": `{"Items": [
+			{"pk": {"S": "ddb"}, "n": {"N": "1"}},
+			{"pk": "plain", "owner": "{{uuid:alice}}", "friend": "{{uuid:alice}}", "expires": "{{unix+1d}}"}
+		]}`,
+		"b.yaml": "- pk: yaml\n  tags: [a, b]\n",
+		"c.csv":  "pk,count:N,labels:SS,meta:M\ncsv,3,x;y,\"{\"\"k\"\":1}\"\nempty,,,\n",
+	}
+	for name, body := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644))
+	}
+
+	var bulk strings.Builder
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&bulk, `{"Item": {"pk": {"S": "bulk-%d"}}}`+"\n", i)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "d.jsonl"), []byte(bulk.String()), 0o644))
+
+	n, err := dynamodb_image.Seed(ctx, cli, "seeded", dir)
+	require.NoError(t, err)
+	require.Equal(t, 65, n)
+
+	get := func(pk string) map[string]types.AttributeValue {
+		out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: ptr.String("seeded"),
+			Key:       map[string]types.AttributeValue{"pk": avS(pk)},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, out.Item, pk)
+		return out.Item
+	}
+
+	require.Equal(t, avN("1"), get("ddb")["n"])
+
+	plain := get("plain")
+	require.Equal(t, plain["owner"], plain["friend"])
+	expires, err := strconv.ParseInt(plain["expires"].(*types.AttributeValueMemberN).Value, 10, 64)
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Add(24*time.Hour).Unix(), expires, 60)
+
+	require.IsType(t, &types.AttributeValueMemberL{}, get("yaml")["tags"])
+
+	row := get("csv")
+	require.Equal(t, avN("3"), row["count"])
+	require.Equal(t, &types.AttributeValueMemberSS{Value: []string{"x", "y"}}, row["labels"])
+	require.Equal(t, avN("1"), row["meta"].(*types.AttributeValueMemberM).Value["k"])
+	require.Len(t, get("empty"), 1)
+
+	get("bulk-59")
+}
+
+func TestUnitDynamoSeedIndex(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("- {pk: a0, n: \"{{index}}\"}\n- {pk: a1, n: \"{{index}}\"}\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.jsonl"), []byte(`{"pk": "b0", "n": "{{index}}"}`+"\n"+`{"pk": "b1", "n": "{{index}}"}`+"\n"), 0o644))
+
+	items, err := dynamodb_image.LoadSeed(dir)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	for _, it := range items {
+		pk := it["pk"].(*types.AttributeValueMemberS).Value
+		require.Equal(t, avN(pk[1:]), it["n"], "{{index}} of %s counts from the start of its file", pk)
+	}
+}
+
+func TestUnitDynamoTruncate(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:   ptr.String("truncated"),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+				{AttributeName: ptr.String("sk"), KeyType: types.KeyTypeRange},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: ptr.String("sk"), AttributeType: types.ScalarAttributeTypeN},
+			},
+		},
+	})
+
+	items := []map[string]types.AttributeValue{}
+	for i := 0; i < 120; i++ {
+		items = append(items, map[string]types.AttributeValue{"pk": avS(fmt.Sprint(i % 7)), "sk": avN(fmt.Sprint(i)), "body": avS("x")})
+	}
+	_, err = dynamodb_image.SeedItems(ctx, cli, "truncated", items)
+	require.NoError(t, err)
+
+	res, err := dynamodb_image.Truncate(ctx, cli, "truncated", dynamodb_image.WithParallelism(3))
+	require.NoError(t, err)
+	require.Equal(t, 120, res.Deleted)
+
+	count := func() int32 {
+		out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("truncated"), Select: types.SelectCount})
+		require.NoError(t, err)
+		return out.Count
+	}
+	require.Zero(t, count())
+
+	t.Run("registered seed", func(t *testing.T) {
+		dynamodb_image.RegisterSeedItemsT(t, cli, "truncated", items[:10])
+		_, err = dynamodb_image.SeedItems(ctx, cli, "truncated", items[50:])
+		require.NoError(t, err)
+
+		res = dynamodb_image.ResetToSeedT(t, ctx, cli, "truncated")
+		require.Equal(t, 70, res.Deleted)
+		require.Equal(t, 10, res.Seeded)
+		require.EqualValues(t, 10, count())
+
+		other, err := img.NewClient()
+		require.NoError(t, err)
+		res = dynamodb_image.ResetToSeedT(t, ctx, other, "truncated")
+		require.Zero(t, res.Seeded, "the seed is registered for the other client")
+	})
+
+	t.Run("registered empty seed", func(t *testing.T) {
+		dynamodb_image.RegisterSeedItemsT(t, cli, "truncated", nil)
+		_, err = dynamodb_image.SeedItems(ctx, cli, "truncated", items[:3])
+		require.NoError(t, err)
+
+		res = dynamodb_image.ResetToSeedT(t, ctx, cli, "truncated")
+		require.Equal(t, 3, res.Deleted)
+		require.Zero(t, res.Seeded)
+	})
+
+	res = dynamodb_image.ResetToSeedT(t, ctx, cli, "truncated")
+	require.Zero(t, res.Seeded, "the seed is removed with the test that registered it")
+	require.Zero(t, count())
+}
+
+func TestUnitDynamoSeedFromTestdata(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	// seeded from testdata/dynamodb/fixture-orders.yaml
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("fixture-orders"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	count := func() int32 {
+		out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("fixture-orders"), Select: types.SelectCount})
+		require.NoError(t, err)
+		return out.Count
+	}
+	require.EqualValues(t, 3, count())
+
+	out, err := cli.GetItem(ctx, &dynamodb.GetItemInput{TableName: ptr.String("fixture-orders"), Key: map[string]types.AttributeValue{"pk": avS("order#3")}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]types.AttributeValue{"pk": avS("order#3"), "status": avS("open"), "position": avN("2")}, out.Item)
+
+	_, err = cli.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: ptr.String("fixture-orders"), Key: map[string]types.AttributeValue{"pk": avS("order#1")}})
+	require.NoError(t, err)
+	_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("fixture-orders"), Item: map[string]types.AttributeValue{"pk": avS("order#9")}})
+	require.NoError(t, err)
+
+	res := dynamodb_image.ResetToSeedT(t, ctx, cli, "fixture-orders")
+	require.Equal(t, 3, res.Deleted)
+	require.Equal(t, 3, res.Seeded, "resetting without a registered seed falls back to testdata")
+	require.EqualValues(t, 3, count())
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoStream(t *testing.T) {
+	ctx := context.Background()
+
+	img := dynamodb_image.EmulateT(t)
+	cli, err := img.NewClient()
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("jobs"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+
+	put := func(pk, status string) {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: ptr.String("jobs"),
+			Item:      map[string]types.AttributeValue{"pk": avS(pk), "status": avS(status)},
+		})
+		require.NoError(t, err)
+	}
+
+	// written before watching, so not delivered
+	put("old", "done")
+
+	watcher := dynamodb_image.WatchStreamT(t, ctx, img, "jobs")
+	require.NotEmpty(t, watcher.StreamArn)
+
+	key := map[string]types.AttributeValue{"pk": avS("a")}
+	put("a", "queued")
+	put("a", "queued")
+	put("a", "running")
+	_, err = cli.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: ptr.String("jobs"), Key: key})
+	require.NoError(t, err)
+	put("b", "queued")
+
+	watcher.ExpectEvent(t, dynamodb_image.Inserted(key).WithNewImage(map[string]types.AttributeValue{"status": avS("queued")}), time.Second)
+	modified := watcher.ExpectEvent(t, dynamodb_image.Modified(key), time.Second)
+	require.Equal(t, avS("queued"), modified.OldImage["status"])
+	require.Equal(t, avS("running"), modified.NewImage["status"])
+	watcher.WaitForEvent(t, dynamodb_image.Inserted(map[string]types.AttributeValue{"pk": avS("b")}), time.Second)
+	watcher.ExpectNoEvent(t, 100*time.Millisecond)
+
+	rec := &recordingTB{TB: t}
+	put("c", "queued")
+	watcher.ExpectEvent(rec, dynamodb_image.Removed(nil), time.Second)
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], `expected REMOVE on jobs, got INSERT {"pk":{"S":"c"}}`)
+}
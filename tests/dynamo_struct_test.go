@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+type orderID struct{ n int }
+
+func (me orderID) MarshalDynamoDBAttributeValue() (types.AttributeValue, error) {
+	return &types.AttributeValueMemberS{Value: "order#" + string(rune('0'+me.n))}, nil
+}
+
+type auditFields struct {
+	Created time.Time `dynamodbav:"created,unixtime" dynamo:"range,gsi:by-status:range"`
+	Expires int64     `dynamodbav:"expires" dynamo:"ttl"`
+}
+
+type structOrder struct {
+	ID       orderID  `dynamodbav:"pk" dynamo:"hash"`
+	Status   string   `dynamodbav:"status" dynamo:"gsi:by-status:hash"`
+	Customer *string  `dynamo:"gsi:by-customer:hash"`
+	Total    float64  `dynamodbav:"total,string" dynamo:"lsi:by-total"`
+	Lines    []string `dynamodbav:"lines"`
+	Internal string   `dynamodbav:"-" dynamo:"hash"`
+	auditFields
+}
+
+func TestUnitDynamoTableFromStruct(t *testing.T) {
+	ctx := context.Background()
+
+	def, err := dynamodb_image.TableFromStruct[structOrder]("orders", dynamodb_image.WithIndexProjection("by-customer", types.ProjectionTypeKeysOnly))
+	require.NoError(t, err)
+
+	in := def.Input
+	require.Equal(t, []types.KeySchemaElement{
+		{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash},
+		{AttributeName: ptr.String("created"), KeyType: types.KeyTypeRange},
+	}, in.KeySchema)
+
+	attrs := map[string]types.ScalarAttributeType{}
+	for _, a := range in.AttributeDefinitions {
+		attrs[*a.AttributeName] = a.AttributeType
+	}
+	require.Equal(t, map[string]types.ScalarAttributeType{"pk": "S", "created": "N", "status": "S", "Customer": "S", "total": "S"}, attrs)
+
+	require.Len(t, in.GlobalSecondaryIndexes, 2)
+	require.Equal(t, "by-customer", *in.GlobalSecondaryIndexes[0].IndexName)
+	require.Equal(t, types.ProjectionTypeKeysOnly, in.GlobalSecondaryIndexes[0].Projection.ProjectionType)
+	require.Equal(t, "by-total", *in.LocalSecondaryIndexes[0].IndexName)
+	require.Equal(t, "expires", *def.TimeToLive.AttributeName)
+
+	cli, err := dynamodb_image.EmulateT(t).NewClient()
+	require.NoError(t, err)
+	dynamodb_image.ProvisionT(t, ctx, cli, def)
+
+	item, err := attributevalue.MarshalMap(&structOrder{ID: orderID{3}, Status: "open", Customer: ptr.String("c1"), Total: 9.5, auditFields: auditFields{Created: time.Unix(1700000000, 0)}})
+	require.NoError(t, err)
+	_, err = cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: item})
+	require.NoError(t, err)
+
+	out, err := cli.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 ptr.String("orders"),
+		IndexName:                 ptr.String("by-status"),
+		KeyConditionExpression:    ptr.String("#s = :s"),
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":s": avS("open")},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Items, 1)
+}
+
+func TestUnitDynamoTableFromStructErrors(t *testing.T) {
+	type valid struct {
+		A string `dynamo:"hash"`
+	}
+	type boolKey struct {
+		Active bool `dynamo:"hash"`
+	}
+	type noHash struct {
+		ID string `dynamodbav:"id"`
+	}
+	type twoHashes struct {
+		A string `dynamo:"hash"`
+		B string `dynamo:"hash"`
+	}
+	type conflicting struct {
+		A string `dynamo:"hash"`
+		B int    `dynamodbav:"A" dynamo:"gsi:x:hash"`
+	}
+	type lsiWithoutRange struct {
+		A string `dynamo:"hash"`
+		B string `dynamo:"lsi:x"`
+	}
+	type unknownRole struct {
+		A string `dynamo:"hash,primary"`
+	}
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: tableErr[boolKey](), want: "not bool"},
+		{err: tableErr[noHash](), want: "no field tagged"},
+		{err: tableErr[twoHashes](), want: "hash key is already A"},
+		{err: tableErr[conflicting](), want: "is used as S and N"},
+		{err: tableErr[lsiWithoutRange](), want: "needs a table with a range key"},
+		{err: tableErr[unknownRole](), want: `unknown dynamo tag "primary"`},
+		{err: tableErr[valid](dynamodb_image.WithIndexProjection("x", types.ProjectionTypeAll)), want: "projection for unknown index x"},
+		{err: tableErr[string](), want: "is not a struct"},
+	}
+	for _, tt := range tests {
+		require.ErrorContains(t, tt.err, tt.want)
+	}
+}
+
+func tableErr[T any](opts ...dynamodb_image.StructOption) error {
+	_, err := dynamodb_image.TableFromStruct[T]("t", opts...)
+	return err
+}
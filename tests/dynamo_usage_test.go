@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/testrc/pkg/docker"
+	dynamodb_image "github.com/walteh/testrc/pkg/images/dynamodb"
+)
+
+func TestUnitDynamoUsage(t *testing.T) {
+	ctx := context.Background()
+	img := dynamodb_image.EmulateT(t)
+
+	usage := dynamodb_image.UsageT(t, dynamodb_image.MaxScans(1), dynamodb_image.MaxWCU(10))
+	cli, err := img.NewClient(usage.ClientOption())
+	require.NoError(t, err)
+
+	dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+		Input: &dynamodb.CreateTableInput{
+			TableName:            ptr.String("orders"),
+			BillingMode:          types.BillingModePayPerRequest,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		},
+	})
+	usage.Reset()
+
+	for i := 0; i < 3; i++ {
+		_, err := cli.PutItem(ctx, &dynamodb.PutItemInput{TableName: ptr.String("orders"), Item: map[string]types.AttributeValue{"pk": avS(fmt.Sprintf("o%d", i))}})
+		require.NoError(t, err)
+	}
+	in := &dynamodb.GetItemInput{TableName: ptr.String("orders"), Key: map[string]types.AttributeValue{"pk": avS("o1")}, ConsistentRead: ptr.Bool(true)}
+	for i := 0; i < 3; i++ {
+		out, err := cli.GetItem(ctx, in)
+		require.NoError(t, err)
+		require.Nil(t, out.ConsumedCapacity, "capacity is only returned when asked for")
+	}
+	require.Empty(t, in.ReturnConsumedCapacity, "the input is left alone")
+
+	out, err := cli.Scan(ctx, &dynamodb.ScanInput{TableName: ptr.String("orders"), ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal})
+	require.NoError(t, err)
+	require.NotNil(t, out.ConsumedCapacity)
+
+	rep := usage.Report()
+	require.Equal(t, 7, rep.Calls)
+	require.Equal(t, 3, rep.Count("GetItem", "orders"))
+	require.Equal(t, 1, rep.Count("Scan"))
+	require.Equal(t, 3.0, rep.WriteUnits)
+	require.Equal(t, 3.5, rep.ReadUnits)
+	require.Contains(t, rep.String(), "GetItem orders: 3 calls, 3 read units")
+
+	rec := &recordingTB{TB: t}
+	require.False(t, usage.AssertUsage(rec, dynamodb_image.MaxScans(0), dynamodb_image.MaxCalls("GetItem", 2), dynamodb_image.MaxRCU(50)))
+	require.Len(t, rec.errors, 2)
+	require.Contains(t, rec.errors[0], "1 Scan calls, at most 0 allowed")
+	require.Contains(t, rec.errors[1], "3 GetItem calls, at most 2 allowed")
+
+	t.Run("batch", func(t *testing.T) {
+		usage := dynamodb_image.UsageT(t)
+		cli, err := img.NewClient(usage.ClientOption())
+		require.NoError(t, err)
+
+		dynamodb_image.ProvisionT(t, ctx, cli, &dynamodb_image.TableDefinition{
+			Input: &dynamodb.CreateTableInput{
+				TableName:            ptr.String("lines"),
+				BillingMode:          types.BillingModePayPerRequest,
+				KeySchema:            []types.KeySchemaElement{{AttributeName: ptr.String("pk"), KeyType: types.KeyTypeHash}},
+				AttributeDefinitions: []types.AttributeDefinition{{AttributeName: ptr.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+			},
+		})
+		usage.Reset()
+
+		_, err = cli.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+			"orders": {{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": avS("b1")}}}},
+			"lines":  {{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": avS("b1")}}}},
+		}})
+		require.NoError(t, err)
+
+		rep := usage.Report()
+		require.Equal(t, 1, rep.Calls, "a call on two tables is one call")
+		require.Equal(t, 1, rep.Count("BatchWriteItem"))
+		require.Equal(t, 1, rep.Count("BatchWriteItem", "lines"))
+		require.Equal(t, 2.0, rep.WriteUnits)
+	})
+
+	var recorded bool
+	for _, r := range docker.BuildTimingReport(nil).Tests {
+		if r.Test == t.Name()+"/batch" && r.Name == dynamodb_image.UsageResultName {
+			recorded = true
+			require.Contains(t, string(r.Value), `"operation":"BatchWriteItem"`)
+		}
+	}
+	require.True(t, recorded, "the usage of the test is in the timing report")
+}
@@ -0,0 +1,22 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/walteh/testrc/pkg/docker"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	ctx := zerolog.New(zerolog.NewConsoleWriter()).With().Logger().WithContext(context.Background())
+
+	if _, err := docker.FinishRun(ctx); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("could not write container timings")
+	}
+
+	os.Exit(code)
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/snake"
+	"github.com/walteh/testrc/cmd/root"
+	"github.com/walteh/testrc/cmd/root/run"
+	"github.com/walteh/testrc/pkg/docker"
+)
+
+func TestUnitRun(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv(docker.SessionEnv, "")
+
+	execute := func(args ...string) (*root.Root, error) {
+		r := &root.Root{}
+		cmd := snake.NewRootCommand(ctx, r)
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		return r, cmd.ExecuteContext(ctx)
+	}
+
+	t.Run("arguments after -- reach the command", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		r, err := execute("run", "--quiet", "--services=", "--session", "s1", "--",
+			"sh", "-c", `printf '%s|' "$@" "$TESTRC_SESSION" > "$0"`, out, "-v", "--session", "other")
+		require.NoError(t, err)
+		require.True(t, r.Quiet, "root flags after run are parsed")
+
+		b, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "-v|--session|other|s1|", string(b))
+	})
+
+	t.Run("parsing stops at the command", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		_, err := execute("--quiet", "run", "--services=", "sh", "-c", `printf '%s' "$1" > "$0"`, out, "--debug")
+		require.NoError(t, err)
+
+		b, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "--debug", string(b))
+	})
+
+	t.Run("no command", func(t *testing.T) {
+		_, err := execute("--quiet", "run", "--services=", "--")
+		require.ErrorContains(t, err, "no command given")
+	})
+
+	t.Run("exit status", func(t *testing.T) {
+		_, err := execute("--quiet", "run", "--services=", "--", "sh", "-c", "exit 3")
+		var exit *run.ExitError
+		require.ErrorAs(t, err, &exit)
+		require.Equal(t, 3, exit.Code)
+	})
+
+	t.Run("killed by a signal", func(t *testing.T) {
+		_, err := execute("--quiet", "run", "--services=", "--", "sh", "-c", "kill -TERM $$")
+		var exit *run.ExitError
+		require.ErrorAs(t, err, &exit)
+		require.Equal(t, 128+15, exit.Code)
+	})
+}